@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/afeeblechild/aws-go-tool/lib/ec2"
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
@@ -9,7 +10,15 @@ import (
 )
 
 var (
-	Cidr string
+	Apply         bool
+	Cidr          string
+	FamilyPattern string
+	FinalSnapshot bool
+	GraceDays     int
+	KeepLast      int
+	MarkTags      map[string]string
+	OlderThan     int
+	PlanFile      string
 )
 
 var ec2Cmd = &cobra.Command{
@@ -24,11 +33,17 @@ var imagesCheckCmd = &cobra.Command{
 	Use:   "imagescheck",
 	Short: "Will generate a report of images in use by instances in the account.",
 	Run: func(cmd *cobra.Command, args []string) {
-		checkedImages, err := ec2.CheckImages(Accounts)
+		profilesImages, err := utils.CollectAccounts("ec2/images", ec2.GetProfilesImages, Accounts)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Println("could not get profiles images:", err)
+			return
+		}
+		profilesInstances, err := utils.CollectAccounts("ec2/instances", ec2.GetProfilesInstances, Accounts)
+		if err != nil {
+			fmt.Println("could not get profiles instances:", err)
 			return
 		}
+		checkedImages := ec2.CheckImages(profilesImages, profilesInstances)
 
 		options := utils.Ec2Options{Tags: Tags}
 		err = ec2.WriteCheckedImages(checkedImages, options)
@@ -43,7 +58,7 @@ var imagesListCmd = &cobra.Command{
 	Use:   "imageslist",
 	Short: "Will generate a report of all images for all given accounts.",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesImages, err := ec2.GetProfilesImages(Accounts)
+		profilesImages, err := utils.CollectAccounts("ec2/images", ec2.GetProfilesImages, Accounts)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -62,7 +77,7 @@ var instancesListCmd = &cobra.Command{
 	Use:   "instanceslist",
 	Short: "Will generate a report of all instances for all given accounts.",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesInstances, err := ec2.GetProfilesInstances(Accounts)
+		profilesInstances, err := utils.CollectAccounts("ec2/instances", ec2.GetProfilesInstances, Accounts)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -80,7 +95,7 @@ var sgsListCmd = &cobra.Command{
 	Use:   "sgslist",
 	Short: "Will generate a report of all security groups for all given accounts.",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesSGs, err := ec2.GetProfilesSGs(Accounts)
+		profilesSGs, err := utils.CollectAccounts("ec2/securitygroups", ec2.GetProfilesSGs, Accounts)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -98,7 +113,7 @@ var sgsRulesListCmd = &cobra.Command{
 	Use:   "sgruleslist",
 	Short: "Will generate a report of all security group rules for all given accounts",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesSGs, err := ec2.GetProfilesSGs(Accounts)
+		profilesSGs, err := utils.CollectAccounts("ec2/securitygroups", ec2.GetProfilesSGs, Accounts)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -113,11 +128,75 @@ var sgsRulesListCmd = &cobra.Command{
 	},
 }
 
+var sgAuditCmd = &cobra.Command{
+	Use:   "sgaudit",
+	Short: "Will generate a report of security group rules open to the internet, scored by risk, for all given accounts.",
+	Long: `Will find the ingress rules open to 0.0.0.0/0 or ::/0, directly or through a managed prefix list that has
+either of them as an entry, and give each one a score from 1 to 10 and a severity.
+All ports, remote admin ports such as ssh and rdp, and database ports score the highest.  Rules for only http or
+https are not reported.  The network interfaces each group is attached to, and what they are attached to, are
+added to each finding, and groups that are not attached, or have no public ip, score lower.
+
+A summary of the findings in each account is written to the sgauditsummary report.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profilesSGs, err := utils.CollectAccounts("ec2/securitygroups", ec2.GetProfilesSGs, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		profilesInterfaces, err := utils.CollectAccounts("ec2/networkinterfaces", ec2.GetProfilesNetworkInterfaces, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		profilesPrefixLists, err := utils.CollectAccounts("ec2/prefixlists", ec2.GetProfilesPrefixLists, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		findings := ec2.AuditSecurityGroups(profilesSGs, profilesInterfaces, profilesPrefixLists)
+
+		options := ec2.SgOptions{Tags: Tags}
+		err = ec2.WriteSgFindings(findings, options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+var sgUnusedCmd = &cobra.Command{
+	Use:   "sgunused",
+	Short: "Will write a plan to delete the security groups nothing uses, and apply it with --apply.",
+	Long: `Will find the security groups that are not used by any network interface, launch template version,
+launch configuration, classic or v2 load balancer, or referenced by a rule of another group.  Most services,
+such as rds, lambda and efs, use groups through their network interfaces.  Every group and what uses it is
+written to the sgusage report.
+
+A plan to delete the unused groups is written for review, and nothing is changed unless --apply is given.
+Default groups can not be deleted, and are always skipped.  A reviewed plan can be applied later with
+--plan <file> --apply.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCleanupPlan(func() (ec2.CleanupPlan, error) {
+			profilesUsage, err := utils.CollectAccounts("ec2/sgusage", ec2.GetProfilesSgUsage, Accounts)
+			if err != nil {
+				return ec2.CleanupPlan{}, err
+			}
+			err = ec2.WriteSgUsage(profilesUsage, ec2.SgOptions{Tags: Tags})
+			if err != nil {
+				return ec2.CleanupPlan{}, err
+			}
+			options := ec2.CleanupOptions{GraceDays: GraceDays, MarkTags: MarkTags}
+			return ec2.BuildSgUnusedPlan(profilesUsage, options, time.Now().UTC()), nil
+		})
+	},
+}
+
 var snapshotsListCmd = &cobra.Command{
 	Use:   "snapshotslist",
 	Short: "Will generate a report of all snapshots for all given accounts.",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesSnapshots, err := ec2.GetProfilesSnapshots(Accounts)
+		profilesSnapshots, err := utils.CollectAccounts("ec2/snapshots", ec2.GetProfilesSnapshots, Accounts)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -135,7 +214,7 @@ var volumesListCmd = &cobra.Command{
 	Use:   "volumeslist",
 	Short: "Will generate a report of all volumes for all given accounts.",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesVolumes, err := ec2.GetProfilesVolumes(Accounts)
+		profilesVolumes, err := utils.CollectAccounts("ec2/volumes", ec2.GetProfilesVolumes, Accounts)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -149,16 +228,133 @@ var volumesListCmd = &cobra.Command{
 	},
 }
 
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Will write a plan to delete unattached volumes and orphaned snapshots, and apply it with --apply.",
+	Long: `Will find volumes that are not attached to an instance, and snapshots whose volume and image no longer exist,
+that are older than --olderThan days.  A plan of what would be done is written for review, and nothing is changed
+unless --apply is given.  A reviewed plan can be applied later with --plan <file> --apply.
+
+With --graceDays, resources are first tagged with aws-go-tool:cleanup, and are only deleted by a later run
+once they have been tagged for that many days.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCleanupPlan(func() (ec2.CleanupPlan, error) {
+			profilesCleanup, err := utils.CollectAccounts("ec2/cleanup", ec2.GetProfilesCleanup, Accounts)
+			if err != nil {
+				return ec2.CleanupPlan{}, err
+			}
+			options := ec2.CleanupOptions{
+				OlderThanDays: OlderThan,
+				GraceDays:     GraceDays,
+				FinalSnapshot: FinalSnapshot,
+				MarkTags:      MarkTags,
+			}
+			return ec2.BuildCleanupPlan(profilesCleanup, options, time.Now().UTC()), nil
+		})
+	},
+}
+
+var imagesPruneCmd = &cobra.Command{
+	Use:   "imagesprune",
+	Short: "Will write a plan to deregister unused images and delete their snapshots, and apply it with --apply.",
+	Long: `Will find the images that are not used by any instance, launch template version, launch configuration,
+auto scaling group, or shared with another account.  Images are grouped into families by their name with
+--familyPattern removed, and the newest --keepLast images of each family are always kept, along with any image
+newer than --olderThan days.  The rest are deregistered along with their snapshots.
+
+A plan of what would be done is written for review, including every kept image and why, and nothing is changed
+unless --apply is given.  A reviewed plan can be applied later with --plan <file> --apply.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCleanupPlan(func() (ec2.CleanupPlan, error) {
+			profilesUsage, err := utils.CollectAccounts("ec2/imageusage", ec2.GetProfilesImageUsage, Accounts)
+			if err != nil {
+				return ec2.CleanupPlan{}, err
+			}
+			options := ec2.PruneOptions{
+				OlderThanDays: OlderThan,
+				KeepLast:      KeepLast,
+				FamilyPattern: FamilyPattern,
+				GraceDays:     GraceDays,
+				MarkTags:      MarkTags,
+			}
+			return ec2.BuildImagePrunePlan(profilesUsage, options, time.Now().UTC())
+		})
+	},
+}
+
+// runCleanupPlan will build and write a plan, or read the --plan file, and then apply it if --apply is given
+func runCleanupPlan(build func() (ec2.CleanupPlan, error)) {
+	var plan ec2.CleanupPlan
+	var err error
+	if PlanFile != "" {
+		plan, err = ec2.ReadCleanupPlan(PlanFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	} else {
+		plan, err = build()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		err = ec2.WriteCleanupPlan(plan)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if !Apply {
+		fmt.Println(len(plan.Actions), "actions planned, review the plan and run again with --apply to carry it out")
+		return
+	}
+	if utils.Replaying() {
+		fmt.Println("a plan can not be applied while replaying")
+		return
+	}
+	ec2.ApplyCleanupPlan(&plan, Accounts)
+	err = ec2.WriteCleanupActions(plan, plan.Name+"results")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(ec2Cmd)
 
+	ec2Cmd.AddCommand(cleanupCmd)
 	ec2Cmd.AddCommand(imagesCheckCmd)
 	ec2Cmd.AddCommand(imagesListCmd)
+	ec2Cmd.AddCommand(imagesPruneCmd)
 	ec2Cmd.AddCommand(instancesListCmd)
 	ec2Cmd.AddCommand(sgsListCmd)
 	ec2Cmd.AddCommand(sgsRulesListCmd)
+	ec2Cmd.AddCommand(sgAuditCmd)
+	ec2Cmd.AddCommand(sgUnusedCmd)
 	ec2Cmd.AddCommand(snapshotsListCmd)
 	ec2Cmd.AddCommand(volumesListCmd)
 
-	sgsRulesListCmd.PersistentFlags().StringVarP(&Cidr, "cidr", "c", "", "cidr to search for")
+	sgsRulesListCmd.PersistentFlags().StringVarP(&Cidr, "cidr", "c", "", "ip or cidr to search for, rules that contain it or are inside it are reported")
+
+	cleanupCmd.Flags().IntVar(&OlderThan, "olderThan", 30, "only clean up volumes and snapshots older than this many days")
+	cleanupCmd.Flags().IntVar(&GraceDays, "graceDays", 0, "tag resources first, and only delete them once tagged for this many days")
+	cleanupCmd.Flags().BoolVar(&FinalSnapshot, "finalSnapshot", false, "take a snapshot of each volume before deleting it")
+	cleanupCmd.Flags().StringToStringVar(&MarkTags, "markTags", nil, "extra tags to add when marking resources, as key=value,key=value")
+	cleanupCmd.Flags().StringVar(&PlanFile, "plan", "", "a plan file written by an earlier run to apply, instead of building a new plan")
+	cleanupCmd.Flags().BoolVar(&Apply, "apply", false, "carry out the plan, without this only the plan is written")
+
+	imagesPruneCmd.Flags().IntVar(&OlderThan, "olderThan", 30, "only deregister images older than this many days")
+	imagesPruneCmd.Flags().IntVar(&KeepLast, "keepLast", 3, "always keep this many of the newest images in each family")
+	imagesPruneCmd.Flags().StringVar(&FamilyPattern, "familyPattern", ec2.DefaultFamilyPattern, "regex removed from image names to get their family")
+	imagesPruneCmd.Flags().IntVar(&GraceDays, "graceDays", 0, "tag images first, and only deregister them once tagged for this many days")
+	imagesPruneCmd.Flags().StringToStringVar(&MarkTags, "markTags", nil, "extra tags to add when marking images, as key=value,key=value")
+	imagesPruneCmd.Flags().StringVar(&PlanFile, "plan", "", "a plan file written by an earlier run to apply, instead of building a new plan")
+	imagesPruneCmd.Flags().BoolVar(&Apply, "apply", false, "carry out the plan, without this only the plan is written")
+
+	sgUnusedCmd.Flags().IntVar(&GraceDays, "graceDays", 0, "tag groups first, and only delete them once tagged for this many days")
+	sgUnusedCmd.Flags().StringToStringVar(&MarkTags, "markTags", nil, "extra tags to add when marking groups, as key=value,key=value")
+	sgUnusedCmd.Flags().StringVar(&PlanFile, "plan", "", "a plan file written by an earlier run to apply, instead of building a new plan")
+	sgUnusedCmd.Flags().BoolVar(&Apply, "apply", false, "carry out the plan, without this only the plan is written")
 }
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/afeeblechild/aws-go-tool/lib/iam"
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
@@ -9,8 +10,15 @@ import (
 )
 
 var (
-	Username  string
-	RolesFile string
+	Username        string
+	RolesFile       string
+	ChangeSetFile   string
+	SessionDuration int64
+	KeyMaxAge       int
+	UnusedDays      int
+	InactiveDays    int
+	Golden          string
+	PolicyDir       string
 )
 
 var iamCmd = &cobra.Command{
@@ -25,7 +33,7 @@ var policiesListCmd = &cobra.Command{
 	Use:   "policieslist",
 	Short: "Will generate a report of policies",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesPolicies, err := iam.GetProfilesPolicies(Accounts)
+		profilesPolicies, err := utils.CollectAccounts("iam/policies", iam.GetProfilesPolicies, Accounts)
 		if err != nil {
 			fmt.Println("Could not get policies from all profiles", err)
 			return
@@ -38,7 +46,7 @@ var rolesListCmd = &cobra.Command{
 	Use:   "roleslist",
 	Short: "Will generate a report of roles",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesRoles, err := iam.GetProfilesRoles(Accounts)
+		profilesRoles, err := utils.CollectAccounts("iam/roles", iam.GetProfilesRoles, Accounts)
 		if err != nil {
 			fmt.Println("Could not get roles from all profiles", err)
 			return
@@ -50,21 +58,95 @@ var rolesListCmd = &cobra.Command{
 var rolesUpdateCmd = &cobra.Command{
 	Use:   "rolesupdate",
 	Short: "Will update the roles session duration",
+	Long: `Will set the max session duration of every role in the -f roles file, which is a roleslist report or any csv
+with the profile and role name as the first two columns, to --duration seconds.
+
+The roles are updated with the profiles in the file, so no profiles file, accounts inventory or --org is needed.`,
+	Annotations: map[string]string{noAccountsAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		err := iam.UpdateProfilesRolesSessionDuration(RolesFile, SessionDuration)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+var rolesApplyCmd = &cobra.Command{
+	Use:   "rolesapply",
+	Short: "Will apply a change set of session durations, descriptions, boundaries, tags and policies to roles",
+	Long: `Will read the --changeSet file, in yaml, json or csv, and change every role that matches it across all given accounts.
+Each change matches roles by name, or by pattern with * and ?, and can be limited to some profiles or account ids.
+A change can set the max session duration, description and permissions boundary ("none" removes it), add and remove
+tags, and attach and detach managed policies by arn, or by name for policies in the account.
+
+  changes:
+    - roles: [admin, "ci-*"]
+      profiles: [prod]
+      maxSessionDuration: 28800
+      tags:
+        owner: platform
+      attachPolicies: [arn:aws:iam::aws:policy/ReadOnlyAccess]
+
+A csv change set has a row per change with a role column, and any of the profile, maxSessionDuration, description,
+permissionsBoundary, tags, untag, attachPolicies and detachPolicies columns.  Lists are separated by |, and tags
+are key=value.
+
+Only the differences are planned.  They are printed as a diff and written to the rolesapplyplan report, and are only
+applied with --apply.  The accounts are changed in parallel, and the result of every change is written to the
+rolesapplyresults report.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		//TODO update this entire function
-		//add cli parameter for duration
-		err := iam.UpdateProfilesRolesSessionDuration(RolesFile, 28800)
+		if ChangeSetFile == "" {
+			fmt.Println("--changeSet is required")
+			return
+		}
+		changeSet, err := iam.LoadChangeSet(ChangeSetFile)
 		if err != nil {
-			panic(err)
+			fmt.Println(err)
+			return
 		}
+		runRolesChangeSet(changeSet, Apply)
 	},
 }
 
+// runRolesChangeSet will plan the change set against the roles in all accounts, print the diff, and apply it if asked
+func runRolesChangeSet(changeSet iam.ChangeSet, apply bool) {
+	profilesStates, err := utils.CollectAccounts("iam/rolestates", func(accounts []utils.AccountInfo) (iam.ProfilesRoleStates, error) {
+		return iam.GetProfilesRoleStates(accounts, changeSet)
+	}, Accounts)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	operations := iam.PlanRoleOperations(profilesStates, changeSet)
+	iam.PrintRoleOperations(os.Stdout, operations)
+	err = iam.WriteRoleOperations(operations, "rolesapplyplan")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if !apply {
+		fmt.Println(len(operations), "changes planned, review them and run again with --apply to carry them out")
+		return
+	}
+	if utils.Replaying() {
+		fmt.Println("a change set can not be applied while replaying")
+		return
+	}
+	iam.ApplyRoleOperations(operations, Accounts)
+	err = iam.WriteRoleOperations(operations, "rolesapplyresults")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+}
+
 var usersListCmd = &cobra.Command{
 	Use:   "userslist",
 	Short: "Will generate a report of users",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesUsers, err := iam.GetProfilesUsers(Accounts)
+		profilesUsers, err := utils.CollectAccounts("iam/users", iam.GetProfilesUsers, Accounts)
 		if err != nil {
 			fmt.Println("Could not get users from all profiles", err)
 			return
@@ -73,13 +155,162 @@ var usersListCmd = &cobra.Command{
 	},
 }
 
-//TODO reformat this func
+var credReportCmd = &cobra.Command{
+	Use:   "credreport",
+	Short: "Will generate a report of credential hygiene from the credential report of all given accounts",
+	Long: `Will generate the iam credential report of every account and write every user, and the root account, to one
+credreport report with a pass, fail or n/a column for each rule:
+
+  Key Age        an active access key was rotated more than --keyMaxAge days ago
+  Unused Keys    an active access key was not used in --unusedDays days, or never used
+  Console MFA    a user with a console password, or the root account, has no mfa
+  Root Keys      the root account has an active access key
+  Inactive User  a user has not logged in or used an access key in --inactiveDays days
+
+The Details column says why each rule failed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profilesReports, err := utils.CollectAccounts("iam/credreport", iam.GetProfilesCredentialReports, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		options := iam.CredReportOptions{KeyMaxAgeDays: KeyMaxAge, UnusedDays: UnusedDays, InactiveDays: InactiveDays}
+		err = iam.WriteCredentialReports(profilesReports, options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+var policyAuditCmd = &cobra.Command{
+	Use:   "policyaudit",
+	Short: "Will generate a report of risky permissions in the policies, roles and users of all given accounts",
+	Long: `Will analyze every managed policy, and every role and user with all of their inline, attached and group policies,
+and write the findings to the policyanalysis report, most severe first:
+
+  full-admin            critical, allows * or *:* on every resource
+  notaction-allow       high, allows every action except the NotAction ones, medium if not on every resource
+  passrole-wildcard     high, allows iam:PassRole on every resource
+  privilege-escalation  high, allows a known combination of actions that lets a principal give itself more permissions
+
+Escalations that are only allowed by combining the policies of a role or user are reported with the policy type combined.
+A deny on every resource without a condition removes an action from the escalations.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profilesAuthorization, err := utils.CollectAccounts("iam/authorization", iam.GetProfilesAuthorization, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		err = iam.WritePolicyFindings(profilesAuthorization)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+var trustAuditCmd = &cobra.Command{
+	Use:   "trustaudit",
+	Short: "Will generate a report of who can assume the roles of all given accounts",
+	Long: `Will decode the trust policy of every role and write every principal of its allow statements to the trustaudit
+report, classified as:
+
+  same-account   a principal in the account of the role
+  known-account  a principal in another account of the accounts inventory, or the accounts collected
+  external       a principal in any other account, a third party
+  *              any aws principal
+  service        an aws service
+  saml           a saml provider
+  oidc           an oidc or web identity provider
+
+A * principal with no condition is critical, and with a condition is high.  Third party accounts without an
+sts:ExternalId condition are high, and oidc providers with no condition on the token are high.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profilesRoles, err := utils.CollectAccounts("iam/roles", iam.GetProfilesRoles, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		options := iam.TrustOptions{KnownAccounts: iam.KnownAccounts(Accounts, profilesRoles)}
+		err = iam.WriteTrustFindings(iam.AuditTrust(profilesRoles, options))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+var unusedCmd = &cobra.Command{
+	Use:   "unused",
+	Short: "Will generate a report of unused roles and users, and the services they are granted but do not use",
+	Long: `Will get when every role and user was last used, and generate the service last accessed details of each one.
+
+The unusedprincipals report has every role and user, and if it was unused in --unusedDays days, or never used and
+older than that.  Roles use the last used date from iam, and users the last time they used any service.
+
+The unusedservices report has every service a role or user is granted but never used, or did not use in --unusedDays
+days, to trim their policies down to least privilege.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profilesAccess, err := utils.CollectAccounts("iam/access", iam.GetProfilesAccess, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		err = iam.WriteProfilesAccess(profilesAccess, iam.UnusedOptions{Days: UnusedDays})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+var policyDiffCmd = &cobra.Command{
+	Use:   "policydiff",
+	Short: "Will compare the customer managed policies of all given accounts to a golden account or a policy directory",
+	Long: `Will group the customer managed policies of every account by name, and compare the default version of each one
+to the policy with the same name in the --golden account (a profile or account id), or in the --policyDir directory
+of <policy name>.json documents, such as the ones policieslist writes and you keep in git.
+
+Documents are normalized first, so ordering, a string instead of a list, whitespace and the case of actions do not
+count as differences.  The policydiffsummary report has every policy in every account as same, different,
+policy missing or policy extra.  The policydiff report has every statement that is missing, extra, or changed with
+the same sid, along with what was added to and removed from it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		options := iam.PolicyDiffOptions{Golden: Golden, PolicyDir: PolicyDir}
+		if options.Golden == "" && options.PolicyDir == "" {
+			fmt.Println("--golden or --policyDir is required")
+			return
+		}
+		profilesPolicies, err := utils.CollectAccounts("iam/policies", iam.GetProfilesPolicies, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		drifts, err := iam.DiffPolicies(profilesPolicies, options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		err = iam.WritePolicyDrift(drifts, options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+// TODO reformat this func
 var userUpdatePWCmd = &cobra.Command{
 	Use:   "userupdatepw",
 	Short: "Will update the users password",
 	Run: func(cmd *cobra.Command, args []string) {
 		for _, account := range Accounts {
-			sess := utils.OpenSession(account.Profile, "us-east-1")
+			sess, err := account.GetSession("us-east-1")
+			if err != nil {
+				utils.LogAll("could not get session for", account.Profile, ":", err)
+				continue
+			}
 			user := iam.UserUpdate{Username: Username, ResetRequired: false}
 			password, err := iam.UpdateUserPassword(user, sess)
 			if err != nil {
@@ -99,8 +330,25 @@ func init() {
 	iamCmd.AddCommand(userUpdatePWCmd)
 	iamCmd.AddCommand(rolesListCmd)
 	iamCmd.AddCommand(rolesUpdateCmd)
+	iamCmd.AddCommand(rolesApplyCmd)
 	iamCmd.AddCommand(policiesListCmd)
+	iamCmd.AddCommand(credReportCmd)
+	iamCmd.AddCommand(policyAuditCmd)
+	iamCmd.AddCommand(trustAuditCmd)
+	iamCmd.AddCommand(unusedCmd)
+	iamCmd.AddCommand(policyDiffCmd)
 
 	RootCmd.PersistentFlags().StringVarP(&Username, "username", "u", "", "username to update")
+	credReportCmd.Flags().IntVar(&KeyMaxAge, "keyMaxAge", 90, "days since an active access key was rotated before it fails")
+	credReportCmd.Flags().IntVar(&UnusedDays, "unusedDays", 90, "days an active access key can go unused before it fails")
+	credReportCmd.Flags().IntVar(&InactiveDays, "inactiveDays", 90, "days a user can go without a login or key use before it fails")
+
+	rolesUpdateCmd.Flags().Int64Var(&SessionDuration, "duration", 28800, "max session duration to set, in seconds")
+	rolesApplyCmd.Flags().StringVar(&ChangeSetFile, "changeSet", "", "yaml, json or csv file of the changes to make to the roles")
+	rolesApplyCmd.Flags().BoolVar(&Apply, "apply", false, "apply the changes, instead of only showing them")
+	policyDiffCmd.Flags().StringVar(&Golden, "golden", "", "profile or account id to compare every other account to")
+	policyDiffCmd.Flags().StringVar(&PolicyDir, "policyDir", "", "directory of <policy name>.json documents to compare every account to")
+	unusedCmd.Flags().IntVar(&UnusedDays, "unusedDays", 90, "days a role, user or service can go unused before it is reported")
+
 	RootCmd.PersistentFlags().StringVarP(&RolesFile, "rolesfile", "f", "", "list of roles to update")
 }
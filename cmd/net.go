@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/afeeblechild/aws-go-tool/lib/network"
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	Matches []string
+)
+
+var netCmd = &cobra.Command{
+	Use:   "net",
+	Short: "For use with searching the network resources across services",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("Run -h to see the help menu")
+	},
+}
+
+var netSearchCmd = &cobra.Command{
+	Use:   "search <ip or cidr>...",
+	Short: "Will generate a report of every resource with a cidr that overlaps the given ips or cidrs, for all given accounts.",
+	Long: `Will search the vpc cidrs, subnets, route table routes, network acl entries and security group rules for
+cidrs that contain the given ip or cidr, are inside it, or are the same.  Both ipv4 and ipv6 are supported.
+
+The Match column is contains when the cidr found contains the search, such as 10.0.0.0/8 for 10.1.2.3,
+and contained when the cidr found is inside the search, such as 10.1.2.0/24 for 10.0.0.0/8.`,
+	Args: cobra.MinimumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		for _, match := range Matches {
+			switch match {
+			case utils.MatchEqual, utils.MatchContains, utils.MatchContained:
+			default:
+				return fmt.Errorf("invalid --match %q, needs to be one of: %s, %s, %s", match, utils.MatchEqual, utils.MatchContains, utils.MatchContained)
+			}
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, arg := range args {
+			if _, err := utils.ParseCidr(arg); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		profilesNetwork, err := utils.CollectAccounts("net/network", network.GetProfilesNetwork, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		options := network.SearchOptions{Matches: Matches}
+		var results []network.SearchResult
+		for _, arg := range args {
+			found, err := network.Search(profilesNetwork, arg, options)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Println("Found", len(found), "resources for", arg)
+			results = append(results, found...)
+		}
+		err = network.WriteSearchResults(results)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(netCmd)
+
+	netCmd.AddCommand(netSearchCmd)
+
+	netSearchCmd.Flags().StringSliceVar(&Matches, "match", nil, "only report these matches: equal, contains, contained")
+}
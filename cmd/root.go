@@ -28,32 +28,82 @@ var (
 	cfgFile string
 
 	// Input flags
-	AccessType   string
-	OutputDir    string
-	ProfilesFile string
-	TagFile      string
+	AccessType       string
+	CredentialSource string
+	ExcludeRegions   []string
+	InventoryFile    string
+	MaxAccounts      int
+	MaxErrors        int
+	MaxRegions       int
+	MaxRetries       int
+	NameTemplate     string
+	Org              bool
+	OrgExternalId    string
+	OrgOus           []string
+	OrgProfile       string
+	OrgRole          string
+	OrgTags          map[string]string
+	OutputDir        string
+	OutputFormat     string
+	Overwrite        bool
+	ProfilesFile     string
+	RateLimit        float64
+	RecordDir        string
+	Regions          []string
+	ReplayDir        string
+	TagFile          string
 
 	// Set in init()
 	LogFile *os.File
 
-	// Set in RootCmd.PersistentPostRun
+	// Set in RootCmd.PersistentPreRun
 	Accounts []utils.AccountInfo
-	Tags []string
+	Tags     []string
 )
 
+// noAccountsAnnotation marks a command that finds its own accounts, so it is not given any by the root command
+const noAccountsAnnotation = "noAccounts"
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "aws-go-tool",
 	Short: "aws-go-tool is an interface to use with aws accounts",
 	Long: `The tool is designed around reporting and interacting with multiple aws accounts.
 There are some parts of the tool that are just for single accounts as well.`,
-	PersistentPostRun: func(cmd *cobra.Command, args []string){
-		var err error
-		Accounts, err = utils.BuildAccountsSlice(ProfilesFile, AccessType)
-		if err != nil {
-			utils.LogAll("error building accounts slice:", err)
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := utils.SetOutputFormat(OutputFormat); err != nil {
+			utils.LogAll(err)
+			os.Exit(1)
+		}
+		if err := utils.Output.SetNameTemplate(NameTemplate); err != nil {
+			utils.LogAll(err)
 			os.Exit(1)
 		}
+		utils.Output.Dir = OutputDir
+		utils.Output.Overwrite = Overwrite
+		utils.IncludeRegions = Regions
+		utils.ExcludeRegions = ExcludeRegions
+		utils.MaxAccounts = MaxAccounts
+		utils.MaxRegions = MaxRegions
+		utils.MaxRetries = MaxRetries
+		utils.RequestsPerSecond = RateLimit
+		if RecordDir != "" && ReplayDir != "" {
+			utils.LogAll("--record and --replay can not be used together")
+			os.Exit(1)
+		}
+		utils.RecordDir = RecordDir
+		utils.ReplayDir = ReplayDir
+
+		var err error
+		// a replay never calls aws, so no accounts are needed
+		if !utils.Replaying() && cmd.Annotations[noAccountsAnnotation] == "" {
+			Accounts, err = buildAccounts()
+			if err != nil {
+				utils.LogAll("error building accounts slice:", err)
+				os.Exit(1)
+			}
+			utils.SetAccountLabels(Accounts)
+		}
 
 		if TagFile != "" {
 			Tags, err = utils.ReadFile(TagFile)
@@ -64,6 +114,50 @@ There are some parts of the tool that are just for single accounts as well.`,
 	},
 }
 
+// buildAccounts will build the accounts from the profiles file and the accounts inventory
+// Both can be passed in, the accounts from each are added together
+func buildAccounts() ([]utils.AccountInfo, error) {
+	if ProfilesFile == "" && InventoryFile == "" && !Org {
+		return nil, fmt.Errorf("either a profiles file (-p), an accounts inventory (-i) or --org is required")
+	}
+
+	var accounts []utils.AccountInfo
+	if ProfilesFile != "" {
+		profileAccounts, err := utils.BuildAccountsSlice(ProfilesFile, AccessType)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, profileAccounts...)
+	}
+	if InventoryFile != "" {
+		inventoryAccounts, err := utils.LoadAccountsInventory(InventoryFile, AccessType, CredentialSource)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, inventoryAccounts...)
+	}
+	if Org {
+		options := utils.OrgOptions{
+			Management: utils.AccountInfo{AccessType: AccessType, Profile: OrgProfile, CredentialSource: CredentialSource},
+			RoleName:   OrgRole,
+			ExternalId: OrgExternalId,
+			OuIds:      OrgOus,
+			Tags:       OrgTags,
+		}
+		orgAccounts, err := utils.DiscoverOrgAccounts(options)
+		if err != nil {
+			return nil, fmt.Errorf("could not discover the organization accounts: %w", err)
+		}
+		accounts = append(accounts, orgAccounts...)
+	}
+	for i := range accounts {
+		if accounts[i].AccessType == "source" && accounts[i].CredentialSource == "" {
+			accounts[i].CredentialSource = CredentialSource
+		}
+	}
+	return accounts, nil
+}
+
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -72,6 +166,28 @@ func Execute() {
 		fmt.Println(err)
 		os.Exit(-1)
 	}
+	if !reportErrors() {
+		os.Exit(2)
+	}
+}
+
+// reportErrors will print a summary of any failures in the run, and write them to errors.csv
+// Returns false if there were more failures than MaxErrors allows
+func reportErrors() bool {
+	count := utils.Errors.Count()
+	if count == 0 {
+		return true
+	}
+
+	utils.Errors.PrintSummary()
+	if err := utils.Errors.WriteReport(); err != nil {
+		utils.LogAll("could not write errors report:", err)
+	}
+	if MaxErrors >= 0 && count > MaxErrors {
+		utils.LogAll("there were", count, "failures, more than the", MaxErrors, "allowed by --maxErrors")
+		return false
+	}
+	return true
 }
 
 func init() {
@@ -86,10 +202,30 @@ func init() {
 	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	//TODO add flag checks to ensure the required flags are set
-	RootCmd.PersistentFlags().StringVarP(&AccessType, "accessType", "a", "", "either assume, profile, instance, or instanceassume")
+	RootCmd.PersistentFlags().StringVarP(&AccessType, "accessType", "a", "", "either assume, profile, instance, instanceassume, profileassume, or source")
+	RootCmd.PersistentFlags().StringVar(&CredentialSource, "credentialSource", "", "credential source from the config file used by accounts with the source access type")
 	RootCmd.PersistentFlags().StringVarP(&ProfilesFile, "profilesFile", "p", "", "file with list of account profiles")
+	RootCmd.PersistentFlags().StringVarP(&InventoryFile, "inventory", "i", "", "yaml or json accounts inventory file, with settings per account")
+	RootCmd.PersistentFlags().BoolVar(&Org, "org", false, "discover the accounts with aws organizations, using the -a access type and --orgProfile")
+	RootCmd.PersistentFlags().StringVar(&OrgProfile, "orgProfile", "", "profile of the management or delegated admin account used with --org")
+	RootCmd.PersistentFlags().StringVar(&OrgRole, "orgRole", utils.DefaultOrgRoleName, "role name assumed into every account found with --org")
+	RootCmd.PersistentFlags().StringVar(&OrgExternalId, "orgExternalId", "", "external id used when assuming the --orgRole")
+	RootCmd.PersistentFlags().StringSliceVar(&OrgOus, "orgOus", nil, "comma separated ou ids to limit --org to, including any ou below them")
+	RootCmd.PersistentFlags().StringToStringVar(&OrgTags, "orgTags", nil, "comma separated key=value account tags to limit --org to")
+	RootCmd.PersistentFlags().IntVar(&MaxErrors, "maxErrors", 0, "exit with a non zero code if there are more failures than this, -1 to never fail")
+	RootCmd.PersistentFlags().StringSliceVar(&Regions, "regions", nil, "comma separated regions to limit every account to, by default all enabled regions are used")
+	RootCmd.PersistentFlags().StringSliceVar(&ExcludeRegions, "excludeRegions", nil, "comma separated regions to skip in every account")
+	RootCmd.PersistentFlags().IntVar(&MaxAccounts, "max-accounts", utils.MaxAccounts, "how many accounts to collect from at once")
+	RootCmd.PersistentFlags().IntVar(&MaxRegions, "max-regions", utils.MaxRegions, "how many regions to collect from at once, per account")
+	RootCmd.PersistentFlags().Float64Var(&RateLimit, "rateLimit", utils.RequestsPerSecond, "api calls per second allowed per service, account and region, 0 for no limit")
+	RootCmd.PersistentFlags().IntVar(&MaxRetries, "maxRetries", utils.MaxRetries, "how many times a throttled or failed api call is retried")
+	RootCmd.PersistentFlags().StringVar(&RecordDir, "record", "", "directory to save every collected result to, so the reports can be replayed later")
+	RootCmd.PersistentFlags().StringVar(&ReplayDir, "replay", "", "directory of results saved with --record to write the reports from, without calling aws")
 	RootCmd.PersistentFlags().StringVarP(&TagFile, "tagFile", "g", "", "file with list of tags to add to output")
-	RootCmd.PersistentFlags().StringVarP(&OutputDir, "outputDir", "o", "", "directory for script output")
+	RootCmd.PersistentFlags().StringVarP(&OutputDir, "outputDir", "o", "output", "directory for script output")
+	RootCmd.PersistentFlags().StringVar(&NameTemplate, "nameTemplate", utils.DefaultNameTemplate, "template for output file names, relative to the output directory")
+	RootCmd.PersistentFlags().BoolVar(&Overwrite, "overwrite", false, "overwrite existing output files instead of adding a number to the file name")
+	RootCmd.PersistentFlags().StringVar(&OutputFormat, "format", utils.FormatCsv, "report format, either csv, json, ndjson, markdown, or xlsx")
 
 	//Create output directory
 	//utils.Dir("output")
@@ -118,4 +254,14 @@ func initConfig() {
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
+
+	var sources map[string]utils.CredentialSource
+	if err := viper.UnmarshalKey("credentialSources", &sources); err != nil {
+		utils.LogAll("could not read credentialSources from the config file:", err)
+		os.Exit(1)
+	}
+	if err := utils.SetCredentialSources(sources); err != nil {
+		utils.LogAll(err)
+		os.Exit(1)
+	}
 }
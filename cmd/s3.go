@@ -20,7 +20,7 @@ var bucketsListCmd = &cobra.Command{
 	Use:   "bucketslist",
 	Short: "Will generate a report of bucket info for all given accounts",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesBuckets, err := s3.GetProfilesBuckets(Accounts)
+		profilesBuckets, err := utils.CollectAccounts("s3/buckets", s3.GetProfilesBuckets, Accounts)
 		if err != nil {
 			utils.LogAll("could not get buckets:", err)
 			return
@@ -40,7 +40,7 @@ var fileSizeCmd = &cobra.Command{
 	Short: "To get the size of objects in buckets per object type",
 	Run: func(cmd *cobra.Command, args []string) {
 		if BucketFile == "public-only" {
-			bucketsInfo, err := s3.GetProfilesPublicBucketsFileSize(Accounts, "public-only")
+			bucketsInfo, err := collectPublicBucketsFileSize()
 
 			if err != nil {
 				utils.LogAll("could not get profiles buckets:", err)
@@ -48,7 +48,7 @@ var fileSizeCmd = &cobra.Command{
 			}
 			s3.WriteProfilesBucketsFileSize(bucketsInfo)
 		} else if BucketFile == "all" {
-			bucketsInfo, err := s3.GetProfilesPublicBucketsFileSize(Accounts, "public-only")
+			bucketsInfo, err := collectPublicBucketsFileSize()
 
 			if err != nil {
 				utils.LogAll("could not get profiles buckets:", err)
@@ -68,7 +68,9 @@ var fileSizeCmd = &cobra.Command{
 				bucketsInfo = append(bucketsInfo, bucketInfo)
 			}
 
-			bucketsSizeInfo, err := s3.GetProfileBucketsFileSize(bucketsInfo, Accounts[0])
+			bucketsSizeInfo, err := utils.Collect("s3/filesize", func() ([]*s3.BucketSizeInfo, error) {
+				return s3.GetProfileBucketsFileSize(bucketsInfo, Accounts[0])
+			})
 			if err != nil {
 				utils.LogAll("could not get buckets size info:", err)
 				return
@@ -88,6 +90,13 @@ var fileSizeCmd = &cobra.Command{
 
 var BucketFile string
 
+// collectPublicBucketsFileSize will get the file sizes of the public buckets in all accounts, or replay them
+func collectPublicBucketsFileSize() ([]*s3.BucketSizeInfo, error) {
+	return utils.Collect("s3/publicfilesize", func() ([]*s3.BucketSizeInfo, error) {
+		return s3.GetProfilesPublicBucketsFileSize(Accounts, "public-only")
+	})
+}
+
 func init() {
 	RootCmd.AddCommand(s3Cmd)
 
@@ -3,10 +3,18 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/afeeblechild/aws-go-tool/lib/vpc"
 	"github.com/spf13/cobra"
 )
 
+var (
+	Supernet       string
+	CidrSize       int
+	CidrCount      int
+	IncludeDefault bool
+)
+
 var vpcCmd = &cobra.Command{
 	Use:   "vpc",
 	Short: "For use with interacting with the vpc service",
@@ -19,7 +27,7 @@ var subnetsListCmd = &cobra.Command{
 	Use:   "subnetslist",
 	Short: "Will generate a report of vpc info for all given accounts",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesSubnets, err := vpc.GetProfilesSubnets(Accounts)
+		profilesSubnets, err := utils.CollectAccounts("vpc/subnets", vpc.GetProfilesSubnets, Accounts)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -37,7 +45,7 @@ var vpcsListCmd = &cobra.Command{
 	Use:   "vpcslist",
 	Short: "Will generate a report of vpc info for all given accounts",
 	Run: func(cmd *cobra.Command, args []string) {
-		profilesVpcs, err := vpc.GetProfilesVpcs(Accounts)
+		profilesVpcs, err := utils.CollectAccounts("vpc/vpcs", vpc.GetProfilesVpcs, Accounts)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -51,9 +59,74 @@ var vpcsListCmd = &cobra.Command{
 	},
 }
 
+var ipamCmd = &cobra.Command{
+	Use:   "ipam",
+	Short: "Will generate a report of overlapping vpc cidrs and subnet ip usage, and suggest free cidrs, for all given accounts",
+	Long: `Will find every pair of vpcs with overlapping cidrs across all accounts and regions, which can not be peered or
+attached to the same transit gateway, and write them to the vpcoverlaps report.  The default vpcs all have the same
+cidr, so they are skipped unless --includeDefault is passed.
+
+The subnetusage report has the usable ips of every subnet, which is its size less the 5 ips aws reserves,
+along with the available and used ips and the utilization.
+
+With --supernet, the freecidrs report suggests --count blocks of --size in the supernet that no vpc cidr overlaps.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if Supernet != "" {
+			if _, err := utils.ParseCidr(Supernet); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		profilesVpcs, err := utils.CollectAccounts("vpc/vpcs", vpc.GetProfilesVpcs, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		options := vpc.IpamOptions{Supernet: Supernet, Size: CidrSize, Count: CidrCount, IncludeDefault: IncludeDefault}
+		err = vpc.WriteIpam(profilesVpcs, options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Will export the network topology of all given accounts as a graphviz dot file and a json graph",
+	Long: `Will gather the vpcs, subnets, route tables, internet and nat gateways, peering connections, transit gateway
+attachments and vpc endpoints of all given accounts, and write them as nodes and edges to vpc/graph.dot and vpc/graph.json.
+
+The dot file has a cluster for each account, each region in it, and each vpc in that. Transit gateways and peering
+connections can span accounts, so they are outside of every cluster. Render it with graphviz, such as:
+  dot -Tsvg vpc/graph.dot -o graph.svg
+
+Edges are subnets in their vpc, subnets to the route table they use, route tables to each route target labelled with
+the destination, gateways to their vpc or subnet, and vpcs to their peering connections and transit gateways.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profilesTopology, err := utils.CollectAccounts("vpc/topology", vpc.GetProfilesTopology, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		err = vpc.WriteGraph(vpc.BuildGraph(profilesTopology))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
 func init() {
 	RootCmd.AddCommand(vpcCmd)
 
 	vpcCmd.AddCommand(vpcsListCmd)
 	vpcCmd.AddCommand(subnetsListCmd)
+	vpcCmd.AddCommand(ipamCmd)
+	vpcCmd.AddCommand(graphCmd)
+
+	ipamCmd.Flags().StringVar(&Supernet, "supernet", "", "ipv4 cidr to suggest free blocks from, such as 10.0.0.0/8")
+	ipamCmd.Flags().IntVar(&CidrSize, "size", 20, "prefix length of the free blocks to suggest, such as 20 for /20 blocks")
+	ipamCmd.Flags().IntVar(&CidrCount, "count", 10, "how many free blocks to suggest")
+	ipamCmd.Flags().BoolVar(&IncludeDefault, "includeDefault", false, "include the default vpcs in the overlaps")
 }
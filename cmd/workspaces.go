@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/afeeblechild/aws-go-tool/lib/workspace"
+	"github.com/spf13/cobra"
+)
+
+var (
+	IdleDays int
+)
+
+var workspacesCmd = &cobra.Command{
+	Use:   "workspaces",
+	Short: "For use with interacting with the workspaces service",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("Run -h to see the help menu")
+	},
+}
+
+var workspacesListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"workspaceslist"},
+	Short:   "Will generate a report of all workspaces for all given accounts.",
+	Run: func(cmd *cobra.Command, args []string) {
+		profilesWorkspaces, err := utils.CollectAccounts("workspaces/workspaces", workspace.GetProfilesWorkspaces, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		options := workspace.WorkspaceOptions{Tags: Tags}
+		err = workspace.WriteProfilesWorkspaces(profilesWorkspaces, options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+var workspacesIdleCmd = &cobra.Command{
+	Use:   "idle",
+	Short: "Will generate a report of the last user connection of all workspaces, to find idle workspaces.",
+	Run: func(cmd *cobra.Command, args []string) {
+		profilesWorkspaces, err := utils.CollectAccounts("workspaces/workspaces", workspace.GetProfilesWorkspaces, Accounts)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		options := workspace.WorkspaceOptions{Tags: Tags, IdleDays: IdleDays}
+		err = workspace.WriteProfilesWorkspacesIdle(profilesWorkspaces, options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(workspacesCmd)
+
+	workspacesCmd.AddCommand(workspacesListCmd)
+	workspacesCmd.AddCommand(workspacesIdleCmd)
+
+	workspacesIdleCmd.Flags().IntVar(&IdleDays, "idleDays", 30, "days without a user connection before a workspace is idle")
+}
@@ -0,0 +1,41 @@
+// Package testutil has the helpers the package tests share
+package testutil
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+)
+
+// ReplayReport will replay the recordings in the replay directory, and write the reports as csv to a temporary
+// directory until the test ends
+// It returns a function to read a report back from that directory
+func ReplayReport(t *testing.T, replayDir string) func(path string) [][]string {
+	t.Helper()
+	dir := t.TempDir()
+	oldReplayDir, outputDir, overwrite, format := utils.ReplayDir, utils.Output.Dir, utils.Output.Overwrite, utils.OutputFormat
+	utils.ReplayDir = replayDir
+	utils.Output.Dir = dir
+	utils.Output.Overwrite = true
+	utils.OutputFormat = utils.FormatCsv
+	t.Cleanup(func() {
+		utils.ReplayDir, utils.Output.Dir, utils.Output.Overwrite, utils.OutputFormat = oldReplayDir, outputDir, overwrite, format
+	})
+
+	return func(path string) [][]string {
+		t.Helper()
+		file, err := os.Open(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatalf("the report was not written: %v", err)
+		}
+		defer file.Close()
+		rows, err := csv.NewReader(file).ReadAll()
+		if err != nil {
+			t.Fatalf("could not read %s: %v", path, err)
+		}
+		return rows
+	}
+}
@@ -0,0 +1,502 @@
+package ec2
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// CleanupTagKey is the tag a resource is marked with when it is waiting out the grace period, the value is the date it was marked
+const CleanupTagKey = "aws-go-tool:cleanup"
+
+// finalSnapshotTimeout is how long to wait for a final snapshot to complete, checking every finalSnapshotDelay
+const (
+	finalSnapshotTimeout = 6 * time.Hour
+	finalSnapshotDelay   = 30 * time.Second
+)
+
+// Cleanup actions
+const (
+	ActionDelete = "delete" // delete the resource now
+	ActionMark   = "mark"   // tag the resource with CleanupTagKey to start the grace period
+	ActionWait   = "wait"   // the resource is marked and still in its grace period, nothing is done
+	ActionKeep   = "keep"   // the resource is kept, it is in the plan only to show why
+)
+
+type (
+	// RegionCleanup is everything in a region needed to find orphaned volumes and snapshots
+	RegionCleanup struct {
+		Profile   string
+		AccountId string
+		Region    string
+		Volumes   []ec2.Volume
+		Snapshots []ec2.Snapshot
+		Images    []ec2.Image
+	}
+
+	AccountCleanup  []RegionCleanup
+	ProfilesCleanup []AccountCleanup
+
+	CleanupOptions struct {
+		// OlderThanDays is how old an unattached volume or orphaned snapshot needs to be before it is cleaned up
+		OlderThanDays int
+		// GraceDays is how long a resource is marked with CleanupTagKey before it is deleted, 0 deletes right away
+		GraceDays int
+		// FinalSnapshot takes a snapshot of each volume before deleting it
+		FinalSnapshot bool
+		// MarkTags are added to every resource along with CleanupTagKey when it is marked
+		MarkTags map[string]string
+	}
+
+	// CleanupAction is one resource in a CleanupPlan, and what will be done to it
+	CleanupAction struct {
+		Profile      string
+		AccountId    string
+		Region       string
+		ResourceType string // volume, snapshot, image, or securitygroup
+		ResourceId   string
+		Name         string
+		Reason       string
+		SizeGiB      int64
+		Created      time.Time
+		MarkedAt     string   `json:",omitempty"`
+		Snapshots    []string `json:",omitempty"` // the snapshots deleted along with an image
+		Action       string
+		Result       string `json:",omitempty"` // set once the plan is applied
+	}
+
+	// CleanupPlan is the reviewable list of actions, which is only carried out with ApplyCleanupPlan
+	CleanupPlan struct {
+		Name    string // the name of the plan reports, such as cleanup
+		Created time.Time
+		Options CleanupOptions
+		Actions []CleanupAction
+	}
+)
+
+// GetRegionCleanup will get the volumes, snapshots and images of the account in the region
+// Any failure fails the whole region, so nothing is seen as orphaned because a call failed
+func GetRegionCleanup(sess *session.Session, accountId string) (RegionCleanup, error) {
+	var info RegionCleanup
+	var err error
+	info.Volumes, err = GetRegionVolumes(sess)
+	if err != nil {
+		return info, fmt.Errorf("could not describe volumes: %w", err)
+	}
+
+	var snapshots RegionSnapshots
+	if err = snapshots.GetRegionSnapshots(sess, accountId); err != nil {
+		return info, fmt.Errorf("could not describe snapshots: %w", err)
+	}
+	info.Snapshots = snapshots.Snapshots
+
+	var images RegionImages
+	if err = images.GetRegionImages(sess, accountId); err != nil {
+		return info, fmt.Errorf("could not describe images: %w", err)
+	}
+	info.Images = images.Images
+	return info, nil
+}
+
+// GetAccountCleanup will take a profile and go through all regions to get the cleanup resources in the account
+func GetAccountCleanup(account utils.AccountInfo) (AccountCleanup, error) {
+	fmt.Println("Getting volumes, snapshots and images for profile:", account.Profile)
+	cleanupChan := make(chan RegionCleanup)
+
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
+			sess, err := account.GetSession(region)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "GetSession", err)
+				return
+			}
+			info, err := GetRegionCleanup(sess, account.AccountId)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "Describe", err)
+				return
+			}
+			info.Profile = account.Profile
+			info.AccountId = account.AccountId
+			info.Region = region
+			cleanupChan <- info
+		})
+		close(cleanupChan)
+	}()
+
+	var accountCleanup AccountCleanup
+	for regionCleanup := range cleanupChan {
+		accountCleanup = append(accountCleanup, regionCleanup)
+	}
+	return accountCleanup, nil
+}
+
+// GetProfilesCleanup will get the cleanup resources in all given accounts
+func GetProfilesCleanup(accounts []utils.AccountInfo) (ProfilesCleanup, error) {
+	profilesCleanupChan := make(chan AccountCleanup)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			accountCleanup, err := GetAccountCleanup(account)
+			if err != nil {
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
+				return
+			}
+			profilesCleanupChan <- accountCleanup
+		})
+		close(profilesCleanupChan)
+	}()
+
+	var profilesCleanup ProfilesCleanup
+	for accountCleanup := range profilesCleanupChan {
+		profilesCleanup = append(profilesCleanup, accountCleanup)
+	}
+	return profilesCleanup, nil
+}
+
+// BuildCleanupPlan will find the unattached volumes, and the snapshots whose volume and image no longer exist
+// Only resources older than options.OlderThanDays are added to the plan
+func BuildCleanupPlan(profilesCleanup ProfilesCleanup, options CleanupOptions, now time.Time) CleanupPlan {
+	plan := CleanupPlan{Name: "cleanup", Created: now, Options: options}
+	cutoff := now.AddDate(0, 0, -options.OlderThanDays)
+
+	for _, accountCleanup := range profilesCleanup {
+		for _, regionCleanup := range accountCleanup {
+			volumeIds := make(map[string]bool)
+			for _, volume := range regionCleanup.Volumes {
+				volumeIds[*volume.VolumeId] = true
+				if aws.StringValue(volume.State) != ec2.VolumeStateAvailable || volume.CreateTime == nil || volume.CreateTime.After(cutoff) {
+					continue
+				}
+				action := CleanupAction{
+					ResourceType: "volume",
+					ResourceId:   *volume.VolumeId,
+					Name:         utils.NameTag(volume.Tags),
+					Reason:       "unattached volume",
+					SizeGiB:      aws.Int64Value(volume.Size),
+					Created:      *volume.CreateTime,
+				}
+				plan.addAction(regionCleanup, action, volume.Tags, now)
+			}
+
+			imageSnapshots := make(map[string]string)
+			for _, image := range regionCleanup.Images {
+				for _, snapshotId := range imageSnapshotIds(image) {
+					imageSnapshots[snapshotId] = *image.ImageId
+				}
+			}
+			for _, snapshot := range regionCleanup.Snapshots {
+				snapshotId := *snapshot.SnapshotId
+				if volumeIds[aws.StringValue(snapshot.VolumeId)] || imageSnapshots[snapshotId] != "" {
+					continue
+				}
+				if snapshot.StartTime == nil || snapshot.StartTime.After(cutoff) {
+					continue
+				}
+				reason := "volume " + aws.StringValue(snapshot.VolumeId) + " no longer exists"
+				if strings.Contains(aws.StringValue(snapshot.Description), "CreateImage") {
+					reason += ", and its image was deregistered"
+				}
+				action := CleanupAction{
+					ResourceType: "snapshot",
+					ResourceId:   snapshotId,
+					Name:         utils.NameTag(snapshot.Tags),
+					Reason:       reason,
+					SizeGiB:      aws.Int64Value(snapshot.VolumeSize),
+					Created:      *snapshot.StartTime,
+				}
+				plan.addAction(regionCleanup, action, snapshot.Tags, now)
+			}
+		}
+	}
+	plan.sort()
+	return plan
+}
+
+// addAction will add the action to the plan, deciding between deleting, marking and waiting from the grace period
+func (plan *CleanupPlan) addAction(region RegionCleanup, action CleanupAction, tags []*ec2.Tag, now time.Time) {
+	action.Profile = region.Profile
+	action.AccountId = region.AccountId
+	action.Region = region.Region
+	action.Action = ActionDelete
+	if plan.Options.GraceDays > 0 {
+		action.MarkedAt = utils.TagValue(tags, CleanupTagKey)
+		markedAt, err := time.Parse(time.RFC3339, action.MarkedAt)
+		switch {
+		case action.MarkedAt == "" || err != nil:
+			action.Action = ActionMark
+		case markedAt.AddDate(0, 0, plan.Options.GraceDays).After(now):
+			action.Action = ActionWait
+		}
+	}
+	plan.Actions = append(plan.Actions, action)
+}
+
+// sort will order the actions by profile, region, type and id, so plans can be diffed between runs
+func (plan *CleanupPlan) sort() {
+	sort.SliceStable(plan.Actions, func(i, j int) bool {
+		a, b := plan.Actions[i], plan.Actions[j]
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		if a.ResourceType != b.ResourceType {
+			return a.ResourceType < b.ResourceType
+		}
+		return a.ResourceId < b.ResourceId
+	})
+}
+
+// imageSnapshotIds will return the ebs snapshots backing the image
+func imageSnapshotIds(image ec2.Image) []string {
+	var snapshotIds []string
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			snapshotIds = append(snapshotIds, *mapping.Ebs.SnapshotId)
+		}
+	}
+	return snapshotIds
+}
+
+// WriteCleanupPlan will write the plan as json, so it can be reviewed and applied later with --plan
+// A report of the actions is also written in the output format
+func WriteCleanupPlan(plan CleanupPlan) error {
+	file, err := utils.Output.Create("ec2", plan.Name+"plan", utils.FormatJson)
+	if err != nil {
+		return fmt.Errorf("could not create plan file: %v", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(plan); err != nil {
+		return fmt.Errorf("could not write plan file: %v", err)
+	}
+	fmt.Println("Writing", plan.Name, "plan to file:", file.Name())
+	return WriteCleanupActions(plan, plan.Name)
+}
+
+// ReadCleanupPlan will read a plan written by WriteCleanupPlan
+func ReadCleanupPlan(path string) (CleanupPlan, error) {
+	var plan CleanupPlan
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return plan, err
+	}
+	if err = json.Unmarshal(data, &plan); err != nil {
+		return plan, fmt.Errorf("could not read plan %s: %v", path, err)
+	}
+	return plan, nil
+}
+
+// WriteCleanupActions will write a report of the actions in the plan, along with their results if it was applied
+func WriteCleanupActions(plan CleanupPlan, name string) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Region",
+		"Resource Type",
+		"Resource ID",
+		"Name",
+		"Reason",
+		"Size (GiB)",
+		"Created",
+		"Marked At",
+		"Snapshots",
+		"Action",
+		"Result",
+	}
+
+	report := utils.NewReport("ec2", name, columnTitles)
+	for _, action := range plan.Actions {
+		var created string
+		if !action.Created.IsZero() {
+			created = action.Created.Format("2006-01-02")
+		}
+		var data = []string{action.Profile,
+			action.AccountId,
+			action.Region,
+			action.ResourceType,
+			action.ResourceId,
+			action.Name,
+			action.Reason,
+			strconv.FormatInt(action.SizeGiB, 10),
+			created,
+			action.MarkedAt,
+			strings.Join(action.Snapshots, "|"),
+			action.Action,
+			action.Result,
+		}
+		report.AddRow(data)
+	}
+	return report.Write()
+}
+
+// ApplyCleanupPlan will carry out every action in the plan with the matching account, and set the result of each one
+// The accounts are changed in parallel, and the regions of each account in parallel, with the actions of each region in order
+// Actions for accounts that are not given are skipped
+func ApplyCleanupPlan(plan *CleanupPlan, accounts []utils.AccountInfo) {
+	byAccount := make(map[string]map[string][]int)
+	var accountOrder []string
+	regionOrder := make(map[string][]string)
+	for i, action := range plan.Actions {
+		key := action.Profile + "/" + action.AccountId
+		if _, ok := byAccount[key]; !ok {
+			byAccount[key] = make(map[string][]int)
+			accountOrder = append(accountOrder, key)
+		}
+		if _, ok := byAccount[key][action.Region]; !ok {
+			regionOrder[key] = append(regionOrder[key], action.Region)
+		}
+		byAccount[key][action.Region] = append(byAccount[key][action.Region], i)
+	}
+
+	var mu sync.Mutex
+	utils.ForEach(len(accountOrder), utils.MaxAccounts, func(a int) {
+		key := accountOrder[a]
+		utils.ForEachRegion(regionOrder[key], func(region string) {
+			for _, i := range byAccount[key][region] {
+				action := plan.Actions[i]
+				result := "skipped"
+				if action.Action == ActionDelete || action.Action == ActionMark {
+					result = applyCleanupAction(action, plan.Options, accounts)
+				}
+				mu.Lock()
+				plan.Actions[i].Result = result
+				mu.Unlock()
+			}
+		})
+	})
+}
+
+// applyCleanupAction will carry out one action, and return the result
+func applyCleanupAction(action CleanupAction, options CleanupOptions, accounts []utils.AccountInfo) string {
+	account, ok := utils.FindAccount(accounts, action.Profile, action.AccountId)
+	if !ok {
+		return "skipped, account " + action.Profile + " was not given"
+	}
+	sess, err := account.GetSession(action.Region)
+	if err != nil {
+		utils.RecordError(account, action.Region, "ec2", "GetSession", err)
+		return "failed: " + err.Error()
+	}
+	svc := ec2.New(sess)
+
+	if action.Action == ActionMark {
+		err = markResource(svc, action.ResourceId, options.MarkTags)
+		if err != nil {
+			utils.RecordError(account, action.Region, "ec2", "CreateTags "+action.ResourceId, err)
+			return "failed: " + err.Error()
+		}
+		fmt.Println("Marked", action.ResourceType, action.ResourceId, "in", action.Profile, action.Region)
+		return "marked"
+	}
+
+	result := "deleted"
+	switch action.ResourceType {
+	case "volume":
+		if options.FinalSnapshot {
+			snapshotId, err := finalSnapshot(svc, action)
+			if err != nil {
+				utils.RecordError(account, action.Region, "ec2", "CreateSnapshot "+action.ResourceId, err)
+				return "failed: " + err.Error()
+			}
+			result = "deleted, final snapshot " + snapshotId
+		}
+		_, err = svc.DeleteVolume(&ec2.DeleteVolumeInput{VolumeId: aws.String(action.ResourceId)})
+		if err != nil {
+			utils.RecordError(account, action.Region, "ec2", "DeleteVolume "+action.ResourceId, err)
+			return "failed: " + err.Error()
+		}
+	case "snapshot":
+		_, err = svc.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: aws.String(action.ResourceId)})
+		if err != nil {
+			utils.RecordError(account, action.Region, "ec2", "DeleteSnapshot "+action.ResourceId, err)
+			return "failed: " + err.Error()
+		}
+	case "securitygroup":
+		_, err = svc.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(action.ResourceId)})
+		if err != nil {
+			utils.RecordError(account, action.Region, "ec2", "DeleteSecurityGroup "+action.ResourceId, err)
+			return "failed: " + err.Error()
+		}
+	case "image":
+		_, err = svc.DeregisterImage(&ec2.DeregisterImageInput{ImageId: aws.String(action.ResourceId)})
+		if err != nil {
+			utils.RecordError(account, action.Region, "ec2", "DeregisterImage "+action.ResourceId, err)
+			return "failed: " + err.Error()
+		}
+		result = "deregistered"
+		for _, snapshotId := range action.Snapshots {
+			_, err = svc.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: aws.String(snapshotId)})
+			if err != nil {
+				utils.RecordError(account, action.Region, "ec2", "DeleteSnapshot "+snapshotId, err)
+				result += ", could not delete snapshot " + snapshotId + ": " + err.Error()
+			} else {
+				result += ", deleted snapshot " + snapshotId
+			}
+		}
+	default:
+		return "skipped, unknown resource type " + action.ResourceType
+	}
+	fmt.Println("Deleted", action.ResourceType, action.ResourceId, "in", action.Profile, action.Region)
+	return result
+}
+
+// markResource will tag the resource with CleanupTagKey set to now, along with the extra tags
+func markResource(svc *ec2.EC2, resourceId string, markTags map[string]string) error {
+	tags := []*ec2.Tag{{Key: aws.String(CleanupTagKey), Value: aws.String(time.Now().UTC().Format(time.RFC3339))}}
+	for key, value := range markTags {
+		tags = append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	_, err := svc.CreateTags(&ec2.CreateTagsInput{Resources: aws.StringSlice([]string{resourceId}), Tags: tags})
+	return err
+}
+
+// finalSnapshot will snapshot the volume and wait for the snapshot to complete, returning the snapshot id
+func finalSnapshot(svc *ec2.EC2, action CleanupAction) (string, error) {
+	params := &ec2.CreateSnapshotInput{
+		VolumeId:    aws.String(action.ResourceId),
+		Description: aws.String("Final snapshot of " + action.ResourceId + " before cleanup by aws-go-tool"),
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeSnapshot),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("Name"), Value: aws.String(action.Name)},
+				{Key: aws.String("aws-go-tool:source-volume"), Value: aws.String(action.ResourceId)},
+			},
+		}},
+	}
+	snapshot, err := svc.CreateSnapshot(params)
+	if err != nil {
+		return "", err
+	}
+	// the default waiter gives up after 10 minutes, which is too short for the first snapshot of a large volume
+	err = svc.WaitUntilSnapshotCompletedWithContext(aws.BackgroundContext(),
+		&ec2.DescribeSnapshotsInput{SnapshotIds: []*string{snapshot.SnapshotId}},
+		request.WithWaiterDelay(request.ConstantWaiterDelay(finalSnapshotDelay)),
+		request.WithWaiterMaxAttempts(int(finalSnapshotTimeout/finalSnapshotDelay)),
+	)
+	if err != nil {
+		return "", fmt.Errorf("snapshot %s did not complete: %w", *snapshot.SnapshotId, err)
+	}
+	return *snapshot.SnapshotId, nil
+}
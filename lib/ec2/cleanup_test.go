@@ -0,0 +1,148 @@
+package ec2
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestBuildCleanupPlan(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) *time.Time {
+		t := now.AddDate(0, 0, -days)
+		return &t
+	}
+	marked := func(days int) []*ec2.Tag {
+		return []*ec2.Tag{{Key: aws.String(CleanupTagKey), Value: aws.String(daysAgo(days).Format(time.RFC3339))}}
+	}
+	volume := func(id string, state string, created int, tags []*ec2.Tag) ec2.Volume {
+		return ec2.Volume{VolumeId: aws.String(id), State: aws.String(state), CreateTime: daysAgo(created), Size: aws.Int64(8), Tags: tags}
+	}
+	snapshot := func(id string, volumeId string, created int, description string) ec2.Snapshot {
+		return ec2.Snapshot{SnapshotId: aws.String(id), VolumeId: aws.String(volumeId), StartTime: daysAgo(created), VolumeSize: aws.Int64(8), Description: aws.String(description)}
+	}
+	region := RegionCleanup{
+		Profile:   "prod",
+		AccountId: "111111111111",
+		Region:    "us-east-1",
+		Volumes: []ec2.Volume{
+			volume("vol-old", ec2.VolumeStateAvailable, 100, []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("data")}}),
+			volume("vol-new", ec2.VolumeStateAvailable, 10, nil),
+			volume("vol-attached", ec2.VolumeStateInUse, 100, nil),
+			volume("vol-marked-old", ec2.VolumeStateAvailable, 100, marked(10)),
+			volume("vol-marked-new", ec2.VolumeStateAvailable, 100, marked(2)),
+			volume("vol-marked-bad", ec2.VolumeStateAvailable, 100, []*ec2.Tag{{Key: aws.String(CleanupTagKey), Value: aws.String("yesterday")}}),
+		},
+		Snapshots: []ec2.Snapshot{
+			snapshot("snap-orphan", "vol-gone", 100, ""),
+			snapshot("snap-image-gone", "vol-gone", 100, "Created by CreateImage(i-1) for ami-gone"),
+			snapshot("snap-volume", "vol-attached", 100, ""),
+			snapshot("snap-image", "vol-gone", 100, "Created by CreateImage(i-1) for ami-1"),
+			snapshot("snap-new", "vol-gone", 10, ""),
+		},
+		Images: []ec2.Image{{
+			ImageId:             aws.String("ami-1"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-image")}}, {VirtualName: aws.String("ephemeral0")}},
+		}},
+	}
+	action := func(resourceType string, id string, reason string, created int, markedAt string, act string) CleanupAction {
+		return CleanupAction{Profile: "prod", AccountId: "111111111111", Region: "us-east-1", ResourceType: resourceType, ResourceId: id,
+			Reason: reason, SizeGiB: 8, Created: *daysAgo(created), MarkedAt: markedAt, Action: act}
+	}
+	named := func(action CleanupAction) CleanupAction {
+		action.Name = "data"
+		return action
+	}
+	orphan := "volume vol-gone no longer exists"
+	imageGone := orphan + ", and its image was deregistered"
+
+	tests := []struct {
+		name    string
+		options CleanupOptions
+		want    []CleanupAction
+	}{
+		{
+			name:    "delete right away",
+			options: CleanupOptions{OlderThanDays: 30},
+			want: []CleanupAction{
+				action("snapshot", "snap-image-gone", imageGone, 100, "", ActionDelete),
+				action("snapshot", "snap-orphan", orphan, 100, "", ActionDelete),
+				action("volume", "vol-marked-bad", "unattached volume", 100, "", ActionDelete),
+				action("volume", "vol-marked-new", "unattached volume", 100, "", ActionDelete),
+				action("volume", "vol-marked-old", "unattached volume", 100, "", ActionDelete),
+				named(action("volume", "vol-old", "unattached volume", 100, "", ActionDelete)),
+			},
+		},
+		{
+			name:    "younger resources are kept out",
+			options: CleanupOptions{OlderThanDays: 5},
+			want: []CleanupAction{
+				action("snapshot", "snap-image-gone", imageGone, 100, "", ActionDelete),
+				action("snapshot", "snap-new", orphan, 10, "", ActionDelete),
+				action("snapshot", "snap-orphan", orphan, 100, "", ActionDelete),
+				action("volume", "vol-marked-bad", "unattached volume", 100, "", ActionDelete),
+				action("volume", "vol-marked-new", "unattached volume", 100, "", ActionDelete),
+				action("volume", "vol-marked-old", "unattached volume", 100, "", ActionDelete),
+				action("volume", "vol-new", "unattached volume", 10, "", ActionDelete),
+				named(action("volume", "vol-old", "unattached volume", 100, "", ActionDelete)),
+			},
+		},
+		{
+			name:    "grace days mark, wait, then delete",
+			options: CleanupOptions{OlderThanDays: 30, GraceDays: 7, FinalSnapshot: true},
+			want: []CleanupAction{
+				action("snapshot", "snap-image-gone", imageGone, 100, "", ActionMark),
+				action("snapshot", "snap-orphan", orphan, 100, "", ActionMark),
+				action("volume", "vol-marked-bad", "unattached volume", 100, "yesterday", ActionMark),
+				action("volume", "vol-marked-new", "unattached volume", 100, daysAgo(2).Format(time.RFC3339), ActionWait),
+				action("volume", "vol-marked-old", "unattached volume", 100, daysAgo(10).Format(time.RFC3339), ActionDelete),
+				named(action("volume", "vol-old", "unattached volume", 100, "", ActionMark)),
+			},
+		},
+		{
+			name:    "grace ends exactly on the day",
+			options: CleanupOptions{OlderThanDays: 30, GraceDays: 10},
+			want: []CleanupAction{
+				action("snapshot", "snap-image-gone", imageGone, 100, "", ActionMark),
+				action("snapshot", "snap-orphan", orphan, 100, "", ActionMark),
+				action("volume", "vol-marked-bad", "unattached volume", 100, "yesterday", ActionMark),
+				action("volume", "vol-marked-new", "unattached volume", 100, daysAgo(2).Format(time.RFC3339), ActionWait),
+				action("volume", "vol-marked-old", "unattached volume", 100, daysAgo(10).Format(time.RFC3339), ActionDelete),
+				named(action("volume", "vol-old", "unattached volume", 100, "", ActionMark)),
+			},
+		},
+	}
+	for _, test := range tests {
+		plan := BuildCleanupPlan(ProfilesCleanup{{region}}, test.options, now)
+		if plan.Name != "cleanup" || !plan.Created.Equal(now) || !reflect.DeepEqual(plan.Options, test.options) {
+			t.Errorf("%s: plan is %s created %v with %+v", test.name, plan.Name, plan.Created, plan.Options)
+		}
+		if !reflect.DeepEqual(plan.Actions, test.want) {
+			t.Errorf("%s: actions =\n%+v\nwant:\n%+v", test.name, plan.Actions, test.want)
+		}
+	}
+}
+
+func TestBuildCleanupPlanOrder(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, 0, -100)
+	volume := func(id string) ec2.Volume {
+		return ec2.Volume{VolumeId: aws.String(id), State: aws.String(ec2.VolumeStateAvailable), CreateTime: &old}
+	}
+	profilesCleanup := ProfilesCleanup{
+		{{Profile: "prod", Region: "us-west-2", Volumes: []ec2.Volume{volume("vol-b"), volume("vol-a")}}, {Profile: "prod", Region: "eu-west-1", Volumes: []ec2.Volume{volume("vol-c")}}},
+		{{Profile: "dev", Region: "us-east-1", Volumes: []ec2.Volume{volume("vol-d")}}},
+	}
+	plan := BuildCleanupPlan(profilesCleanup, CleanupOptions{OlderThanDays: 30}, now)
+	var got []string
+	for _, action := range plan.Actions {
+		got = append(got, action.Profile+"/"+action.Region+"/"+action.ResourceId)
+	}
+	want := []string{"dev/us-east-1/vol-d", "prod/eu-west-1/vol-c", "prod/us-west-2/vol-a", "prod/us-west-2/vol-b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("actions are in the order %v, want %v", got, want)
+	}
+}
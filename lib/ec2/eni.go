@@ -0,0 +1,136 @@
+package ec2
+
+import (
+	"fmt"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+type RegionNetworkInterfaces struct {
+	AccountId         string
+	Region            string
+	Profile           string
+	NetworkInterfaces []ec2.NetworkInterface
+}
+
+type AccountNetworkInterfaces []RegionNetworkInterfaces
+type ProfilesNetworkInterfaces []AccountNetworkInterfaces
+
+// GetRegionNetworkInterfaces will take a session and get all network interfaces based on the region of the session
+func GetRegionNetworkInterfaces(sess *session.Session) ([]ec2.NetworkInterface, error) {
+	var interfaces []ec2.NetworkInterface
+	params := &ec2.DescribeNetworkInterfacesInput{}
+
+	err := ec2.New(sess).DescribeNetworkInterfacesPages(params, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		for _, eni := range page.NetworkInterfaces {
+			interfaces = append(interfaces, *eni)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return interfaces, nil
+}
+
+// GetAccountNetworkInterfaces will take a profile and go through all regions to get all network interfaces in the account
+func GetAccountNetworkInterfaces(account utils.AccountInfo) (AccountNetworkInterfaces, error) {
+	profile := account.Profile
+	fmt.Println("Getting network interfaces for profile:", profile)
+	interfacesChan := make(chan RegionNetworkInterfaces)
+
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
+			info := RegionNetworkInterfaces{AccountId: account.AccountId, Profile: profile, Region: region}
+			sess, err := account.GetSession(region)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "GetSession", err)
+				return
+			}
+			info.NetworkInterfaces, err = GetRegionNetworkInterfaces(sess)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "DescribeNetworkInterfaces", err)
+				return
+			}
+			interfacesChan <- info
+		})
+		close(interfacesChan)
+	}()
+
+	var accountInterfaces AccountNetworkInterfaces
+	for regionInterfaces := range interfacesChan {
+		accountInterfaces = append(accountInterfaces, regionInterfaces)
+	}
+
+	return accountInterfaces, nil
+}
+
+// GetProfilesNetworkInterfaces will return all the network interfaces in all given accounts
+func GetProfilesNetworkInterfaces(accounts []utils.AccountInfo) (ProfilesNetworkInterfaces, error) {
+	profilesInterfacesChan := make(chan AccountNetworkInterfaces)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			accountInterfaces, err := GetAccountNetworkInterfaces(account)
+			if err != nil {
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
+				return
+			}
+			profilesInterfacesChan <- accountInterfaces
+		})
+		close(profilesInterfacesChan)
+	}()
+
+	var profilesInterfaces ProfilesNetworkInterfaces
+	for accountInterfaces := range profilesInterfacesChan {
+		profilesInterfaces = append(profilesInterfaces, accountInterfaces)
+	}
+	return profilesInterfaces, nil
+}
+
+// FindRegion will return the network interfaces of the account in the region
+func (pi ProfilesNetworkInterfaces) FindRegion(accountId string, region string) []ec2.NetworkInterface {
+	for _, accountInterfaces := range pi {
+		for _, regionInterfaces := range accountInterfaces {
+			if regionInterfaces.AccountId == accountId && regionInterfaces.Region == region {
+				return regionInterfaces.NetworkInterfaces
+			}
+		}
+	}
+	return nil
+}
+
+// InterfaceResource will return what the network interface is attached to, such as the instance id,
+// or the description for interfaces aws manages, such as load balancers and rds instances
+func InterfaceResource(eni ec2.NetworkInterface) string {
+	if eni.Attachment != nil && eni.Attachment.InstanceId != nil {
+		return *eni.Attachment.InstanceId
+	}
+	if description := aws.StringValue(eni.Description); description != "" {
+		return description
+	}
+	if interfaceType := aws.StringValue(eni.InterfaceType); interfaceType != "" && interfaceType != "interface" {
+		return interfaceType + " " + aws.StringValue(eni.NetworkInterfaceId)
+	}
+	return aws.StringValue(eni.NetworkInterfaceId)
+}
+
+// InterfacePublicIp will return the public ip of the network interface, or an empty string
+func InterfacePublicIp(eni ec2.NetworkInterface) string {
+	if eni.Association != nil {
+		return aws.StringValue(eni.Association.PublicIp)
+	}
+	return ""
+}
@@ -1,12 +1,9 @@
 package ec2
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws"
@@ -71,28 +68,25 @@ func GetAccountImages(account utils.AccountInfo) (AccountImages, error) {
 	profile := account.Profile
 	fmt.Println("Getting images for profile:", profile)
 	imagesChan := make(chan RegionImages)
-	var wg sync.WaitGroup
 
-	for _, region := range utils.RegionMap {
-		wg.Add(1)
-		go func(region string) {
-			defer wg.Done()
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
 			info := &RegionImages{AccountId: account.AccountId, Region: region, Profile: profile}
 			sess, err := account.GetSession(region)
 			if err != nil {
-				log.Println("Could not get session for", account.Profile, ":", err)
+				utils.RecordError(account, region, "ec2", "GetSession", err)
 				return
 			}
 			if err = info.GetRegionImages(sess, account.AccountId); err != nil {
-				log.Println("Could not get images for", region, "in", profile, ":", err)
+				utils.RecordError(account, region, "ec2", "DescribeImages", err)
 				return
 			}
 			imagesChan <- *info
-		}(region)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(imagesChan)
 	}()
 
@@ -107,27 +101,20 @@ func GetAccountImages(account utils.AccountInfo) (AccountImages, error) {
 // GetProfilesImages will return all the images in all accounts of a given filename with a list of profiles in it
 func GetProfilesImages(accounts []utils.AccountInfo) (ProfilesImages, error) {
 	profilesImagesChan := make(chan AccountImages)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			if err := account.SetAccountId(); err != nil {
-				log.Println("could not set account id for", account.Profile, ":", err)
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
 				return
 			}
 			accountImages, err := GetAccountImages(account)
 			if err != nil {
-				log.Println("Could not get images for", account.Profile, ":", err)
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
 				return
 			}
 			profilesImagesChan <- accountImages
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesImagesChan)
 	}()
 
@@ -139,18 +126,6 @@ func GetProfilesImages(accounts []utils.AccountInfo) (ProfilesImages, error) {
 }
 
 func WriteProfilesImages(profileImages ProfilesImages, options utils.Ec2Options) error {
-	outputDir := "output/ec2/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "images.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create images file: %v", err)
-	}
-
-	fmt.Println("Writing images to file:", outfile.Name())
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-
 	var columnTitles = []string{"Profile",
 		"Account ID",
 		"Region",
@@ -167,9 +142,7 @@ func WriteProfilesImages(profileImages ProfilesImages, options utils.Ec2Options)
 		}
 	}
 
-	if err = writer.Write(columnTitles); err != nil {
-		fmt.Println(err)
-	}
+	report := utils.NewReport("ec2", "images", columnTitles)
 	for _, accountImages := range profileImages {
 		for _, regionImages := range accountImages {
 			for _, image := range regionImages.Images {
@@ -212,25 +185,14 @@ func WriteProfilesImages(profileImages ProfilesImages, options utils.Ec2Options)
 					}
 				}
 
-				if err = writer.Write(data); err != nil {
-					fmt.Println(err)
-				}
+				report.AddRow(data)
 			}
 		}
 	}
-	return nil
+	return report.Write()
 }
 
-func CheckImages(accounts []utils.AccountInfo) ([]ImageInfo, error) {
-	profilesImages, err := GetProfilesImages(accounts)
-	if err != nil {
-		return nil, fmt.Errorf("could not get profiles images: %v", err)
-	}
-	profilesInstances, err := GetProfilesInstances(accounts)
-	if err != nil {
-		return nil, fmt.Errorf("could not get profiles instances: %v", err)
-	}
-
+func CheckImages(profilesImages ProfilesImages, profilesInstances ProfilesInstances) []ImageInfo {
 	var checkedImages []ImageInfo
 	//loop through images
 	for _, accountImages := range profilesImages {
@@ -271,22 +233,10 @@ func CheckImages(accounts []utils.AccountInfo) ([]ImageInfo, error) {
 			}
 		}
 	}
-	return checkedImages, nil
+	return checkedImages
 }
 
 func WriteCheckedImages(checkedImages []ImageInfo, options utils.Ec2Options) error {
-	outputDir := "output/ec2/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "checkedImages.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create checkedImages file: %v", err)
-	}
-
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing images to file:", outfile.Name())
-
 	var columnTitles = []string{"Profile",
 		"Account ID",
 		"Region",
@@ -305,9 +255,7 @@ func WriteCheckedImages(checkedImages []ImageInfo, options utils.Ec2Options) err
 		}
 	}
 
-	if err = writer.Write(columnTitles); err != nil {
-		fmt.Println(err)
-	}
+	report := utils.NewReport("ec2", "checkedImages", columnTitles)
 	for _, checkedImage := range checkedImages {
 		image := checkedImage.Image
 		var imageName string
@@ -351,9 +299,7 @@ func WriteCheckedImages(checkedImages []ImageInfo, options utils.Ec2Options) err
 			}
 		}
 
-		if err = writer.Write(data); err != nil {
-			fmt.Println(err)
-		}
+		report.AddRow(data)
 	}
-	return nil
+	return report.Write()
 }
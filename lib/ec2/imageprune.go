@@ -0,0 +1,458 @@
+package ec2
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// DefaultFamilyPattern is removed from the end of an image name to get its family, such as web-2024-01-02 to web
+const DefaultFamilyPattern = `[-_. ]*v?[0-9][-0-9_.:TZ]*$`
+
+type (
+	// RegionImageUsage is the images in a region, and everything that can use them
+	// User data is removed from the launch templates and configurations, so it is never recorded
+	RegionImageUsage struct {
+		Profile              string
+		AccountId            string
+		Region               string
+		Images               []ec2.Image
+		Instances            []ec2.Instance
+		LaunchTemplates      []ec2.LaunchTemplateVersion
+		LaunchConfigurations []autoscaling.LaunchConfiguration
+		AutoScalingGroups    []autoscaling.Group
+		LaunchPermissions    map[string][]ec2.LaunchPermission // by image id
+	}
+
+	AccountImageUsage  []RegionImageUsage
+	ProfilesImageUsage []AccountImageUsage
+
+	// ImageUsage is everything found using an image
+	ImageUsage struct {
+		Instances            []string
+		LaunchTemplates      []string // as name:version
+		LaunchConfigurations []string
+		AutoScalingGroups    []string
+		SharedWith           []string // account ids, groups such as all, and organization arns
+	}
+
+	PruneOptions struct {
+		// OlderThanDays is how old an image needs to be before it is deregistered
+		OlderThanDays int
+		// KeepLast is how many of the newest images in each family are always kept
+		KeepLast int
+		// FamilyPattern is removed from the image name to get its family, defaults to DefaultFamilyPattern
+		FamilyPattern string
+		GraceDays     int
+		MarkTags      map[string]string
+	}
+)
+
+// InUse will return true if anything uses the image
+func (usage ImageUsage) InUse() bool {
+	return len(usage.Instances) > 0 || len(usage.LaunchTemplates) > 0 || len(usage.LaunchConfigurations) > 0 ||
+		len(usage.AutoScalingGroups) > 0 || len(usage.SharedWith) > 0
+}
+
+// String will describe what uses the image
+func (usage ImageUsage) String() string {
+	var uses []string
+	add := func(kind string, names []string) {
+		if len(names) > 0 {
+			uses = append(uses, kind+" "+strings.Join(names, "|"))
+		}
+	}
+	add("instances", usage.Instances)
+	add("launch templates", usage.LaunchTemplates)
+	add("launch configurations", usage.LaunchConfigurations)
+	add("auto scaling groups", usage.AutoScalingGroups)
+	add("shared with", usage.SharedWith)
+	return strings.Join(uses, ", ")
+}
+
+// GetRegionImageUsage will get the images of the account in the region, along with the instances, launch templates,
+// launch configurations, auto scaling groups and launch permissions that use them
+// Any failure fails the whole region, so no image is seen as unused because a call failed
+func GetRegionImageUsage(sess *session.Session, accountId string) (RegionImageUsage, error) {
+	var info RegionImageUsage
+	svc := ec2.New(sess)
+
+	var images RegionImages
+	if err := images.GetRegionImages(sess, accountId); err != nil {
+		return info, fmt.Errorf("could not describe images: %w", err)
+	}
+	info.Images = images.Images
+
+	var instances RegionInstances
+	if err := instances.GetRegionInstances(sess); err != nil {
+		return info, fmt.Errorf("could not describe instances: %w", err)
+	}
+	info.Instances = instances.Instances
+
+	var err error
+	info.LaunchTemplates, err = GetRegionLaunchTemplateVersions(sess)
+	if err != nil {
+		return info, err
+	}
+	info.LaunchConfigurations, err = GetRegionLaunchConfigurations(sess)
+	if err != nil {
+		return info, err
+	}
+	err = autoscaling.New(sess).DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{}, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+		for _, group := range page.AutoScalingGroups {
+			info.AutoScalingGroups = append(info.AutoScalingGroups, *group)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe auto scaling groups: %w", err)
+	}
+
+	info.LaunchPermissions = make(map[string][]ec2.LaunchPermission)
+	for _, image := range info.Images {
+		params := &ec2.DescribeImageAttributeInput{
+			ImageId:   image.ImageId,
+			Attribute: aws.String(ec2.ImageAttributeNameLaunchPermission),
+		}
+		resp, err := svc.DescribeImageAttribute(params)
+		if err != nil {
+			return info, fmt.Errorf("could not describe launch permissions of %s: %w", *image.ImageId, err)
+		}
+		for _, permission := range resp.LaunchPermissions {
+			info.LaunchPermissions[*image.ImageId] = append(info.LaunchPermissions[*image.ImageId], *permission)
+		}
+	}
+	return info, nil
+}
+
+// GetRegionLaunchTemplateVersions will get every version of every launch template in the region, without their user data
+func GetRegionLaunchTemplateVersions(sess *session.Session) ([]ec2.LaunchTemplateVersion, error) {
+	svc := ec2.New(sess)
+	var templates []*ec2.LaunchTemplate
+	err := svc.DescribeLaunchTemplatesPages(&ec2.DescribeLaunchTemplatesInput{}, func(page *ec2.DescribeLaunchTemplatesOutput, lastPage bool) bool {
+		templates = append(templates, page.LaunchTemplates...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe launch templates: %w", err)
+	}
+
+	var versions []ec2.LaunchTemplateVersion
+	for _, template := range templates {
+		params := &ec2.DescribeLaunchTemplateVersionsInput{LaunchTemplateId: template.LaunchTemplateId}
+		err = svc.DescribeLaunchTemplateVersionsPages(params, func(page *ec2.DescribeLaunchTemplateVersionsOutput, lastPage bool) bool {
+			for _, version := range page.LaunchTemplateVersions {
+				if version.LaunchTemplateData != nil {
+					version.LaunchTemplateData.UserData = nil
+				}
+				versions = append(versions, *version)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not describe versions of launch template %s: %w", *template.LaunchTemplateId, err)
+		}
+	}
+	return versions, nil
+}
+
+// GetRegionLaunchConfigurations will get every launch configuration in the region, without their user data
+func GetRegionLaunchConfigurations(sess *session.Session) ([]autoscaling.LaunchConfiguration, error) {
+	var configs []autoscaling.LaunchConfiguration
+	err := autoscaling.New(sess).DescribeLaunchConfigurationsPages(&autoscaling.DescribeLaunchConfigurationsInput{}, func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+		for _, config := range page.LaunchConfigurations {
+			config.UserData = nil
+			configs = append(configs, *config)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe launch configurations: %w", err)
+	}
+	return configs, nil
+}
+
+// GetAccountImageUsage will take a profile and go through all regions to get the images and their usage in the account
+func GetAccountImageUsage(account utils.AccountInfo) (AccountImageUsage, error) {
+	fmt.Println("Getting images and their usage for profile:", account.Profile)
+	usageChan := make(chan RegionImageUsage)
+
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
+			sess, err := account.GetSession(region)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "GetSession", err)
+				return
+			}
+			info, err := GetRegionImageUsage(sess, account.AccountId)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "Describe", err)
+				return
+			}
+			info.Profile = account.Profile
+			info.AccountId = account.AccountId
+			info.Region = region
+			usageChan <- info
+		})
+		close(usageChan)
+	}()
+
+	var accountUsage AccountImageUsage
+	for regionUsage := range usageChan {
+		accountUsage = append(accountUsage, regionUsage)
+	}
+	return accountUsage, nil
+}
+
+// GetProfilesImageUsage will get the images and their usage in all given accounts
+func GetProfilesImageUsage(accounts []utils.AccountInfo) (ProfilesImageUsage, error) {
+	profilesUsageChan := make(chan AccountImageUsage)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			accountUsage, err := GetAccountImageUsage(account)
+			if err != nil {
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
+				return
+			}
+			profilesUsageChan <- accountUsage
+		})
+		close(profilesUsageChan)
+	}()
+
+	var profilesUsage ProfilesImageUsage
+	for accountUsage := range profilesUsageChan {
+		profilesUsage = append(profilesUsage, accountUsage)
+	}
+	return profilesUsage, nil
+}
+
+// Usage will return what uses each image in the region, by image id
+func (ru RegionImageUsage) Usage() map[string]*ImageUsage {
+	usage := make(map[string]*ImageUsage)
+	get := func(imageId string) *ImageUsage {
+		if usage[imageId] == nil {
+			usage[imageId] = &ImageUsage{}
+		}
+		return usage[imageId]
+	}
+
+	for _, instance := range ru.Instances {
+		if instance.ImageId != nil && aws.StringValue(instance.State.Name) != ec2.InstanceStateNameTerminated {
+			get(*instance.ImageId).Instances = append(get(*instance.ImageId).Instances, *instance.InstanceId)
+		}
+	}
+
+	// the image of each launch template version, by template id and version number
+	templateImages := make(map[string]map[int64]string)
+	latest := make(map[string]int64)
+	defaults := make(map[string]int64)
+	templateIds := make(map[string]string) // by name
+	for _, version := range ru.LaunchTemplates {
+		templateId := *version.LaunchTemplateId
+		templateIds[aws.StringValue(version.LaunchTemplateName)] = templateId
+		number := aws.Int64Value(version.VersionNumber)
+		if number > latest[templateId] {
+			latest[templateId] = number
+		}
+		if aws.BoolValue(version.DefaultVersion) {
+			defaults[templateId] = number
+		}
+		if version.LaunchTemplateData == nil || !strings.HasPrefix(aws.StringValue(version.LaunchTemplateData.ImageId), "ami-") {
+			continue
+		}
+		imageId := *version.LaunchTemplateData.ImageId
+		if templateImages[templateId] == nil {
+			templateImages[templateId] = make(map[int64]string)
+		}
+		templateImages[templateId][number] = imageId
+		name := aws.StringValue(version.LaunchTemplateName) + ":" + strconv.FormatInt(number, 10)
+		get(imageId).LaunchTemplates = append(get(imageId).LaunchTemplates, name)
+	}
+	templateImage := func(spec *autoscaling.LaunchTemplateSpecification) string {
+		if spec == nil {
+			return ""
+		}
+		templateId := aws.StringValue(spec.LaunchTemplateId)
+		if templateId == "" {
+			templateId = templateIds[aws.StringValue(spec.LaunchTemplateName)]
+		}
+		var number int64
+		switch version := aws.StringValue(spec.Version); version {
+		case "$Latest":
+			number = latest[templateId]
+		case "", "$Default":
+			number = defaults[templateId]
+		default:
+			number, _ = strconv.ParseInt(version, 10, 64)
+		}
+		return templateImages[templateId][number]
+	}
+
+	configImages := make(map[string]string)
+	for _, config := range ru.LaunchConfigurations {
+		imageId := aws.StringValue(config.ImageId)
+		configImages[aws.StringValue(config.LaunchConfigurationName)] = imageId
+		get(imageId).LaunchConfigurations = append(get(imageId).LaunchConfigurations, aws.StringValue(config.LaunchConfigurationName))
+	}
+
+	for _, group := range ru.AutoScalingGroups {
+		groupImages := make(map[string]bool)
+		if group.LaunchConfigurationName != nil {
+			groupImages[configImages[*group.LaunchConfigurationName]] = true
+		}
+		groupImages[templateImage(group.LaunchTemplate)] = true
+		if group.MixedInstancesPolicy != nil && group.MixedInstancesPolicy.LaunchTemplate != nil {
+			policyTemplate := group.MixedInstancesPolicy.LaunchTemplate
+			groupImages[templateImage(policyTemplate.LaunchTemplateSpecification)] = true
+			for _, override := range policyTemplate.Overrides {
+				groupImages[templateImage(override.LaunchTemplateSpecification)] = true
+			}
+		}
+		for imageId := range groupImages {
+			if imageId != "" {
+				get(imageId).AutoScalingGroups = append(get(imageId).AutoScalingGroups, aws.StringValue(group.AutoScalingGroupName))
+			}
+		}
+	}
+
+	for imageId, permissions := range ru.LaunchPermissions {
+		for _, permission := range permissions {
+			for _, shared := range []*string{permission.UserId, permission.Group, permission.OrganizationArn, permission.OrganizationalUnitArn} {
+				if shared != nil {
+					get(imageId).SharedWith = append(get(imageId).SharedWith, *shared)
+				}
+			}
+		}
+	}
+	return usage
+}
+
+// imageFamily will return the family of the image, which is its name with the pattern removed
+func imageFamily(image ec2.Image, pattern *regexp.Regexp) string {
+	name := aws.StringValue(image.Name)
+	family := pattern.ReplaceAllString(name, "")
+	if family == "" {
+		return name
+	}
+	return family
+}
+
+// BuildImagePrunePlan will plan to deregister the images that are not in use, are older than options.OlderThanDays,
+// and are not one of the newest options.KeepLast images in their family, along with their snapshots
+// Every kept image is also in the plan with the reason it is kept
+func BuildImagePrunePlan(profilesUsage ProfilesImageUsage, options PruneOptions, now time.Time) (CleanupPlan, error) {
+	cleanupOptions := CleanupOptions{OlderThanDays: options.OlderThanDays, GraceDays: options.GraceDays, MarkTags: options.MarkTags}
+	plan := CleanupPlan{Name: "imagesprune", Created: now, Options: cleanupOptions}
+
+	familyPattern := options.FamilyPattern
+	if familyPattern == "" {
+		familyPattern = DefaultFamilyPattern
+	}
+	pattern, err := regexp.Compile(familyPattern)
+	if err != nil {
+		return plan, fmt.Errorf("invalid family pattern %s: %v", familyPattern, err)
+	}
+	cutoff := now.AddDate(0, 0, -options.OlderThanDays)
+
+	for _, accountUsage := range profilesUsage {
+		for _, regionUsage := range accountUsage {
+			usage := regionUsage.Usage()
+
+			families := make(map[string][]ec2.Image)
+			for _, image := range regionUsage.Images {
+				family := imageFamily(image, pattern)
+				families[family] = append(families[family], image)
+			}
+
+			var actions []CleanupAction
+			keptSnapshots := make(map[string]bool)
+			for family, images := range families {
+				// newest first
+				sort.SliceStable(images, func(i, j int) bool {
+					return aws.StringValue(images[i].CreationDate) > aws.StringValue(images[j].CreationDate)
+				})
+				for i, image := range images {
+					created, _ := time.Parse(time.RFC3339, aws.StringValue(image.CreationDate))
+					action := CleanupAction{
+						ResourceType: "image",
+						ResourceId:   *image.ImageId,
+						Name:         aws.StringValue(image.Name),
+						Created:      created,
+						Snapshots:    imageSnapshotIds(image),
+						Action:       ActionDelete,
+					}
+					for _, mapping := range image.BlockDeviceMappings {
+						if mapping.Ebs != nil {
+							action.SizeGiB += aws.Int64Value(mapping.Ebs.VolumeSize)
+						}
+					}
+
+					imageUsage := usage[*image.ImageId]
+					switch {
+					case imageUsage != nil && imageUsage.InUse():
+						action.Action = ActionKeep
+						action.Reason = "in use, " + imageUsage.String()
+					case i < options.KeepLast:
+						action.Action = ActionKeep
+						action.Reason = fmt.Sprintf("one of the newest %d images in family %s", options.KeepLast, family)
+					case created.After(cutoff):
+						action.Action = ActionKeep
+						action.Reason = fmt.Sprintf("newer than %d days", options.OlderThanDays)
+					default:
+						action.Reason = fmt.Sprintf("not in use, %d newer images in family %s", i, family)
+					}
+					if action.Action == ActionKeep {
+						for _, snapshotId := range action.Snapshots {
+							keptSnapshots[snapshotId] = true
+						}
+					}
+					actions = append(actions, action)
+				}
+			}
+
+			tags := make(map[string][]*ec2.Tag)
+			for _, image := range regionUsage.Images {
+				tags[*image.ImageId] = image.Tags
+			}
+			regionCleanup := RegionCleanup{Profile: regionUsage.Profile, AccountId: regionUsage.AccountId, Region: regionUsage.Region}
+			for _, action := range actions {
+				if action.Action == ActionKeep {
+					action.Profile = regionCleanup.Profile
+					action.AccountId = regionCleanup.AccountId
+					action.Region = regionCleanup.Region
+					plan.Actions = append(plan.Actions, action)
+					continue
+				}
+				// a snapshot that also backs a kept image is left alone
+				var snapshots []string
+				for _, snapshotId := range action.Snapshots {
+					if !keptSnapshots[snapshotId] {
+						snapshots = append(snapshots, snapshotId)
+					}
+				}
+				action.Snapshots = snapshots
+				plan.addAction(regionCleanup, action, tags[action.ResourceId], now)
+			}
+		}
+	}
+	plan.sort()
+	return plan, nil
+}
@@ -0,0 +1,224 @@
+package ec2
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestRegionImageUsageUsage(t *testing.T) {
+	instance := func(id string, imageId string, state string) ec2.Instance {
+		return ec2.Instance{InstanceId: aws.String(id), ImageId: aws.String(imageId), State: &ec2.InstanceState{Name: aws.String(state)}}
+	}
+	version := func(id string, name string, number int64, isDefault bool, imageId string) ec2.LaunchTemplateVersion {
+		return ec2.LaunchTemplateVersion{
+			LaunchTemplateId:   aws.String(id),
+			LaunchTemplateName: aws.String(name),
+			VersionNumber:      aws.Int64(number),
+			DefaultVersion:     aws.Bool(isDefault),
+			LaunchTemplateData: &ec2.ResponseLaunchTemplateData{ImageId: aws.String(imageId)},
+		}
+	}
+	spec := func(id string, name string, version string) *autoscaling.LaunchTemplateSpecification {
+		spec := &autoscaling.LaunchTemplateSpecification{Version: aws.String(version)}
+		if id != "" {
+			spec.LaunchTemplateId = aws.String(id)
+		}
+		if name != "" {
+			spec.LaunchTemplateName = aws.String(name)
+		}
+		return spec
+	}
+	regionUsage := RegionImageUsage{
+		Instances: []ec2.Instance{
+			instance("i-1", "ami-web", ec2.InstanceStateNameRunning),
+			instance("i-2", "ami-web", ec2.InstanceStateNameStopped),
+			instance("i-3", "ami-old", ec2.InstanceStateNameTerminated),
+		},
+		LaunchTemplates: []ec2.LaunchTemplateVersion{
+			version("lt-1", "web", 1, true, "ami-web"),
+			version("lt-1", "web", 2, false, "ami-web2"),
+			version("lt-1", "web", 3, false, "resolve:ssm:/images/web"),
+			version("lt-2", "api", 1, true, "ami-api"),
+		},
+		LaunchConfigurations: []autoscaling.LaunchConfiguration{
+			{LaunchConfigurationName: aws.String("legacy"), ImageId: aws.String("ami-legacy")},
+		},
+		AutoScalingGroups: []autoscaling.Group{
+			{AutoScalingGroupName: aws.String("web-default"), LaunchTemplate: spec("lt-1", "", "$Default")},
+			{AutoScalingGroupName: aws.String("web-pinned"), LaunchTemplate: spec("", "web", "2")},
+			{AutoScalingGroupName: aws.String("web-latest"), LaunchTemplate: spec("lt-1", "", "$Latest")},
+			{AutoScalingGroupName: aws.String("legacy"), LaunchConfigurationName: aws.String("legacy")},
+			{
+				AutoScalingGroupName: aws.String("mixed"),
+				MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{LaunchTemplate: &autoscaling.LaunchTemplate{
+					LaunchTemplateSpecification: spec("", "api", ""),
+					Overrides:                   []*autoscaling.LaunchTemplateOverrides{{LaunchTemplateSpecification: spec("lt-1", "", "2")}},
+				}},
+			},
+		},
+		LaunchPermissions: map[string][]ec2.LaunchPermission{
+			"ami-shared": {{UserId: aws.String("222222222222")}, {Group: aws.String("all")}},
+			"ami-web":    {{OrganizationArn: aws.String("arn:aws:organizations::111111111111:organization/o-1")}},
+		},
+	}
+
+	want := map[string]*ImageUsage{
+		"ami-web": {
+			Instances:         []string{"i-1", "i-2"},
+			LaunchTemplates:   []string{"web:1"},
+			AutoScalingGroups: []string{"web-default"},
+			SharedWith:        []string{"arn:aws:organizations::111111111111:organization/o-1"},
+		},
+		"ami-web2":   {LaunchTemplates: []string{"web:2"}, AutoScalingGroups: []string{"web-pinned", "mixed"}},
+		"ami-api":    {LaunchTemplates: []string{"api:1"}, AutoScalingGroups: []string{"mixed"}},
+		"ami-legacy": {LaunchConfigurations: []string{"legacy"}, AutoScalingGroups: []string{"legacy"}},
+		"ami-shared": {SharedWith: []string{"222222222222", "all"}},
+	}
+	got := regionUsage.Usage()
+	for imageId, usage := range got {
+		if want[imageId] == nil {
+			t.Errorf("%s: unexpected usage %+v", imageId, *usage)
+		}
+	}
+	for imageId, usage := range want {
+		if got[imageId] == nil {
+			t.Errorf("%s: no usage, want %+v", imageId, *usage)
+			continue
+		}
+		if !reflect.DeepEqual(*got[imageId], *usage) {
+			t.Errorf("%s: usage = %+v, want %+v", imageId, *got[imageId], *usage)
+		}
+	}
+}
+
+func TestBuildImagePrunePlan(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	marked := func(days int) []*ec2.Tag {
+		return []*ec2.Tag{{Key: aws.String(CleanupTagKey), Value: aws.String(now.AddDate(0, 0, -days).Format(time.RFC3339))}}
+	}
+	image := func(id string, name string, created string, tags []*ec2.Tag, snapshotIds ...string) ec2.Image {
+		image := ec2.Image{ImageId: aws.String(id), Name: aws.String(name), CreationDate: aws.String(created + "T00:00:00.000Z"), Tags: tags}
+		for _, snapshotId := range snapshotIds {
+			image.BlockDeviceMappings = append(image.BlockDeviceMappings,
+				&ec2.BlockDeviceMapping{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String(snapshotId), VolumeSize: aws.Int64(8)}})
+		}
+		return image
+	}
+	regionUsage := RegionImageUsage{
+		Profile:   "prod",
+		AccountId: "111111111111",
+		Region:    "us-east-1",
+		Images: []ec2.Image{
+			image("ami-web1", "web-2025-01-01", "2025-01-01", nil, "snap-web1"),
+			image("ami-web2", "web-2025-02-01", "2025-02-01", marked(10), "snap-web2"),
+			image("ami-web3", "web-2025-05-25", "2025-05-25", nil, "snap-web3"),
+			image("ami-api0", "api-v0", "2024-12-01", marked(2), "snap-api0", "snap-shared"),
+			image("ami-api1", "api-v1", "2025-01-01", nil, "snap-shared"),
+			image("ami-api2", "api-v2", "2025-01-02", nil, "snap-api2"),
+		},
+		Instances: []ec2.Instance{
+			{InstanceId: aws.String("i-1"), ImageId: aws.String("ami-api1"), State: &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)}},
+		},
+		LaunchTemplates: []ec2.LaunchTemplateVersion{{
+			LaunchTemplateId:   aws.String("lt-1"),
+			LaunchTemplateName: aws.String("api"),
+			VersionNumber:      aws.Int64(1),
+			LaunchTemplateData: &ec2.ResponseLaunchTemplateData{ImageId: aws.String("ami-api2")},
+		}},
+	}
+
+	// planned is the part of each action that the options decide
+	type planned struct {
+		ResourceId string
+		Action     string
+		Reason     string
+		MarkedAt   string
+		Snapshots  []string
+	}
+	inUse := []planned{
+		{"ami-api1", ActionKeep, "in use, instances i-1", "", []string{"snap-shared"}},
+		{"ami-api2", ActionKeep, "in use, launch templates api:1", "", []string{"snap-api2"}},
+	}
+	tests := []struct {
+		name    string
+		options PruneOptions
+		want    []planned
+		wantErr bool
+	}{
+		{
+			name:    "keep the newest in each family",
+			options: PruneOptions{OlderThanDays: 30, KeepLast: 1},
+			want: append([]planned{{"ami-api0", ActionDelete, "not in use, 2 newer images in family api", "", []string{"snap-api0"}}}, append(inUse,
+				planned{"ami-web1", ActionDelete, "not in use, 2 newer images in family web", "", []string{"snap-web1"}},
+				planned{"ami-web2", ActionDelete, "not in use, 1 newer images in family web", "", []string{"snap-web2"}},
+				planned{"ami-web3", ActionKeep, "one of the newest 1 images in family web", "", []string{"snap-web3"}},
+			)...),
+		},
+		{
+			name:    "newer than older than days",
+			options: PruneOptions{OlderThanDays: 30},
+			want: append([]planned{{"ami-api0", ActionDelete, "not in use, 2 newer images in family api", "", []string{"snap-api0"}}}, append(inUse,
+				planned{"ami-web1", ActionDelete, "not in use, 2 newer images in family web", "", []string{"snap-web1"}},
+				planned{"ami-web2", ActionDelete, "not in use, 1 newer images in family web", "", []string{"snap-web2"}},
+				planned{"ami-web3", ActionKeep, "newer than 30 days", "", []string{"snap-web3"}},
+			)...),
+		},
+		{
+			name:    "keep more than the family has",
+			options: PruneOptions{OlderThanDays: 30, KeepLast: 3},
+			want: append([]planned{{"ami-api0", ActionKeep, "one of the newest 3 images in family api", "", []string{"snap-api0", "snap-shared"}}}, append(inUse,
+				planned{"ami-web1", ActionKeep, "one of the newest 3 images in family web", "", []string{"snap-web1"}},
+				planned{"ami-web2", ActionKeep, "one of the newest 3 images in family web", "", []string{"snap-web2"}},
+				planned{"ami-web3", ActionKeep, "one of the newest 3 images in family web", "", []string{"snap-web3"}},
+			)...),
+		},
+		{
+			name:    "family pattern",
+			options: PruneOptions{OlderThanDays: 30, KeepLast: 1, FamilyPattern: `-v[0-9]+$`},
+			want: append([]planned{{"ami-api0", ActionDelete, "not in use, 2 newer images in family api", "", []string{"snap-api0"}}}, append(inUse,
+				planned{"ami-web1", ActionKeep, "one of the newest 1 images in family web-2025-01-01", "", []string{"snap-web1"}},
+				planned{"ami-web2", ActionKeep, "one of the newest 1 images in family web-2025-02-01", "", []string{"snap-web2"}},
+				planned{"ami-web3", ActionKeep, "one of the newest 1 images in family web-2025-05-25", "", []string{"snap-web3"}},
+			)...),
+		},
+		{
+			name:    "grace days mark, wait, then delete",
+			options: PruneOptions{OlderThanDays: 30, KeepLast: 1, GraceDays: 7},
+			want: append([]planned{{"ami-api0", ActionWait, "not in use, 2 newer images in family api", now.AddDate(0, 0, -2).Format(time.RFC3339), []string{"snap-api0"}}}, append(inUse,
+				planned{"ami-web1", ActionMark, "not in use, 2 newer images in family web", "", []string{"snap-web1"}},
+				planned{"ami-web2", ActionDelete, "not in use, 1 newer images in family web", now.AddDate(0, 0, -10).Format(time.RFC3339), []string{"snap-web2"}},
+				planned{"ami-web3", ActionKeep, "one of the newest 1 images in family web", "", []string{"snap-web3"}},
+			)...),
+		},
+		{
+			name:    "invalid family pattern",
+			options: PruneOptions{FamilyPattern: "("},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		plan, err := BuildImagePrunePlan(ProfilesImageUsage{{regionUsage}}, test.options, now)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+		var got []planned
+		for _, action := range plan.Actions {
+			if action.Profile != "prod" || action.AccountId != "111111111111" || action.Region != "us-east-1" {
+				t.Errorf("%s: %s is in %s %s %s", test.name, action.ResourceId, action.Profile, action.AccountId, action.Region)
+			}
+			got = append(got, planned{action.ResourceId, action.Action, action.Reason, action.MarkedAt, action.Snapshots})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: actions =\n%+v\nwant:\n%+v", test.name, got, test.want)
+		}
+	}
+}
@@ -1,10 +1,7 @@
 package ec2
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -80,32 +77,29 @@ func GetAccountInstances(account utils.AccountInfo) (AccountInstances, error) {
 	profile := account.Profile
 	fmt.Println("Getting instances for profile:", profile)
 	instancesChan := make(chan RegionInstances)
-	var wg sync.WaitGroup
 
-	for _, region := range utils.RegionMap {
-		wg.Add(1)
-		go func(region string) {
-			defer wg.Done()
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
 			info := &RegionInstances{AccountId: account.AccountId, Region: region, Profile: profile}
 			sess, err := account.GetSession(region)
 			if err != nil {
-				log.Println("could not get session for", account.Profile, ":", err)
+				utils.RecordError(account, region, "ec2", "GetSession", err)
 				return
 			}
 			if err = info.GetRegionInstances(sess); err != nil {
-				log.Println("could not get instances for", region, "in", profile, ":", err)
+				utils.RecordError(account, region, "ec2", "DescribeInstances", err)
 				return
 			}
 			if err = info.GetRegionInstancesStatuses(sess); err != nil {
-				log.Println("could not get instance statuses for", region, "in", profile, ":", err)
+				utils.RecordError(account, region, "ec2", "DescribeInstanceStatus", err)
 				return
 			}
 			instancesChan <- *info
-		}(region)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(instancesChan)
 	}()
 
@@ -120,27 +114,20 @@ func GetAccountInstances(account utils.AccountInfo) (AccountInstances, error) {
 // GetProfilesInstances will return all the instances in all accounts of a given filename with a list of profiles in it
 func GetProfilesInstances(accounts []utils.AccountInfo) (ProfilesInstances, error) {
 	profilesInstancesChan := make(chan AccountInstances)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			if err := account.SetAccountId(); err != nil {
-				log.Println("could not set account id for", account.Profile, ":", err)
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
 				return
 			}
 			accountInstances, err := GetAccountInstances(account)
 			if err != nil {
-				log.Println("Could not get instances for", account.Profile, ":", err)
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
 				return
 			}
 			profilesInstancesChan <- accountInstances
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesInstancesChan)
 	}()
 
@@ -152,17 +139,6 @@ func GetProfilesInstances(accounts []utils.AccountInfo) (ProfilesInstances, erro
 }
 
 func WriteProfilesInstances(profileInstances ProfilesInstances, options utils.Ec2Options) error {
-	outputDir := "output/ec2/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "instances.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create instances file: %v", err)
-	}
-
-	fmt.Println("Writing instances to file:", outfile.Name())
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
 	var columnTitles = []string{"Profile",
 		"Account ID",
 		"Region",
@@ -184,10 +160,7 @@ func WriteProfilesInstances(profileInstances ProfilesInstances, options utils.Ec
 		}
 	}
 
-	if err = writer.Write(columnTitles); err != nil {
-		fmt.Println(err)
-	}
-
+	report := utils.NewReport("ec2", "instances", columnTitles)
 	for _, accountInstances := range profileInstances {
 		for _, regionInstances := range accountInstances {
 			for _, instance := range regionInstances.Instances {
@@ -250,11 +223,9 @@ func WriteProfilesInstances(profileInstances ProfilesInstances, options utils.Ec
 					}
 				}
 
-				if err = writer.Write(data); err != nil {
-					fmt.Println(err)
-				}
+				report.AddRow(data)
 			}
 		}
 	}
-	return nil
+	return report.Write()
 }
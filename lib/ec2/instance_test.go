@@ -0,0 +1,30 @@
+package ec2
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/afeeblechild/aws-go-tool/internal/testutil"
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+)
+
+func TestReplayInstances(t *testing.T) {
+	readReport := testutil.ReplayReport(t, "testdata/replay")
+	profilesInstances, err := utils.CollectAccounts("ec2/instances", GetProfilesInstances, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteProfilesInstances(profilesInstances, utils.Ec2Options{Tags: []string{"env"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"Profile", "Account ID", "Region", "Instance Name", "Instance ID", "Private IP", "Public IP", "Pem Key", "Instance Type", "Instance State", "AMI ID", "VPC", "env"},
+		{"prod", "111111111111", "us-east-1", "web1", "i-0001", "10.0.1.10", "54.1.2.3", "prod-key", "t3.micro", "running", "ami-0aaa", "vpc-0001", "prod"},
+		{"prod", "111111111111", "us-east-1", "", "i-0002", "10.0.2.20", "N/A", "", "m5.large", "stopped", "N/A", "vpc-0001", ""},
+		{"dev", "222222222222", "eu-west-1", "", "i-0003", "", "N/A", "", "t3.small", "running", "ami-0bbb", "", "dev"},
+	}
+	if got := readReport("ec2/instances.csv"); !reflect.DeepEqual(got, want) {
+		t.Errorf("instances report:\n%v\nwant:\n%v", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package ec2
+
+import (
+	"fmt"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// PrefixList is a managed prefix list and the cidrs in it
+type PrefixList struct {
+	Id    string
+	Name  string
+	Owner string
+	Cidrs []string
+}
+
+type RegionPrefixLists struct {
+	AccountId   string
+	Region      string
+	Profile     string
+	PrefixLists []PrefixList
+}
+
+type AccountPrefixLists []RegionPrefixLists
+type ProfilesPrefixLists []AccountPrefixLists
+
+// GetRegionPrefixLists will take a session and get the entries of every managed prefix list in the region of the session
+// The prefix lists owned by aws are skipped, as they are the ranges of aws services and can never be the internet
+func GetRegionPrefixLists(sess *session.Session) ([]PrefixList, error) {
+	svc := ec2.New(sess)
+	var prefixLists []PrefixList
+	err := svc.DescribeManagedPrefixListsPages(&ec2.DescribeManagedPrefixListsInput{}, func(page *ec2.DescribeManagedPrefixListsOutput, lastPage bool) bool {
+		for _, prefixList := range page.PrefixLists {
+			if aws.StringValue(prefixList.OwnerId) == "AWS" {
+				continue
+			}
+			prefixLists = append(prefixLists, PrefixList{
+				Id:    aws.StringValue(prefixList.PrefixListId),
+				Name:  aws.StringValue(prefixList.PrefixListName),
+				Owner: aws.StringValue(prefixList.OwnerId),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range prefixLists {
+		params := &ec2.GetManagedPrefixListEntriesInput{PrefixListId: aws.String(prefixLists[i].Id)}
+		err = svc.GetManagedPrefixListEntriesPages(params, func(page *ec2.GetManagedPrefixListEntriesOutput, lastPage bool) bool {
+			for _, entry := range page.Entries {
+				prefixLists[i].Cidrs = append(prefixLists[i].Cidrs, aws.StringValue(entry.Cidr))
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not get the entries of %s: %w", prefixLists[i].Id, err)
+		}
+	}
+	return prefixLists, nil
+}
+
+// GetAccountPrefixLists will take a profile and go through all regions to get all managed prefix lists in the account
+func GetAccountPrefixLists(account utils.AccountInfo) (AccountPrefixLists, error) {
+	profile := account.Profile
+	fmt.Println("Getting prefix lists for profile:", profile)
+	prefixListsChan := make(chan RegionPrefixLists)
+
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
+			info := RegionPrefixLists{AccountId: account.AccountId, Profile: profile, Region: region}
+			sess, err := account.GetSession(region)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "GetSession", err)
+				return
+			}
+			info.PrefixLists, err = GetRegionPrefixLists(sess)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "GetManagedPrefixListEntries", err)
+				return
+			}
+			prefixListsChan <- info
+		})
+		close(prefixListsChan)
+	}()
+
+	var accountPrefixLists AccountPrefixLists
+	for regionPrefixLists := range prefixListsChan {
+		accountPrefixLists = append(accountPrefixLists, regionPrefixLists)
+	}
+
+	return accountPrefixLists, nil
+}
+
+// GetProfilesPrefixLists will return all the managed prefix lists in all given accounts
+func GetProfilesPrefixLists(accounts []utils.AccountInfo) (ProfilesPrefixLists, error) {
+	profilesPrefixListsChan := make(chan AccountPrefixLists)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			accountPrefixLists, err := GetAccountPrefixLists(account)
+			if err != nil {
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
+				return
+			}
+			profilesPrefixListsChan <- accountPrefixLists
+		})
+		close(profilesPrefixListsChan)
+	}()
+
+	var profilesPrefixLists ProfilesPrefixLists
+	for accountPrefixLists := range profilesPrefixListsChan {
+		profilesPrefixLists = append(profilesPrefixLists, accountPrefixLists)
+	}
+	return profilesPrefixLists, nil
+}
+
+// FindRegion will return the prefix lists of the account in the region, by id
+func (pp ProfilesPrefixLists) FindRegion(accountId string, region string) map[string]PrefixList {
+	prefixLists := make(map[string]PrefixList)
+	for _, accountPrefixLists := range pp {
+		for _, regionPrefixLists := range accountPrefixLists {
+			if regionPrefixLists.AccountId != accountId || regionPrefixLists.Region != region {
+				continue
+			}
+			for _, prefixList := range regionPrefixLists.PrefixLists {
+				prefixLists[prefixList.Id] = prefixList
+			}
+		}
+	}
+	return prefixLists
+}
@@ -1,11 +1,8 @@
 package ec2
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
-	"strconv"
-	"sync"
+	"net/netip"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -55,28 +52,25 @@ func GetAccountSecurityGroups(account utils.AccountInfo) (AccountSecurityGroups,
 	profile := account.Profile
 	fmt.Println("Getting Security Groups for profile:", profile)
 	SGsChan := make(chan RegionSecurityGroups)
-	var wg sync.WaitGroup
 
-	for _, region := range utils.RegionMap {
-		wg.Add(1)
-		go func(region string) {
-			defer wg.Done()
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
 			info := RegionSecurityGroups{AccountId: account.AccountId, Region: region, Profile: profile}
 			sess, err := account.GetSession(region)
 			if err != nil {
-				log.Println("Could not get security groups for", account.Profile, ":", err)
+				utils.RecordError(account, region, "ec2", "GetSession", err)
 				return
 			}
 			if err = info.GetRegionSecurityGroups(sess); err != nil {
-				log.Println("Could not get security groups for", region, "in", profile, ":", err)
+				utils.RecordError(account, region, "ec2", "DescribeSecurityGroups", err)
 				return
 			}
 			SGsChan <- info
-		}(region)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(SGsChan)
 	}()
 
@@ -90,27 +84,20 @@ func GetAccountSecurityGroups(account utils.AccountInfo) (AccountSecurityGroups,
 
 func GetProfilesSGs(accounts []utils.AccountInfo) (ProfilesSecurityGroups, error) {
 	profilesSGsChan := make(chan AccountSecurityGroups)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			if err := account.SetAccountId(); err != nil {
-				log.Println("could not set account id for", account.Profile, ":", err)
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
 				return
 			}
 			accountSGs, err := GetAccountSecurityGroups(account)
 			if err != nil {
-				log.Println("Could not get security groups for", account.Profile, ":", err)
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
 				return
 			}
 			profilesSGsChan <- accountSGs
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesSGsChan)
 	}()
 
@@ -122,18 +109,6 @@ func GetProfilesSGs(accounts []utils.AccountInfo) (ProfilesSecurityGroups, error
 }
 
 func WriteProfilesSgs(profileSGs ProfilesSecurityGroups, options SgOptions) error {
-	outputDir := "output/ec2/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "sgs.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create sgs file: %v", err)
-	}
-
-	fmt.Println("Writing SGs to file:", outfile.Name())
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-
 	var columnTitles = []string{"Profile",
 		"Account ID",
 		"Region",
@@ -148,10 +123,7 @@ func WriteProfilesSgs(profileSGs ProfilesSecurityGroups, options SgOptions) erro
 		}
 	}
 
-	if err = writer.Write(columnTitles); err != nil {
-		fmt.Println(err)
-	}
-
+	report := utils.NewReport("ec2", "sgs", columnTitles)
 	for _, accountSGs := range profileSGs {
 		for _, regionSGs := range accountSGs {
 			for _, SG := range regionSGs.SecurityGroups {
@@ -177,30 +149,24 @@ func WriteProfilesSgs(profileSGs ProfilesSecurityGroups, options SgOptions) erro
 					}
 				}
 
-				if err = writer.Write(data); err != nil {
-					fmt.Println(err)
-				}
+				report.AddRow(data)
 			}
 		}
 	}
-	return nil
+	return report.Write()
 }
 
-// if a cidr is given, search the SGs for that rule and only print those containing the cidr
+// if a cidr or ip is given, only print the rules with a cidr that contains it, is inside it, or is the same
+// every source of a rule is a row, including ipv6 ranges, prefix lists and other security groups
 func WriteProfilesSgRules(profileSGs ProfilesSecurityGroups, options SgOptions) error {
-	outputDir := "output/ec2/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "sgRules.csv"
-	cidr := options.Cidr
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create sgRules file: %v", err)
+	var search netip.Prefix
+	if options.Cidr != "" {
+		var err error
+		search, err = utils.ParseCidr(options.Cidr)
+		if err != nil {
+			return err
+		}
 	}
-
-	fmt.Println("Writing SG Rules to file:", outfile.Name())
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-
 	var columnTitles = []string{"Profile",
 		"Account ID",
 		"Region",
@@ -210,6 +176,8 @@ func WriteProfilesSgRules(profileSGs ProfilesSecurityGroups, options SgOptions)
 		"Rule CIDR",
 		"From Port",
 		"To Port",
+		"Source Type",
+		"Match",
 	}
 
 	tags := options.Tags
@@ -219,96 +187,56 @@ func WriteProfilesSgRules(profileSGs ProfilesSecurityGroups, options SgOptions)
 		}
 	}
 
-	if err = writer.Write(columnTitles); err != nil {
-		fmt.Println(err)
-	}
-
+	report := utils.NewReport("ec2", "sgRules", columnTitles)
 	for _, accountSGs := range profileSGs {
 		for _, regionSGs := range accountSGs {
 			for _, SG := range regionSGs.SecurityGroups {
-				//the cidr option has been passed so need to search for it
-				if cidr != "" {
-					for _, rule := range SG.IpPermissions {
-						for _, ip := range rule.IpRanges {
-							if *ip.CidrIp == cidr {
-								var data = []string{regionSGs.Profile,
-									regionSGs.AccountId,
-									regionSGs.Region,
-									*SG.GroupName,
-									*SG.GroupId,
-									*rule.IpProtocol,
-									*ip.CidrIp,
-									strconv.FormatInt(*rule.FromPort, 10),
-									strconv.FormatInt(*rule.ToPort, 10),
-								}
-
-								if len(tags) > 0 {
-									for _, tag := range tags {
-										x := false
-										for _, SGTag := range SG.Tags {
-											if *SGTag.Key == tag {
-												data = append(data, *SGTag.Value)
-												x = true
-											}
-										}
-										if !x {
-											data = append(data, "")
-										}
-									}
-								}
-
-								err = writer.Write(data)
-								if err != nil {
-									fmt.Println(err)
-								}
+				for _, rule := range SG.IpPermissions {
+					fromPort, toPort := RulePorts(rule)
+					for _, source := range RuleSources(rule) {
+						//the cidr option has been passed so only print the rules that overlap it
+						var match string
+						if search.IsValid() {
+							if source.Type != SourceIpv4 && source.Type != SourceIpv6 {
+								continue
+							}
+							if match = utils.CidrMatch(search, source.Value); match == "" {
+								continue
 							}
 						}
-					}
-					//no cidr option, just print all the rules
-				} else {
-					for _, rule := range SG.IpPermissions {
-						var fromPort, toPort string
-						if rule.FromPort != nil {
-							fromPort = strconv.FormatInt(*rule.FromPort, 10)
-						}
-						if rule.ToPort != nil {
-							toPort = strconv.FormatInt(*rule.ToPort, 10)
+						var data = []string{regionSGs.Profile,
+							regionSGs.AccountId,
+							regionSGs.Region,
+							*SG.GroupName,
+							*SG.GroupId,
+							*rule.IpProtocol,
+							source.Value,
+							fromPort,
+							toPort,
+							source.Type,
+							match,
 						}
-						for _, ip := range rule.IpRanges {
-							var data = []string{regionSGs.Profile,
-								regionSGs.AccountId,
-								regionSGs.Region,
-								*SG.GroupName,
-								*SG.GroupId,
-								*rule.IpProtocol,
-								*ip.CidrIp,
-								fromPort,
-								toPort,
-							}
 
-							if len(tags) > 0 {
-								for _, tag := range tags {
-									x := false
-									for _, SGTag := range SG.Tags {
-										if *SGTag.Key == tag {
-											data = append(data, *SGTag.Value)
-											x = true
-										}
-									}
-									if !x {
-										data = append(data, "")
+						if len(tags) > 0 {
+							for _, tag := range tags {
+								x := false
+								for _, SGTag := range SG.Tags {
+									if *SGTag.Key == tag {
+										data = append(data, *SGTag.Value)
+										x = true
 									}
 								}
-							}
-
-							if err = writer.Write(data); err != nil {
-								fmt.Println(err)
+								if !x {
+									data = append(data, "")
+								}
 							}
 						}
+
+						report.AddRow(data)
 					}
 				}
 			}
 		}
 	}
-	return nil
+	return report.Write()
 }
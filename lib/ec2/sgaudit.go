@@ -0,0 +1,445 @@
+package ec2
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Rule source types
+const (
+	SourceIpv4          = "ipv4"
+	SourceIpv6          = "ipv6"
+	SourcePrefixList    = "prefixlist"
+	SourceSecurityGroup = "securitygroup"
+)
+
+// Finding severities, from the score of the finding
+const (
+	SeverityCritical = "critical" // 9 and above
+	SeverityHigh     = "high"     // 7 and above
+	SeverityMedium   = "medium"   // 4 and above
+	SeverityLow      = "low"
+)
+
+type (
+	// RuleSource is one source of a security group rule, such as a cidr or another security group
+	RuleSource struct {
+		Type  string
+		Value string
+	}
+
+	// SensitivePort is a port that should never be open to the internet
+	SensitivePort struct {
+		Name      string
+		Class     string   // admin or database
+		Protocols []string // tcp, and udp only if the service listens on it
+	}
+
+	// SgFinding is a security group rule open to the internet
+	SgFinding struct {
+		Profile   string
+		AccountId string
+		Region    string
+		GroupId   string
+		GroupName string
+		VpcId     string
+		Tags      []*ec2.Tag
+		Protocol  string
+		Source    string
+		FromPort  string
+		ToPort    string
+		Ports     []string // the sensitive ports the rule opens, such as 22 (ssh)
+		Score     int
+		Severity  string
+		Reason    string
+		// the network interfaces the group is attached to, what they are attached to, and their public ips
+		Interfaces []string
+		Resources  []string
+		PublicIps  []string
+	}
+)
+
+// the protocols of a sensitive port, most services only listen on tcp
+var (
+	tcpOnly   = []string{"tcp"}
+	tcpAndUdp = []string{"tcp", "udp"}
+)
+
+// SensitivePorts are the ports flagged when open to the internet, by port number
+var SensitivePorts = map[int64]SensitivePort{
+	21:    {"ftp", "admin", tcpOnly},
+	22:    {"ssh", "admin", tcpOnly},
+	23:    {"telnet", "admin", tcpOnly},
+	3389:  {"rdp", "admin", tcpOnly},
+	5900:  {"vnc", "admin", tcpOnly},
+	5985:  {"winrm", "admin", tcpOnly},
+	5986:  {"winrm", "admin", tcpOnly},
+	445:   {"smb", "database", tcpOnly},
+	1433:  {"mssql", "database", tcpOnly},
+	1521:  {"oracle", "database", tcpOnly},
+	2049:  {"nfs", "database", tcpAndUdp},
+	2379:  {"etcd", "database", tcpOnly},
+	3306:  {"mysql", "database", tcpOnly},
+	5432:  {"postgres", "database", tcpOnly},
+	5439:  {"redshift", "database", tcpOnly},
+	6379:  {"redis", "database", tcpOnly},
+	9042:  {"cassandra", "database", tcpOnly},
+	9092:  {"kafka", "database", tcpOnly},
+	9200:  {"elasticsearch", "database", tcpOnly},
+	11211: {"memcached", "database", tcpAndUdp},
+	27017: {"mongodb", "database", tcpOnly},
+}
+
+// RuleSources will return every source of the rule, including ipv6 ranges, prefix lists and security groups
+func RuleSources(rule *ec2.IpPermission) []RuleSource {
+	var sources []RuleSource
+	for _, ip := range rule.IpRanges {
+		sources = append(sources, RuleSource{SourceIpv4, aws.StringValue(ip.CidrIp)})
+	}
+	for _, ip := range rule.Ipv6Ranges {
+		sources = append(sources, RuleSource{SourceIpv6, aws.StringValue(ip.CidrIpv6)})
+	}
+	for _, prefixList := range rule.PrefixListIds {
+		sources = append(sources, RuleSource{SourcePrefixList, aws.StringValue(prefixList.PrefixListId)})
+	}
+	for _, pair := range rule.UserIdGroupPairs {
+		value := aws.StringValue(pair.GroupId)
+		if pair.UserId != nil {
+			value = *pair.UserId + "/" + value
+		}
+		sources = append(sources, RuleSource{SourceSecurityGroup, value})
+	}
+	return sources
+}
+
+// RulePorts will return the from and to ports of the rule, which are empty when it covers all ports
+func RulePorts(rule *ec2.IpPermission) (string, string) {
+	var fromPort, toPort string
+	if rule.FromPort != nil {
+		fromPort = strconv.FormatInt(*rule.FromPort, 10)
+	}
+	if rule.ToPort != nil {
+		toPort = strconv.FormatInt(*rule.ToPort, 10)
+	}
+	return fromPort, toPort
+}
+
+// ruleCoversPort will return true if the rule allows traffic to the port over one of the protocols
+func ruleCoversPort(rule *ec2.IpPermission, port int64, protocols []string) bool {
+	protocol := utils.RuleProtocol(rule)
+	if protocol == "all" {
+		return true
+	}
+	covered := false
+	for _, p := range protocols {
+		covered = covered || p == protocol
+	}
+	if !covered {
+		return false
+	}
+	if rule.FromPort == nil || rule.ToPort == nil {
+		return true
+	}
+	return *rule.FromPort <= port && port <= *rule.ToPort
+}
+
+// ruleCoversAllPorts will return true if the rule allows traffic to every port
+func ruleCoversAllPorts(rule *ec2.IpPermission) bool {
+	switch utils.RuleProtocol(rule) {
+	case "all":
+		return true
+	case "tcp", "udp":
+		return rule.FromPort == nil || rule.ToPort == nil || (*rule.FromPort <= 1 && *rule.ToPort >= 65535)
+	}
+	return false
+}
+
+// IsInternet will return true if the cidr is all of ipv4 or ipv6
+func IsInternet(cidr string) bool {
+	return cidr == "0.0.0.0/0" || cidr == "::/0"
+}
+
+// Severity will return the severity of the score
+func Severity(score int) string {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	}
+	return SeverityLow
+}
+
+// scoreRule will score an ingress rule open to the internet from 1 to 10, and return the sensitive ports it opens
+// A score of 0 is not a finding, such as a rule for only http and https
+func scoreRule(rule *ec2.IpPermission) (int, string, []string) {
+	var ports []int64
+	for port, sensitive := range SensitivePorts {
+		if ruleCoversPort(rule, port, sensitive.Protocols) {
+			ports = append(ports, port)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	var portNames []string
+	var admin, database bool
+	for _, port := range ports {
+		sensitive := SensitivePorts[port]
+		portNames = append(portNames, fmt.Sprintf("%d (%s)", port, sensitive.Name))
+		admin = admin || sensitive.Class == "admin"
+		database = database || sensitive.Class == "database"
+	}
+
+	if ruleCoversAllPorts(rule) {
+		return 10, "all ports are open to the internet", nil
+	}
+	switch {
+	case admin:
+		return 9, "remote admin ports are open to the internet", portNames
+	case database:
+		return 8, "database ports are open to the internet", portNames
+	}
+
+	switch utils.RuleProtocol(rule) {
+	case "tcp", "udp":
+	case "icmp", "icmpv6":
+		return 1, "icmp is open to the internet", nil
+	default:
+		return 3, "protocol " + utils.RuleProtocol(rule) + " is open to the internet", nil
+	}
+	from, to := aws.Int64Value(rule.FromPort), aws.Int64Value(rule.ToPort)
+	if to-from >= 100 {
+		return 5, fmt.Sprintf("%d ports are open to the internet", to-from+1), nil
+	}
+	if from == to && (from == 80 || from == 443) {
+		return 0, "", nil
+	}
+	return 3, "ports are open to the internet", nil
+}
+
+// internetSources will return the sources of the rule that are 0.0.0.0/0 or ::/0, along with the prefix lists that
+// have either of them as an entry, as <prefix list id> (<cidr>)
+func internetSources(rule *ec2.IpPermission, prefixLists map[string]PrefixList) []string {
+	var sources []string
+	for _, source := range RuleSources(rule) {
+		switch source.Type {
+		case SourceIpv4, SourceIpv6:
+			if IsInternet(source.Value) {
+				sources = append(sources, source.Value)
+			}
+		case SourcePrefixList:
+			for _, cidr := range prefixLists[source.Value].Cidrs {
+				if IsInternet(cidr) {
+					sources = append(sources, source.Value+" ("+cidr+")")
+				}
+			}
+		}
+	}
+	return sources
+}
+
+// AuditSecurityGroups will find the ingress rules open to 0.0.0.0/0 or ::/0, directly or through a managed prefix list,
+// score them, and add what each group is attached to
+// A group not attached to any network interface scores lower, as nothing is exposed until it is used
+func AuditSecurityGroups(profilesSGs ProfilesSecurityGroups, profilesInterfaces ProfilesNetworkInterfaces, profilesPrefixLists ProfilesPrefixLists) []SgFinding {
+	var findings []SgFinding
+	for _, accountSGs := range profilesSGs {
+		for _, regionSGs := range accountSGs {
+			prefixLists := profilesPrefixLists.FindRegion(regionSGs.AccountId, regionSGs.Region)
+			// the network interfaces of each group, by group id
+			groupInterfaces := make(map[string][]ec2.NetworkInterface)
+			for _, eni := range profilesInterfaces.FindRegion(regionSGs.AccountId, regionSGs.Region) {
+				for _, group := range eni.Groups {
+					groupId := aws.StringValue(group.GroupId)
+					groupInterfaces[groupId] = append(groupInterfaces[groupId], eni)
+				}
+			}
+
+			for _, SG := range regionSGs.SecurityGroups {
+				var interfaces, resources, publicIps []string
+				for _, eni := range groupInterfaces[*SG.GroupId] {
+					interfaces = append(interfaces, aws.StringValue(eni.NetworkInterfaceId))
+					resources = append(resources, InterfaceResource(eni))
+					if publicIp := InterfacePublicIp(eni); publicIp != "" {
+						publicIps = append(publicIps, publicIp)
+					}
+				}
+
+				for _, rule := range SG.IpPermissions {
+					for _, source := range internetSources(rule, prefixLists) {
+						score, reason, ports := scoreRule(rule)
+						if score == 0 {
+							continue
+						}
+						switch {
+						case len(interfaces) == 0:
+							score -= 3
+							reason += ", but the group is not attached to any network interface"
+						case len(publicIps) == 0:
+							score--
+							reason += ", but no attached network interface has a public ip"
+						}
+						if score < 1 {
+							score = 1
+						}
+
+						fromPort, toPort := RulePorts(rule)
+						findings = append(findings, SgFinding{
+							Profile:    regionSGs.Profile,
+							AccountId:  regionSGs.AccountId,
+							Region:     regionSGs.Region,
+							GroupId:    *SG.GroupId,
+							GroupName:  aws.StringValue(SG.GroupName),
+							VpcId:      aws.StringValue(SG.VpcId),
+							Tags:       SG.Tags,
+							Protocol:   utils.RuleProtocol(rule),
+							Source:     source,
+							FromPort:   fromPort,
+							ToPort:     toPort,
+							Ports:      ports,
+							Score:      score,
+							Severity:   Severity(score),
+							Reason:     reason,
+							Interfaces: interfaces,
+							Resources:  resources,
+							PublicIps:  publicIps,
+						})
+					}
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Profile != findings[j].Profile {
+			return findings[i].Profile < findings[j].Profile
+		}
+		return findings[i].Score > findings[j].Score
+	})
+	return findings
+}
+
+// WriteSgFindings will write every finding, and a summary of the findings in each account
+func WriteSgFindings(findings []SgFinding, options SgOptions) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Region",
+		"Security Group Name",
+		"Security Group ID",
+		"VPC ID",
+		"Severity",
+		"Score",
+		"Reason",
+		"Rule Protocol",
+		"Rule Source",
+		"From Port",
+		"To Port",
+		"Sensitive Ports",
+		"Network Interfaces",
+		"Attached To",
+		"Public IPs",
+	}
+
+	tags := options.Tags
+	if len(tags) > 0 {
+		for _, tag := range tags {
+			columnTitles = append(columnTitles, tag)
+		}
+	}
+
+	report := utils.NewReport("ec2", "sgaudit", columnTitles)
+	for _, finding := range findings {
+		var data = []string{finding.Profile,
+			finding.AccountId,
+			finding.Region,
+			finding.GroupName,
+			finding.GroupId,
+			finding.VpcId,
+			finding.Severity,
+			strconv.Itoa(finding.Score),
+			finding.Reason,
+			finding.Protocol,
+			finding.Source,
+			finding.FromPort,
+			finding.ToPort,
+			strings.Join(finding.Ports, "|"),
+			strings.Join(finding.Interfaces, "|"),
+			strings.Join(finding.Resources, "|"),
+			strings.Join(finding.PublicIps, "|"),
+		}
+
+		if len(tags) > 0 {
+			for _, tag := range tags {
+				data = append(data, utils.TagValue(finding.Tags, tag))
+			}
+		}
+
+		report.AddRow(data)
+	}
+	if err := report.Write(); err != nil {
+		return err
+	}
+
+	return writeSgFindingsSummary(findings)
+}
+
+// writeSgFindingsSummary will write how many findings of each severity are in each account
+func writeSgFindingsSummary(findings []SgFinding) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Critical",
+		"High",
+		"Medium",
+		"Low",
+		"Security Groups",
+		"Highest Score",
+	}
+
+	type accountSummary struct {
+		profile    string
+		accountId  string
+		severities map[string]int
+		groups     map[string]bool
+		highest    int
+	}
+	var order []string
+	summaries := make(map[string]*accountSummary)
+	for _, finding := range findings {
+		key := finding.Profile + "/" + finding.AccountId
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &accountSummary{profile: finding.Profile, accountId: finding.AccountId, severities: make(map[string]int), groups: make(map[string]bool)}
+			summaries[key] = summary
+			order = append(order, key)
+		}
+		summary.severities[finding.Severity]++
+		summary.groups[finding.Region+"/"+finding.GroupId] = true
+		if finding.Score > summary.highest {
+			summary.highest = finding.Score
+		}
+	}
+
+	report := utils.NewReport("ec2", "sgauditsummary", columnTitles)
+	for _, key := range order {
+		summary := summaries[key]
+		var data = []string{summary.profile,
+			summary.accountId,
+			strconv.Itoa(summary.severities[SeverityCritical]),
+			strconv.Itoa(summary.severities[SeverityHigh]),
+			strconv.Itoa(summary.severities[SeverityMedium]),
+			strconv.Itoa(summary.severities[SeverityLow]),
+			strconv.Itoa(len(summary.groups)),
+			strconv.Itoa(summary.highest),
+		}
+		report.AddRow(data)
+	}
+	return report.Write()
+}
@@ -0,0 +1,179 @@
+package ec2
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// testRule will return an ingress rule for the protocol and ports, from the cidrs
+func testRule(protocol string, fromPort int64, toPort int64, cidrs ...string) *ec2.IpPermission {
+	rule := &ec2.IpPermission{IpProtocol: aws.String(protocol)}
+	if fromPort >= 0 {
+		rule.FromPort = aws.Int64(fromPort)
+		rule.ToPort = aws.Int64(toPort)
+	}
+	for _, cidr := range cidrs {
+		rule.IpRanges = append(rule.IpRanges, &ec2.IpRange{CidrIp: aws.String(cidr)})
+	}
+	return rule
+}
+
+func TestScoreRule(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   *ec2.IpPermission
+		score  int
+		reason string
+		ports  []string
+	}{
+		{"all traffic", testRule("-1", -1, -1), 10, "all ports are open to the internet", nil},
+		{"every tcp port", testRule("tcp", 0, 65535), 10, "all ports are open to the internet", nil},
+		{"every udp port", testRule("udp", 1, 65535), 10, "all ports are open to the internet", nil},
+		{"ssh", testRule("tcp", 22, 22), 9, "remote admin ports are open to the internet", []string{"22 (ssh)"}},
+		{"ssh by protocol number", testRule("6", 22, 22), 9, "remote admin ports are open to the internet", []string{"22 (ssh)"}},
+		{"admin range", testRule("tcp", 20, 23), 9, "remote admin ports are open to the internet", []string{"21 (ftp)", "22 (ssh)", "23 (telnet)"}},
+		{"admin and database", testRule("tcp", 3306, 3389), 9, "remote admin ports are open to the internet", []string{"3306 (mysql)", "3389 (rdp)"}},
+		{"mysql", testRule("tcp", 3306, 3306), 8, "database ports are open to the internet", []string{"3306 (mysql)"}},
+		{"nfs over udp", testRule("udp", 2049, 2049), 8, "database ports are open to the internet", []string{"2049 (nfs)"}},
+		{"memcached over udp", testRule("17", 11211, 11211), 8, "database ports are open to the internet", []string{"11211 (memcached)"}},
+		{"ssh port over udp", testRule("udp", 22, 22), 3, "ports are open to the internet", nil},
+		{"rdp port over udp", testRule("udp", 3389, 3389), 3, "ports are open to the internet", nil},
+		{"mysql port over udp", testRule("udp", 3306, 3306), 3, "ports are open to the internet", nil},
+		{"udp range without udp services", testRule("udp", 1000, 2000), 5, "1001 ports are open to the internet", nil},
+		{"large tcp range", testRule("tcp", 8000, 8100), 5, "101 ports are open to the internet", nil},
+		{"small tcp range", testRule("tcp", 8080, 8081), 3, "ports are open to the internet", nil},
+		{"http", testRule("tcp", 80, 80), 0, "", nil},
+		{"https", testRule("tcp", 443, 443), 0, "", nil},
+		{"icmp", testRule("icmp", -1, -1), 1, "icmp is open to the internet", nil},
+		{"other protocol", testRule("50", -1, -1), 3, "protocol 50 is open to the internet", nil},
+	}
+	for _, test := range tests {
+		score, reason, ports := scoreRule(test.rule)
+		if score != test.score || reason != test.reason || !reflect.DeepEqual(ports, test.ports) {
+			t.Errorf("%s: scoreRule = %d, %q, %v, want %d, %q, %v", test.name, score, reason, ports, test.score, test.reason, test.ports)
+		}
+	}
+}
+
+func TestInternetSources(t *testing.T) {
+	prefixLists := map[string]PrefixList{
+		"pl-internet": {Id: "pl-internet", Cidrs: []string{"10.0.0.0/8", "0.0.0.0/0", "::/0"}},
+		"pl-office":   {Id: "pl-office", Cidrs: []string{"203.0.113.0/24"}},
+	}
+	prefixList := func(ids ...string) []*ec2.PrefixListId {
+		var prefixListIds []*ec2.PrefixListId
+		for _, id := range ids {
+			prefixListIds = append(prefixListIds, &ec2.PrefixListId{PrefixListId: aws.String(id)})
+		}
+		return prefixListIds
+	}
+
+	tests := []struct {
+		name string
+		rule *ec2.IpPermission
+		want []string
+	}{
+		{
+			name: "ipv4 and ipv6",
+			rule: &ec2.IpPermission{
+				IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("10.0.0.0/8")}, {CidrIp: aws.String("0.0.0.0/0")}},
+				Ipv6Ranges: []*ec2.Ipv6Range{{CidrIpv6: aws.String("::/0")}},
+			},
+			want: []string{"0.0.0.0/0", "::/0"},
+		},
+		{
+			name: "prefix list entries",
+			rule: &ec2.IpPermission{PrefixListIds: prefixList("pl-office", "pl-internet")},
+			want: []string{"pl-internet (0.0.0.0/0)", "pl-internet (::/0)"},
+		},
+		{
+			name: "unknown prefix lists are not resolved",
+			rule: &ec2.IpPermission{PrefixListIds: prefixList("pl-aws")},
+			want: nil,
+		},
+		{
+			name: "security groups and private ranges",
+			rule: &ec2.IpPermission{
+				IpRanges:         []*ec2.IpRange{{CidrIp: aws.String("192.168.0.0/16")}},
+				Ipv6Ranges:       []*ec2.Ipv6Range{{CidrIpv6: aws.String("2001:db8::/32")}},
+				UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String("sg-1")}},
+			},
+			want: nil,
+		},
+	}
+	for _, test := range tests {
+		if got := internetSources(test.rule, prefixLists); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: internetSources = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAuditSecurityGroups(t *testing.T) {
+	ssh := testRule("tcp", 22, 22)
+	ssh.PrefixListIds = []*ec2.PrefixListId{{PrefixListId: aws.String("pl-1")}}
+	group := func(id string, rules ...*ec2.IpPermission) ec2.SecurityGroup {
+		return ec2.SecurityGroup{GroupId: aws.String(id), GroupName: aws.String(id), VpcId: aws.String("vpc-1"), IpPermissions: rules}
+	}
+	regionSGs := func(region string) RegionSecurityGroups {
+		return RegionSecurityGroups{Profile: "prod", AccountId: "111111111111", Region: region, SecurityGroups: []ec2.SecurityGroup{
+			group("sg-public", ssh, testRule("tcp", 443, 443, "0.0.0.0/0")),
+			group("sg-private", testRule("tcp", 3306, 3306, "0.0.0.0/0")),
+			group("sg-unused", testRule("udp", 3389, 3389, "0.0.0.0/0")),
+		}}
+	}
+	profilesSGs := ProfilesSecurityGroups{{regionSGs("us-east-1"), regionSGs("us-west-2")}}
+	profilesInterfaces := ProfilesNetworkInterfaces{{{AccountId: "111111111111", Region: "us-east-1", NetworkInterfaces: []ec2.NetworkInterface{
+		{
+			NetworkInterfaceId: aws.String("eni-1"),
+			Groups:             []*ec2.GroupIdentifier{{GroupId: aws.String("sg-public")}},
+			Attachment:         &ec2.NetworkInterfaceAttachment{InstanceId: aws.String("i-1")},
+			Association:        &ec2.NetworkInterfaceAssociation{PublicIp: aws.String("198.51.100.1")},
+		},
+		{
+			NetworkInterfaceId: aws.String("eni-2"),
+			Groups:             []*ec2.GroupIdentifier{{GroupId: aws.String("sg-private")}},
+			Description:        aws.String("RDSNetworkInterface"),
+		},
+	}}}}
+	// the prefix list only has the internet in us-east-1, so the ssh rule in us-west-2 is not a finding
+	profilesPrefixLists := ProfilesPrefixLists{{
+		{AccountId: "111111111111", Region: "us-east-1", PrefixLists: []PrefixList{{Id: "pl-1", Cidrs: []string{"0.0.0.0/0"}}}},
+		{AccountId: "111111111111", Region: "us-west-2", PrefixLists: []PrefixList{{Id: "pl-1", Cidrs: []string{"10.0.0.0/8"}}}},
+	}}
+
+	// summary is the part of each finding that the rules and attachments decide
+	type summary struct {
+		Region   string
+		GroupId  string
+		Source   string
+		Score    int
+		Severity string
+		Reason   string
+	}
+	want := []summary{
+		{"us-east-1", "sg-public", "pl-1 (0.0.0.0/0)", 9, SeverityCritical, "remote admin ports are open to the internet"},
+		{"us-east-1", "sg-private", "0.0.0.0/0", 7, SeverityHigh, "database ports are open to the internet, but no attached network interface has a public ip"},
+		{"us-west-2", "sg-private", "0.0.0.0/0", 5, SeverityMedium, "database ports are open to the internet, but the group is not attached to any network interface"},
+		{"us-east-1", "sg-unused", "0.0.0.0/0", 1, SeverityLow, "ports are open to the internet, but the group is not attached to any network interface"},
+		{"us-west-2", "sg-unused", "0.0.0.0/0", 1, SeverityLow, "ports are open to the internet, but the group is not attached to any network interface"},
+	}
+
+	findings := AuditSecurityGroups(profilesSGs, profilesInterfaces, profilesPrefixLists)
+	var got []summary
+	for _, finding := range findings {
+		got = append(got, summary{finding.Region, finding.GroupId, finding.Source, finding.Score, finding.Severity, finding.Reason})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AuditSecurityGroups =\n%+v\nwant:\n%+v", got, want)
+	}
+	if len(findings) > 0 {
+		finding := findings[0]
+		if !reflect.DeepEqual(finding.Resources, []string{"i-1"}) || !reflect.DeepEqual(finding.PublicIps, []string{"198.51.100.1"}) ||
+			!reflect.DeepEqual(finding.Ports, []string{"22 (ssh)"}) {
+			t.Errorf("%s: attached to %v with public ips %v and ports %v", finding.GroupId, finding.Resources, finding.PublicIps, finding.Ports)
+		}
+	}
+}
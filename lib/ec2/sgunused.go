@@ -0,0 +1,307 @@
+package ec2
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// RegionSgUsage is the security groups in a region, and everything that can use them
+// Most services, such as rds, lambda and efs, use security groups through network interfaces
+type RegionSgUsage struct {
+	Profile              string
+	AccountId            string
+	Region               string
+	SecurityGroups       []ec2.SecurityGroup
+	NetworkInterfaces    []ec2.NetworkInterface
+	LaunchTemplates      []ec2.LaunchTemplateVersion
+	LaunchConfigurations []autoscaling.LaunchConfiguration
+	ClassicLoadBalancers []elb.LoadBalancerDescription
+	LoadBalancers        []elbv2.LoadBalancer
+}
+
+type AccountSgUsage []RegionSgUsage
+type ProfilesSgUsage []AccountSgUsage
+
+// GetRegionSgUsage will get the security groups in the region, along with the network interfaces, launch templates,
+// launch configurations and load balancers that use them
+// Any failure fails the whole region, so no group is seen as unused because a call failed
+func GetRegionSgUsage(sess *session.Session) (RegionSgUsage, error) {
+	var info RegionSgUsage
+	var groups RegionSecurityGroups
+	if err := groups.GetRegionSecurityGroups(sess); err != nil {
+		return info, fmt.Errorf("could not describe security groups: %w", err)
+	}
+	info.SecurityGroups = groups.SecurityGroups
+
+	var err error
+	info.NetworkInterfaces, err = GetRegionNetworkInterfaces(sess)
+	if err != nil {
+		return info, fmt.Errorf("could not describe network interfaces: %w", err)
+	}
+	info.LaunchTemplates, err = GetRegionLaunchTemplateVersions(sess)
+	if err != nil {
+		return info, err
+	}
+	info.LaunchConfigurations, err = GetRegionLaunchConfigurations(sess)
+	if err != nil {
+		return info, err
+	}
+
+	err = elb.New(sess).DescribeLoadBalancersPages(&elb.DescribeLoadBalancersInput{}, func(page *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, loadBalancer := range page.LoadBalancerDescriptions {
+			info.ClassicLoadBalancers = append(info.ClassicLoadBalancers, *loadBalancer)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe classic load balancers: %w", err)
+	}
+	err = elbv2.New(sess).DescribeLoadBalancersPages(&elbv2.DescribeLoadBalancersInput{}, func(page *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, loadBalancer := range page.LoadBalancers {
+			info.LoadBalancers = append(info.LoadBalancers, *loadBalancer)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe load balancers: %w", err)
+	}
+	return info, nil
+}
+
+// GetAccountSgUsage will take a profile and go through all regions to get the security groups and their usage in the account
+func GetAccountSgUsage(account utils.AccountInfo) (AccountSgUsage, error) {
+	fmt.Println("Getting security groups and their usage for profile:", account.Profile)
+	usageChan := make(chan RegionSgUsage)
+
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
+			sess, err := account.GetSession(region)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "GetSession", err)
+				return
+			}
+			info, err := GetRegionSgUsage(sess)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "Describe", err)
+				return
+			}
+			info.Profile = account.Profile
+			info.AccountId = account.AccountId
+			info.Region = region
+			usageChan <- info
+		})
+		close(usageChan)
+	}()
+
+	var accountUsage AccountSgUsage
+	for regionUsage := range usageChan {
+		accountUsage = append(accountUsage, regionUsage)
+	}
+	return accountUsage, nil
+}
+
+// GetProfilesSgUsage will get the security groups and their usage in all given accounts
+func GetProfilesSgUsage(accounts []utils.AccountInfo) (ProfilesSgUsage, error) {
+	profilesUsageChan := make(chan AccountSgUsage)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			accountUsage, err := GetAccountSgUsage(account)
+			if err != nil {
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
+				return
+			}
+			profilesUsageChan <- accountUsage
+		})
+		close(profilesUsageChan)
+	}()
+
+	var profilesUsage ProfilesSgUsage
+	for accountUsage := range profilesUsageChan {
+		profilesUsage = append(profilesUsage, accountUsage)
+	}
+	return profilesUsage, nil
+}
+
+// Usage will return what uses each security group in the region, by group id
+// A rule of a group referencing itself is not a use
+func (ru RegionSgUsage) Usage() map[string][]string {
+	usage := make(map[string][]string)
+	// launch configurations and templates can use group names, which are only unique in a vpc,
+	// so a name is a use of every group with that name
+	groupIds := make(map[string][]string)
+	for _, group := range ru.SecurityGroups {
+		groupIds[aws.StringValue(group.GroupName)] = append(groupIds[aws.StringValue(group.GroupName)], *group.GroupId)
+	}
+	add := func(group string, use string) {
+		ids := []string{group}
+		if !strings.HasPrefix(group, "sg-") && len(groupIds[group]) > 0 {
+			ids = groupIds[group]
+		}
+		for _, id := range ids {
+			found := false
+			for _, existing := range usage[id] {
+				found = found || existing == use
+			}
+			if !found {
+				usage[id] = append(usage[id], use)
+			}
+		}
+	}
+
+	for _, eni := range ru.NetworkInterfaces {
+		for _, group := range eni.Groups {
+			add(aws.StringValue(group.GroupId), "network interface "+aws.StringValue(eni.NetworkInterfaceId)+" ("+InterfaceResource(eni)+")")
+		}
+	}
+
+	for _, group := range ru.SecurityGroups {
+		for _, rules := range [][]*ec2.IpPermission{group.IpPermissions, group.IpPermissionsEgress} {
+			for _, rule := range rules {
+				for _, pair := range rule.UserIdGroupPairs {
+					referenced := aws.StringValue(pair.GroupId)
+					if referenced != *group.GroupId {
+						add(referenced, "rule of "+*group.GroupId)
+					}
+				}
+			}
+		}
+	}
+
+	for _, version := range ru.LaunchTemplates {
+		data := version.LaunchTemplateData
+		if data == nil {
+			continue
+		}
+		use := fmt.Sprintf("launch template %s:%d", aws.StringValue(version.LaunchTemplateName), aws.Int64Value(version.VersionNumber))
+		groups := append(aws.StringValueSlice(data.SecurityGroupIds), aws.StringValueSlice(data.SecurityGroups)...)
+		for _, eni := range data.NetworkInterfaces {
+			groups = append(groups, aws.StringValueSlice(eni.Groups)...)
+		}
+		for _, group := range groups {
+			add(group, use)
+		}
+	}
+
+	for _, config := range ru.LaunchConfigurations {
+		for _, group := range config.SecurityGroups {
+			add(aws.StringValue(group), "launch configuration "+aws.StringValue(config.LaunchConfigurationName))
+		}
+	}
+
+	for _, loadBalancer := range ru.ClassicLoadBalancers {
+		for _, group := range loadBalancer.SecurityGroups {
+			add(aws.StringValue(group), "classic load balancer "+aws.StringValue(loadBalancer.LoadBalancerName))
+		}
+	}
+	for _, loadBalancer := range ru.LoadBalancers {
+		for _, group := range loadBalancer.SecurityGroups {
+			add(aws.StringValue(group), "load balancer "+aws.StringValue(loadBalancer.LoadBalancerName))
+		}
+	}
+	return usage
+}
+
+// BuildSgUnusedPlan will plan to delete every security group that nothing uses
+// Default groups can not be deleted, so they are kept even when unused
+func BuildSgUnusedPlan(profilesUsage ProfilesSgUsage, options CleanupOptions, now time.Time) CleanupPlan {
+	plan := CleanupPlan{Name: "sgunused", Created: now, Options: options}
+
+	for _, accountUsage := range profilesUsage {
+		for _, regionUsage := range accountUsage {
+			usage := regionUsage.Usage()
+			regionCleanup := RegionCleanup{Profile: regionUsage.Profile, AccountId: regionUsage.AccountId, Region: regionUsage.Region}
+			for _, group := range regionUsage.SecurityGroups {
+				if len(usage[*group.GroupId]) > 0 {
+					continue
+				}
+				action := CleanupAction{
+					ResourceType: "securitygroup",
+					ResourceId:   *group.GroupId,
+					Name:         aws.StringValue(group.GroupName),
+					Reason:       "not used by any network interface, launch template, launch configuration, load balancer or other group in " + aws.StringValue(group.VpcId),
+				}
+				if aws.StringValue(group.GroupName) == "default" {
+					action.Profile = regionUsage.Profile
+					action.AccountId = regionUsage.AccountId
+					action.Region = regionUsage.Region
+					action.Action = ActionKeep
+					action.Reason = "default group of " + aws.StringValue(group.VpcId) + ", which can not be deleted"
+					plan.Actions = append(plan.Actions, action)
+					continue
+				}
+				plan.addAction(regionCleanup, action, group.Tags, now)
+			}
+		}
+	}
+	plan.sort()
+	return plan
+}
+
+// WriteSgUsage will write every security group and what uses it
+func WriteSgUsage(profilesUsage ProfilesSgUsage, options SgOptions) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Region",
+		"Security Group Name",
+		"Security Group ID",
+		"VPC ID",
+		"In Use",
+		"Used By",
+	}
+
+	tags := options.Tags
+	if len(tags) > 0 {
+		for _, tag := range tags {
+			columnTitles = append(columnTitles, tag)
+		}
+	}
+
+	report := utils.NewReport("ec2", "sgusage", columnTitles)
+	for _, accountUsage := range profilesUsage {
+		for _, regionUsage := range accountUsage {
+			usage := regionUsage.Usage()
+			groups := regionUsage.SecurityGroups
+			sort.SliceStable(groups, func(i, j int) bool { return *groups[i].GroupId < *groups[j].GroupId })
+			for _, group := range groups {
+				uses := usage[*group.GroupId]
+				var data = []string{regionUsage.Profile,
+					regionUsage.AccountId,
+					regionUsage.Region,
+					aws.StringValue(group.GroupName),
+					*group.GroupId,
+					aws.StringValue(group.VpcId),
+					fmt.Sprint(len(uses) > 0),
+					strings.Join(uses, "|"),
+				}
+
+				if len(tags) > 0 {
+					for _, tag := range tags {
+						data = append(data, utils.TagValue(group.Tags, tag))
+					}
+				}
+
+				report.AddRow(data)
+			}
+		}
+	}
+	return report.Write()
+}
@@ -0,0 +1,147 @@
+package ec2
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// testGroup will return a security group with ingress rules referencing each of the other groups
+func testGroup(id string, name string, tags []*ec2.Tag, referenced ...string) ec2.SecurityGroup {
+	group := ec2.SecurityGroup{GroupId: aws.String(id), GroupName: aws.String(name), VpcId: aws.String("vpc-1"), Tags: tags}
+	for _, other := range referenced {
+		group.IpPermissions = append(group.IpPermissions, &ec2.IpPermission{
+			IpProtocol:       aws.String("tcp"),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String(other)}},
+		})
+	}
+	return group
+}
+
+func TestRegionSgUsageUsage(t *testing.T) {
+	web := testGroup("sg-web", "web", nil, "sg-lb", "sg-web")
+	web.IpPermissionsEgress = []*ec2.IpPermission{{IpProtocol: aws.String("-1"), UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String("sg-db")}}}}
+	regionUsage := RegionSgUsage{
+		SecurityGroups: []ec2.SecurityGroup{
+			web,
+			testGroup("sg-db", "db", nil, "sg-web"),
+			testGroup("sg-lb", "lb", nil),
+			testGroup("sg-self", "self", nil, "sg-self"),
+			testGroup("sg-shared1", "shared", nil),
+			testGroup("sg-shared2", "shared", nil),
+			testGroup("sg-default", "default", nil),
+		},
+		NetworkInterfaces: []ec2.NetworkInterface{{
+			NetworkInterfaceId: aws.String("eni-1"),
+			Groups:             []*ec2.GroupIdentifier{{GroupId: aws.String("sg-web")}, {GroupId: aws.String("sg-default")}},
+			Attachment:         &ec2.NetworkInterfaceAttachment{InstanceId: aws.String("i-1")},
+		}},
+		LaunchTemplates: []ec2.LaunchTemplateVersion{{
+			LaunchTemplateName: aws.String("app"),
+			VersionNumber:      aws.Int64(2),
+			LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+				SecurityGroups:    aws.StringSlice([]string{"shared"}),
+				NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{{Groups: aws.StringSlice([]string{"sg-web"})}},
+			},
+		}},
+		LaunchConfigurations: []autoscaling.LaunchConfiguration{
+			{LaunchConfigurationName: aws.String("legacy"), SecurityGroups: aws.StringSlice([]string{"sg-web", "sg-gone"})},
+		},
+		ClassicLoadBalancers: []elb.LoadBalancerDescription{{LoadBalancerName: aws.String("classic"), SecurityGroups: aws.StringSlice([]string{"sg-lb"})}},
+		LoadBalancers:        []elbv2.LoadBalancer{{LoadBalancerName: aws.String("alb"), SecurityGroups: aws.StringSlice([]string{"sg-lb"})}},
+	}
+
+	want := map[string][]string{
+		"sg-web":     {"network interface eni-1 (i-1)", "rule of sg-db", "launch template app:2", "launch configuration legacy"},
+		"sg-default": {"network interface eni-1 (i-1)"},
+		"sg-lb":      {"rule of sg-web", "classic load balancer classic", "load balancer alb"},
+		"sg-db":      {"rule of sg-web"},
+		"sg-shared1": {"launch template app:2"},
+		"sg-shared2": {"launch template app:2"},
+		"sg-gone":    {"launch configuration legacy"},
+	}
+	if got := regionUsage.Usage(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Usage =\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestBuildSgUnusedPlan(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	marked := func(days int) []*ec2.Tag {
+		return []*ec2.Tag{{Key: aws.String(CleanupTagKey), Value: aws.String(now.AddDate(0, 0, -days).Format(time.RFC3339))}}
+	}
+	regionUsage := RegionSgUsage{
+		Profile:   "prod",
+		AccountId: "111111111111",
+		Region:    "us-east-1",
+		SecurityGroups: []ec2.SecurityGroup{
+			testGroup("sg-web", "web", nil),
+			testGroup("sg-db", "db", nil, "sg-web"),
+			testGroup("sg-self", "self", nil, "sg-self"),
+			testGroup("sg-default", "default", nil),
+			testGroup("sg-marked-old", "old", marked(10)),
+			testGroup("sg-marked-new", "new", marked(2)),
+		},
+		NetworkInterfaces: []ec2.NetworkInterface{
+			{NetworkInterfaceId: aws.String("eni-1"), Groups: []*ec2.GroupIdentifier{{GroupId: aws.String("sg-db")}}},
+		},
+	}
+	unused := "not used by any network interface, launch template, launch configuration, load balancer or other group in vpc-1"
+	defaultGroup := "default group of vpc-1, which can not be deleted"
+
+	// planned is the part of each action that the options decide
+	type planned struct {
+		ResourceId string
+		Action     string
+		Reason     string
+		MarkedAt   string
+	}
+	tests := []struct {
+		name    string
+		options CleanupOptions
+		want    []planned
+	}{
+		{
+			name:    "delete right away",
+			options: CleanupOptions{},
+			want: []planned{
+				{"sg-default", ActionKeep, defaultGroup, ""},
+				{"sg-marked-new", ActionDelete, unused, ""},
+				{"sg-marked-old", ActionDelete, unused, ""},
+				{"sg-self", ActionDelete, unused, ""},
+			},
+		},
+		{
+			name:    "grace days mark, wait, then delete",
+			options: CleanupOptions{GraceDays: 7},
+			want: []planned{
+				{"sg-default", ActionKeep, defaultGroup, ""},
+				{"sg-marked-new", ActionWait, unused, now.AddDate(0, 0, -2).Format(time.RFC3339)},
+				{"sg-marked-old", ActionDelete, unused, now.AddDate(0, 0, -10).Format(time.RFC3339)},
+				{"sg-self", ActionMark, unused, ""},
+			},
+		},
+	}
+	for _, test := range tests {
+		plan := BuildSgUnusedPlan(ProfilesSgUsage{{regionUsage}}, test.options, now)
+		if plan.Name != "sgunused" || !reflect.DeepEqual(plan.Options, test.options) {
+			t.Errorf("%s: plan is %s with %+v", test.name, plan.Name, plan.Options)
+		}
+		var got []planned
+		for _, action := range plan.Actions {
+			if action.Profile != "prod" || action.AccountId != "111111111111" || action.Region != "us-east-1" || action.ResourceType != "securitygroup" {
+				t.Errorf("%s: %s is a %s in %s %s %s", test.name, action.ResourceId, action.ResourceType, action.Profile, action.AccountId, action.Region)
+			}
+			got = append(got, planned{action.ResourceId, action.Action, action.Reason, action.MarkedAt})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: actions =\n%+v\nwant:\n%+v", test.name, got, test.want)
+		}
+	}
+}
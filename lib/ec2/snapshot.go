@@ -1,13 +1,10 @@
 package ec2
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws"
@@ -58,32 +55,29 @@ func GetAccountSnapshots(account utils.AccountInfo) (AccountSnapshots, error) {
 	profile := account.Profile
 	fmt.Println("Getting snapshots for profile:", profile)
 	snapshotsChan := make(chan RegionSnapshots)
-	var wg sync.WaitGroup
 
-	for _, region := range utils.RegionMap {
-		wg.Add(1)
-		go func(region string) {
-			defer wg.Done()
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
 			info := RegionSnapshots{AccountId: account.AccountId, Region: region, Profile: profile}
 			sess, err := account.GetSession(region)
 			if err != nil {
-				log.Println("Could not get snapshots for", account.Profile, ":", err)
+				utils.RecordError(account, region, "ec2", "GetSession", err)
 				return
 			}
 			if err = info.GetRegionSnapshots(sess, info.AccountId); err != nil {
-				log.Println("Could not get snapshots for", account.Profile, ":", err)
+				utils.RecordError(account, region, "ec2", "DescribeSnapshots", err)
 				return
 			}
 			info.Volumes, err = GetRegionVolumes(sess)
 			if err != nil {
-				log.Println("Could not get volumes for", account.Profile, ":", err)
+				utils.RecordError(account, region, "ec2", "DescribeVolumes", err)
 			}
 			snapshotsChan <- info
-		}(region)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(snapshotsChan)
 	}()
 
@@ -98,26 +92,20 @@ func GetAccountSnapshots(account utils.AccountInfo) (AccountSnapshots, error) {
 // GetProfilesSnapshots will return all the snapshots in all accounts of a given filename with a list of profiles in it
 func GetProfilesSnapshots(accounts []utils.AccountInfo) (ProfilesSnapshots, error) {
 	profilesSnapshotsChan := make(chan AccountSnapshots)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			if err := account.SetAccountId(); err != nil {
-				log.Println("could not set account id for", account.Profile, ":", err)
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
 				return
 			}
 			accountSnapshots, err := GetAccountSnapshots(account)
 			if err != nil {
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
 				return
 			}
 			profilesSnapshotsChan <- accountSnapshots
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesSnapshotsChan)
 	}()
 
@@ -129,16 +117,6 @@ func GetProfilesSnapshots(accounts []utils.AccountInfo) (ProfilesSnapshots, erro
 }
 
 func WriteProfilesSnapshots(profileSnapshots ProfilesSnapshots, options utils.Ec2Options) error {
-	outputDir := "output/ec2/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "snapshots.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create snapshots file: %v", err)
-	}
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing snapshots to file:", outfile.Name())
 	var columnTitles = []string{"Profile",
 		"Account ID",
 		"Region",
@@ -160,8 +138,7 @@ func WriteProfilesSnapshots(profileSnapshots ProfilesSnapshots, options utils.Ec
 		}
 	}
 
-	err = writer.Write(columnTitles)
-
+	report := utils.NewReport("ec2", "snapshots", columnTitles)
 	for _, accountSnapshots := range profileSnapshots {
 		for _, regionSnapshots := range accountSnapshots {
 			for _, snapshot := range regionSnapshots.Snapshots {
@@ -242,12 +219,9 @@ func WriteProfilesSnapshots(profileSnapshots ProfilesSnapshots, options utils.Ec
 					}
 				}
 
-				err = writer.Write(data)
-				if err != nil {
-					fmt.Println(err)
-				}
+				report.AddRow(data)
 			}
 		}
 	}
-	return nil
+	return report.Write()
 }
@@ -1,12 +1,9 @@
 package ec2
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws"
@@ -31,16 +28,17 @@ func GetRegionVolumes(sess *session.Session) ([]ec2.Volume, error) {
 		DryRun: aws.Bool(false),
 	}
 
-	resp, err := ec2.New(sess).DescribeVolumes(params)
+	err := ec2.New(sess).DescribeVolumesPages(params, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		//Add the volumes from the response to a slice to return
+		for _, volume := range page.Volumes {
+			volumes = append(volumes, *volume)
+		}
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	//Add the volumes from the response to a slice to return
-	for _, volume := range resp.Volumes {
-		volumes = append(volumes, *volume)
-	}
-
 	return volumes, nil
 }
 
@@ -49,29 +47,26 @@ func GetAccountVolumes(account utils.AccountInfo) (AccountVolumes, error) {
 	profile := account.Profile
 	fmt.Println("Getting volumes for profile:", profile)
 	volumesChan := make(chan RegionVolumes)
-	var wg sync.WaitGroup
 
-	for _, region := range utils.RegionMap {
-		wg.Add(1)
-		go func(region string) {
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
 			info := RegionVolumes{AccountId: account.AccountId, Profile: profile, Region: region}
-			defer wg.Done()
 			sess, err := account.GetSession(region)
 			if err != nil {
-				log.Println("Could not get volumes for", account.Profile, ":", err)
+				utils.RecordError(account, region, "ec2", "GetSession", err)
 				return
 			}
 			info.Volumes, err = GetRegionVolumes(sess)
 			if err != nil {
-				log.Println("Could not get volumes for", account.Profile, ":", err)
+				utils.RecordError(account, region, "ec2", "DescribeVolumes", err)
 				return
 			}
 			volumesChan <- info
-		}(region)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(volumesChan)
 	}()
 
@@ -86,26 +81,20 @@ func GetAccountVolumes(account utils.AccountInfo) (AccountVolumes, error) {
 // GetProfilesVolumes will return all the volumes in all accounts of a given filename with a list of profiles in it
 func GetProfilesVolumes(accounts []utils.AccountInfo) (ProfilesVolumes, error) {
 	profilesVolumesChan := make(chan AccountVolumes)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			if err := account.SetAccountId(); err != nil {
-				log.Println("could not set account id for", account.Profile, ":", err)
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
 				return
 			}
 			accountVolumes, err := GetAccountVolumes(account)
 			if err != nil {
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
 				return
 			}
 			profilesVolumesChan <- accountVolumes
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesVolumesChan)
 	}()
 
@@ -117,16 +106,6 @@ func GetProfilesVolumes(accounts []utils.AccountInfo) (ProfilesVolumes, error) {
 }
 
 func WriteProfilesVolumes(profileVolumes ProfilesVolumes, options utils.Ec2Options) error {
-	outputDir := "output/ec2/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "volumes.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create volumes file: %v", err)
-	}
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing volumes to file:", outfile.Name())
 	var columnTitles = []string{
 		"Account ID",
 		"Profile",
@@ -147,8 +126,7 @@ func WriteProfilesVolumes(profileVolumes ProfilesVolumes, options utils.Ec2Optio
 		}
 	}
 
-	err = writer.Write(columnTitles)
-
+	report := utils.NewReport("ec2", "volumes", columnTitles)
 	for _, accountVolumes := range profileVolumes {
 		for _, regionVolumes := range accountVolumes {
 			for _, volume := range regionVolumes.Volumes {
@@ -218,12 +196,9 @@ func WriteProfilesVolumes(profileVolumes ProfilesVolumes, options utils.Ec2Optio
 					}
 				}
 
-				err = writer.Write(data)
-				if err != nil {
-					fmt.Println(err)
-				}
+				report.AddRow(data)
 			}
 		}
 	}
-	return nil
+	return report.Write()
 }
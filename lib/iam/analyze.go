@@ -0,0 +1,531 @@
+package iam
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// Policy finding rules
+const (
+	RuleFullAdmin           = "full-admin"
+	RulePassRoleWildcard    = "passrole-wildcard"
+	RuleNotActionAllow      = "notaction-allow"
+	RulePrivilegeEscalation = "privilege-escalation"
+)
+
+// Policy finding severities
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+)
+
+// Policy types
+const (
+	PolicyManaged    = "managed"
+	PolicyAwsManaged = "aws managed"
+	PolicyInline     = "inline"
+	PolicyGroup      = "group"
+	PolicyCombined   = "combined"
+)
+
+// Escalation is a set of actions that together let a principal give itself more permissions
+type Escalation struct {
+	Name    string
+	Actions []string
+}
+
+// FoundEscalation is an escalation that documents allow
+// It is conditional when any of its actions is only allowed with a condition, or a deny with a condition or limited
+// resources applies to it, so whether it is allowed depends on the request
+type FoundEscalation struct {
+	Escalation
+	Conditional bool
+}
+
+// PrivilegeEscalations are the known combinations of actions that allow privilege escalation
+var PrivilegeEscalations = []Escalation{
+	{"create a new version of a managed policy", []string{"iam:CreatePolicyVersion"}},
+	{"set the default version of a managed policy", []string{"iam:SetDefaultPolicyVersion"}},
+	{"create access keys for another user", []string{"iam:CreateAccessKey"}},
+	{"create a console password for another user", []string{"iam:CreateLoginProfile"}},
+	{"change the console password of another user", []string{"iam:UpdateLoginProfile"}},
+	{"attach a managed policy to a user", []string{"iam:AttachUserPolicy"}},
+	{"attach a managed policy to a group", []string{"iam:AttachGroupPolicy"}},
+	{"attach a managed policy to a role", []string{"iam:AttachRolePolicy"}},
+	{"put an inline policy on a user", []string{"iam:PutUserPolicy"}},
+	{"put an inline policy on a group", []string{"iam:PutGroupPolicy"}},
+	{"put an inline policy on a role", []string{"iam:PutRolePolicy"}},
+	{"add a user to a group", []string{"iam:AddUserToGroup"}},
+	{"change the trust policy of a role and assume it", []string{"iam:UpdateAssumeRolePolicy", "sts:AssumeRole"}},
+	{"pass a role to a new ec2 instance", []string{"iam:PassRole", "ec2:RunInstances"}},
+	{"pass a role to a new lambda function and invoke it", []string{"iam:PassRole", "lambda:CreateFunction", "lambda:InvokeFunction"}},
+	{"pass a role to a new lambda function with an event source", []string{"iam:PassRole", "lambda:CreateFunction", "lambda:CreateEventSourceMapping"}},
+	{"change the code of an existing lambda function", []string{"lambda:UpdateFunctionCode"}},
+	{"pass a role to a new glue dev endpoint", []string{"iam:PassRole", "glue:CreateDevEndpoint"}},
+	{"change the ssh key of an existing glue dev endpoint", []string{"glue:UpdateDevEndpoint"}},
+	{"pass a role to a new cloudformation stack", []string{"iam:PassRole", "cloudformation:CreateStack"}},
+	{"pass a role to a new data pipeline", []string{"iam:PassRole", "datapipeline:CreatePipeline", "datapipeline:PutPipelineDefinition"}},
+}
+
+type (
+	// ProfileAuthorization is every user, group, role and managed policy in an account, with their policy documents
+	ProfileAuthorization struct {
+		Profile   string
+		AccountId string
+		Users     []iam.UserDetail
+		Groups    []iam.GroupDetail
+		Roles     []iam.RoleDetail
+		Policies  []iam.ManagedPolicyDetail
+	}
+	ProfilesAuthorization []ProfileAuthorization
+
+	// NamedDocument is a policy document along with where it came from
+	NamedDocument struct {
+		Name     string
+		Type     string
+		Document Document
+	}
+
+	// DocumentFinding is a rule a policy document, or a set of documents, breaks
+	DocumentFinding struct {
+		Rule     string
+		Severity string
+		Sid      string
+		Details  string
+	}
+
+	// PolicyFinding is a finding for a managed policy, role or user in an account
+	PolicyFinding struct {
+		Profile       string
+		AccountId     string
+		PrincipalType string // policy, role or user
+		PrincipalName string
+		PolicyName    string
+		PolicyType    string
+		DocumentFinding
+	}
+)
+
+// GetProfileAuthorization will get the users, groups, roles and managed policies of the account
+// Only the managed policies aws includes in the details are returned, which are the local policies and the attached aws policies
+func GetProfileAuthorization(sess *session.Session) (ProfileAuthorization, error) {
+	var info ProfileAuthorization
+	err := iam.New(sess).GetAccountAuthorizationDetailsPages(&iam.GetAccountAuthorizationDetailsInput{}, func(page *iam.GetAccountAuthorizationDetailsOutput, lastPage bool) bool {
+		for _, user := range page.UserDetailList {
+			info.Users = append(info.Users, *user)
+		}
+		for _, group := range page.GroupDetailList {
+			info.Groups = append(info.Groups, *group)
+		}
+		for _, role := range page.RoleDetailList {
+			info.Roles = append(info.Roles, *role)
+		}
+		for _, policy := range page.Policies {
+			info.Policies = append(info.Policies, *policy)
+		}
+		return true
+	})
+	return info, err
+}
+
+// GetProfilesAuthorization will get the users, groups, roles and managed policies of all given accounts
+func GetProfilesAuthorization(accounts []utils.AccountInfo) (ProfilesAuthorization, error) {
+	profilesAuthorizationChan := make(chan ProfileAuthorization)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			fmt.Println("Getting authorization details for profile:", account.Profile)
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			sess, err := account.GetSession("us-east-1")
+			if err != nil {
+				utils.RecordError(account, "", "iam", "GetSession", err)
+				return
+			}
+			profileAuthorization, err := GetProfileAuthorization(sess)
+			if err != nil {
+				utils.RecordError(account, "", "iam", "GetAccountAuthorizationDetails", err)
+				return
+			}
+			profileAuthorization.Profile = account.Profile
+			profileAuthorization.AccountId = account.AccountId
+			profilesAuthorizationChan <- profileAuthorization
+		})
+		close(profilesAuthorizationChan)
+	}()
+
+	var profilesAuthorization ProfilesAuthorization
+	for profileAuthorization := range profilesAuthorizationChan {
+		profilesAuthorization = append(profilesAuthorization, profileAuthorization)
+	}
+	return profilesAuthorization, nil
+}
+
+// DefaultDocument will return the default version of the managed policy document
+func DefaultDocument(policy iam.ManagedPolicyDetail) (Document, error) {
+	for _, version := range policy.PolicyVersionList {
+		if aws.BoolValue(version.IsDefaultVersion) {
+			return ParseDocument(aws.StringValue(version.Document))
+		}
+	}
+	return Document{}, fmt.Errorf("policy %s has no default version", aws.StringValue(policy.PolicyName))
+}
+
+// managedPolicyType will return if the policy is managed by aws or the account
+func managedPolicyType(arn string) string {
+	if strings.HasPrefix(arn, "arn:aws:iam::aws:") {
+		return PolicyAwsManaged
+	}
+	return PolicyManaged
+}
+
+// wildcardMatch will match the value to an iam pattern with * and ?, ignoring case as iam does for actions
+func wildcardMatch(pattern string, value string) bool {
+	pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+	p, v := 0, 0
+	star, match := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, v
+			p++
+		case star != -1:
+			p = star + 1
+			match++
+			v = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchesAny will return if the value matches any of the patterns
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if wildcardMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAction will return if the statement applies to the action, through Action or NotAction
+func (s Statement) hasAction(action string) bool {
+	if len(s.NotAction) > 0 {
+		return !matchesAny(s.NotAction, action)
+	}
+	return matchesAny(s.Action, action)
+}
+
+// allActions will return if the statement has an Action of * or *:*
+func (s Statement) allActions() bool {
+	for _, action := range s.Action {
+		if action == "*" || action == "*:*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allResources will return if the statement applies to every resource
+func (s Statement) allResources() bool {
+	if len(s.NotResource) > 0 {
+		return false
+	}
+	for _, resource := range s.Resource {
+		if resource == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows will return if the documents allow the action on some resource, and do not deny it on every resource
+// without a condition
+// The access is conditional when only allow statements with a condition allow it, or when a deny with a condition,
+// NotResource or limited resources applies to it.  Conditions and resources are not evaluated, so a conditional
+// action may or may not be allowed for a request
+func Allows(documents []NamedDocument, action string) (allowed bool, conditional bool) {
+	unconditionalAllow, limitedDeny := false, false
+	for _, named := range documents {
+		for _, statement := range named.Document.Statement {
+			if !statement.hasAction(action) {
+				continue
+			}
+			switch statement.Effect {
+			case "Allow":
+				allowed = true
+				unconditionalAllow = unconditionalAllow || len(statement.Condition) == 0
+			case "Deny":
+				if statement.allResources() && len(statement.Condition) == 0 {
+					return false, false
+				}
+				limitedDeny = true
+			}
+		}
+	}
+	return allowed, allowed && (!unconditionalAllow || limitedDeny)
+}
+
+// AnalyzeDocument will find the statements of the document that allow every action, pass any role, or use NotAction
+// with Allow, along with the privilege escalations the whole document allows
+func AnalyzeDocument(document Document) []DocumentFinding {
+	var findings []DocumentFinding
+	// full admin is found first, as the passrole findings depend on it whatever order the statements are in
+	fullAdmin := false
+	for _, statement := range document.Statement {
+		if statement.Effect == "Allow" && len(statement.NotAction) == 0 && statement.allActions() && statement.allResources() {
+			fullAdmin = true
+		}
+	}
+	for i, statement := range document.Statement {
+		if statement.Effect != "Allow" {
+			continue
+		}
+		sid := statement.Sid
+		if sid == "" {
+			sid = fmt.Sprintf("statement %d", i+1)
+		}
+		conditional := ""
+		if len(statement.Condition) > 0 {
+			conditional = ", with a condition"
+		}
+
+		switch {
+		case len(statement.NotAction) > 0:
+			severity := SeverityMedium
+			if statement.allResources() {
+				severity = SeverityHigh
+			}
+			findings = append(findings, DocumentFinding{
+				Rule:     RuleNotActionAllow,
+				Severity: severity,
+				Sid:      sid,
+				Details:  "allows every action except " + strings.Join(statement.NotAction, ", ") + " on " + resourceList(statement) + conditional,
+			})
+		case statement.allActions() && statement.allResources():
+			findings = append(findings, DocumentFinding{Rule: RuleFullAdmin, Severity: SeverityCritical, Sid: sid, Details: "allows every action on every resource" + conditional})
+		}
+
+		if statement.hasAction("iam:PassRole") && statement.allResources() && !fullAdmin {
+			findings = append(findings, DocumentFinding{Rule: RulePassRoleWildcard, Severity: SeverityHigh, Sid: sid, Details: "allows passing any role to a service" + conditional})
+		}
+	}
+
+	// full admin can already do everything, so listing the escalations only adds noise
+	if !fullAdmin {
+		for _, escalation := range FindEscalations([]NamedDocument{{Document: document}}) {
+			findings = append(findings, escalationFinding(escalation))
+		}
+	}
+	return findings
+}
+
+// FindEscalations will return the privilege escalations all of the documents together allow
+func FindEscalations(documents []NamedDocument) []FoundEscalation {
+	var escalations []FoundEscalation
+	for _, known := range PrivilegeEscalations {
+		allowed := true
+		escalation := FoundEscalation{Escalation: known}
+		for _, action := range known.Actions {
+			actionAllowed, conditional := Allows(documents, action)
+			allowed = allowed && actionAllowed
+			escalation.Conditional = escalation.Conditional || conditional
+		}
+		if allowed {
+			escalations = append(escalations, escalation)
+		}
+	}
+	return escalations
+}
+
+// escalationFinding will return the finding for an escalation, which is medium instead of high if it is conditional
+func escalationFinding(escalation FoundEscalation) DocumentFinding {
+	finding := DocumentFinding{Rule: RulePrivilegeEscalation, Severity: SeverityHigh, Details: escalation.Name + " with " + strings.Join(escalation.Actions, " + ")}
+	if escalation.Conditional {
+		finding.Severity = SeverityMedium
+		finding.Details += ", conditional on a condition or a limited deny"
+	}
+	return finding
+}
+
+func resourceList(statement Statement) string {
+	if len(statement.NotResource) > 0 {
+		return "every resource except " + strings.Join(statement.NotResource, ", ")
+	}
+	return strings.Join(statement.Resource, ", ")
+}
+
+// AnalyzePrincipal will analyze each policy document of a role or user, and the escalations that are only allowed
+// by combining the documents
+func AnalyzePrincipal(documents []NamedDocument) []PolicyFinding {
+	var findings []PolicyFinding
+	fullAdmin := false
+	for _, named := range documents {
+		for _, finding := range AnalyzeDocument(named.Document) {
+			// nothing else matters for a principal with full admin
+			fullAdmin = fullAdmin || finding.Rule == RuleFullAdmin
+			findings = append(findings, PolicyFinding{PolicyName: named.Name, PolicyType: named.Type, DocumentFinding: finding})
+		}
+	}
+	if fullAdmin {
+		return findings
+	}
+
+	// the escalations a single document allows are already found for that document
+	single := make(map[string]bool)
+	for _, named := range documents {
+		for _, escalation := range FindEscalations([]NamedDocument{named}) {
+			single[escalation.Name] = true
+		}
+	}
+
+	var names []string
+	for _, named := range documents {
+		names = append(names, named.Name)
+	}
+	for _, escalation := range FindEscalations(documents) {
+		if single[escalation.Name] {
+			continue
+		}
+		findings = append(findings, PolicyFinding{
+			PolicyName:      strings.Join(names, "|"),
+			PolicyType:      PolicyCombined,
+			DocumentFinding: escalationFinding(escalation),
+		})
+	}
+	return findings
+}
+
+// AnalyzeAuthorization will analyze every managed policy in the account, and every role and user with all of their
+// inline, attached and group policies
+func AnalyzeAuthorization(profileAuthorization ProfileAuthorization) []PolicyFinding {
+	var findings []PolicyFinding
+	add := func(principalType string, principalName string, principalFindings []PolicyFinding) {
+		for _, finding := range principalFindings {
+			finding.Profile = profileAuthorization.Profile
+			finding.AccountId = profileAuthorization.AccountId
+			finding.PrincipalType = principalType
+			finding.PrincipalName = principalName
+			findings = append(findings, finding)
+		}
+	}
+	parseError := func(name string, err error) {
+		fmt.Println("Could not analyze policy", name, "in", profileAuthorization.Profile, ":", err)
+	}
+
+	managed := make(map[string]NamedDocument)
+	for _, policy := range profileAuthorization.Policies {
+		name := aws.StringValue(policy.PolicyName)
+		document, err := DefaultDocument(policy)
+		if err != nil {
+			parseError(name, err)
+			continue
+		}
+		named := NamedDocument{Name: name, Type: managedPolicyType(aws.StringValue(policy.Arn)), Document: document}
+		managed[aws.StringValue(policy.Arn)] = named
+		add("policy", name, AnalyzePrincipal([]NamedDocument{named}))
+	}
+	attached := func(policies []*iam.AttachedPolicy) []NamedDocument {
+		var documents []NamedDocument
+		for _, policy := range policies {
+			if named, ok := managed[aws.StringValue(policy.PolicyArn)]; ok {
+				documents = append(documents, named)
+			}
+		}
+		return documents
+	}
+	inline := func(policies []*iam.PolicyDetail, policyType string) []NamedDocument {
+		var documents []NamedDocument
+		for _, policy := range policies {
+			document, err := ParseDocument(aws.StringValue(policy.PolicyDocument))
+			if err != nil {
+				parseError(aws.StringValue(policy.PolicyName), err)
+				continue
+			}
+			documents = append(documents, NamedDocument{Name: aws.StringValue(policy.PolicyName), Type: policyType, Document: document})
+		}
+		return documents
+	}
+
+	groups := make(map[string][]NamedDocument)
+	for _, group := range profileAuthorization.Groups {
+		documents := inline(group.GroupPolicyList, PolicyGroup)
+		for _, named := range attached(group.AttachedManagedPolicies) {
+			named.Type = PolicyGroup
+			documents = append(documents, named)
+		}
+		groups[aws.StringValue(group.GroupName)] = documents
+	}
+
+	for _, role := range profileAuthorization.Roles {
+		documents := append(inline(role.RolePolicyList, PolicyInline), attached(role.AttachedManagedPolicies)...)
+		add("role", aws.StringValue(role.RoleName), AnalyzePrincipal(documents))
+	}
+	for _, user := range profileAuthorization.Users {
+		documents := append(inline(user.UserPolicyList, PolicyInline), attached(user.AttachedManagedPolicies)...)
+		for _, group := range user.GroupList {
+			documents = append(documents, groups[aws.StringValue(group)]...)
+		}
+		add("user", aws.StringValue(user.UserName), AnalyzePrincipal(documents))
+	}
+	return findings
+}
+
+// WritePolicyFindings will write the findings of every account, most severe first
+func WritePolicyFindings(profilesAuthorization ProfilesAuthorization) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Principal Type",
+		"Principal Name",
+		"Policy Name",
+		"Policy Type",
+		"Statement",
+		"Rule",
+		"Severity",
+		"Details",
+	}
+
+	var findings []PolicyFinding
+	for _, profileAuthorization := range profilesAuthorization {
+		findings = append(findings, AnalyzeAuthorization(profileAuthorization)...)
+	}
+	severities := map[string]int{SeverityCritical: 0, SeverityHigh: 1, SeverityMedium: 2}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severities[findings[i].Severity] < severities[findings[j].Severity]
+	})
+
+	report := utils.NewReport("iam", "policyanalysis", columnTitles)
+	for _, finding := range findings {
+		var data = []string{finding.Profile,
+			finding.AccountId,
+			finding.PrincipalType,
+			finding.PrincipalName,
+			finding.PolicyName,
+			finding.PolicyType,
+			finding.Sid,
+			finding.Rule,
+			finding.Severity,
+			finding.Details,
+		}
+		report.AddRow(data)
+	}
+	fmt.Println("Found", len(findings), "policy findings")
+	return report.Write()
+}
@@ -0,0 +1,238 @@
+package iam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func parseTestDocument(t *testing.T, document string) Document {
+	t.Helper()
+	doc, err := ParseDocument(document)
+	if err != nil {
+		t.Fatalf("could not parse %s: %v", document, err)
+	}
+	return doc
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"iam:PassRole", "iam:PassRole", true},
+		{"iam:passrole", "IAM:PassRole", true},
+		{"iam:PassRole", "iam:PassRoles", false},
+		{"*", "iam:PassRole", true},
+		{"*", "", true},
+		{"iam:*", "iam:PassRole", true},
+		{"iam:*", "sts:AssumeRole", false},
+		{"iam:Pass*", "iam:PassRole", true},
+		{"iam:*Role", "iam:PassRole", true},
+		{"iam:*Role", "iam:PassRolePolicy", false},
+		{"*:*Policy*", "iam:PutRolePolicy", true},
+		{"iam:?assRole", "iam:PassRole", true},
+		{"iam:?assRole", "iam:assRole", false},
+		{"iam:Pass**", "iam:Pass", true},
+		{"", "", true},
+		{"", "iam:PassRole", false},
+		{"iam:Create*Version", "iam:CreatePolicyVersionX", false},
+	}
+	for _, test := range tests {
+		if got := wildcardMatch(test.pattern, test.value); got != test.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", test.pattern, test.value, got, test.want)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		name            string
+		documents       []string
+		action          string
+		wantAllowed     bool
+		wantConditional bool
+	}{
+		{
+			name:        "allowed",
+			documents:   []string{`{"Statement":{"Effect":"Allow","Action":"iam:PassRole","Resource":"*"}}`},
+			action:      "iam:PassRole",
+			wantAllowed: true,
+		},
+		{
+			name:        "allowed by a wildcard on limited resources",
+			documents:   []string{`{"Statement":{"Effect":"Allow","Action":"iam:*","Resource":"arn:aws:iam::111111111111:role/app"}}`},
+			action:      "iam:PassRole",
+			wantAllowed: true,
+		},
+		{
+			name:      "not allowed",
+			documents: []string{`{"Statement":{"Effect":"Allow","Action":"s3:*","Resource":"*"}}`},
+			action:    "iam:PassRole",
+		},
+		{
+			name:      "deny statements do not allow",
+			documents: []string{`{"Statement":{"Effect":"Deny","Action":"iam:PassRole","Resource":"*"}}`},
+			action:    "iam:PassRole",
+		},
+		{
+			name:        "allowed through NotAction",
+			documents:   []string{`{"Statement":{"Effect":"Allow","NotAction":"s3:*","Resource":"*"}}`},
+			action:      "iam:PassRole",
+			wantAllowed: true,
+		},
+		{
+			name:      "excluded by NotAction",
+			documents: []string{`{"Statement":{"Effect":"Allow","NotAction":"iam:*","Resource":"*"}}`},
+			action:    "iam:PassRole",
+		},
+		{
+			name: "denied on every resource in another document",
+			documents: []string{
+				`{"Statement":{"Effect":"Allow","Action":"*","Resource":"*"}}`,
+				`{"Statement":{"Effect":"Deny","Action":"iam:*","Resource":"*"}}`,
+			},
+			action: "iam:PassRole",
+		},
+		{
+			name: "denied before it is allowed",
+			documents: []string{`{"Statement":[
+				{"Effect":"Deny","Action":"iam:PassRole","Resource":"*"},
+				{"Effect":"Allow","Action":"iam:PassRole","Resource":"*"}]}`},
+			action: "iam:PassRole",
+		},
+		{
+			name:            "only allowed with a condition",
+			documents:       []string{`{"Statement":{"Effect":"Allow","Action":"iam:PassRole","Resource":"*","Condition":{"StringEquals":{"iam:PassedToService":"ec2.amazonaws.com"}}}}`},
+			action:          "iam:PassRole",
+			wantAllowed:     true,
+			wantConditional: true,
+		},
+		{
+			name: "allowed with and without a condition",
+			documents: []string{`{"Statement":[
+				{"Effect":"Allow","Action":"iam:PassRole","Resource":"*","Condition":{"Bool":{"aws:MultiFactorAuthPresent":"true"}}},
+				{"Effect":"Allow","Action":"iam:PassRole","Resource":"*"}]}`},
+			action:      "iam:PassRole",
+			wantAllowed: true,
+		},
+		{
+			name: "deny with a condition",
+			documents: []string{`{"Statement":[
+				{"Effect":"Allow","Action":"*","Resource":"*"},
+				{"Effect":"Deny","Action":"iam:*","Resource":"*","Condition":{"Bool":{"aws:MultiFactorAuthPresent":"false"}}}]}`},
+			action:          "iam:PassRole",
+			wantAllowed:     true,
+			wantConditional: true,
+		},
+		{
+			name: "deny with NotResource",
+			documents: []string{`{"Statement":[
+				{"Effect":"Allow","Action":"*","Resource":"*"},
+				{"Effect":"Deny","Action":"iam:PassRole","NotResource":"arn:aws:iam::111111111111:role/app"}]}`},
+			action:          "iam:PassRole",
+			wantAllowed:     true,
+			wantConditional: true,
+		},
+		{
+			name: "deny on limited resources",
+			documents: []string{`{"Statement":[
+				{"Effect":"Allow","Action":"*","Resource":"*"},
+				{"Effect":"Deny","Action":"iam:PassRole","Resource":"arn:aws:iam::111111111111:role/admin"}]}`},
+			action:          "iam:PassRole",
+			wantAllowed:     true,
+			wantConditional: true,
+		},
+		{
+			name: "deny for another action",
+			documents: []string{`{"Statement":[
+				{"Effect":"Allow","Action":"*","Resource":"*"},
+				{"Effect":"Deny","Action":"s3:*","Resource":"*","Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`},
+			action:      "iam:PassRole",
+			wantAllowed: true,
+		},
+	}
+	for _, test := range tests {
+		var documents []NamedDocument
+		for _, document := range test.documents {
+			documents = append(documents, NamedDocument{Document: parseTestDocument(t, document)})
+		}
+		allowed, conditional := Allows(documents, test.action)
+		if allowed != test.wantAllowed || conditional != test.wantConditional {
+			t.Errorf("%s: Allows = %v, %v, want %v, %v", test.name, allowed, conditional, test.wantAllowed, test.wantConditional)
+		}
+	}
+}
+
+func TestAnalyzeDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     []DocumentFinding
+	}{
+		{
+			name:     "read only",
+			document: `{"Statement":{"Effect":"Allow","Action":["s3:Get*","s3:List*"],"Resource":"*"}}`,
+			want:     nil,
+		},
+		{
+			name:     "full admin",
+			document: `{"Statement":{"Effect":"Allow","Action":"*","Resource":"*"}}`,
+			want:     []DocumentFinding{{Rule: RuleFullAdmin, Severity: SeverityCritical, Sid: "statement 1", Details: "allows every action on every resource"}},
+		},
+		{
+			name: "full admin after passrole on every resource",
+			document: `{"Statement":[
+				{"Sid":"Pass","Effect":"Allow","Action":"iam:PassRole","Resource":"*"},
+				{"Sid":"Admin","Effect":"Allow","Action":"*:*","Resource":"*"}]}`,
+			want: []DocumentFinding{{Rule: RuleFullAdmin, Severity: SeverityCritical, Sid: "Admin", Details: "allows every action on every resource"}},
+		},
+		{
+			name:     "full admin with a condition",
+			document: `{"Statement":{"Effect":"Allow","Action":"*","Resource":"*","Condition":{"Bool":{"aws:MultiFactorAuthPresent":"true"}}}}`,
+			want:     []DocumentFinding{{Rule: RuleFullAdmin, Severity: SeverityCritical, Sid: "statement 1", Details: "allows every action on every resource, with a condition"}},
+		},
+		{
+			name:     "every s3 action on every resource",
+			document: `{"Statement":{"Effect":"Allow","Action":"s3:*","Resource":"*"}}`,
+			want:     nil,
+		},
+		{
+			name:     "passrole on every resource",
+			document: `{"Statement":{"Sid":"Pass","Effect":"Allow","Action":"iam:PassRole","Resource":"*"}}`,
+			want:     []DocumentFinding{{Rule: RulePassRoleWildcard, Severity: SeverityHigh, Sid: "Pass", Details: "allows passing any role to a service"}},
+		},
+		{
+			name: "passrole with ec2 is an escalation",
+			document: `{"Statement":[
+				{"Effect":"Allow","Action":"iam:PassRole","Resource":"arn:aws:iam::111111111111:role/app"},
+				{"Effect":"Allow","Action":"ec2:RunInstances","Resource":"*"}]}`,
+			want: []DocumentFinding{{Rule: RulePrivilegeEscalation, Severity: SeverityHigh, Details: "pass a role to a new ec2 instance with iam:PassRole + ec2:RunInstances"}},
+		},
+		{
+			name: "escalation limited by a deny",
+			document: `{"Statement":[
+				{"Effect":"Allow","Action":"iam:CreateAccessKey","Resource":"*"},
+				{"Effect":"Deny","Action":"iam:CreateAccessKey","Resource":"arn:aws:iam::111111111111:user/admin"}]}`,
+			want: []DocumentFinding{{Rule: RulePrivilegeEscalation, Severity: SeverityMedium, Details: "create access keys for another user with iam:CreateAccessKey, conditional on a condition or a limited deny"}},
+		},
+		{
+			name: "escalation denied on every resource",
+			document: `{"Statement":[
+				{"Effect":"Allow","Action":"iam:CreateAccessKey","Resource":"*"},
+				{"Effect":"Deny","Action":"iam:*","Resource":"*"}]}`,
+			want: nil,
+		},
+		{
+			name:     "notaction allow",
+			document: `{"Statement":{"Effect":"Allow","NotAction":["iam:*","organizations:*","lambda:*","glue:*","ec2:*","cloudformation:*","datapipeline:*","sts:*"],"Resource":"arn:aws:s3:::bucket"}}`,
+			want: []DocumentFinding{{Rule: RuleNotActionAllow, Severity: SeverityMedium, Sid: "statement 1",
+				Details: "allows every action except iam:*, organizations:*, lambda:*, glue:*, ec2:*, cloudformation:*, datapipeline:*, sts:* on arn:aws:s3:::bucket"}},
+		},
+	}
+	for _, test := range tests {
+		if got := AnalyzeDocument(parseTestDocument(t, test.document)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: AnalyzeDocument =\n%+v\nwant:\n%+v", test.name, got, test.want)
+		}
+	}
+}
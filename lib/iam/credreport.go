@@ -0,0 +1,359 @@
+package iam
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// RootUser is the user name of the root account in the credential report
+const RootUser = "<root_account>"
+
+// Credential rule results
+const (
+	RulePass          = "pass"
+	RuleFail          = "fail"
+	RuleNotApplicable = "n/a"
+)
+
+// credentialReportAttempts is how many times to check if the credential report is generated, two seconds apart
+const credentialReportAttempts = 30
+
+type (
+	CredReportOptions struct {
+		// KeyMaxAgeDays is how many days since an active access key was rotated before it fails the key age rule
+		KeyMaxAgeDays int
+		// UnusedDays is how many days an active access key can go unused before it fails the unused keys rule
+		UnusedDays int
+		// InactiveDays is how many days a user can go without a console login or key use before it fails the inactive user rule
+		InactiveDays int
+	}
+
+	// CredentialAccessKey is one of the two access keys of a user in the credential report
+	// Times are zero when the report has no date, such as a key that was never used
+	CredentialAccessKey struct {
+		Number      int
+		Active      bool
+		LastRotated time.Time
+		LastUsed    time.Time
+		LastService string
+	}
+
+	// CredentialReportEntry is one user, or the root account, from the credential report
+	CredentialReportEntry struct {
+		User             string
+		Arn              string
+		Created          time.Time
+		PasswordEnabled  bool
+		PasswordLastUsed time.Time
+		MfaActive        bool
+		AccessKeys       []CredentialAccessKey
+	}
+
+	// CredentialRules is the result of each rule for a user, along with why the rules failed
+	CredentialRules struct {
+		KeyAge       string
+		UnusedKeys   string
+		ConsoleMfa   string
+		RootKeys     string
+		InactiveUser string
+		Details      []string
+	}
+
+	ProfileCredentialReport struct {
+		Profile   string
+		AccountId string
+		Entries   []CredentialReportEntry
+	}
+	ProfilesCredentialReports []ProfileCredentialReport
+)
+
+// GetCredentialReport will generate the credential report of the account, wait for it to finish, and parse it
+func GetCredentialReport(sess *session.Session) ([]CredentialReportEntry, error) {
+	svc := iam.New(sess)
+	for attempt := 0; ; attempt++ {
+		resp, err := svc.GenerateCredentialReport(&iam.GenerateCredentialReportInput{})
+		if err != nil {
+			return nil, fmt.Errorf("could not generate credential report: %w", err)
+		}
+		if aws.StringValue(resp.State) == iam.ReportStateTypeComplete {
+			break
+		}
+		if attempt >= credentialReportAttempts {
+			return nil, fmt.Errorf("credential report was not generated after %d attempts", attempt)
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	resp, err := svc.GetCredentialReport(&iam.GetCredentialReportInput{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get credential report: %w", err)
+	}
+	return ParseCredentialReport(resp.Content)
+}
+
+// ParseCredentialReport will parse the csv content of a credential report
+// Columns are found by their header, so a report with columns added or moved can still be read
+func ParseCredentialReport(content []byte) ([]CredentialReportEntry, error) {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not parse credential report: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("credential report is empty")
+	}
+	columns := make(map[string]int)
+	for i, column := range records[0] {
+		columns[column] = i
+	}
+	if _, ok := columns["user"]; !ok {
+		return nil, fmt.Errorf("credential report has no user column")
+	}
+
+	var entries []CredentialReportEntry
+	for _, record := range records[1:] {
+		value := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		entry := CredentialReportEntry{
+			User:             value("user"),
+			Arn:              value("arn"),
+			Created:          reportTime(value("user_creation_time")),
+			PasswordEnabled:  value("password_enabled") == "true",
+			PasswordLastUsed: reportTime(value("password_last_used")),
+			MfaActive:        value("mfa_active") == "true",
+		}
+		for number := 1; number <= 2; number++ {
+			prefix := "access_key_" + strconv.Itoa(number) + "_"
+			entry.AccessKeys = append(entry.AccessKeys, CredentialAccessKey{
+				Number:      number,
+				Active:      value(prefix+"active") == "true",
+				LastRotated: reportTime(value(prefix + "last_rotated")),
+				LastUsed:    reportTime(value(prefix + "last_used_date")),
+				LastService: reportValue(value(prefix + "last_used_service")),
+			})
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// reportValue will return an empty string for the placeholders the credential report uses for no value
+func reportValue(value string) string {
+	switch value {
+	case "N/A", "no_information", "not_supported":
+		return ""
+	}
+	return value
+}
+
+// reportTime will parse a time from the credential report, or return a zero time if it has none
+func reportTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, reportValue(value))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// LastActivity will return the last time the user logged in to the console or used an access key
+func (entry CredentialReportEntry) LastActivity() time.Time {
+	last := entry.PasswordLastUsed
+	for _, key := range entry.AccessKeys {
+		if key.LastUsed.After(last) {
+			last = key.LastUsed
+		}
+	}
+	return last
+}
+
+// ActiveKeys will return the access keys that are active
+func (entry CredentialReportEntry) ActiveKeys() []CredentialAccessKey {
+	var keys []CredentialAccessKey
+	for _, key := range entry.AccessKeys {
+		if key.Active {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// CheckCredentials will check the entry against each rule
+// Rules that do not apply to the entry, such as key age for a user with no active keys, are n/a
+func CheckCredentials(entry CredentialReportEntry, options CredReportOptions, now time.Time) CredentialRules {
+	rules := CredentialRules{
+		KeyAge:       RuleNotApplicable,
+		UnusedKeys:   RuleNotApplicable,
+		ConsoleMfa:   RuleNotApplicable,
+		RootKeys:     RuleNotApplicable,
+		InactiveUser: RuleNotApplicable,
+	}
+	days := func(t time.Time) int {
+		return int(now.Sub(t).Hours() / 24)
+	}
+	root := entry.User == RootUser
+	keys := entry.ActiveKeys()
+
+	if len(keys) > 0 {
+		rules.KeyAge = RulePass
+		rules.UnusedKeys = RulePass
+	}
+	for _, key := range keys {
+		if !key.LastRotated.IsZero() && days(key.LastRotated) > options.KeyMaxAgeDays {
+			rules.KeyAge = RuleFail
+			rules.Details = append(rules.Details, fmt.Sprintf("access key %d is %d days old", key.Number, days(key.LastRotated)))
+		}
+		switch {
+		case key.LastUsed.IsZero() && !key.LastRotated.IsZero() && days(key.LastRotated) > options.UnusedDays:
+			rules.UnusedKeys = RuleFail
+			rules.Details = append(rules.Details, fmt.Sprintf("access key %d was never used", key.Number))
+		case !key.LastUsed.IsZero() && days(key.LastUsed) > options.UnusedDays:
+			rules.UnusedKeys = RuleFail
+			rules.Details = append(rules.Details, fmt.Sprintf("access key %d was last used %d days ago", key.Number, days(key.LastUsed)))
+		}
+	}
+
+	// the root account can always log in to the console, so it always needs mfa
+	if entry.PasswordEnabled || root {
+		rules.ConsoleMfa = RulePass
+		if !entry.MfaActive {
+			rules.ConsoleMfa = RuleFail
+			rules.Details = append(rules.Details, "console access without mfa")
+		}
+	}
+
+	if root {
+		rules.RootKeys = RulePass
+		if len(keys) > 0 {
+			rules.RootKeys = RuleFail
+			rules.Details = append(rules.Details, "root account has active access keys")
+		}
+	} else if !entry.Created.IsZero() && days(entry.Created) > options.InactiveDays {
+		// users created recently have not had the time to be inactive
+		rules.InactiveUser = RulePass
+		last := entry.LastActivity()
+		switch {
+		case last.IsZero():
+			rules.InactiveUser = RuleFail
+			rules.Details = append(rules.Details, "never logged in or used an access key")
+		case days(last) > options.InactiveDays:
+			rules.InactiveUser = RuleFail
+			rules.Details = append(rules.Details, fmt.Sprintf("last active %d days ago", days(last)))
+		}
+	}
+	return rules
+}
+
+// GetProfilesCredentialReports will get the credential report of all given accounts
+func GetProfilesCredentialReports(accounts []utils.AccountInfo) (ProfilesCredentialReports, error) {
+	profilesReportsChan := make(chan ProfileCredentialReport)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			fmt.Println("Getting credential report for profile:", account.Profile)
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			sess, err := account.GetSession("us-east-1")
+			if err != nil {
+				utils.RecordError(account, "", "iam", "GetSession", err)
+				return
+			}
+			entries, err := GetCredentialReport(sess)
+			if err != nil {
+				utils.RecordError(account, "", "iam", "GetCredentialReport", err)
+				return
+			}
+			profilesReportsChan <- ProfileCredentialReport{Profile: account.Profile, AccountId: account.AccountId, Entries: entries}
+		})
+		close(profilesReportsChan)
+	}()
+
+	var profilesReports ProfilesCredentialReports
+	for profileReport := range profilesReportsChan {
+		profilesReports = append(profilesReports, profileReport)
+	}
+	return profilesReports, nil
+}
+
+// reportDate will format the date of a time, or return an empty string if it is zero
+func reportDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// WriteCredentialReports will write every user of every account with the result of each rule
+func WriteCredentialReports(profilesReports ProfilesCredentialReports, options CredReportOptions) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"User Name",
+		"ARN",
+		"Created",
+		"Password Enabled",
+		"Password Last Used",
+		"MFA Active",
+		"Access Key 1 Active",
+		"Access Key 1 Last Rotated",
+		"Access Key 1 Last Used",
+		"Access Key 2 Active",
+		"Access Key 2 Last Rotated",
+		"Access Key 2 Last Used",
+		"Last Activity",
+		"Key Age",
+		"Unused Keys",
+		"Console MFA",
+		"Root Keys",
+		"Inactive User",
+		"Details",
+	}
+
+	now := time.Now()
+	failed := 0
+	report := utils.NewReport("iam", "credreport", columnTitles)
+	for _, profileReport := range profilesReports {
+		for _, entry := range profileReport.Entries {
+			rules := CheckCredentials(entry, options, now)
+			if len(rules.Details) > 0 {
+				failed++
+			}
+			var data = []string{profileReport.Profile,
+				profileReport.AccountId,
+				entry.User,
+				entry.Arn,
+				reportDate(entry.Created),
+				strconv.FormatBool(entry.PasswordEnabled),
+				reportDate(entry.PasswordLastUsed),
+				strconv.FormatBool(entry.MfaActive),
+			}
+			for _, key := range entry.AccessKeys {
+				data = append(data, strconv.FormatBool(key.Active), reportDate(key.LastRotated), reportDate(key.LastUsed))
+			}
+			data = append(data,
+				reportDate(entry.LastActivity()),
+				rules.KeyAge,
+				rules.UnusedKeys,
+				rules.ConsoleMfa,
+				rules.RootKeys,
+				rules.InactiveUser,
+				strings.Join(rules.Details, "|"),
+			)
+			report.AddRow(data)
+		}
+	}
+	fmt.Println("Found", failed, "users failing at least one rule")
+	return report.Write()
+}
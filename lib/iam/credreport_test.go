@@ -0,0 +1,168 @@
+package iam
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCredentialReport(t *testing.T) {
+	date := func(value string) time.Time {
+		t, _ := time.Parse(time.RFC3339, value)
+		return t
+	}
+	header := "user,arn,user_creation_time,password_enabled,password_last_used,password_last_changed,password_next_rotation,mfa_active," +
+		"access_key_1_active,access_key_1_last_rotated,access_key_1_last_used_date,access_key_1_last_used_region,access_key_1_last_used_service," +
+		"access_key_2_active,access_key_2_last_rotated,access_key_2_last_used_date,access_key_2_last_used_region,access_key_2_last_used_service," +
+		"cert_1_active,cert_1_last_rotated,cert_2_active,cert_2_last_rotated\n"
+
+	tests := []struct {
+		name    string
+		content string
+		want    []CredentialReportEntry
+		wantErr bool
+	}{
+		{
+			name: "root and users",
+			content: header +
+				"<root_account>,arn:aws:iam::111111111111:root,2020-01-01T00:00:00+00:00,not_supported,2024-05-01T10:00:00+00:00,not_supported,not_supported,true," +
+				"false,N/A,N/A,N/A,N/A,false,N/A,N/A,N/A,N/A,false,N/A,false,N/A\n" +
+				"alice,arn:aws:iam::111111111111:user/alice,2021-03-04T05:06:07+00:00,true,no_information,2021-03-04T05:06:07+00:00,N/A,false," +
+				"true,2021-03-04T05:06:08+00:00,2024-06-01T00:00:00+00:00,us-east-1,s3,false,N/A,N/A,N/A,N/A,false,N/A,false,N/A\n",
+			want: []CredentialReportEntry{
+				{
+					User:             RootUser,
+					Arn:              "arn:aws:iam::111111111111:root",
+					Created:          date("2020-01-01T00:00:00+00:00"),
+					PasswordLastUsed: date("2024-05-01T10:00:00+00:00"),
+					MfaActive:        true,
+					AccessKeys:       []CredentialAccessKey{{Number: 1}, {Number: 2}},
+				},
+				{
+					User:            "alice",
+					Arn:             "arn:aws:iam::111111111111:user/alice",
+					Created:         date("2021-03-04T05:06:07+00:00"),
+					PasswordEnabled: true,
+					AccessKeys: []CredentialAccessKey{
+						{Number: 1, Active: true, LastRotated: date("2021-03-04T05:06:08+00:00"), LastUsed: date("2024-06-01T00:00:00+00:00"), LastService: "s3"},
+						{Number: 2},
+					},
+				},
+			},
+		},
+		{
+			name:    "columns in any order, and missing columns",
+			content: "mfa_active,access_key_2_active,user\ntrue,true,bob\n",
+			want: []CredentialReportEntry{
+				{User: "bob", MfaActive: true, AccessKeys: []CredentialAccessKey{{Number: 1}, {Number: 2, Active: true}}},
+			},
+		},
+		{
+			name:    "header only",
+			content: header,
+			want:    nil,
+		},
+		{
+			name:    "no user column",
+			content: "arn,mfa_active\narn:aws:iam::111111111111:user/bob,true\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			content: "",
+			wantErr: true,
+		},
+		{
+			name:    "invalid csv",
+			content: "user,arn\n\"bob,arn\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseCredentialReport([]byte(test.content))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ParseCredentialReport =\n%+v\nwant:\n%+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckCredentials(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time {
+		return now.AddDate(0, 0, -days)
+	}
+	options := CredReportOptions{KeyMaxAgeDays: 90, UnusedDays: 90, InactiveDays: 90}
+	key := func(number int, rotated int, used int) CredentialAccessKey {
+		key := CredentialAccessKey{Number: number, Active: true, LastRotated: daysAgo(rotated)}
+		if used >= 0 {
+			key.LastUsed = daysAgo(used)
+		}
+		return key
+	}
+
+	tests := []struct {
+		name  string
+		entry CredentialReportEntry
+		want  CredentialRules
+	}{
+		{
+			name:  "healthy user",
+			entry: CredentialReportEntry{User: "alice", Created: daysAgo(400), PasswordEnabled: true, MfaActive: true, PasswordLastUsed: daysAgo(1), AccessKeys: []CredentialAccessKey{key(1, 30, 1)}},
+			want:  CredentialRules{KeyAge: RulePass, UnusedKeys: RulePass, ConsoleMfa: RulePass, RootKeys: RuleNotApplicable, InactiveUser: RulePass},
+		},
+		{
+			name:  "old and unused keys",
+			entry: CredentialReportEntry{User: "ci", Created: daysAgo(400), AccessKeys: []CredentialAccessKey{key(1, 200, 100), key(2, 120, -1)}},
+			want: CredentialRules{KeyAge: RuleFail, UnusedKeys: RuleFail, ConsoleMfa: RuleNotApplicable, RootKeys: RuleNotApplicable, InactiveUser: RuleFail,
+				Details: []string{
+					"access key 1 is 200 days old",
+					"access key 1 was last used 100 days ago",
+					"access key 2 is 120 days old",
+					"access key 2 was never used",
+					"last active 100 days ago",
+				}},
+		},
+		{
+			name:  "new key that was never used",
+			entry: CredentialReportEntry{User: "new", Created: daysAgo(10), AccessKeys: []CredentialAccessKey{key(1, 10, -1)}},
+			want:  CredentialRules{KeyAge: RulePass, UnusedKeys: RulePass, ConsoleMfa: RuleNotApplicable, RootKeys: RuleNotApplicable, InactiveUser: RuleNotApplicable},
+		},
+		{
+			name:  "inactive keys are not checked",
+			entry: CredentialReportEntry{User: "bob", Created: daysAgo(400), PasswordEnabled: true, MfaActive: true, PasswordLastUsed: daysAgo(5), AccessKeys: []CredentialAccessKey{{Number: 1, LastRotated: daysAgo(500)}}},
+			want:  CredentialRules{KeyAge: RuleNotApplicable, UnusedKeys: RuleNotApplicable, ConsoleMfa: RulePass, RootKeys: RuleNotApplicable, InactiveUser: RulePass},
+		},
+		{
+			name:  "console without mfa",
+			entry: CredentialReportEntry{User: "carol", Created: daysAgo(400), PasswordEnabled: true, PasswordLastUsed: daysAgo(2)},
+			want: CredentialRules{KeyAge: RuleNotApplicable, UnusedKeys: RuleNotApplicable, ConsoleMfa: RuleFail, RootKeys: RuleNotApplicable, InactiveUser: RulePass,
+				Details: []string{"console access without mfa"}},
+		},
+		{
+			name:  "user that was never active",
+			entry: CredentialReportEntry{User: "dave", Created: daysAgo(100)},
+			want: CredentialRules{KeyAge: RuleNotApplicable, UnusedKeys: RuleNotApplicable, ConsoleMfa: RuleNotApplicable, RootKeys: RuleNotApplicable, InactiveUser: RuleFail,
+				Details: []string{"never logged in or used an access key"}},
+		},
+		{
+			name:  "root with mfa and no keys",
+			entry: CredentialReportEntry{User: RootUser, Created: daysAgo(1000), MfaActive: true},
+			want:  CredentialRules{KeyAge: RuleNotApplicable, UnusedKeys: RuleNotApplicable, ConsoleMfa: RulePass, RootKeys: RulePass, InactiveUser: RuleNotApplicable},
+		},
+		{
+			name:  "root with keys and no mfa",
+			entry: CredentialReportEntry{User: RootUser, Created: daysAgo(1000), AccessKeys: []CredentialAccessKey{key(1, 10, 1)}},
+			want: CredentialRules{KeyAge: RulePass, UnusedKeys: RulePass, ConsoleMfa: RuleFail, RootKeys: RuleFail, InactiveUser: RuleNotApplicable,
+				Details: []string{"console access without mfa", "root account has active access keys"}},
+		},
+	}
+	for _, test := range tests {
+		if got := CheckCredentials(test.entry, options, now); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: CheckCredentials =\n%+v\nwant:\n%+v", test.name, got, test.want)
+		}
+	}
+}
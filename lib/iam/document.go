@@ -0,0 +1,154 @@
+package iam
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+type (
+	// Document is an iam policy document, for identity, resource and trust policies
+	Document struct {
+		Version   string     `json:"Version,omitempty"`
+		Id        string     `json:"Id,omitempty"`
+		Statement Statements `json:"Statement"`
+	}
+
+	// Statements is the statements of a document, which can be a single statement or a list of them
+	Statements []Statement
+
+	Statement struct {
+		Sid          string        `json:"Sid,omitempty"`
+		Effect       string        `json:"Effect"`
+		Principal    Principal     `json:"Principal,omitempty"`
+		NotPrincipal Principal     `json:"NotPrincipal,omitempty"`
+		Action       StringOrSlice `json:"Action,omitempty"`
+		NotAction    StringOrSlice `json:"NotAction,omitempty"`
+		Resource     StringOrSlice `json:"Resource,omitempty"`
+		NotResource  StringOrSlice `json:"NotResource,omitempty"`
+		Condition    Condition     `json:"Condition,omitempty"`
+	}
+
+	// StringOrSlice is a policy element that can be a string or a list of strings
+	// Numbers and booleans, which are used in conditions, are kept as their json text
+	StringOrSlice []string
+
+	// Principal is the principal types, such as AWS, Service or Federated, and their values
+	// A principal of "*" is kept as the type "*" with the value "*"
+	Principal map[string]StringOrSlice
+
+	// Condition is the condition operators, such as StringEquals, and the values of each condition key
+	Condition map[string]map[string]StringOrSlice
+)
+
+// PrincipalWildcard is the principal type used for a principal of "*"
+const PrincipalWildcard = "*"
+
+// ParseDocument will parse a policy document, which iam returns url encoded
+func ParseDocument(document string) (Document, error) {
+	var doc Document
+	decoded, err := url.QueryUnescape(document)
+	if err != nil {
+		return doc, fmt.Errorf("could not decode policy document: %w", err)
+	}
+	if err = json.Unmarshal([]byte(decoded), &doc); err != nil {
+		return doc, fmt.Errorf("could not parse policy document: %w", err)
+	}
+	return doc, nil
+}
+
+// UnmarshalJSON will read a single statement or a list of them
+func (s *Statements) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var statement Statement
+		if err := json.Unmarshal(data, &statement); err != nil {
+			return err
+		}
+		*s = Statements{statement}
+		return nil
+	}
+	var statements []Statement
+	if err := json.Unmarshal(data, &statements); err != nil {
+		return err
+	}
+	*s = statements
+	return nil
+}
+
+// UnmarshalJSON will read a string, a number or boolean, or a list of them
+func (s *StringOrSlice) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var values []json.RawMessage
+		if err := json.Unmarshal(data, &values); err != nil {
+			return err
+		}
+		result := make(StringOrSlice, 0, len(values))
+		for _, value := range values {
+			str, err := scalarString(value)
+			if err != nil {
+				return err
+			}
+			result = append(result, str)
+		}
+		*s = result
+		return nil
+	}
+	str, err := scalarString(data)
+	if err != nil {
+		return err
+	}
+	*s = StringOrSlice{str}
+	return nil
+}
+
+// MarshalJSON will write a single value as a string, and anything else as a list
+func (s StringOrSlice) MarshalJSON() ([]byte, error) {
+	if len(s) == 1 {
+		return json.Marshal(s[0])
+	}
+	return json.Marshal([]string(s))
+}
+
+// scalarString will return a json string, or the json text of a number or boolean
+func scalarString(data json.RawMessage) (string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var str string
+		err := json.Unmarshal(data, &str)
+		return str, err
+	}
+	if len(data) == 0 || data[0] == '{' || data[0] == '[' || string(data) == "null" {
+		return "", fmt.Errorf("expected a string, number or boolean, got %s", data)
+	}
+	return string(data), nil
+}
+
+// UnmarshalJSON will read a principal of "*" or a map of principal types
+func (p *Principal) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*p = Principal{PrincipalWildcard: StringOrSlice{str}}
+		return nil
+	}
+	var principal map[string]StringOrSlice
+	if err := json.Unmarshal(data, &principal); err != nil {
+		return err
+	}
+	*p = principal
+	return nil
+}
+
+// MarshalJSON will write a principal of "*" back as "*"
+func (p Principal) MarshalJSON() ([]byte, error) {
+	if values, ok := p[PrincipalWildcard]; ok && len(p) == 1 && len(values) == 1 {
+		return json.Marshal(values[0])
+	}
+	return json.Marshal(map[string]StringOrSlice(p))
+}
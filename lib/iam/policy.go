@@ -1,15 +1,13 @@
 package iam
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws"
@@ -17,19 +15,6 @@ import (
 	"github.com/aws/aws-sdk-go/service/iam"
 )
 
-// two structs to help with printing the policy documents
-type Document struct {
-	Version   string      `json:"Version"`
-	Statement []Statement `json:"Statement"`
-}
-
-type Statement struct {
-	Action   []string `json:"Action"`
-	Resource string   `json:"Resource"`
-	Effect   string   `json:"Effect"`
-	Sid      string   `json:"Sid"`
-}
-
 // The Policies and PolicyVersion need to have the same index to match up for later reference
 type ProfilePolicies struct {
 	AccountId string
@@ -72,7 +57,7 @@ func GetProfilePolicies(sess *session.Session) (ProfilePolicies, error) {
 
 		resp, err := svc.GetPolicyVersion(versionParams)
 		if err != nil {
-			log.Println("could not get policy version for", detail.PolicyName, ":", err)
+			return ProfilePolicies{}, fmt.Errorf("could not get policy version for %s: %w", *detail.PolicyName, err)
 		}
 		policies.PolicyVersions = append(policies.PolicyVersions, *resp.PolicyVersion)
 	}
@@ -82,31 +67,29 @@ func GetProfilePolicies(sess *session.Session) (ProfilePolicies, error) {
 
 func GetProfilesPolicies(accounts []utils.AccountInfo) (ProfilesPolicies, error) {
 	profilesPoliciesChan := make(chan ProfilePolicies)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			fmt.Println("Getting policies for profile:", account.Profile)
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
 			sess, err := account.GetSession("us-east-1")
 			if err != nil {
-				log.Println("could not open session for ", account.Profile, " : ", err)
+				utils.RecordError(account, "", "iam", "GetSession", err)
 				return
 			}
 			profilePolicies, err := GetProfilePolicies(sess)
 			if err != nil {
-				log.Println("could not get profiles for ", account.Profile, " : ", err)
+				utils.RecordError(account, "", "iam", "GetAccountAuthorizationDetails", err)
 				return
 			}
 			profilePolicies.Profile = account.Profile
+			profilePolicies.AccountId = account.AccountId
 
 			profilesPoliciesChan <- profilePolicies
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesPoliciesChan)
 	}()
 
@@ -119,17 +102,7 @@ func GetProfilesPolicies(accounts []utils.AccountInfo) (ProfilesPolicies, error)
 }
 
 func WriteProfilesPolicies(profilesPolicies ProfilesPolicies) error {
-	outputDir := "output/iam/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "policies.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create policies file: %v", err)
-	}
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing policies to file", outfile.Name())
-	writer.Write([]string{"Account", "Policy", "Description", "Create Date", "Attachment Count"})
+	report := utils.NewReport("iam", "policies", []string{"Account", "Policy", "Description", "Create Date", "Attachment Count"})
 
 	for _, profilePolicies := range profilesPolicies {
 		for x := 0; x < len(profilePolicies.PolicyVersions); x++ {
@@ -137,28 +110,33 @@ func WriteProfilesPolicies(profilesPolicies ProfilesPolicies) error {
 			profile := profilePolicies.Profile
 
 			//writing the policy document to file in a profile specific directory
-			policyDir := outputDir + "/" + profilePolicies.Profile
-			utils.MakeDir(policyDir)
-			//filename to create for the policy document
-			policyOutput := policyDir + "/" + policyName + ".json"
-			file, err := os.Create(policyOutput)
+			file, err := utils.Output.CreateDocument("iam", profilePolicies.Profile+"/"+policyName, utils.FormatJson)
 			if err != nil {
 				log.Println("could not open file for policy", policyName, "in account", profile, ":", err)
 			}
-			decoded, err := url.QueryUnescape(*profilePolicies.PolicyVersions[x].Document)
-			if err != nil {
-				log.Println("could not decode policy", policyName, "in account", profile, ":", err)
-			}
-
 			//decode the document and marshall into structs to be able to print
-			document := &Document{}
-			err = json.Unmarshal([]byte(decoded), document)
-			if err != nil {
-				log.Println("could not marshall policy", policyName, "in account", profile, ":", err)
+			//a document that can not be parsed is written as it was decoded, rather than as an empty document
+			rawDocument := *profilePolicies.PolicyVersions[x].Document
+			document, parseErr := ParseDocument(rawDocument)
+			if parseErr != nil {
+				log.Println("could not parse policy", policyName, "in account", profile, ":", parseErr)
+			}
+			if file != nil {
+				if parseErr == nil {
+					enc := json.NewEncoder(file)
+					enc.SetIndent("", "	")
+					err = enc.Encode(document)
+				} else {
+					if decoded, decodeErr := url.QueryUnescape(rawDocument); decodeErr == nil {
+						rawDocument = decoded
+					}
+					_, err = io.WriteString(file, rawDocument)
+				}
+				if err != nil {
+					log.Println("could not write policy", policyName, "in account", profile, ":", err)
+				}
+				file.Close()
 			}
-			enc := json.NewEncoder(file)
-			enc.SetIndent("", "	")
-			enc.Encode(document)
 
 			//fmt.Fprintf(file, document)
 
@@ -177,11 +155,8 @@ func WriteProfilesPolicies(profilesPolicies ProfilesPolicies) error {
 				strconv.Itoa(int(*profilePolicies.PolicyDetails[x].AttachmentCount)),
 			}
 
-			err = writer.Write(data)
-			if err != nil {
-				fmt.Println(err)
-			}
+			report.AddRow(data)
 		}
 	}
-	return nil
+	return report.Write()
 }
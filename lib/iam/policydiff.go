@@ -0,0 +1,399 @@
+package iam
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Policy drift statuses
+const (
+	DriftSame          = "same"
+	DriftDifferent     = "different"
+	DriftPolicyMissing = "policy missing" // the golden policy is not in the account
+	DriftPolicyExtra   = "policy extra"   // the account has a policy that is not in the golden set
+)
+
+// Statement differences
+const (
+	StatementMissing = "missing" // in the golden policy but not the account
+	StatementExtra   = "extra"   // in the account but not the golden policy
+	StatementChanged = "changed" // the same sid in both, with different elements
+)
+
+type (
+	PolicyDiffOptions struct {
+		// Golden is the profile or account id every other account is compared to
+		Golden string
+		// PolicyDir is a directory of <policy name>.json documents every account is compared to, instead of an account
+		PolicyDir string
+	}
+
+	// PolicyDrift is how a policy in an account differs from the golden policy
+	PolicyDrift struct {
+		PolicyName  string
+		Profile     string
+		AccountId   string
+		Status      string
+		Differences []StatementDiff
+	}
+
+	// StatementDiff is a statement that is only in one of the policies, or changed between them
+	StatementDiff struct {
+		Difference string
+		Sid        string
+		Details    string
+	}
+)
+
+// NormalizeDocument will sort and remove duplicates from every list in the document, and sort the statements,
+// so documents that only differ in ordering, a string instead of a list, or whitespace are the same
+// Actions are lower cased, as iam does not match them by case
+func NormalizeDocument(document Document) Document {
+	normalized := Document{Version: document.Version, Id: document.Id}
+	for _, statement := range document.Statement {
+		statement.Action = normalizeList(statement.Action, true)
+		statement.NotAction = normalizeList(statement.NotAction, true)
+		statement.Resource = normalizeList(statement.Resource, false)
+		statement.NotResource = normalizeList(statement.NotResource, false)
+		statement.Principal = normalizePrincipal(statement.Principal)
+		statement.NotPrincipal = normalizePrincipal(statement.NotPrincipal)
+		if len(statement.Condition) > 0 {
+			condition := make(Condition)
+			for operator, keys := range statement.Condition {
+				condition[operator] = make(map[string]StringOrSlice)
+				for key, values := range keys {
+					condition[operator][key] = normalizeList(values, false)
+				}
+			}
+			statement.Condition = condition
+		}
+		normalized.Statement = append(normalized.Statement, statement)
+	}
+	sort.SliceStable(normalized.Statement, func(i, j int) bool {
+		return statementKey(normalized.Statement[i]) < statementKey(normalized.Statement[j])
+	})
+	return normalized
+}
+
+func normalizeList(values StringOrSlice, lower bool) StringOrSlice {
+	if len(values) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var list StringOrSlice
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if lower {
+			value = strings.ToLower(value)
+		}
+		if !seen[value] {
+			seen[value] = true
+			list = append(list, value)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func normalizePrincipal(principal Principal) Principal {
+	if len(principal) == 0 {
+		return nil
+	}
+	normalized := make(Principal)
+	for principalType, values := range principal {
+		normalized[principalType] = normalizeList(values, false)
+	}
+	return normalized
+}
+
+// statementKey will return the statement as json without its sid, which is the same for statements that grant the same
+func statementKey(statement Statement) string {
+	statement.Sid = ""
+	data, _ := json.Marshal(statement)
+	return string(data)
+}
+
+// DiffDocuments will return the statements that are only in the golden or the other document, after normalizing them
+// A statement missing from one and extra in the other with the same sid is one changed statement
+func DiffDocuments(golden Document, other Document) []StatementDiff {
+	golden, other = NormalizeDocument(golden), NormalizeDocument(other)
+	count := func(statements Statements) map[string]int {
+		counts := make(map[string]int)
+		for _, statement := range statements {
+			counts[statementKey(statement)]++
+		}
+		return counts
+	}
+	goldenKeys, otherKeys := count(golden.Statement), count(other.Statement)
+
+	var missing, extra []Statement
+	for _, statement := range golden.Statement {
+		key := statementKey(statement)
+		if otherKeys[key] > 0 {
+			otherKeys[key]--
+			continue
+		}
+		missing = append(missing, statement)
+	}
+	for _, statement := range other.Statement {
+		key := statementKey(statement)
+		if goldenKeys[key] > 0 {
+			goldenKeys[key]--
+			continue
+		}
+		extra = append(extra, statement)
+	}
+
+	var diffs []StatementDiff
+	for _, statement := range missing {
+		paired := -1
+		for i, candidate := range extra {
+			if statement.Sid != "" && candidate.Sid == statement.Sid {
+				paired = i
+				break
+			}
+		}
+		if paired == -1 {
+			diffs = append(diffs, StatementDiff{Difference: StatementMissing, Sid: statement.Sid, Details: statementKey(statement)})
+			continue
+		}
+		diffs = append(diffs, StatementDiff{Difference: StatementChanged, Sid: statement.Sid, Details: diffStatement(statement, extra[paired])})
+		extra = append(extra[:paired], extra[paired+1:]...)
+	}
+	for _, statement := range extra {
+		diffs = append(diffs, StatementDiff{Difference: StatementExtra, Sid: statement.Sid, Details: statementKey(statement)})
+	}
+	return diffs
+}
+
+// diffStatement will describe what was added to and removed from each element of the statement
+func diffStatement(golden Statement, other Statement) string {
+	var details []string
+	if golden.Effect != other.Effect {
+		details = append(details, "Effect: "+golden.Effect+" -> "+other.Effect)
+	}
+	lists := []struct {
+		name          string
+		golden, other StringOrSlice
+	}{
+		{"Action", golden.Action, other.Action},
+		{"NotAction", golden.NotAction, other.NotAction},
+		{"Resource", golden.Resource, other.Resource},
+		{"NotResource", golden.NotResource, other.NotResource},
+	}
+	for _, list := range lists {
+		if diff := diffList(list.golden, list.other); diff != "" {
+			details = append(details, list.name+": "+diff)
+		}
+	}
+	for _, element := range []struct {
+		name          string
+		golden, other interface{}
+	}{
+		{"Principal", golden.Principal, other.Principal},
+		{"NotPrincipal", golden.NotPrincipal, other.NotPrincipal},
+		{"Condition", golden.Condition, other.Condition},
+	} {
+		goldenJson, _ := json.Marshal(element.golden)
+		otherJson, _ := json.Marshal(element.other)
+		if string(goldenJson) != string(otherJson) {
+			details = append(details, element.name+": "+string(goldenJson)+" -> "+string(otherJson))
+		}
+	}
+	return strings.Join(details, "; ")
+}
+
+// diffList will describe the values added to and removed from a sorted list
+func diffList(golden StringOrSlice, other StringOrSlice) string {
+	inGolden := make(map[string]bool)
+	for _, value := range golden {
+		inGolden[value] = true
+	}
+	inOther := make(map[string]bool)
+	for _, value := range other {
+		inOther[value] = true
+	}
+	var changes []string
+	for _, value := range other {
+		if !inGolden[value] {
+			changes = append(changes, "+"+value)
+		}
+	}
+	for _, value := range golden {
+		if !inOther[value] {
+			changes = append(changes, "-"+value)
+		}
+	}
+	return strings.Join(changes, " ")
+}
+
+// ReadPolicyDir will read every <policy name>.json document in the directory, such as the ones policieslist writes
+func ReadPolicyDir(dir string) (map[string]Document, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("there are no policy documents in %s", dir)
+	}
+	documents := make(map[string]Document)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// the files are plain json, unlike the url encoded documents from iam
+		var document Document
+		if err = json.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("could not parse policy %s: %v", path, err)
+		}
+		documents[strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))] = document
+	}
+	return documents, nil
+}
+
+// profileDocuments will return the default version document of every policy in the account, by name
+func profileDocuments(profilePolicies ProfilePolicies) map[string]Document {
+	documents := make(map[string]Document)
+	for x := 0; x < len(profilePolicies.PolicyVersions) && x < len(profilePolicies.PolicyDetails); x++ {
+		name := aws.StringValue(profilePolicies.PolicyDetails[x].PolicyName)
+		document, err := ParseDocument(aws.StringValue(profilePolicies.PolicyVersions[x].Document))
+		if err != nil {
+			fmt.Println("Could not parse policy", name, "in", profilePolicies.Profile, ":", err)
+			continue
+		}
+		documents[name] = document
+	}
+	return documents
+}
+
+// DiffPolicies will compare the policies of every account to the golden account or policy directory, grouped by name
+func DiffPolicies(profilesPolicies ProfilesPolicies, options PolicyDiffOptions) ([]PolicyDrift, error) {
+	var golden map[string]Document
+	var err error
+	compared := profilesPolicies
+	switch {
+	case options.PolicyDir != "":
+		golden, err = ReadPolicyDir(options.PolicyDir)
+		if err != nil {
+			return nil, err
+		}
+	case options.Golden != "":
+		compared = nil
+		for _, profilePolicies := range profilesPolicies {
+			if profilePolicies.Profile == options.Golden || profilePolicies.AccountId == options.Golden {
+				golden = profileDocuments(profilePolicies)
+				continue
+			}
+			compared = append(compared, profilePolicies)
+		}
+		if golden == nil {
+			return nil, fmt.Errorf("the golden account %s was not collected", options.Golden)
+		}
+	default:
+		return nil, fmt.Errorf("a golden account or a policy directory is needed to compare to")
+	}
+
+	names := make([]string, 0, len(golden))
+	for name := range golden {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var drifts []PolicyDrift
+	for _, profilePolicies := range compared {
+		documents := profileDocuments(profilePolicies)
+		drift := func(name string, status string, differences []StatementDiff) PolicyDrift {
+			return PolicyDrift{PolicyName: name, Profile: profilePolicies.Profile, AccountId: profilePolicies.AccountId, Status: status, Differences: differences}
+		}
+		for _, name := range names {
+			document, ok := documents[name]
+			if !ok {
+				drifts = append(drifts, drift(name, DriftPolicyMissing, nil))
+				continue
+			}
+			differences := DiffDocuments(golden[name], document)
+			status := DriftSame
+			if len(differences) > 0 {
+				status = DriftDifferent
+			}
+			drifts = append(drifts, drift(name, status, differences))
+		}
+
+		var extra []string
+		for name := range documents {
+			if _, ok := golden[name]; !ok {
+				extra = append(extra, name)
+			}
+		}
+		sort.Strings(extra)
+		for _, name := range extra {
+			drifts = append(drifts, drift(name, DriftPolicyExtra, nil))
+		}
+	}
+	sort.SliceStable(drifts, func(i, j int) bool { return drifts[i].PolicyName < drifts[j].PolicyName })
+	return drifts, nil
+}
+
+// WritePolicyDrift will write the status of every policy in every account, and every statement that differs
+func WritePolicyDrift(drifts []PolicyDrift, options PolicyDiffOptions) error {
+	golden := options.Golden
+	if options.PolicyDir != "" {
+		golden = options.PolicyDir
+	}
+
+	var summaryTitles = []string{"Policy Name",
+		"Profile",
+		"Account ID",
+		"Golden",
+		"Status",
+		"Differences",
+	}
+	var diffTitles = []string{"Policy Name",
+		"Profile",
+		"Account ID",
+		"Golden",
+		"Difference",
+		"Sid",
+		"Details",
+	}
+
+	drifted := 0
+	summary := utils.NewReport("iam", "policydiffsummary", summaryTitles)
+	diff := utils.NewReport("iam", "policydiff", diffTitles)
+	for _, drift := range drifts {
+		if drift.Status != DriftSame {
+			drifted++
+		}
+		summary.AddRow([]string{drift.PolicyName,
+			drift.Profile,
+			drift.AccountId,
+			golden,
+			drift.Status,
+			strconv.Itoa(len(drift.Differences)),
+		})
+		for _, difference := range drift.Differences {
+			diff.AddRow([]string{drift.PolicyName,
+				drift.Profile,
+				drift.AccountId,
+				golden,
+				difference.Difference,
+				difference.Sid,
+				difference.Details,
+			})
+		}
+	}
+	fmt.Println("Found", drifted, "policies that differ from", golden)
+	if err := summary.Write(); err != nil {
+		return err
+	}
+	return diff.Write()
+}
@@ -0,0 +1,140 @@
+package iam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeDocument(t *testing.T) {
+	got := NormalizeDocument(parseTestDocument(t, `{"Version":"2012-10-17","Statement":[
+		{"Sid":"List","Effect":"Allow","Action":["S3:ListBucket"," s3:listbucket "],"Resource":["arn:aws:s3:::b","arn:aws:s3:::a","arn:aws:s3:::a"],
+			"Condition":{"StringEquals":{"aws:PrincipalTag/team":["web","api"]}}},
+		{"Effect":"Allow","Principal":{"AWS":["arn:aws:iam::222222222222:root","arn:aws:iam::111111111111:root"]},"Action":"sts:AssumeRole"}]}`))
+	want := Document{Version: "2012-10-17", Statement: Statements{
+		{
+			Sid:       "List",
+			Effect:    "Allow",
+			Action:    StringOrSlice{"s3:listbucket"},
+			Resource:  StringOrSlice{"arn:aws:s3:::a", "arn:aws:s3:::b"},
+			Condition: Condition{"StringEquals": {"aws:PrincipalTag/team": StringOrSlice{"api", "web"}}},
+		},
+		{
+			Effect:    "Allow",
+			Principal: Principal{"AWS": StringOrSlice{"arn:aws:iam::111111111111:root", "arn:aws:iam::222222222222:root"}},
+			Action:    StringOrSlice{"sts:assumerole"},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeDocument =\n%+v\nwant:\n%+v", got, want)
+	}
+
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		same bool
+	}{
+		{
+			name: "string and list",
+			a:    `{"Statement":{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}}`,
+			b:    `{"Statement":[{"Effect":"Allow","Action":["s3:GetObject"],"Resource":["*"]}]}`,
+			same: true,
+		},
+		{
+			name: "statement order",
+			a:    `{"Statement":[{"Effect":"Allow","Action":"s3:*","Resource":"*"},{"Effect":"Deny","Action":"iam:*","Resource":"*"}]}`,
+			b:    `{"Statement":[{"Effect":"Deny","Action":"iam:*","Resource":"*"},{"Effect":"Allow","Action":"s3:*","Resource":"*"}]}`,
+			same: true,
+		},
+		{
+			name: "action case and duplicates",
+			a:    `{"Statement":{"Effect":"Allow","Action":["ec2:DescribeInstances","EC2:describeinstances"],"Resource":"*"}}`,
+			b:    `{"Statement":{"Effect":"Allow","Action":"ec2:describeinstances","Resource":"*"}}`,
+			same: true,
+		},
+		{
+			name: "resources are case sensitive",
+			a:    `{"Statement":{"Effect":"Allow","Action":"s3:GetObject","Resource":"arn:aws:s3:::Bucket/*"}}`,
+			b:    `{"Statement":{"Effect":"Allow","Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/*"}}`,
+			same: false,
+		},
+		{
+			name: "different actions",
+			a:    `{"Statement":{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}}`,
+			b:    `{"Statement":{"Effect":"Allow","Action":"s3:PutObject","Resource":"*"}}`,
+			same: false,
+		},
+	}
+	for _, test := range tests {
+		a, b := NormalizeDocument(parseTestDocument(t, test.a)), NormalizeDocument(parseTestDocument(t, test.b))
+		if same := reflect.DeepEqual(a, b); same != test.same {
+			t.Errorf("%s: normalized documents are the same = %v, want %v\n%+v\n%+v", test.name, same, test.same, a, b)
+		}
+	}
+}
+
+func TestDiffDocuments(t *testing.T) {
+	tests := []struct {
+		name   string
+		golden string
+		other  string
+		want   []StatementDiff
+	}{
+		{
+			name:   "same after normalizing",
+			golden: `{"Statement":[{"Sid":"Read","Effect":"Allow","Action":["s3:GetObject","s3:ListBucket"],"Resource":"*"}]}`,
+			other:  `{"Statement":{"Sid":"Read","Effect":"Allow","Action":["S3:ListBucket","s3:GetObject"],"Resource":["*"]}}`,
+			want:   nil,
+		},
+		{
+			name:   "a different sid is the same statement",
+			golden: `{"Statement":{"Sid":"Read","Effect":"Allow","Action":"s3:GetObject","Resource":"*"}}`,
+			other:  `{"Statement":{"Sid":"ReadObjects","Effect":"Allow","Action":"s3:GetObject","Resource":"*"}}`,
+			want:   nil,
+		},
+		{
+			name:   "missing statement",
+			golden: `{"Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"},{"Effect":"Deny","Action":"iam:*","Resource":"*"}]}`,
+			other:  `{"Statement":{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}}`,
+			want:   []StatementDiff{{Difference: StatementMissing, Details: `{"Effect":"Deny","Action":"iam:*","Resource":"*"}`}},
+		},
+		{
+			name:   "extra statement",
+			golden: `{"Statement":{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}}`,
+			other:  `{"Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"},{"Sid":"Admin","Effect":"Allow","Action":"*","Resource":"*"}]}`,
+			want:   []StatementDiff{{Difference: StatementExtra, Sid: "Admin", Details: `{"Effect":"Allow","Action":"*","Resource":"*"}`}},
+		},
+		{
+			name:   "duplicate statements are counted",
+			golden: `{"Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"},{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`,
+			other:  `{"Statement":{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}}`,
+			want:   []StatementDiff{{Difference: StatementMissing, Details: `{"Effect":"Allow","Action":"s3:getobject","Resource":"*"}`}},
+		},
+		{
+			name:   "changed statement with the same sid",
+			golden: `{"Statement":{"Sid":"Read","Effect":"Allow","Action":["s3:GetObject","s3:ListBucket"],"Resource":"arn:aws:s3:::bucket/*"}}`,
+			other: `{"Statement":{"Sid":"Read","Effect":"Deny","Action":["s3:GetObject","s3:PutObject"],"Resource":"arn:aws:s3:::bucket/*",
+				"Condition":{"Bool":{"aws:SecureTransport":"false"}}}}`,
+			want: []StatementDiff{{
+				Difference: StatementChanged,
+				Sid:        "Read",
+				Details:    `Effect: Allow -> Deny; Action: +s3:putobject -s3:listbucket; Condition: null -> {"Bool":{"aws:SecureTransport":"false"}}`,
+			}},
+		},
+		{
+			name:   "different statements without sids are missing and extra",
+			golden: `{"Statement":{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}}`,
+			other:  `{"Statement":{"Effect":"Allow","Action":"s3:PutObject","Resource":"*"}}`,
+			want: []StatementDiff{
+				{Difference: StatementMissing, Details: `{"Effect":"Allow","Action":"s3:getobject","Resource":"*"}`},
+				{Difference: StatementExtra, Details: `{"Effect":"Allow","Action":"s3:putobject","Resource":"*"}`},
+			},
+		},
+	}
+	for _, test := range tests {
+		got := DiffDocuments(parseTestDocument(t, test.golden), parseTestDocument(t, test.other))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: DiffDocuments =\n%+v\nwant:\n%+v", test.name, got, test.want)
+		}
+	}
+}
@@ -1,12 +1,9 @@
 package iam
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws"
@@ -21,8 +18,9 @@ type RoleInfo struct {
 }
 
 type ProfileRoles struct {
-	Profile string
-	Roles   []RoleInfo
+	Profile   string
+	AccountId string
+	Roles     []RoleInfo
 }
 type ProfilesRoles []ProfileRoles
 
@@ -46,7 +44,7 @@ func GetProfileRoles(sess *session.Session) ([]RoleInfo, error) {
 	for {
 		resp, err := svc.ListRoles(params)
 		if err != nil {
-			return nil, fmt.Errorf("could not get roles: %v", err)
+			return nil, fmt.Errorf("could not get roles: %w", err)
 		}
 		for _, role := range resp.Roles {
 			roles = append(roles, *role)
@@ -69,7 +67,7 @@ func GetProfileRoles(sess *session.Session) ([]RoleInfo, error) {
 		for {
 			resp, err := svc.ListRolePolicies(inlineParams)
 			if err != nil {
-				return nil, fmt.Errorf("could not get inline role policies: %v", err)
+				return nil, fmt.Errorf("could not get inline role policies: %w", err)
 			}
 
 			tempInfo.Role = role
@@ -89,7 +87,7 @@ func GetProfileRoles(sess *session.Session) ([]RoleInfo, error) {
 		for {
 			resp, err := svc.ListAttachedRolePolicies(attachedParams)
 			if err != nil {
-				return nil, fmt.Errorf("could not get inline role policies: %v", err)
+				return nil, fmt.Errorf("could not get attached role policies: %w", err)
 			}
 
 			tempInfo.Role = role
@@ -111,23 +109,25 @@ func GetProfileRoles(sess *session.Session) ([]RoleInfo, error) {
 // GetProfilesRoles will get all of the roles in all given accounts
 func GetProfilesRoles(accounts []utils.AccountInfo) (ProfilesRoles, error) {
 	profilesRolesChan := make(chan ProfileRoles)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			fmt.Println("Getting roles for profile:", account.Profile)
 			var profileRoles ProfileRoles
 			profileRoles.Profile = account.Profile
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			profileRoles.AccountId = account.AccountId
 			sess, err := account.GetSession("us-east-1")
 			if err != nil {
-				log.Println("could not open session for ", account.Profile, " : ", err)
+				utils.RecordError(account, "", "iam", "GetSession", err)
 				return
 			}
 			roles, err := GetProfileRoles(sess)
 			if err != nil {
-				log.Println("could not get profiles for ", account.Profile, " : ", err)
+				utils.RecordError(account, "", "iam", "ListRoles", err)
 				return
 			}
 			//for _, role := range roles {
@@ -137,11 +137,7 @@ func GetProfilesRoles(accounts []utils.AccountInfo) (ProfilesRoles, error) {
 			profileRoles.Roles = roles
 
 			profilesRolesChan <- profileRoles
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesRolesChan)
 	}()
 
@@ -200,17 +196,7 @@ func UpdateProfilesRolesSessionDuration(filename string, duration int64) error {
 }
 
 func WriteProfilesRoles(profilesRoles ProfilesRoles) error {
-	outputDir := "output/iam/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "roles.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create roles file: %v", err)
-	}
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing roles to file", outfile.Name())
-	writer.Write([]string{"Account", "Role", "Max Session Duration", "Attached Policies", "Inline Policies"})
+	report := utils.NewReport("iam", "roles", []string{"Account", "Role", "Max Session Duration", "Attached Policies", "Inline Policies"})
 
 	for _, profileRoles := range profilesRoles {
 		for _, roleInfo := range profileRoles.Roles {
@@ -225,11 +211,8 @@ func WriteProfilesRoles(profilesRoles ProfilesRoles) error {
 				stringInline,
 			}
 
-			err = writer.Write(data)
-			if err != nil {
-				fmt.Println(err)
-			}
+			report.AddRow(data)
 		}
 	}
-	return nil
+	return report.Write()
 }
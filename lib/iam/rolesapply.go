@@ -0,0 +1,583 @@
+package iam
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"gopkg.in/yaml.v3"
+)
+
+// BoundaryNone is the permissions boundary that removes the boundary of a role
+const BoundaryNone = "none"
+
+// Role operations, each one is a single api call
+const (
+	OpSessionDuration     = "session-duration"
+	OpDescription         = "description"
+	OpPermissionsBoundary = "permissions-boundary"
+	OpTag                 = "tag"
+	OpUntag               = "untag"
+	OpAttachPolicy        = "attach-policy"
+	OpDetachPolicy        = "detach-policy"
+)
+
+type (
+	// ChangeSet is the changes to make to roles across accounts, read from yaml, json or csv
+	// Example:
+	/*
+		changes:
+		  - roles: [admin, "ci-*"]
+		    profiles: [prod]
+		    maxSessionDuration: 28800
+		    description: managed by aws-go-tool
+		    permissionsBoundary: arn:aws:iam::123456789012:policy/boundary
+		    tags:
+		      owner: platform
+		    untag: [temp]
+		    attachPolicies: [arn:aws:iam::aws:policy/ReadOnlyAccess]
+		    detachPolicies: [old-policy]
+	*/
+	ChangeSet struct {
+		Changes []RoleChange `yaml:"changes" json:"changes"`
+	}
+
+	// RoleChange is the changes to make to every role matching one of the names or patterns, in the given accounts
+	// Anything left empty is not changed
+	RoleChange struct {
+		// Roles is role names, or patterns with * and ?
+		Roles []string `yaml:"roles" json:"roles"`
+		// Profiles limits the change to the accounts with these profiles or account ids, all accounts if empty
+		Profiles           []string `yaml:"profiles" json:"profiles"`
+		MaxSessionDuration int64    `yaml:"maxSessionDuration" json:"maxSessionDuration"`
+		// Description is a pointer so it can be set to empty
+		Description *string `yaml:"description" json:"description"`
+		// PermissionsBoundary is the arn of the boundary policy, or BoundaryNone to remove it
+		PermissionsBoundary string            `yaml:"permissionsBoundary" json:"permissionsBoundary"`
+		Tags                map[string]string `yaml:"tags" json:"tags"`
+		Untag               []string          `yaml:"untag" json:"untag"`
+		// AttachPolicies and DetachPolicies are policy arns, or the names of policies in the account
+		AttachPolicies []string `yaml:"attachPolicies" json:"attachPolicies"`
+		DetachPolicies []string `yaml:"detachPolicies" json:"detachPolicies"`
+	}
+
+	// RoleState is a role as it is now, along with its attached policies
+	RoleState struct {
+		Role             iam.Role
+		AttachedPolicies []string // policy arns
+	}
+
+	ProfileRoleStates struct {
+		Profile   string
+		AccountId string
+		Roles     []RoleState
+	}
+	ProfilesRoleStates []ProfileRoleStates
+
+	// RoleOperation is one difference between a role and the change set, and the result once it is applied
+	RoleOperation struct {
+		Profile   string
+		AccountId string
+		RoleName  string
+		Operation string
+		Key       string // the tag key or policy arn
+		Current   string
+		Desired   string
+		Result    string
+	}
+)
+
+// LoadChangeSet will read a change set from a csv, json or yaml file, by its extension
+func LoadChangeSet(path string) (ChangeSet, error) {
+	var changeSet ChangeSet
+	file, err := os.Open(path)
+	if err != nil {
+		return changeSet, fmt.Errorf("could not open change set: %v", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		changeSet, err = ParseChangeSetCsv(file)
+	case ".json":
+		err = json.NewDecoder(file).Decode(&changeSet)
+	default:
+		err = yaml.NewDecoder(file).Decode(&changeSet)
+	}
+	if err != nil {
+		return changeSet, fmt.Errorf("could not parse change set %s: %v", path, err)
+	}
+	return changeSet, changeSet.Validate()
+}
+
+// ParseChangeSetCsv will read a change set with a change on each row
+// The columns are profile, role, maxSessionDuration, description, permissionsBoundary, tags, untag, attachPolicies
+// and detachPolicies, in any order and case.  Only role is needed.  Lists are separated by |, and tags are key=value
+// An empty cell is not changed, so a description can not be cleared from a csv
+func ParseChangeSetCsv(r io.Reader) (ChangeSet, error) {
+	var changeSet ChangeSet
+	reader := csv.NewReader(r)
+	// rows can leave off the columns they do not change
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return changeSet, err
+	}
+	if len(records) == 0 {
+		return changeSet, fmt.Errorf("the change set is empty")
+	}
+	columns := make(map[string]int)
+	for i, column := range records[0] {
+		columns[strings.ToLower(strings.ReplaceAll(strings.TrimSpace(column), " ", ""))] = i
+	}
+	if _, ok := columns["role"]; !ok {
+		return changeSet, fmt.Errorf("the change set needs a role column")
+	}
+
+	for line, record := range records[1:] {
+		value := func(column string) string {
+			if i, ok := columns[strings.ToLower(column)]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		list := func(column string) []string {
+			if value(column) == "" {
+				return nil
+			}
+			return strings.Split(value(column), "|")
+		}
+
+		change := RoleChange{
+			Roles:               list("role"),
+			Profiles:            list("profile"),
+			PermissionsBoundary: value("permissionsBoundary"),
+			Untag:               list("untag"),
+			AttachPolicies:      list("attachPolicies"),
+			DetachPolicies:      list("detachPolicies"),
+		}
+		if duration := value("maxSessionDuration"); duration != "" {
+			change.MaxSessionDuration, err = strconv.ParseInt(duration, 10, 64)
+			if err != nil {
+				return changeSet, fmt.Errorf("line %d: invalid maxSessionDuration %s", line+2, duration)
+			}
+		}
+		if description := value("description"); description != "" {
+			change.Description = aws.String(description)
+		}
+		for _, tag := range list("tags") {
+			key, tagValue, ok := strings.Cut(tag, "=")
+			if !ok {
+				return changeSet, fmt.Errorf("line %d: tag %s needs to be key=value", line+2, tag)
+			}
+			if change.Tags == nil {
+				change.Tags = make(map[string]string)
+			}
+			change.Tags[key] = tagValue
+		}
+		changeSet.Changes = append(changeSet.Changes, change)
+	}
+	return changeSet, nil
+}
+
+// Validate will check that every change has roles and something to change
+func (changeSet ChangeSet) Validate() error {
+	if len(changeSet.Changes) == 0 {
+		return fmt.Errorf("the change set has no changes")
+	}
+	for i, change := range changeSet.Changes {
+		if len(change.Roles) == 0 {
+			return fmt.Errorf("change %d has no roles", i+1)
+		}
+		if change.MaxSessionDuration != 0 && (change.MaxSessionDuration < 3600 || change.MaxSessionDuration > 43200) {
+			return fmt.Errorf("change %d: maxSessionDuration needs to be between 3600 and 43200 seconds", i+1)
+		}
+		if change.MaxSessionDuration == 0 && change.Description == nil && change.PermissionsBoundary == "" && len(change.Tags) == 0 &&
+			len(change.Untag) == 0 && len(change.AttachPolicies) == 0 && len(change.DetachPolicies) == 0 {
+			return fmt.Errorf("change %d for %s has nothing to change", i+1, strings.Join(change.Roles, ", "))
+		}
+	}
+	return nil
+}
+
+// matches will return if the change applies to the role in the account
+func (change RoleChange) matches(profile string, accountId string, roleName string) bool {
+	if len(change.Profiles) > 0 {
+		found := false
+		for _, p := range change.Profiles {
+			found = found || p == profile || p == accountId
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchesAny(change.Roles, roleName)
+}
+
+// GetProfileRoleStates will get every role in the account that a change in the change set matches
+func GetProfileRoleStates(sess *session.Session, profile string, accountId string, changeSet ChangeSet) ([]RoleState, error) {
+	svc := iam.New(sess)
+	var names []string
+	err := svc.ListRolesPages(&iam.ListRolesInput{}, func(page *iam.ListRolesOutput, lastPage bool) bool {
+		for _, role := range page.Roles {
+			for _, change := range changeSet.Changes {
+				if change.matches(profile, accountId, aws.StringValue(role.RoleName)) {
+					names = append(names, aws.StringValue(role.RoleName))
+					break
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list roles: %w", err)
+	}
+
+	// list roles does not return the tags or boundary, so each matched role is read in full
+	var states []RoleState
+	for _, name := range names {
+		resp, err := svc.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+		if err != nil {
+			return nil, fmt.Errorf("could not get role %s: %w", name, err)
+		}
+		state := RoleState{Role: *resp.Role}
+		err = svc.ListAttachedRolePoliciesPages(&iam.ListAttachedRolePoliciesInput{RoleName: aws.String(name)}, func(page *iam.ListAttachedRolePoliciesOutput, lastPage bool) bool {
+			for _, policy := range page.AttachedPolicies {
+				state.AttachedPolicies = append(state.AttachedPolicies, aws.StringValue(policy.PolicyArn))
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list attached policies of role %s: %w", name, err)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// GetProfilesRoleStates will get the roles the change set matches in all given accounts
+func GetProfilesRoleStates(accounts []utils.AccountInfo, changeSet ChangeSet) (ProfilesRoleStates, error) {
+	profilesStatesChan := make(chan ProfileRoleStates)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			fmt.Println("Getting roles to change for profile:", account.Profile)
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			sess, err := account.GetSession("us-east-1")
+			if err != nil {
+				utils.RecordError(account, "", "iam", "GetSession", err)
+				return
+			}
+			states, err := GetProfileRoleStates(sess, account.Profile, account.AccountId, changeSet)
+			if err != nil {
+				utils.RecordError(account, "", "iam", "GetRole", err)
+				return
+			}
+			profilesStatesChan <- ProfileRoleStates{Profile: account.Profile, AccountId: account.AccountId, Roles: states}
+		})
+		close(profilesStatesChan)
+	}()
+
+	var profilesStates ProfilesRoleStates
+	for profileStates := range profilesStatesChan {
+		profilesStates = append(profilesStates, profileStates)
+	}
+	return profilesStates, nil
+}
+
+// policyArn will return the arn of a policy in the account of the role, if the policy is not an arn already
+func policyArn(policy string, roleArn string) string {
+	if strings.HasPrefix(policy, "arn:") {
+		return policy
+	}
+	partition := "aws"
+	if split := strings.Split(roleArn, ":"); len(split) > 1 {
+		partition = split[1]
+	}
+	return "arn:" + partition + ":iam::" + utils.AccountIdFromArn(roleArn) + ":policy/" + policy
+}
+
+// PlanRoleOperations will compare every role to the changes that match it, and return only the operations that change
+// something.  When more than one change matches a role, the later changes win
+func PlanRoleOperations(profilesStates ProfilesRoleStates, changeSet ChangeSet) []RoleOperation {
+	var operations []RoleOperation
+	for _, profileStates := range profilesStates {
+		for _, state := range profileStates.Roles {
+			role := state.Role
+			roleName := aws.StringValue(role.RoleName)
+
+			// merge every matching change into one desired state
+			var desired RoleChange
+			attach := make(map[string]bool)
+			for _, change := range changeSet.Changes {
+				if !change.matches(profileStates.Profile, profileStates.AccountId, roleName) {
+					continue
+				}
+				if change.MaxSessionDuration != 0 {
+					desired.MaxSessionDuration = change.MaxSessionDuration
+				}
+				if change.Description != nil {
+					desired.Description = change.Description
+				}
+				if change.PermissionsBoundary != "" {
+					desired.PermissionsBoundary = change.PermissionsBoundary
+				}
+				for key, value := range change.Tags {
+					if desired.Tags == nil {
+						desired.Tags = make(map[string]string)
+					}
+					desired.Tags[key] = value
+				}
+				desired.Untag = append(desired.Untag, change.Untag...)
+				for _, policy := range change.AttachPolicies {
+					attach[policyArn(policy, aws.StringValue(role.Arn))] = true
+				}
+				for _, policy := range change.DetachPolicies {
+					attach[policyArn(policy, aws.StringValue(role.Arn))] = false
+				}
+			}
+
+			add := func(operation string, key string, current string, want string) {
+				operations = append(operations, RoleOperation{
+					Profile:   profileStates.Profile,
+					AccountId: profileStates.AccountId,
+					RoleName:  roleName,
+					Operation: operation,
+					Key:       key,
+					Current:   current,
+					Desired:   want,
+				})
+			}
+
+			if desired.MaxSessionDuration != 0 && desired.MaxSessionDuration != aws.Int64Value(role.MaxSessionDuration) {
+				add(OpSessionDuration, "", strconv.FormatInt(aws.Int64Value(role.MaxSessionDuration), 10), strconv.FormatInt(desired.MaxSessionDuration, 10))
+			}
+			if desired.Description != nil && *desired.Description != aws.StringValue(role.Description) {
+				add(OpDescription, "", aws.StringValue(role.Description), *desired.Description)
+			}
+			var boundary string
+			if role.PermissionsBoundary != nil {
+				boundary = aws.StringValue(role.PermissionsBoundary.PermissionsBoundaryArn)
+			}
+			switch {
+			case desired.PermissionsBoundary == BoundaryNone && boundary != "":
+				add(OpPermissionsBoundary, "", boundary, "")
+			case desired.PermissionsBoundary != "" && desired.PermissionsBoundary != BoundaryNone && desired.PermissionsBoundary != boundary:
+				add(OpPermissionsBoundary, "", boundary, desired.PermissionsBoundary)
+			}
+
+			tags := make(map[string]string)
+			for _, tag := range role.Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			keys := make([]string, 0, len(desired.Tags))
+			for key := range desired.Tags {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				current, ok := tags[key]
+				if !ok || current != desired.Tags[key] {
+					add(OpTag, key, current, desired.Tags[key])
+				}
+			}
+			for _, key := range desired.Untag {
+				// a key that is also tagged is kept
+				if _, tagged := desired.Tags[key]; tagged {
+					continue
+				}
+				if current, ok := tags[key]; ok {
+					add(OpUntag, key, current, "")
+				}
+			}
+
+			attached := make(map[string]bool)
+			for _, policy := range state.AttachedPolicies {
+				attached[policy] = true
+			}
+			policies := make([]string, 0, len(attach))
+			for policy := range attach {
+				policies = append(policies, policy)
+			}
+			sort.Strings(policies)
+			for _, policy := range policies {
+				switch {
+				case attach[policy] && !attached[policy]:
+					add(OpAttachPolicy, policy, "", "attached")
+				case !attach[policy] && attached[policy]:
+					add(OpDetachPolicy, policy, "attached", "")
+				}
+			}
+		}
+	}
+	sort.SliceStable(operations, func(i, j int) bool {
+		if operations[i].Profile != operations[j].Profile {
+			return operations[i].Profile < operations[j].Profile
+		}
+		return operations[i].RoleName < operations[j].RoleName
+	})
+	return operations
+}
+
+// PrintRoleOperations will print every operation as a diff, grouped by role
+func PrintRoleOperations(w io.Writer, operations []RoleOperation) {
+	var last string
+	for _, op := range operations {
+		role := op.Profile + " (" + op.AccountId + ") " + op.RoleName
+		if role != last {
+			fmt.Fprintln(w, role)
+			last = role
+		}
+		name := op.Operation
+		if op.Key != "" {
+			name += " " + op.Key
+		}
+		switch {
+		case op.Current == "":
+			fmt.Fprintf(w, "  + %s: %s\n", name, op.Desired)
+		case op.Desired == "":
+			fmt.Fprintf(w, "  - %s: %s\n", name, op.Current)
+		default:
+			fmt.Fprintf(w, "  ~ %s: %s -> %s\n", name, op.Current, op.Desired)
+		}
+	}
+	if len(operations) == 0 {
+		fmt.Fprintln(w, "No changes, every role already matches the change set")
+	}
+}
+
+// ApplyRoleOperations will carry out every operation, with the accounts in parallel and the operations of each
+// account in order, and set the result of each one
+// Operations for accounts that are not given are skipped
+func ApplyRoleOperations(operations []RoleOperation, accounts []utils.AccountInfo) {
+	byAccount := make(map[string][]int)
+	var order []string
+	for i, op := range operations {
+		key := op.Profile + "/" + op.AccountId
+		if _, ok := byAccount[key]; !ok {
+			order = append(order, key)
+		}
+		byAccount[key] = append(byAccount[key], i)
+	}
+
+	var mu sync.Mutex
+	utils.ForEach(len(order), utils.MaxAccounts, func(i int) {
+		indexes := byAccount[order[i]]
+		first := operations[indexes[0]]
+		account, ok := utils.FindAccount(accounts, first.Profile, first.AccountId)
+		var svc *iam.IAM
+		var sessErr error
+		if ok {
+			var sess *session.Session
+			sess, sessErr = account.GetSession("us-east-1")
+			if sessErr != nil {
+				utils.RecordError(account, "", "iam", "GetSession", sessErr)
+			} else {
+				svc = iam.New(sess)
+			}
+		}
+		for _, index := range indexes {
+			var result string
+			switch {
+			case !ok:
+				result = "skipped, account " + first.Profile + " was not given"
+			case sessErr != nil:
+				result = "failed: " + sessErr.Error()
+			default:
+				result = applyRoleOperation(svc, account, operations[index])
+			}
+			mu.Lock()
+			operations[index].Result = result
+			mu.Unlock()
+		}
+	})
+}
+
+// applyRoleOperation will carry out one operation, and return the result
+func applyRoleOperation(svc *iam.IAM, account utils.AccountInfo, op RoleOperation) string {
+	roleName := aws.String(op.RoleName)
+	var err error
+	var apiCall string
+	switch op.Operation {
+	case OpSessionDuration:
+		apiCall = "UpdateRole"
+		var duration int64
+		duration, err = strconv.ParseInt(op.Desired, 10, 64)
+		if err == nil {
+			_, err = svc.UpdateRole(&iam.UpdateRoleInput{RoleName: roleName, MaxSessionDuration: aws.Int64(duration)})
+		}
+	case OpDescription:
+		apiCall = "UpdateRoleDescription"
+		_, err = svc.UpdateRoleDescription(&iam.UpdateRoleDescriptionInput{RoleName: roleName, Description: aws.String(op.Desired)})
+	case OpPermissionsBoundary:
+		if op.Desired == "" {
+			apiCall = "DeleteRolePermissionsBoundary"
+			_, err = svc.DeleteRolePermissionsBoundary(&iam.DeleteRolePermissionsBoundaryInput{RoleName: roleName})
+		} else {
+			apiCall = "PutRolePermissionsBoundary"
+			_, err = svc.PutRolePermissionsBoundary(&iam.PutRolePermissionsBoundaryInput{RoleName: roleName, PermissionsBoundary: aws.String(op.Desired)})
+		}
+	case OpTag:
+		apiCall = "TagRole"
+		_, err = svc.TagRole(&iam.TagRoleInput{RoleName: roleName, Tags: []*iam.Tag{{Key: aws.String(op.Key), Value: aws.String(op.Desired)}}})
+	case OpUntag:
+		apiCall = "UntagRole"
+		_, err = svc.UntagRole(&iam.UntagRoleInput{RoleName: roleName, TagKeys: aws.StringSlice([]string{op.Key})})
+	case OpAttachPolicy:
+		apiCall = "AttachRolePolicy"
+		_, err = svc.AttachRolePolicy(&iam.AttachRolePolicyInput{RoleName: roleName, PolicyArn: aws.String(op.Key)})
+	case OpDetachPolicy:
+		apiCall = "DetachRolePolicy"
+		_, err = svc.DetachRolePolicy(&iam.DetachRolePolicyInput{RoleName: roleName, PolicyArn: aws.String(op.Key)})
+	default:
+		return "skipped, unknown operation " + op.Operation
+	}
+	if err != nil {
+		utils.RecordError(account, "", "iam", apiCall+" "+op.RoleName, err)
+		return "failed: " + err.Error()
+	}
+	fmt.Println("Applied", op.Operation, op.Key, "to role", op.RoleName, "in", op.Profile)
+	return "applied"
+}
+
+// WriteRoleOperations will write a report of every operation, along with its result if it was applied
+func WriteRoleOperations(operations []RoleOperation, name string) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Role Name",
+		"Operation",
+		"Key",
+		"Current",
+		"Desired",
+		"Result",
+	}
+
+	report := utils.NewReport("iam", name, columnTitles)
+	for _, op := range operations {
+		var data = []string{op.Profile,
+			op.AccountId,
+			op.RoleName,
+			op.Operation,
+			op.Key,
+			op.Current,
+			op.Desired,
+			op.Result,
+		}
+		report.AddRow(data)
+	}
+	return report.Write()
+}
@@ -0,0 +1,212 @@
+package iam
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestParseChangeSetCsv(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    ChangeSet
+		wantErr bool
+	}{
+		{
+			name: "every column",
+			content: "profile,role,maxSessionDuration,description,permissionsBoundary,tags,untag,attachPolicies,detachPolicies\n" +
+				"prod|111111111111,admin|ci-*,28800,managed,arn:aws:iam::111111111111:policy/boundary,owner=platform|env=prod,temp|old," +
+				"arn:aws:iam::aws:policy/ReadOnlyAccess,old-policy\n",
+			want: ChangeSet{Changes: []RoleChange{{
+				Roles:               []string{"admin", "ci-*"},
+				Profiles:            []string{"prod", "111111111111"},
+				MaxSessionDuration:  28800,
+				Description:         aws.String("managed"),
+				PermissionsBoundary: "arn:aws:iam::111111111111:policy/boundary",
+				Tags:                map[string]string{"owner": "platform", "env": "prod"},
+				Untag:               []string{"temp", "old"},
+				AttachPolicies:      []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+				DetachPolicies:      []string{"old-policy"},
+			}}},
+		},
+		{
+			name:    "columns in any order and case",
+			content: "Max Session Duration, ROLE ,Profile\n3600,admin,dev\n43200,ops,\n",
+			want: ChangeSet{Changes: []RoleChange{
+				{Roles: []string{"admin"}, Profiles: []string{"dev"}, MaxSessionDuration: 3600},
+				{Roles: []string{"ops"}, MaxSessionDuration: 43200},
+			}},
+		},
+		{
+			name:    "tag values can have an equals sign",
+			content: "role,tags\nadmin,query=a=b\n",
+			want:    ChangeSet{Changes: []RoleChange{{Roles: []string{"admin"}, Tags: map[string]string{"query": "a=b"}}}},
+		},
+		{
+			name:    "short rows",
+			content: "role,description,untag\nadmin\n",
+			want:    ChangeSet{Changes: []RoleChange{{Roles: []string{"admin"}}}},
+		},
+		{
+			name:    "header only",
+			content: "role,maxSessionDuration\n",
+			want:    ChangeSet{},
+		},
+		{
+			name:    "no role column",
+			content: "profile,maxSessionDuration\nprod,3600\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid duration",
+			content: "role,maxSessionDuration\nadmin,8h\n",
+			wantErr: true,
+		},
+		{
+			name:    "tag without a value",
+			content: "role,tags\nadmin,owner\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			content: "",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseChangeSetCsv(strings.NewReader(test.content))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ParseChangeSetCsv =\n%+v\nwant:\n%+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPlanRoleOperations(t *testing.T) {
+	roleArn := "arn:aws:iam::111111111111:role/"
+	states := ProfilesRoleStates{
+		{
+			Profile:   "prod",
+			AccountId: "111111111111",
+			Roles: []RoleState{
+				{
+					Role: iam.Role{
+						RoleName:           aws.String("admin"),
+						Arn:                aws.String(roleArn + "admin"),
+						MaxSessionDuration: aws.Int64(3600),
+						Description:        aws.String("old"),
+						PermissionsBoundary: &iam.AttachedPermissionsBoundary{
+							PermissionsBoundaryArn: aws.String("arn:aws:iam::111111111111:policy/boundary"),
+						},
+						Tags: []*iam.Tag{
+							{Key: aws.String("owner"), Value: aws.String("platform")},
+							{Key: aws.String("temp"), Value: aws.String("1")},
+						},
+					},
+					AttachedPolicies: []string{"arn:aws:iam::111111111111:policy/old-policy"},
+				},
+				{
+					Role: iam.Role{
+						RoleName:           aws.String("ci-deploy"),
+						Arn:                aws.String(roleArn + "ci-deploy"),
+						MaxSessionDuration: aws.Int64(28800),
+					},
+				},
+			},
+		},
+		{
+			Profile:   "dev",
+			AccountId: "222222222222",
+			Roles: []RoleState{
+				{Role: iam.Role{RoleName: aws.String("admin"), Arn: aws.String("arn:aws:iam::222222222222:role/admin"), MaxSessionDuration: aws.Int64(3600)}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		changeSet ChangeSet
+		want      []RoleOperation
+	}{
+		{
+			name:      "nothing to change",
+			changeSet: ChangeSet{Changes: []RoleChange{{Roles: []string{"ci-*"}, MaxSessionDuration: 28800}}},
+			want:      nil,
+		},
+		{
+			name:      "session duration in every account, sorted by profile",
+			changeSet: ChangeSet{Changes: []RoleChange{{Roles: []string{"*"}, MaxSessionDuration: 28800}}},
+			want: []RoleOperation{
+				{Profile: "dev", AccountId: "222222222222", RoleName: "admin", Operation: OpSessionDuration, Current: "3600", Desired: "28800"},
+				{Profile: "prod", AccountId: "111111111111", RoleName: "admin", Operation: OpSessionDuration, Current: "3600", Desired: "28800"},
+			},
+		},
+		{
+			name:      "limited to an account id",
+			changeSet: ChangeSet{Changes: []RoleChange{{Roles: []string{"admin"}, Profiles: []string{"222222222222"}, MaxSessionDuration: 7200}}},
+			want: []RoleOperation{
+				{Profile: "dev", AccountId: "222222222222", RoleName: "admin", Operation: OpSessionDuration, Current: "3600", Desired: "7200"},
+			},
+		},
+		{
+			name: "every operation",
+			changeSet: ChangeSet{Changes: []RoleChange{{
+				Roles:               []string{"admin"},
+				Profiles:            []string{"prod"},
+				Description:         aws.String("new"),
+				PermissionsBoundary: BoundaryNone,
+				Tags:                map[string]string{"owner": "platform", "env": "prod"},
+				Untag:               []string{"temp", "missing"},
+				AttachPolicies:      []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+				DetachPolicies:      []string{"old-policy"},
+			}}},
+			want: []RoleOperation{
+				{Profile: "prod", AccountId: "111111111111", RoleName: "admin", Operation: OpDescription, Current: "old", Desired: "new"},
+				{Profile: "prod", AccountId: "111111111111", RoleName: "admin", Operation: OpPermissionsBoundary, Current: "arn:aws:iam::111111111111:policy/boundary"},
+				{Profile: "prod", AccountId: "111111111111", RoleName: "admin", Operation: OpTag, Key: "env", Desired: "prod"},
+				{Profile: "prod", AccountId: "111111111111", RoleName: "admin", Operation: OpUntag, Key: "temp", Current: "1"},
+				{Profile: "prod", AccountId: "111111111111", RoleName: "admin", Operation: OpDetachPolicy, Key: "arn:aws:iam::111111111111:policy/old-policy", Current: "attached"},
+				{Profile: "prod", AccountId: "111111111111", RoleName: "admin", Operation: OpAttachPolicy, Key: "arn:aws:iam::aws:policy/ReadOnlyAccess", Desired: "attached"},
+			},
+		},
+		{
+			name: "later changes win",
+			changeSet: ChangeSet{Changes: []RoleChange{
+				{Roles: []string{"*"}, Profiles: []string{"prod"}, MaxSessionDuration: 7200, Tags: map[string]string{"owner": "ops"}, DetachPolicies: []string{"old-policy"}},
+				{Roles: []string{"admin"}, MaxSessionDuration: 3600, Tags: map[string]string{"owner": "platform"}, AttachPolicies: []string{"old-policy"}},
+			}},
+			want: []RoleOperation{
+				{Profile: "dev", AccountId: "222222222222", RoleName: "admin", Operation: OpTag, Key: "owner", Desired: "platform"},
+				{Profile: "dev", AccountId: "222222222222", RoleName: "admin", Operation: OpAttachPolicy, Key: "arn:aws:iam::222222222222:policy/old-policy", Desired: "attached"},
+				{Profile: "prod", AccountId: "111111111111", RoleName: "ci-deploy", Operation: OpSessionDuration, Current: "28800", Desired: "7200"},
+				{Profile: "prod", AccountId: "111111111111", RoleName: "ci-deploy", Operation: OpTag, Key: "owner", Desired: "ops"},
+			},
+		},
+		{
+			name:      "a key that is also tagged is not untagged",
+			changeSet: ChangeSet{Changes: []RoleChange{{Roles: []string{"admin"}, Profiles: []string{"prod"}, Tags: map[string]string{"temp": "2"}, Untag: []string{"temp"}}}},
+			want: []RoleOperation{
+				{Profile: "prod", AccountId: "111111111111", RoleName: "admin", Operation: OpTag, Key: "temp", Current: "1", Desired: "2"},
+			},
+		},
+		{
+			name:      "set a boundary",
+			changeSet: ChangeSet{Changes: []RoleChange{{Roles: []string{"ci-deploy"}, PermissionsBoundary: "arn:aws:iam::111111111111:policy/boundary"}}},
+			want: []RoleOperation{
+				{Profile: "prod", AccountId: "111111111111", RoleName: "ci-deploy", Operation: OpPermissionsBoundary, Desired: "arn:aws:iam::111111111111:policy/boundary"},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := PlanRoleOperations(states, test.changeSet); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: PlanRoleOperations =\n%+v\nwant:\n%+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package iam
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Trust principal classifications
+const (
+	TrustSameAccount  = "same-account"
+	TrustKnownAccount = "known-account"
+	TrustExternal     = "external"
+	TrustWildcard     = "*"
+	TrustService      = "service"
+	TrustSaml         = "saml"
+	TrustOidc         = "oidc"
+	TrustOther        = "other"
+)
+
+// accountIdPattern matches a principal that is an account id, or the account id in an arn
+var accountIdPattern = regexp.MustCompile(`^(?:arn:[^:]+:(?:iam|sts)::)?([0-9]{12})(?::|$)`)
+
+type (
+	TrustOptions struct {
+		// KnownAccounts is the account ids of the accounts inventory, and their profile
+		KnownAccounts map[string]string
+	}
+
+	// TrustFinding is one principal of an allow statement in the trust policy of a role
+	TrustFinding struct {
+		Profile          string
+		AccountId        string
+		RoleName         string
+		RoleArn          string
+		Sid              string
+		Actions          string
+		PrincipalType    string
+		Principal        string
+		PrincipalAccount string
+		KnownProfile     string
+		Classification   string
+		ConditionKeys    []string
+		ExternalId       bool
+		Severity         string
+		Details          string
+	}
+)
+
+// KnownAccounts will return the account ids of the inventory, along with every account the roles were collected from
+// Account ids are taken from the role arn of an account if the inventory does not have them
+func KnownAccounts(accounts []utils.AccountInfo, profilesRoles ProfilesRoles) map[string]string {
+	known := make(map[string]string)
+	for _, account := range accounts {
+		accountId := account.AccountId
+		if match := accountIdPattern.FindStringSubmatch(account.Arn); accountId == "" && match != nil {
+			accountId = match[1]
+		}
+		if accountId != "" {
+			known[accountId] = account.Profile
+		}
+	}
+	for _, profileRoles := range profilesRoles {
+		if profileRoles.AccountId != "" {
+			known[profileRoles.AccountId] = profileRoles.Profile
+		}
+	}
+	return known
+}
+
+// ClassifyPrincipal will classify a trust principal, compared to the account of the role and the known accounts,
+// and return the account of the principal if it has one
+func ClassifyPrincipal(principalType string, principal string, accountId string, options TrustOptions) (string, string) {
+	switch principalType {
+	case PrincipalWildcard:
+		return TrustWildcard, ""
+	case "Service":
+		return TrustService, ""
+	case "Federated":
+		if strings.Contains(principal, ":saml-provider/") {
+			return TrustSaml, accountIdOf(principal)
+		}
+		// oidc providers in the account, and the web identity providers such as cognito, are all oidc
+		return TrustOidc, accountIdOf(principal)
+	case "AWS":
+		if principal == "*" {
+			return TrustWildcard, ""
+		}
+		principalAccount := accountIdOf(principal)
+		switch {
+		case principalAccount == "":
+			return TrustOther, ""
+		case principalAccount == accountId:
+			return TrustSameAccount, principalAccount
+		}
+		if _, ok := options.KnownAccounts[principalAccount]; ok {
+			return TrustKnownAccount, principalAccount
+		}
+		return TrustExternal, principalAccount
+	}
+	return TrustOther, ""
+}
+
+// accountIdOf will return the account id of a principal that is an account id or an arn, or an empty string
+func accountIdOf(principal string) string {
+	if match := accountIdPattern.FindStringSubmatch(principal); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// conditionKeys will return every condition key of the statement, sorted
+func conditionKeys(condition Condition) []string {
+	var keys []string
+	for _, operator := range condition {
+		for key := range operator {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hasConditionKey will return if any of the keys is the key, which are not case sensitive
+func hasConditionKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditTrust will classify every principal of the allow statements in the trust policy of each role
+// Third party trusts, which are external accounts, need an sts:ExternalId condition to prevent the confused deputy problem
+func AuditTrust(profilesRoles ProfilesRoles, options TrustOptions) []TrustFinding {
+	var findings []TrustFinding
+	for _, profileRoles := range profilesRoles {
+		for _, roleInfo := range profileRoles.Roles {
+			role := roleInfo.Role
+			document, err := ParseDocument(aws.StringValue(role.AssumeRolePolicyDocument))
+			if err != nil {
+				fmt.Println("Could not parse the trust policy of role", aws.StringValue(role.RoleName), "in", profileRoles.Profile, ":", err)
+				continue
+			}
+			for i, statement := range document.Statement {
+				if statement.Effect != "Allow" {
+					continue
+				}
+				sid := statement.Sid
+				if sid == "" {
+					sid = fmt.Sprintf("statement %d", i+1)
+				}
+				keys := conditionKeys(statement.Condition)
+				externalId := hasConditionKey(keys, "sts:ExternalId")
+
+				principalTypes := make([]string, 0, len(statement.Principal))
+				for principalType := range statement.Principal {
+					principalTypes = append(principalTypes, principalType)
+				}
+				sort.Strings(principalTypes)
+				for _, principalType := range principalTypes {
+					for _, principal := range statement.Principal[principalType] {
+						classification, principalAccount := ClassifyPrincipal(principalType, principal, profileRoles.AccountId, options)
+						finding := TrustFinding{
+							Profile:          profileRoles.Profile,
+							AccountId:        profileRoles.AccountId,
+							RoleName:         aws.StringValue(role.RoleName),
+							RoleArn:          aws.StringValue(role.Arn),
+							Sid:              sid,
+							Actions:          strings.Join(statement.Action, "|"),
+							PrincipalType:    principalType,
+							Principal:        principal,
+							PrincipalAccount: principalAccount,
+							KnownProfile:     options.KnownAccounts[principalAccount],
+							Classification:   classification,
+							ConditionKeys:    keys,
+							ExternalId:       externalId,
+						}
+						finding.Severity, finding.Details = trustSeverity(finding)
+						findings = append(findings, finding)
+					}
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// trustSeverity will return how risky the trust is, and why
+func trustSeverity(finding TrustFinding) (string, string) {
+	switch finding.Classification {
+	case TrustWildcard:
+		if len(finding.ConditionKeys) == 0 {
+			return SeverityCritical, "any aws principal can assume the role"
+		}
+		return SeverityHigh, "any aws principal that meets the condition can assume the role"
+	case TrustExternal:
+		if !finding.ExternalId {
+			return SeverityHigh, "third party account without an sts:ExternalId condition"
+		}
+		return "", "third party account with an sts:ExternalId condition"
+	case TrustOidc:
+		if len(finding.ConditionKeys) == 0 {
+			return SeverityHigh, "any identity of the provider can assume the role, with no condition on the token"
+		}
+	}
+	return "", ""
+}
+
+// WriteTrustFindings will write every principal of every role trust policy
+func WriteTrustFindings(findings []TrustFinding) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Role Name",
+		"Role ARN",
+		"Statement",
+		"Actions",
+		"Principal Type",
+		"Principal",
+		"Principal Account",
+		"Known Profile",
+		"Classification",
+		"Condition Keys",
+		"External ID",
+		"Severity",
+		"Details",
+	}
+
+	flagged := 0
+	report := utils.NewReport("iam", "trustaudit", columnTitles)
+	for _, finding := range findings {
+		if finding.Severity != "" {
+			flagged++
+		}
+		var data = []string{finding.Profile,
+			finding.AccountId,
+			finding.RoleName,
+			finding.RoleArn,
+			finding.Sid,
+			finding.Actions,
+			finding.PrincipalType,
+			finding.Principal,
+			finding.PrincipalAccount,
+			finding.KnownProfile,
+			finding.Classification,
+			strings.Join(finding.ConditionKeys, "|"),
+			fmt.Sprint(finding.ExternalId),
+			finding.Severity,
+			finding.Details,
+		}
+		report.AddRow(data)
+	}
+	fmt.Println("Found", flagged, "risky trust principals")
+	return report.Write()
+}
@@ -0,0 +1,145 @@
+package iam
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestClassifyPrincipal(t *testing.T) {
+	options := TrustOptions{KnownAccounts: map[string]string{"222222222222": "dev"}}
+	tests := []struct {
+		name           string
+		principalType  string
+		principal      string
+		classification string
+		account        string
+	}{
+		{"wildcard principal", PrincipalWildcard, "*", TrustWildcard, ""},
+		{"aws wildcard", "AWS", "*", TrustWildcard, ""},
+		{"same account id", "AWS", "111111111111", TrustSameAccount, "111111111111"},
+		{"same account root", "AWS", "arn:aws:iam::111111111111:root", TrustSameAccount, "111111111111"},
+		{"known account role", "AWS", "arn:aws:iam::222222222222:role/deploy", TrustKnownAccount, "222222222222"},
+		{"known account id", "AWS", "222222222222", TrustKnownAccount, "222222222222"},
+		{"external account id", "AWS", "333333333333", TrustExternal, "333333333333"},
+		{"external user", "AWS", "arn:aws:iam::333333333333:user/vendor", TrustExternal, "333333333333"},
+		{"assumed role session", "AWS", "arn:aws:sts::333333333333:assumed-role/vendor/session", TrustExternal, "333333333333"},
+		{"govcloud partition", "AWS", "arn:aws-us-gov:iam::111111111111:root", TrustSameAccount, "111111111111"},
+		{"role unique id", "AWS", "AROAEXAMPLEID", TrustOther, ""},
+		{"too short account id", "AWS", "11111111111", TrustOther, ""},
+		{"service", "Service", "ec2.amazonaws.com", TrustService, ""},
+		{"saml provider", "Federated", "arn:aws:iam::111111111111:saml-provider/okta", TrustSaml, "111111111111"},
+		{"oidc provider", "Federated", "arn:aws:iam::111111111111:oidc-provider/token.actions.githubusercontent.com", TrustOidc, "111111111111"},
+		{"web identity", "Federated", "cognito-identity.amazonaws.com", TrustOidc, ""},
+		{"canonical user", "CanonicalUser", "79a59df900b949e55d96a1e698fbaced", TrustOther, ""},
+	}
+	for _, test := range tests {
+		classification, account := ClassifyPrincipal(test.principalType, test.principal, "111111111111", options)
+		if classification != test.classification || account != test.account {
+			t.Errorf("%s: ClassifyPrincipal = %s, %q, want %s, %q", test.name, classification, account, test.classification, test.account)
+		}
+	}
+}
+
+func TestTrustSeverity(t *testing.T) {
+	tests := []struct {
+		name     string
+		finding  TrustFinding
+		severity string
+		details  string
+	}{
+		{
+			name:     "wildcard",
+			finding:  TrustFinding{Classification: TrustWildcard},
+			severity: SeverityCritical,
+			details:  "any aws principal can assume the role",
+		},
+		{
+			name:     "wildcard with a condition",
+			finding:  TrustFinding{Classification: TrustWildcard, ConditionKeys: []string{"aws:PrincipalOrgID"}},
+			severity: SeverityHigh,
+			details:  "any aws principal that meets the condition can assume the role",
+		},
+		{
+			name:     "external without an external id",
+			finding:  TrustFinding{Classification: TrustExternal, ConditionKeys: []string{"aws:SourceIp"}},
+			severity: SeverityHigh,
+			details:  "third party account without an sts:ExternalId condition",
+		},
+		{
+			name:    "external with an external id",
+			finding: TrustFinding{Classification: TrustExternal, ConditionKeys: []string{"sts:ExternalId"}, ExternalId: true},
+			details: "third party account with an sts:ExternalId condition",
+		},
+		{
+			name:     "oidc without a condition",
+			finding:  TrustFinding{Classification: TrustOidc},
+			severity: SeverityHigh,
+			details:  "any identity of the provider can assume the role, with no condition on the token",
+		},
+		{
+			name:    "oidc with a condition",
+			finding: TrustFinding{Classification: TrustOidc, ConditionKeys: []string{"token.actions.githubusercontent.com:sub"}},
+		},
+		{
+			name:    "saml",
+			finding: TrustFinding{Classification: TrustSaml},
+		},
+		{
+			name:    "known account",
+			finding: TrustFinding{Classification: TrustKnownAccount},
+		},
+		{
+			name:    "same account",
+			finding: TrustFinding{Classification: TrustSameAccount},
+		},
+	}
+	for _, test := range tests {
+		if severity, details := trustSeverity(test.finding); severity != test.severity || details != test.details {
+			t.Errorf("%s: trustSeverity = %q, %q, want %q, %q", test.name, severity, details, test.severity, test.details)
+		}
+	}
+}
+
+func TestAuditTrust(t *testing.T) {
+	document := `{"Version":"2012-10-17","Statement":[
+		{"Sid":"Vendor","Effect":"Allow","Principal":{"AWS":["444444444444","arn:aws:iam::333333333333:root"]},"Action":"sts:AssumeRole",
+			"Condition":{"StringEquals":{"STS:externalid":"abc"}}},
+		{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::222222222222:role/deploy","Service":"lambda.amazonaws.com"},"Action":"sts:AssumeRole"},
+		{"Effect":"Deny","Principal":"*","Action":"sts:AssumeRole"}]}`
+	profilesRoles := ProfilesRoles{{
+		Profile:   "prod",
+		AccountId: "111111111111",
+		Roles: []RoleInfo{{Role: iam.Role{
+			RoleName:                 aws.String("vendor"),
+			Arn:                      aws.String("arn:aws:iam::111111111111:role/vendor"),
+			AssumeRolePolicyDocument: aws.String(document),
+		}}},
+	}}
+	options := TrustOptions{KnownAccounts: map[string]string{"222222222222": "dev"}}
+
+	// summary is the part of each finding that the trust policy decides
+	type summary struct {
+		Sid            string
+		Principal      string
+		Classification string
+		KnownProfile   string
+		ExternalId     bool
+		Severity       string
+	}
+	want := []summary{
+		{"Vendor", "444444444444", TrustExternal, "", true, ""},
+		{"Vendor", "arn:aws:iam::333333333333:root", TrustExternal, "", true, ""},
+		{"statement 2", "arn:aws:iam::222222222222:role/deploy", TrustKnownAccount, "dev", false, ""},
+		{"statement 2", "lambda.amazonaws.com", TrustService, "", false, ""},
+	}
+	var got []summary
+	for _, finding := range AuditTrust(profilesRoles, options) {
+		got = append(got, summary{finding.Sid, finding.Principal, finding.Classification, finding.KnownProfile, finding.ExternalId, finding.Severity})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AuditTrust =\n%+v\nwant:\n%+v", got, want)
+	}
+}
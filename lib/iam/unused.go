@@ -0,0 +1,283 @@
+package iam
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// lastAccessedAttempts is how many times to check if a last accessed job is done, a second apart
+const lastAccessedAttempts = 60
+
+type (
+	UnusedOptions struct {
+		// Days is how many days a role, user or service can go unused before it is reported as unused
+		Days int
+	}
+
+	// PrincipalAccess is a role or user, when it was last used, and when it last used each service it is granted
+	PrincipalAccess struct {
+		Type     string // role or user
+		Name     string
+		Arn      string
+		Created  time.Time
+		LastUsed time.Time
+		Services []iam.ServiceLastAccessed
+	}
+
+	ProfileAccess struct {
+		Profile    string
+		AccountId  string
+		Principals []PrincipalAccess
+	}
+	ProfilesAccess []ProfileAccess
+)
+
+// GetProfileAccess will get every role and user in the account, with the last accessed details of their services
+// The last used time of a role is from iam, and of a user is the last time it used any service
+// Any failed job fails the whole account, so nothing is seen as unused because a call failed
+func GetProfileAccess(sess *session.Session) ([]PrincipalAccess, error) {
+	svc := iam.New(sess)
+	var principals []PrincipalAccess
+	params := &iam.GetAccountAuthorizationDetailsInput{Filter: aws.StringSlice([]string{iam.EntityTypeRole, iam.EntityTypeUser})}
+	err := svc.GetAccountAuthorizationDetailsPages(params, func(page *iam.GetAccountAuthorizationDetailsOutput, lastPage bool) bool {
+		for _, role := range page.RoleDetailList {
+			principal := PrincipalAccess{Type: "role", Name: aws.StringValue(role.RoleName), Arn: aws.StringValue(role.Arn), Created: aws.TimeValue(role.CreateDate)}
+			if role.RoleLastUsed != nil {
+				principal.LastUsed = aws.TimeValue(role.RoleLastUsed.LastUsedDate)
+			}
+			principals = append(principals, principal)
+		}
+		for _, user := range page.UserDetailList {
+			principals = append(principals, PrincipalAccess{Type: "user", Name: aws.StringValue(user.UserName), Arn: aws.StringValue(user.Arn), Created: aws.TimeValue(user.CreateDate)})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get authorization details: %w", err)
+	}
+
+	// start every job first, as they can take a while to finish
+	jobIds := make([]string, len(principals))
+	for i, principal := range principals {
+		resp, err := svc.GenerateServiceLastAccessedDetails(&iam.GenerateServiceLastAccessedDetailsInput{Arn: aws.String(principal.Arn)})
+		if err != nil {
+			return nil, fmt.Errorf("could not generate last accessed details for %s: %w", principal.Arn, err)
+		}
+		jobIds[i] = aws.StringValue(resp.JobId)
+	}
+
+	for i := range principals {
+		services, err := getServiceLastAccessed(svc, jobIds[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not get last accessed details for %s: %w", principals[i].Arn, err)
+		}
+		principals[i].Services = services
+		for _, service := range services {
+			if principals[i].Type == "user" && aws.TimeValue(service.LastAuthenticated).After(principals[i].LastUsed) {
+				principals[i].LastUsed = aws.TimeValue(service.LastAuthenticated)
+			}
+		}
+	}
+	return principals, nil
+}
+
+// getServiceLastAccessed will wait for the last accessed job to finish, and return every service in it
+func getServiceLastAccessed(svc *iam.IAM, jobId string) ([]iam.ServiceLastAccessed, error) {
+	params := &iam.GetServiceLastAccessedDetailsInput{JobId: aws.String(jobId)}
+	var services []iam.ServiceLastAccessed
+	for attempt := 0; ; attempt++ {
+		resp, err := svc.GetServiceLastAccessedDetails(params)
+		if err != nil {
+			return nil, err
+		}
+		switch aws.StringValue(resp.JobStatus) {
+		case iam.JobStatusTypeFailed:
+			if resp.Error != nil {
+				return nil, fmt.Errorf("job failed: %s", aws.StringValue(resp.Error.Message))
+			}
+			return nil, fmt.Errorf("job failed")
+		case iam.JobStatusTypeCompleted:
+			for _, service := range resp.ServicesLastAccessed {
+				services = append(services, *service)
+			}
+			if !aws.BoolValue(resp.IsTruncated) {
+				return services, nil
+			}
+			params.Marker = resp.Marker
+			continue
+		}
+		if attempt >= lastAccessedAttempts {
+			return nil, fmt.Errorf("job was not done after %d attempts", attempt)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// GetProfilesAccess will get the roles and users, and their last accessed details, in all given accounts
+func GetProfilesAccess(accounts []utils.AccountInfo) (ProfilesAccess, error) {
+	profilesAccessChan := make(chan ProfileAccess)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			fmt.Println("Getting last accessed details for profile:", account.Profile)
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			sess, err := account.GetSession("us-east-1")
+			if err != nil {
+				utils.RecordError(account, "", "iam", "GetSession", err)
+				return
+			}
+			principals, err := GetProfileAccess(sess)
+			if err != nil {
+				utils.RecordError(account, "", "iam", "GetServiceLastAccessedDetails", err)
+				return
+			}
+			profilesAccessChan <- ProfileAccess{Profile: account.Profile, AccountId: account.AccountId, Principals: principals}
+		})
+		close(profilesAccessChan)
+	}()
+
+	var profilesAccess ProfilesAccess
+	for profileAccess := range profilesAccessChan {
+		profilesAccess = append(profilesAccess, profileAccess)
+	}
+	return profilesAccess, nil
+}
+
+// Unused will return if the principal was not used in the days, or was never used and is older than the days
+func (principal PrincipalAccess) Unused(days int, now time.Time) bool {
+	if principal.LastUsed.IsZero() {
+		return daysSince(principal.Created, now) > days
+	}
+	return daysSince(principal.LastUsed, now) > days
+}
+
+// UnusedServices will return the services the principal is granted but never used, or did not use in the days
+func (principal PrincipalAccess) UnusedServices(days int, now time.Time) []iam.ServiceLastAccessed {
+	var unused []iam.ServiceLastAccessed
+	for _, service := range principal.Services {
+		if service.LastAuthenticated == nil || daysSince(*service.LastAuthenticated, now) > days {
+			unused = append(unused, service)
+		}
+	}
+	return unused
+}
+
+func daysSince(t time.Time, now time.Time) int {
+	return int(now.Sub(t).Hours() / 24)
+}
+
+// WriteProfilesAccess will write every role and user with when they were last used, and every service they are
+// granted but have not used
+func WriteProfilesAccess(profilesAccess ProfilesAccess, options UnusedOptions) error {
+	now := time.Now()
+	if err := writeUnusedPrincipals(profilesAccess, options, now); err != nil {
+		return err
+	}
+	return writeUnusedServices(profilesAccess, options, now)
+}
+
+func writeUnusedPrincipals(profilesAccess ProfilesAccess, options UnusedOptions, now time.Time) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Type",
+		"Name",
+		"ARN",
+		"Created",
+		"Last Used",
+		"Days Unused",
+		"Unused",
+		"Services Granted",
+		"Services Used",
+		"Services Unused",
+		"Unused Service Namespaces",
+	}
+
+	unused := 0
+	report := utils.NewReport("iam", "unusedprincipals", columnTitles)
+	for _, profileAccess := range profilesAccess {
+		for _, principal := range profileAccess.Principals {
+			lastUsed := principal.LastUsed
+			if lastUsed.IsZero() {
+				lastUsed = principal.Created
+			}
+			unusedServices := principal.UnusedServices(options.Days, now)
+			isUnused := principal.Unused(options.Days, now)
+			if isUnused {
+				unused++
+			}
+			var data = []string{profileAccess.Profile,
+				profileAccess.AccountId,
+				principal.Type,
+				principal.Name,
+				principal.Arn,
+				reportDate(principal.Created),
+				reportDate(principal.LastUsed),
+				strconv.Itoa(daysSince(lastUsed, now)),
+				strconv.FormatBool(isUnused),
+				strconv.Itoa(len(principal.Services)),
+				strconv.Itoa(len(principal.Services) - len(unusedServices)),
+				strconv.Itoa(len(unusedServices)),
+				servicesList(unusedServices),
+			}
+			report.AddRow(data)
+		}
+	}
+	fmt.Println("Found", unused, "roles and users unused in", options.Days, "days")
+	return report.Write()
+}
+
+func writeUnusedServices(profilesAccess ProfilesAccess, options UnusedOptions, now time.Time) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Type",
+		"Name",
+		"Service Namespace",
+		"Service Name",
+		"Last Authenticated",
+		"Last Authenticated Region",
+		"Status",
+	}
+
+	report := utils.NewReport("iam", "unusedservices", columnTitles)
+	for _, profileAccess := range profilesAccess {
+		for _, principal := range profileAccess.Principals {
+			for _, service := range principal.UnusedServices(options.Days, now) {
+				status := "never used"
+				if service.LastAuthenticated != nil {
+					status = "not used in " + strconv.Itoa(options.Days) + " days"
+				}
+				var data = []string{profileAccess.Profile,
+					profileAccess.AccountId,
+					principal.Type,
+					principal.Name,
+					aws.StringValue(service.ServiceNamespace),
+					aws.StringValue(service.ServiceName),
+					reportDate(aws.TimeValue(service.LastAuthenticated)),
+					aws.StringValue(service.LastAuthenticatedRegion),
+					status,
+				}
+				report.AddRow(data)
+			}
+		}
+	}
+	return report.Write()
+}
+
+// servicesList will join the namespaces of the services
+func servicesList(services []iam.ServiceLastAccessed) string {
+	var namespaces []string
+	for _, service := range services {
+		namespaces = append(namespaces, aws.StringValue(service.ServiceNamespace))
+	}
+	return strings.Join(namespaces, "|")
+}
@@ -0,0 +1,76 @@
+package iam
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestPrincipalAccessUnused(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	tests := []struct {
+		name      string
+		principal PrincipalAccess
+		want      bool
+	}{
+		{"never used, older than the days", PrincipalAccess{Created: daysAgo(100)}, true},
+		{"never used, younger than the days", PrincipalAccess{Created: daysAgo(10)}, false},
+		{"never used, created exactly the days ago", PrincipalAccess{Created: daysAgo(90)}, false},
+		{"never used, created a day more than the days ago", PrincipalAccess{Created: daysAgo(91)}, true},
+		{"used recently", PrincipalAccess{Created: daysAgo(400), LastUsed: daysAgo(5)}, false},
+		{"last used exactly the days ago", PrincipalAccess{Created: daysAgo(400), LastUsed: daysAgo(90)}, false},
+		{"last used an hour short of a day past the days", PrincipalAccess{Created: daysAgo(400), LastUsed: daysAgo(91).Add(time.Hour)}, false},
+		{"last used a day more than the days ago", PrincipalAccess{Created: daysAgo(400), LastUsed: daysAgo(91)}, true},
+		{"last used long ago", PrincipalAccess{Created: daysAgo(400), LastUsed: daysAgo(200)}, true},
+	}
+	for _, test := range tests {
+		if got := test.principal.Unused(90, now); got != test.want {
+			t.Errorf("%s: Unused = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPrincipalAccessUnusedServices(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	service := func(namespace string, days int) iam.ServiceLastAccessed {
+		accessed := iam.ServiceLastAccessed{ServiceNamespace: aws.String(namespace)}
+		if days >= 0 {
+			accessed.LastAuthenticated = aws.Time(now.AddDate(0, 0, -days))
+		}
+		return accessed
+	}
+	principal := PrincipalAccess{Services: []iam.ServiceLastAccessed{
+		service("s3", 1),
+		service("ec2", -1),
+		service("iam", 30),
+		service("sqs", 31),
+		service("kms", 365),
+	}}
+
+	tests := []struct {
+		name string
+		days int
+		want []string
+	}{
+		{"never used and past the days", 30, []string{"ec2", "sqs", "kms"}},
+		{"never used is always unused", 1000, []string{"ec2"}},
+		{"every service", 0, []string{"s3", "ec2", "iam", "sqs", "kms"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, unused := range principal.UnusedServices(test.days, now) {
+			got = append(got, aws.StringValue(unused.ServiceNamespace))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: UnusedServices = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if got := (PrincipalAccess{}).UnusedServices(30, now); got != nil {
+		t.Errorf("no services: UnusedServices = %v, want none", got)
+	}
+}
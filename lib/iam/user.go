@@ -1,11 +1,8 @@
 package iam
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
 	"strings"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws"
@@ -105,22 +102,19 @@ func GetProfileAccountAuthInfo(sess *session.Session) ([]iam.GroupDetail, []iam.
 // GetProfilesUsers will get all of the users in all given accounts
 func GetProfilesUsers(accounts []utils.AccountInfo) (ProfilesUsers, error) {
 	profilesUsersChan := make(chan ProfileUsers)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			fmt.Println("Getting users for profile:", account.Profile)
-			defer wg.Done()
 			var profileUsers ProfileUsers
 			sess, err := account.GetSession("us-east-1")
 			if err != nil {
-				log.Println("Could not get users for", account.Profile, ":", err)
+				utils.RecordError(account, "", "iam", "GetSession", err)
 				return
 			}
 			users, err := GetProfileUsers(sess)
 			if err != nil {
-				log.Println("could not get users for ", account.Profile, " : ", err)
+				utils.RecordError(account, "", "iam", "ListUsers", err)
 				return
 			}
 			for _, user := range users {
@@ -129,6 +123,9 @@ func GetProfilesUsers(accounts []utils.AccountInfo) (ProfilesUsers, error) {
 
 			//Getting account auth info from iam to get policy info
 			groupsInfo, usersInfo, err := GetProfileAccountAuthInfo(sess)
+			if err != nil {
+				utils.RecordError(account, "", "iam", "GetAccountAuthorizationDetails", err)
+			}
 			for _, group := range groupsInfo {
 				profileUsers.GroupsInfo = append(profileUsers.GroupsInfo, group)
 			}
@@ -137,17 +134,13 @@ func GetProfilesUsers(accounts []utils.AccountInfo) (ProfilesUsers, error) {
 			}
 
 			profileUsers.Profile = account.Profile
-			profileUsers.AccountID, err = utils.GetAccountId(sess)
+			profileUsers.AccountID, err = account.CallerAccountId()
 			if err != nil {
-				log.Println("could not get account id for", account.Profile, ":", err)
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
 				return
 			}
 			profilesUsersChan <- profileUsers
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesUsersChan)
 	}()
 
@@ -160,17 +153,6 @@ func GetProfilesUsers(accounts []utils.AccountInfo) (ProfilesUsers, error) {
 }
 
 func WriteProfilesUsers(profilesUsers ProfilesUsers) error {
-	outputDir := "output/iam/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "users.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create users file: %v", err)
-	}
-
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing users to file:", outfile.Name())
 	var columnTitles = []string{"Account",
 		"Account ID",
 		"User Name",
@@ -182,8 +164,7 @@ func WriteProfilesUsers(profilesUsers ProfilesUsers) error {
 		"Group Inline Policies",
 	}
 
-	err = writer.Write(columnTitles)
-
+	report := utils.NewReport("iam", "users", columnTitles)
 	for _, profileUsers := range profilesUsers {
 		for _, user := range profileUsers.Users {
 			var userInfo iam.UserDetail
@@ -243,11 +224,8 @@ func WriteProfilesUsers(profilesUsers ProfilesUsers) error {
 				stringGroupInlinePolicies,
 			}
 
-			err := writer.Write(data)
-			if err != nil {
-				fmt.Println(err)
-			}
+			report.AddRow(data)
 		}
 	}
-	return nil
+	return report.Write()
 }
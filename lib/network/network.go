@@ -0,0 +1,147 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// RegionNetwork is every resource in a region with a cidr in it
+type RegionNetwork struct {
+	AccountId      string
+	Region         string
+	Profile        string
+	Vpcs           []ec2.Vpc
+	Subnets        []ec2.Subnet
+	RouteTables    []ec2.RouteTable
+	NetworkAcls    []ec2.NetworkAcl
+	SecurityGroups []ec2.SecurityGroup
+}
+
+type AccountNetwork []RegionNetwork
+type ProfilesNetwork []AccountNetwork
+
+// GetRegionNetwork will take a session and get the vpcs, subnets, route tables, network acls and security groups in the region
+func GetRegionNetwork(sess *session.Session) (RegionNetwork, error) {
+	var info RegionNetwork
+	svc := ec2.New(sess)
+
+	err := svc.DescribeVpcsPages(&ec2.DescribeVpcsInput{}, func(page *ec2.DescribeVpcsOutput, lastPage bool) bool {
+		for _, vpc := range page.Vpcs {
+			info.Vpcs = append(info.Vpcs, *vpc)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe vpcs: %w", err)
+	}
+
+	err = svc.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{}, func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+		for _, subnet := range page.Subnets {
+			info.Subnets = append(info.Subnets, *subnet)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe subnets: %w", err)
+	}
+
+	err = svc.DescribeRouteTablesPages(&ec2.DescribeRouteTablesInput{}, func(page *ec2.DescribeRouteTablesOutput, lastPage bool) bool {
+		for _, routeTable := range page.RouteTables {
+			info.RouteTables = append(info.RouteTables, *routeTable)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe route tables: %w", err)
+	}
+
+	err = svc.DescribeNetworkAclsPages(&ec2.DescribeNetworkAclsInput{}, func(page *ec2.DescribeNetworkAclsOutput, lastPage bool) bool {
+		for _, acl := range page.NetworkAcls {
+			info.NetworkAcls = append(info.NetworkAcls, *acl)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe network acls: %w", err)
+	}
+
+	err = svc.DescribeSecurityGroupsPages(&ec2.DescribeSecurityGroupsInput{}, func(page *ec2.DescribeSecurityGroupsOutput, lastPage bool) bool {
+		for _, group := range page.SecurityGroups {
+			info.SecurityGroups = append(info.SecurityGroups, *group)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe security groups: %w", err)
+	}
+
+	return info, nil
+}
+
+// GetAccountNetwork will take a profile and go through all regions to get the network resources in the account
+func GetAccountNetwork(account utils.AccountInfo) (AccountNetwork, error) {
+	profile := account.Profile
+	fmt.Println("Getting network info for profile:", profile)
+	networkChan := make(chan RegionNetwork)
+
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
+			sess, err := account.GetSession(region)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "GetSession", err)
+				return
+			}
+			info, err := GetRegionNetwork(sess)
+			if err != nil {
+				utils.RecordError(account, region, "ec2", "Describe", err)
+				return
+			}
+			info.AccountId = account.AccountId
+			info.Region = region
+			info.Profile = profile
+			networkChan <- info
+		})
+		close(networkChan)
+	}()
+
+	var accountNetwork AccountNetwork
+	for regionNetwork := range networkChan {
+		accountNetwork = append(accountNetwork, regionNetwork)
+	}
+
+	return accountNetwork, nil
+}
+
+// GetProfilesNetwork will return the network resources in all given accounts
+func GetProfilesNetwork(accounts []utils.AccountInfo) (ProfilesNetwork, error) {
+	profilesNetworkChan := make(chan AccountNetwork)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			accountNetwork, err := GetAccountNetwork(account)
+			if err != nil {
+				utils.RecordError(account, "", "ec2", "GetRegions", err)
+				return
+			}
+			profilesNetworkChan <- accountNetwork
+		})
+		close(profilesNetworkChan)
+	}()
+
+	var profilesNetwork ProfilesNetwork
+	for accountNetwork := range profilesNetworkChan {
+		profilesNetwork = append(profilesNetwork, accountNetwork)
+	}
+	return profilesNetwork, nil
+}
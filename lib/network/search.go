@@ -0,0 +1,207 @@
+package network
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+type (
+	SearchOptions struct {
+		// Matches limits the results to the given match types, all are included if empty
+		Matches []string
+	}
+
+	// SearchResult is a resource with a cidr that overlaps the cidr searched for
+	SearchResult struct {
+		Search       string
+		Profile      string
+		AccountId    string
+		Region       string
+		ResourceType string // vpc, subnet, route, networkacl, or securitygroup
+		ResourceId   string
+		Name         string
+		VpcId        string
+		Cidr         string
+		Match        string
+		Details      string
+	}
+)
+
+// Search will find every vpc cidr, subnet, route, network acl entry and security group rule that overlaps the cidr
+func Search(profilesNetwork ProfilesNetwork, cidr string, options SearchOptions) ([]SearchResult, error) {
+	search, err := utils.ParseCidr(cidr)
+	if err != nil {
+		return nil, err
+	}
+	wanted := func(match string) bool {
+		if match == "" {
+			return false
+		}
+		if len(options.Matches) == 0 {
+			return true
+		}
+		for _, m := range options.Matches {
+			if m == match {
+				return true
+			}
+		}
+		return false
+	}
+
+	var results []SearchResult
+	for _, accountNetwork := range profilesNetwork {
+		for _, regionNetwork := range accountNetwork {
+			add := func(resourceType string, resourceId string, name string, vpcId string, found string, details string) {
+				match := utils.CidrMatch(search, found)
+				if !wanted(match) {
+					return
+				}
+				results = append(results, SearchResult{
+					Search:       cidr,
+					Profile:      regionNetwork.Profile,
+					AccountId:    regionNetwork.AccountId,
+					Region:       regionNetwork.Region,
+					ResourceType: resourceType,
+					ResourceId:   resourceId,
+					Name:         name,
+					VpcId:        vpcId,
+					Cidr:         found,
+					Match:        match,
+					Details:      details,
+				})
+			}
+
+			for _, vpc := range regionNetwork.Vpcs {
+				for _, association := range vpc.CidrBlockAssociationSet {
+					add("vpc", *vpc.VpcId, utils.NameTag(vpc.Tags), *vpc.VpcId, aws.StringValue(association.CidrBlock), "")
+				}
+				for _, association := range vpc.Ipv6CidrBlockAssociationSet {
+					add("vpc", *vpc.VpcId, utils.NameTag(vpc.Tags), *vpc.VpcId, aws.StringValue(association.Ipv6CidrBlock), "")
+				}
+			}
+
+			for _, subnet := range regionNetwork.Subnets {
+				details := "az " + aws.StringValue(subnet.AvailabilityZone)
+				add("subnet", *subnet.SubnetId, utils.NameTag(subnet.Tags), aws.StringValue(subnet.VpcId), aws.StringValue(subnet.CidrBlock), details)
+				for _, association := range subnet.Ipv6CidrBlockAssociationSet {
+					add("subnet", *subnet.SubnetId, utils.NameTag(subnet.Tags), aws.StringValue(subnet.VpcId), aws.StringValue(association.Ipv6CidrBlock), details)
+				}
+			}
+
+			for _, routeTable := range regionNetwork.RouteTables {
+				for _, route := range routeTable.Routes {
+					details := "target " + utils.RouteTarget(route) + ", " + aws.StringValue(route.State)
+					add("route", *routeTable.RouteTableId, utils.NameTag(routeTable.Tags), aws.StringValue(routeTable.VpcId), aws.StringValue(route.DestinationCidrBlock), details)
+					add("route", *routeTable.RouteTableId, utils.NameTag(routeTable.Tags), aws.StringValue(routeTable.VpcId), aws.StringValue(route.DestinationIpv6CidrBlock), details)
+				}
+			}
+
+			for _, acl := range regionNetwork.NetworkAcls {
+				for _, entry := range acl.Entries {
+					direction := "ingress"
+					if aws.BoolValue(entry.Egress) {
+						direction = "egress"
+					}
+					details := fmt.Sprintf("rule %d %s %s %s", aws.Int64Value(entry.RuleNumber), direction, aws.StringValue(entry.RuleAction), aclProtocol(entry))
+					add("networkacl", *acl.NetworkAclId, utils.NameTag(acl.Tags), aws.StringValue(acl.VpcId), aws.StringValue(entry.CidrBlock), details)
+					add("networkacl", *acl.NetworkAclId, utils.NameTag(acl.Tags), aws.StringValue(acl.VpcId), aws.StringValue(entry.Ipv6CidrBlock), details)
+				}
+			}
+
+			for _, group := range regionNetwork.SecurityGroups {
+				name := aws.StringValue(group.GroupName)
+				directions := []string{"ingress", "egress"}
+				for i, rules := range [][]*ec2.IpPermission{group.IpPermissions, group.IpPermissionsEgress} {
+					for _, rule := range rules {
+						details := directions[i] + " " + ruleTraffic(rule)
+						for _, ip := range rule.IpRanges {
+							add("securitygroup", *group.GroupId, name, aws.StringValue(group.VpcId), aws.StringValue(ip.CidrIp), ruleDetails(details, ip.Description))
+						}
+						for _, ip := range rule.Ipv6Ranges {
+							add("securitygroup", *group.GroupId, name, aws.StringValue(group.VpcId), aws.StringValue(ip.CidrIpv6), ruleDetails(details, ip.Description))
+						}
+					}
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+// portRange will format the ports as from-to, or all if either is missing
+func portRange(from *int64, to *int64) string {
+	if from == nil || to == nil || (*from <= 0 && *to >= 65535) || *from == -1 {
+		return "all ports"
+	}
+	if *from == *to {
+		return strconv.FormatInt(*from, 10)
+	}
+	return strconv.FormatInt(*from, 10) + "-" + strconv.FormatInt(*to, 10)
+}
+
+// ruleTraffic will format the protocol and ports of a security group rule
+func ruleTraffic(rule *ec2.IpPermission) string {
+	protocol := utils.RuleProtocol(rule)
+	if protocol == "all" {
+		return "all traffic"
+	}
+	return protocol + " " + portRange(rule.FromPort, rule.ToPort)
+}
+
+// aclProtocol will format the protocol and ports of a network acl entry
+func aclProtocol(entry *ec2.NetworkAclEntry) string {
+	protocol := utils.ProtocolName(aws.StringValue(entry.Protocol))
+	if protocol == "all" {
+		return "all traffic"
+	}
+	if entry.PortRange == nil {
+		return protocol
+	}
+	return protocol + " " + portRange(entry.PortRange.From, entry.PortRange.To)
+}
+
+// ruleDetails will add the description of a rule to its details
+func ruleDetails(details string, description *string) string {
+	if aws.StringValue(description) == "" {
+		return details
+	}
+	return details + " (" + *description + ")"
+}
+
+// WriteSearchResults will write every resource found
+func WriteSearchResults(results []SearchResult) error {
+	var columnTitles = []string{"Search",
+		"Profile",
+		"Account ID",
+		"Region",
+		"Resource Type",
+		"Resource ID",
+		"Name",
+		"VPC ID",
+		"CIDR",
+		"Match",
+		"Details",
+	}
+
+	report := utils.NewReport("net", "search", columnTitles)
+	for _, result := range results {
+		var data = []string{result.Search,
+			result.Profile,
+			result.AccountId,
+			result.Region,
+			result.ResourceType,
+			result.ResourceId,
+			result.Name,
+			result.VpcId,
+			result.Cidr,
+			result.Match,
+			result.Details,
+		}
+		report.AddRow(data)
+	}
+	return report.Write()
+}
@@ -1,11 +1,8 @@
 package s3
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
 	"strings"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws"
@@ -49,24 +46,17 @@ func CheckPublicBucket(bucketName string, sess *session.Session) (bool, error) {
 
 func GetProfilesBuckets(accounts []utils.AccountInfo) (ProfilesBuckets, error) {
 	profilesBucketsChan := make(chan AccountBuckets)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			var err error
-			defer wg.Done()
 			accountBuckets, err := GetProfileBuckets(account, "")
 			if err != nil {
-				log.Println("Could not get buckets for", account.Profile, ":", err)
+				utils.RecordError(account, "", "s3", "ListBuckets", err)
 				return
 			}
 			profilesBucketsChan <- accountBuckets
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesBucketsChan)
 	}()
 
@@ -92,9 +82,9 @@ func GetProfileBuckets(account utils.AccountInfo, name string) ([]BucketInfo, er
 
 	var buckets []BucketInfo
 
-	accountId, err := utils.GetAccountId(sess)
+	accountId, err := account.CallerAccountId()
 	if err != nil {
-		utils.LogAll("could not get account ID for profile: ", profile)
+		utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
 	}
 	for _, bucketName := range bucketNames {
 		var bucket BucketInfo
@@ -103,19 +93,20 @@ func GetProfileBuckets(account utils.AccountInfo, name string) ([]BucketInfo, er
 		bucket.AccountId = accountId
 		bucket.Region, err = GetBucketRegion(sess, bucketName)
 		if err != nil {
-			utils.LogAll("could not get region for", bucketName, "in", profile, ":", err)
+			utils.RecordError(account, "", "s3", "GetBucketLocation "+bucketName, err)
 		}
 		encryptionSess := sess
 		if bucket.Region != "us-east-1" {
 			encryptionSess, err = account.GetSession(bucket.Region)
-			//TODO determine a good fail condition here
 			if err != nil {
-
+				utils.RecordError(account, bucket.Region, "s3", "GetSession", err)
+				buckets = append(buckets, bucket)
+				continue
 			}
 		}
 		bucket.Encryption, err = GetBucketEncryption(encryptionSess, bucketName)
 		if err != nil {
-			utils.LogAll("could not get encryption for", bucketName, "in", profile, ":", err)
+			utils.RecordError(account, bucket.Region, "s3", "GetBucketEncryption "+bucketName, err)
 		}
 
 		buckets = append(buckets, bucket)
@@ -186,17 +177,6 @@ func GetBucketRegion(sess *session.Session, bucketName string) (string, error) {
 }
 
 func WriteProfilesBuckets(profileBuckets ProfilesBuckets) error {
-	outputDir := "output/s3/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "buckets.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create buckets file: %v", err)
-	}
-
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing buckets to file:", outfile.Name())
 	var columnTitles = []string{"Profile",
 		"Account ID",
 		"Region",
@@ -211,10 +191,7 @@ func WriteProfilesBuckets(profileBuckets ProfilesBuckets) error {
 	//	}
 	//}
 
-	err = writer.Write(columnTitles)
-	if err != nil {
-		fmt.Println(err)
-	}
+	report := utils.NewReport("s3", "buckets", columnTitles)
 	//var pemKeys []string
 	//pemKeyFile, _ := utils.CreateFile("pemKeys.csv")
 	for _, accountBuckets := range profileBuckets {
@@ -241,11 +218,8 @@ func WriteProfilesBuckets(profileBuckets ProfilesBuckets) error {
 			//	}
 			//}
 
-			err = writer.Write(data)
-			if err != nil {
-				fmt.Println(err)
-			}
+			report.AddRow(data)
 		}
 	}
-	return nil
+	return report.Write()
 }
@@ -1,11 +1,8 @@
 package s3
 
 import (
-	"encoding/csv"
-	"fmt"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws"
@@ -101,32 +98,34 @@ func GetBucketFileSize(bucket BucketInfo, sess *session.Session) (*BucketSizeInf
 
 func GetProfileBucketsFileSize(buckets []BucketInfo, account utils.AccountInfo) ([]*BucketSizeInfo, error) {
 	getBucketsChan := make(chan *BucketSizeInfo)
-	var wg sync.WaitGroup
 	var err error
 
 	if err = account.SetAccountId(); err != nil {
-		utils.LogAll("could not get account id for", account.Profile, ":", err)
+		utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
 	}
-	for _, bucket := range buckets {
-		wg.Add(1)
-		go func(bucket BucketInfo) {
+	go func() {
+		utils.ForEach(len(buckets), utils.MaxRegions, func(i int) {
+			bucket := buckets[i]
 			var err error
-			defer wg.Done()
 			sess, err := account.GetSession("us-east-1")
 			if err != nil {
-				utils.LogAll("could not open session for", account.Profile, ":", err)
+				utils.RecordError(account, "", "s3", "GetSession", err)
 				return
 			}
 			//Need to get the bucket region in the session in order to get the contents
 			bucketRegion, err := GetBucketRegion(sess, bucket.Name)
 			if err != nil {
-				utils.LogAll("could not get the region for", bucket.Name, "in account", account.Profile, ":", err)
+				utils.RecordError(account, "", "s3", "GetBucketLocation "+bucket.Name, err)
 				return
 			}
 			sess, err = account.GetSession(bucketRegion)
+			if err != nil {
+				utils.RecordError(account, bucketRegion, "s3", "GetSession", err)
+				return
+			}
 			bucketSizeInfo, err := GetBucketFileSize(bucket, sess)
 			if err != nil {
-				utils.LogAll("could not get bucketinfo for", account.Profile, ":", err)
+				utils.RecordError(account, bucketRegion, "s3", "ListObjects "+bucket.Name, err)
 				return
 			}
 			bucketSizeInfo.BucketInfo.Region = bucketRegion
@@ -134,10 +133,7 @@ func GetProfileBucketsFileSize(buckets []BucketInfo, account utils.AccountInfo)
 			bucketSizeInfo.BucketInfo.AccountId = account.AccountId
 
 			getBucketsChan <- bucketSizeInfo
-		}(bucket)
-	}
-	go func() {
-		wg.Wait()
+		})
 		close(getBucketsChan)
 	}()
 
@@ -150,28 +146,25 @@ func GetProfileBucketsFileSize(buckets []BucketInfo, account utils.AccountInfo)
 
 func GetProfilesPublicBucketsFileSize(accounts []utils.AccountInfo, bucketOption string) ([]*BucketSizeInfo, error) {
 	getBucketsChan := make(chan *BucketSizeInfo)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 			buckets, err := GetProfileBuckets(account, "")
 			if err != nil {
-				utils.LogAll("could not get buckets for profile", account.Profile, ":", err)
+				utils.RecordError(account, "", "s3", "ListBuckets", err)
 				return
 			}
 			if bucketOption == "public-only" {
 				var publicBuckets []BucketInfo
-				if err != nil {
-					utils.LogAll("could not public bucket check session:", err)
-					return
-				}
 				for _, bucket := range buckets {
 					sess, err := account.GetSession(bucket.Region)
+					if err != nil {
+						utils.RecordError(account, bucket.Region, "s3", "GetSession", err)
+						continue
+					}
 					public, err := CheckPublicBucket(bucket.Name, sess)
 					if err != nil {
-						utils.LogAll("could not check public bucket", bucket.Name, ":", err)
+						utils.RecordError(account, bucket.Region, "s3", "GetBucketAcl "+bucket.Name, err)
 						continue
 					}
 					if public {
@@ -180,7 +173,7 @@ func GetProfilesPublicBucketsFileSize(accounts []utils.AccountInfo, bucketOption
 				}
 				bucketsSizeInfo, err := GetProfileBucketsFileSize(publicBuckets, account)
 				if err != nil {
-					utils.LogAll("could not get bucket info for profile", account.Profile, ":", err)
+					utils.RecordError(account, "", "s3", "GetBucketsFileSize", err)
 				}
 
 				for _, bucketSizeInfo := range bucketsSizeInfo {
@@ -189,17 +182,14 @@ func GetProfilesPublicBucketsFileSize(accounts []utils.AccountInfo, bucketOption
 			} else {
 				bucketsSizeInfo, err := GetProfileBucketsFileSize(buckets, account)
 				if err != nil {
-					utils.LogAll("could not get bucket info for profile", account.Profile, ":", err)
+					utils.RecordError(account, "", "s3", "GetBucketsFileSize", err)
 				}
 
 				for _, bucketSizeInfo := range bucketsSizeInfo {
 					getBucketsChan <- bucketSizeInfo
 				}
 			}
-		}(account)
-	}
-	go func() {
-		wg.Wait()
+		})
 		close(getBucketsChan)
 	}()
 
@@ -211,17 +201,6 @@ func GetProfilesPublicBucketsFileSize(accounts []utils.AccountInfo, bucketOption
 }
 
 func WriteProfilesBucketsFileSize(profilesBuckets []*BucketSizeInfo) error {
-	outputDir := "output/s3/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "bucketsSize.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create buckets file: %v", err)
-	}
-
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing buckets to file:", outfile.Name())
 	var columnTitles = []string{"Profile",
 		"Account ID",
 		"Region",
@@ -253,10 +232,7 @@ func WriteProfilesBucketsFileSize(profilesBuckets []*BucketSizeInfo) error {
 	//}
 
 	columnTitles = append(columnTitles, "Total Size")
-	err = writer.Write(columnTitles)
-	if err != nil {
-		fmt.Println(err)
-	}
+	report := utils.NewReport("s3", "bucketsSize", columnTitles)
 	for _, bucket := range profilesBuckets {
 		var data = []string{bucket.BucketInfo.Profile,
 			bucket.BucketInfo.AccountId,
@@ -298,10 +274,7 @@ func WriteProfilesBucketsFileSize(profilesBuckets []*BucketSizeInfo) error {
 		//	}
 		//}
 
-		err = writer.Write(data)
-		if err != nil {
-			fmt.Println(err)
-		}
+		report.AddRow(data)
 	}
-	return nil
+	return report.Write()
 }
@@ -24,10 +24,12 @@ func RemoveDocumentPermissionsFromAccounts(accounts []utils.AccountInfo, account
 	for _, account := range accounts {
 		sess, err := account.GetSession("us-east-1")
 		if err != nil {
-			utils.LogAll("could not get session for "+account.AccountId+":", err)
+			utils.RecordError(account, "us-east-1", "ssm", "GetSession", err)
 			continue
 		}
-		RemoveDocumentPermissions(sess, pointerAccountIds, documentName)
+		if err = RemoveDocumentPermissions(sess, pointerAccountIds, documentName); err != nil {
+			utils.RecordError(account, "us-east-1", "ssm", "ModifyDocumentPermission", err)
+		}
 	}
 
 	return nil
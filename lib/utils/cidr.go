@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Cidr match types, of a cidr found compared to the cidr searched for
+const (
+	MatchEqual     = "equal"     // the same range
+	MatchContains  = "contains"  // the cidr found contains the cidr searched for, such as 10.0.0.0/8 for 10.1.2.3
+	MatchContained = "contained" // the cidr found is inside the cidr searched for, such as 10.1.2.0/24 for 10.0.0.0/8
+)
+
+// ParseCidr will parse an ipv4 or ipv6 cidr, or a single ip as a /32 or /128
+func ParseCidr(cidr string) (netip.Prefix, error) {
+	cidr = strings.TrimSpace(cidr)
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%s is not an ip or cidr", cidr)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%s is not an ip or cidr", cidr)
+	}
+	return prefix.Masked(), nil
+}
+
+// CidrMatch will compare the cidr found to the cidr searched for, and return how they match, or an empty string if they do not overlap
+// Two cidrs can only overlap by one containing the other, so an overlap is always one of the match types
+func CidrMatch(search netip.Prefix, found string) string {
+	prefix, err := ParseCidr(found)
+	if err != nil || !search.IsValid() || prefix.Addr().Is4() != search.Addr().Is4() {
+		return ""
+	}
+	switch {
+	case prefix == search:
+		return MatchEqual
+	case prefix.Bits() < search.Bits() && prefix.Contains(search.Addr()):
+		return MatchContains
+	case search.Bits() < prefix.Bits() && search.Contains(prefix.Addr()):
+		return MatchContained
+	}
+	return ""
+}
+
+// CidrSize will return how many addresses are in an ipv4 cidr
+func CidrSize(prefix netip.Prefix) int64 {
+	return int64(1) << (32 - prefix.Bits())
+}
+
+// FreeCidrs will return up to count blocks of the given size inside the ipv4 supernet, that do not overlap any of the used cidrs
+// The blocks are returned lowest first
+func FreeCidrs(supernet netip.Prefix, used []netip.Prefix, bits int, count int) ([]netip.Prefix, error) {
+	if !supernet.Addr().Is4() {
+		return nil, fmt.Errorf("the supernet %s needs to be ipv4", supernet)
+	}
+	if bits < supernet.Bits() || bits > 32 {
+		return nil, fmt.Errorf("the size /%d does not fit in the supernet %s", bits, supernet)
+	}
+
+	type ipRange struct{ start, end uint64 }
+	toRange := func(prefix netip.Prefix) ipRange {
+		ip := prefix.Masked().Addr().As4()
+		start := uint64(ip[0])<<24 | uint64(ip[1])<<16 | uint64(ip[2])<<8 | uint64(ip[3])
+		return ipRange{start, start + uint64(CidrSize(prefix)) - 1}
+	}
+	var ranges []ipRange
+	for _, prefix := range used {
+		if prefix.Addr().Is4() {
+			ranges = append(ranges, toRange(prefix))
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+
+	super := toRange(supernet)
+	blockSize := uint64(1) << (32 - bits)
+	var free []netip.Prefix
+	next := 0
+	for start := super.start; start+blockSize-1 <= super.end && len(free) < count; {
+		end := start + blockSize - 1
+		// skip the used ranges that end before this block
+		for next < len(ranges) && ranges[next].end < start {
+			next++
+		}
+		overlap := false
+		for i := next; i < len(ranges) && ranges[i].start <= end; i++ {
+			if ranges[i].end >= start {
+				overlap = true
+				// move past the used range, to the next aligned block
+				start = (ranges[i].end/blockSize + 1) * blockSize
+				break
+			}
+		}
+		if overlap {
+			continue
+		}
+		ip := netip.AddrFrom4([4]byte{byte(start >> 24), byte(start >> 16), byte(start >> 8), byte(start)})
+		free = append(free, netip.PrefixFrom(ip, bits))
+		start += blockSize
+	}
+	return free, nil
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseCidr(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		want    string
+		wantErr bool
+	}{
+		{"10.1.2.3", "10.1.2.3/32", false},
+		{" 10.1.2.3/16 ", "10.1.0.0/16", false},
+		{"0.0.0.0/0", "0.0.0.0/0", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"2001:db8::/32", "2001:db8::/32", false},
+		{"10.0.0.0/33", "", true},
+		{"not an ip", "", true},
+	}
+	for _, test := range tests {
+		got, err := ParseCidr(test.cidr)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseCidr(%q) error = %v, want error %v", test.cidr, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && got.String() != test.want {
+			t.Errorf("ParseCidr(%q) = %s, want %s", test.cidr, got, test.want)
+		}
+	}
+}
+
+func TestCidrMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		search string
+		found  string
+		want   string
+	}{
+		{"same cidr", "10.0.0.0/16", "10.0.0.0/16", MatchEqual},
+		{"same host", "10.1.2.3", "10.1.2.3/32", MatchEqual},
+		{"found contains ip", "10.1.2.3", "10.0.0.0/8", MatchContains},
+		{"found inside search", "10.0.0.0/8", "10.1.2.0/24", MatchContained},
+		{"found host inside search", "10.0.0.0/8", "10.1.2.3/32", MatchContained},
+		{"disjoint", "10.0.0.0/16", "10.1.0.0/16", ""},
+		{"adjacent", "10.0.0.0/24", "10.0.1.0/24", ""},
+		{"search /0 contains everything", "0.0.0.0/0", "192.168.1.0/24", MatchContained},
+		{"found /0 contains everything", "192.168.1.1", "0.0.0.0/0", MatchContains},
+		{"both /0", "0.0.0.0/0", "0.0.0.0/0", MatchEqual},
+		{"unmasked found", "10.0.0.0/16", "10.0.5.7/16", MatchEqual},
+		{"ipv6 contains", "2001:db8:1::1", "2001:db8::/32", MatchContains},
+		{"ipv6 contained", "2001:db8::/32", "2001:db8:1::/48", MatchContained},
+		{"ipv6 /0", "::/0", "2001:db8::/32", MatchContained},
+		{"ipv6 disjoint", "2001:db8::/32", "2001:db9::/32", ""},
+		{"ipv4 search ipv6 found", "0.0.0.0/0", "::/0", ""},
+		{"ipv6 search ipv4 found", "::/0", "10.0.0.0/8", ""},
+		{"invalid found", "10.0.0.0/8", "", ""},
+	}
+	for _, test := range tests {
+		search, err := ParseCidr(test.search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := CidrMatch(search, test.found); got != test.want {
+			t.Errorf("%s: CidrMatch(%s, %q) = %q, want %q", test.name, test.search, test.found, got, test.want)
+		}
+	}
+}
+
+func TestFreeCidrs(t *testing.T) {
+	prefixes := func(cidrs ...string) []netip.Prefix {
+		var list []netip.Prefix
+		for _, cidr := range cidrs {
+			list = append(list, netip.MustParsePrefix(cidr))
+		}
+		return list
+	}
+	tests := []struct {
+		name     string
+		supernet string
+		used     []netip.Prefix
+		bits     int
+		count    int
+		want     []netip.Prefix
+		wantErr  bool
+	}{
+		{
+			name:     "empty supernet",
+			supernet: "10.0.0.0/16",
+			bits:     18,
+			count:    10,
+			want:     prefixes("10.0.0.0/18", "10.0.64.0/18", "10.0.128.0/18", "10.0.192.0/18"),
+		},
+		{
+			name:     "count limits the blocks",
+			supernet: "10.0.0.0/16",
+			bits:     24,
+			count:    2,
+			want:     prefixes("10.0.0.0/24", "10.0.1.0/24"),
+		},
+		{
+			name:     "skips used blocks",
+			supernet: "10.0.0.0/16",
+			used:     prefixes("10.0.0.0/24", "10.0.2.0/23"),
+			bits:     24,
+			count:    3,
+			want:     prefixes("10.0.1.0/24", "10.0.4.0/24", "10.0.5.0/24"),
+		},
+		{
+			name:     "a small used cidr takes the whole block",
+			supernet: "10.0.0.0/22",
+			used:     prefixes("10.0.1.17/32"),
+			bits:     23,
+			count:    5,
+			want:     prefixes("10.0.2.0/23"),
+		},
+		{
+			name:     "overlapping used cidrs",
+			supernet: "10.0.0.0/16",
+			used:     prefixes("10.0.0.0/20", "10.0.4.0/24", "10.0.0.0/16"),
+			bits:     24,
+			count:    1,
+			want:     nil,
+		},
+		{
+			name:     "used cidr containing a later one",
+			supernet: "10.0.0.0/24",
+			used:     prefixes("10.0.0.0/25", "10.0.0.16/28"),
+			bits:     26,
+			count:    5,
+			want:     prefixes("10.0.0.128/26", "10.0.0.192/26"),
+		},
+		{
+			name:     "used cidrs outside the supernet and ipv6 are ignored",
+			supernet: "10.0.0.0/30",
+			used:     prefixes("192.168.0.0/16", "2001:db8::/32"),
+			bits:     31,
+			count:    5,
+			want:     prefixes("10.0.0.0/31", "10.0.0.2/31"),
+		},
+		{
+			name:     "/32 blocks",
+			supernet: "10.0.0.0/30",
+			used:     prefixes("10.0.0.1/32"),
+			bits:     32,
+			count:    5,
+			want:     prefixes("10.0.0.0/32", "10.0.0.2/32", "10.0.0.3/32"),
+		},
+		{
+			name:     "/0 supernet",
+			supernet: "0.0.0.0/0",
+			used:     prefixes("0.0.0.0/1"),
+			bits:     1,
+			count:    5,
+			want:     prefixes("128.0.0.0/1"),
+		},
+		{
+			name:     "/0 block",
+			supernet: "0.0.0.0/0",
+			bits:     0,
+			count:    5,
+			want:     prefixes("0.0.0.0/0"),
+		},
+		{
+			name:     "used up to the last address",
+			supernet: "255.255.255.0/24",
+			used:     prefixes("255.255.255.128/25"),
+			bits:     25,
+			count:    5,
+			want:     prefixes("255.255.255.0/25"),
+		},
+		{
+			name:     "block larger than the supernet",
+			supernet: "10.0.0.0/24",
+			bits:     16,
+			count:    1,
+			wantErr:  true,
+		},
+		{
+			name:     "block smaller than /32",
+			supernet: "10.0.0.0/24",
+			bits:     33,
+			count:    1,
+			wantErr:  true,
+		},
+		{
+			name:     "ipv6 supernet",
+			supernet: "2001:db8::/32",
+			bits:     48,
+			count:    1,
+			wantErr:  true,
+		},
+	}
+	for _, test := range tests {
+		got, err := FreeCidrs(netip.MustParsePrefix(test.supernet), test.used, test.bits, test.count)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: FreeCidrs = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
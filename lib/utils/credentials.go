@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Credential source types
+const (
+	SourceTypeProfile     = "profile"
+	SourceTypeSso         = "sso"
+	SourceTypeWebIdentity = "webidentity"
+	SourceTypeInstance    = "instance"
+)
+
+// CredentialSource is a named set of base credentials, configured under credentialSources in .aws-go-tool.yaml
+// Accounts with the source access type use it by name, and assume their roleArn with it if they have one
+/*
+credentialSources:
+  corp:
+    type: profile
+    profile: saml
+    mfaSerial: arn:aws:iam::123456789012:mfa/me
+  identitycenter:
+    type: sso
+    profile: my-sso-profile
+  ci:
+    type: webidentity
+    roleArn: arn:aws:iam::123456789012:role/ci
+    tokenFile: /var/run/secrets/token
+*/
+type CredentialSource struct {
+	Type string `mapstructure:"type"`
+	// Profile is the profile in the shared config or credentials file for the profile and sso types
+	Profile string `mapstructure:"profile"`
+	// The sso fields are used for the sso type when there is no profile, after logging in with aws sso login
+	SsoStartUrl  string `mapstructure:"ssoStartUrl"`
+	SsoRegion    string `mapstructure:"ssoRegion"`
+	SsoAccountId string `mapstructure:"ssoAccountId"`
+	SsoRoleName  string `mapstructure:"ssoRoleName"`
+	// RoleArn and TokenFile are used for the webidentity type
+	RoleArn   string `mapstructure:"roleArn"`
+	TokenFile string `mapstructure:"tokenFile"`
+	// MfaSerial turns the source credentials into an mfa session, prompting for the token code once per run
+	// This needs iam user credentials, as GetSessionToken can not be called with role credentials
+	MfaSerial   string        `mapstructure:"mfaSerial"`
+	MfaDuration time.Duration `mapstructure:"mfaDuration"` // defaults to DefaultMfaDuration
+}
+
+// DefaultMfaDuration is how long the mfa session of a credential source is valid for, before prompting again
+const DefaultMfaDuration = 12 * time.Hour
+
+var (
+	// CredentialSources are the sources loaded from the config file, by name
+	CredentialSources = make(map[string]CredentialSource)
+
+	sourceSessions   = make(map[string]*sourceSession)
+	sourceSessionsMu sync.Mutex
+	promptMu         sync.Mutex
+)
+
+// sourceSession holds the session of a credential source, so every account using it shares the same credentials
+type sourceSession struct {
+	once sync.Once
+	sess *session.Session
+	err  error
+}
+
+// Validate will check that the source has the settings its type needs
+func (source CredentialSource) Validate() error {
+	switch source.Type {
+	case SourceTypeProfile:
+		if source.Profile == "" {
+			return fmt.Errorf("a profile is required")
+		}
+	case SourceTypeSso:
+		if source.Profile == "" && (source.SsoStartUrl == "" || source.SsoRegion == "" || source.SsoAccountId == "" || source.SsoRoleName == "") {
+			return fmt.Errorf("either a profile, or the ssoStartUrl, ssoRegion, ssoAccountId and ssoRoleName are required")
+		}
+	case SourceTypeWebIdentity:
+		if source.RoleArn == "" || source.TokenFile == "" {
+			return fmt.Errorf("a roleArn and tokenFile are required")
+		}
+	case SourceTypeInstance:
+	default:
+		return fmt.Errorf("invalid type %s.  Needs 'profile', 'sso', 'webidentity', or 'instance'", source.Type)
+	}
+	return nil
+}
+
+// SetCredentialSources will validate the sources and make them available to the accounts
+func SetCredentialSources(sources map[string]CredentialSource) error {
+	var names []string
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := sources[name].Validate(); err != nil {
+			return fmt.Errorf("invalid credential source %s: %v", name, err)
+		}
+	}
+	CredentialSources = sources
+	return nil
+}
+
+// GetSourceSession will return the session of the named credential source, opening it the first time it is used
+func GetSourceSession(name string) (*session.Session, error) {
+	source, ok := CredentialSources[name]
+	if !ok {
+		return nil, fmt.Errorf("credential source %s is not configured in the config file", name)
+	}
+
+	sourceSessionsMu.Lock()
+	entry, ok := sourceSessions[name]
+	if !ok {
+		entry = &sourceSession{}
+		sourceSessions[name] = entry
+	}
+	sourceSessionsMu.Unlock()
+
+	entry.once.Do(func() {
+		entry.sess, entry.err = source.NewSession()
+		if entry.err == nil && source.MfaSerial != "" {
+			entry.sess, entry.err = source.mfaSession(entry.sess)
+		}
+	})
+	return entry.sess, entry.err
+}
+
+// NewSession will open a session with the base credentials of the source
+func (source CredentialSource) NewSession() (*session.Session, error) {
+	switch source.Type {
+	case SourceTypeProfile:
+		return openProfileSession(source.Profile, "us-east-1")
+	case SourceTypeSso:
+		if source.Profile != "" {
+			return openProfileSession(source.Profile, "us-east-1")
+		}
+		ssoSess, err := session.NewSession(&aws.Config{Region: aws.String(source.SsoRegion)})
+		if err != nil {
+			return nil, err
+		}
+		creds := ssocreds.NewCredentials(ssoSess, source.SsoAccountId, source.SsoRoleName, source.SsoStartUrl)
+		return session.NewSession(&aws.Config{Region: aws.String("us-east-1"), Credentials: creds})
+	case SourceTypeWebIdentity:
+		stsSess, err := session.NewSession(&aws.Config{Region: aws.String("us-east-1")})
+		if err != nil {
+			return nil, err
+		}
+		creds := stscreds.NewWebIdentityCredentials(stsSess, source.RoleArn, DefaultSessionName, source.TokenFile)
+		return session.NewSession(&aws.Config{Region: aws.String("us-east-1"), Credentials: creds})
+	case SourceTypeInstance:
+		return session.NewSession(&aws.Config{Region: aws.String("us-east-1")})
+	}
+	return nil, source.Validate()
+}
+
+// mfaSession will return a session using mfa session credentials from GetSessionToken with the source credentials
+// Roles that need mfa can then be assumed with it, without prompting for every account
+func (source CredentialSource) mfaSession(sess *session.Session) (*session.Session, error) {
+	duration := source.MfaDuration
+	if duration == 0 {
+		duration = DefaultMfaDuration
+	}
+	creds := credentials.NewCredentials(&mfaSessionProvider{
+		client:   sts.New(sess),
+		serial:   source.MfaSerial,
+		duration: duration,
+	})
+	return session.NewSession(&aws.Config{Region: aws.String("us-east-1"), Credentials: creds})
+}
+
+// mfaSessionProvider gets session credentials with an mfa token code from the user, and prompts again once they expire
+type mfaSessionProvider struct {
+	credentials.Expiry
+	client   *sts.STS
+	serial   string
+	duration time.Duration
+}
+
+// Retrieve will prompt for the token code and get new session credentials
+func (p *mfaSessionProvider) Retrieve() (credentials.Value, error) {
+	code, err := PromptMfaToken(p.serial)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	params := &sts.GetSessionTokenInput{
+		DurationSeconds: aws.Int64(int64(p.duration / time.Second)),
+		SerialNumber:    aws.String(p.serial),
+		TokenCode:       aws.String(code),
+	}
+	resp, err := p.client.GetSessionToken(params)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("could not get mfa session for %s: %w", p.serial, err)
+	}
+
+	p.SetExpiration(*resp.Credentials.Expiration, AssumeRoleExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     *resp.Credentials.AccessKeyId,
+		SecretAccessKey: *resp.Credentials.SecretAccessKey,
+		SessionToken:    *resp.Credentials.SessionToken,
+		ProviderName:    "MfaSessionProvider",
+	}, nil
+}
+
+// PromptMfaToken will ask for the mfa token code on the terminal, falling back to stdin if there is no terminal
+// Only one prompt is shown at a time, even when many accounts need a code at once
+func PromptMfaToken(serial string) (string, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	in, out := os.Stdin, os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		in, out = tty, tty
+	}
+	fmt.Fprintf(out, "Enter MFA code for %s: ", serial)
+	code, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && code == "" {
+		return "", fmt.Errorf("could not read mfa code: %v", err)
+	}
+	return strings.TrimSpace(code), nil
+}
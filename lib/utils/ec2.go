@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TagValue will return the value of the tag with the key, or an empty string
+func TagValue(tags []*ec2.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+// NameTag will return the value of the Name tag, or an empty string
+func NameTag(tags []*ec2.Tag) string {
+	return TagValue(tags, "Name")
+}
+
+// RouteTarget will return where the route sends traffic to
+func RouteTarget(route *ec2.Route) string {
+	for _, target := range []*string{route.GatewayId, route.NatGatewayId, route.TransitGatewayId, route.VpcPeeringConnectionId,
+		route.NetworkInterfaceId, route.InstanceId, route.EgressOnlyInternetGatewayId,
+		route.LocalGatewayId, route.CarrierGatewayId, route.CoreNetworkArn} {
+		if target != nil {
+			return *target
+		}
+	}
+	return ""
+}
+
+// ProtocolName will return the name of a protocol that is either a name or a number, -1 is all
+func ProtocolName(protocol string) string {
+	switch protocol {
+	case "-1":
+		return "all"
+	case "6":
+		return "tcp"
+	case "17":
+		return "udp"
+	case "1":
+		return "icmp"
+	case "58":
+		return "icmpv6"
+	default:
+		return protocol
+	}
+}
+
+// RuleProtocol will return the name of the protocol of a security group rule, as rules can use either the name or number
+func RuleProtocol(rule *ec2.IpPermission) string {
+	return ProtocolName(aws.StringValue(rule.IpProtocol))
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// CollectionError is a single failure while collecting from an account, region and service
+type CollectionError struct {
+	Profile   string
+	AccountId string
+	Region    string
+	Service   string
+	Operation string
+	Code      string // the aws error code, if the failure came from an api call
+	Message   string
+}
+
+// ErrorCollector keeps every CollectionError of the run, and is safe to use from many goroutines
+type ErrorCollector struct {
+	mu     sync.Mutex
+	errors []CollectionError
+}
+
+// Errors is the collector every Get* function records its failures in
+var Errors = &ErrorCollector{}
+
+// OperationError is a failure of a step inside of a larger one, such as the DescribeRegions call of GetRegions
+// RecordError uses its service and operation instead of the ones it is given, so the real failed call is recorded
+type OperationError struct {
+	Service   string
+	Operation string
+	Err       error
+}
+
+func (e *OperationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// RecordError will log the failure and add it to the Errors collector
+// region should be empty for global services and account level failures
+// If the error wraps an OperationError, its service and operation are recorded instead
+func RecordError(account AccountInfo, region string, service string, operation string, err error) {
+	var operationErr *OperationError
+	if errors.As(err, &operationErr) {
+		service = operationErr.Service
+		operation = operationErr.Operation
+	}
+	location := account.Profile
+	if region != "" {
+		location = region + " in " + account.Profile
+	}
+	log.Println("could not", operation, "("+service+") for", location, ":", err)
+
+	Errors.Add(CollectionError{
+		Profile:   account.Profile,
+		AccountId: account.AccountId,
+		Region:    region,
+		Service:   service,
+		Operation: operation,
+		Code:      ErrorCode(err),
+		Message:   err.Error(),
+	})
+}
+
+// ErrorCode will return the aws error code of the error, or an empty string if it did not come from aws
+func ErrorCode(err error) string {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return awsErr.Code()
+	}
+	return ""
+}
+
+// Add will add a failure to the collector
+func (c *ErrorCollector) Add(collectionError CollectionError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors = append(c.errors, collectionError)
+}
+
+// Count will return how many failures have been collected
+func (c *ErrorCollector) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.errors)
+}
+
+// List will return a copy of the collected failures, sorted by profile, region, service and operation
+func (c *ErrorCollector) List() []CollectionError {
+	c.mu.Lock()
+	list := make([]CollectionError, len(c.errors))
+	copy(list, c.errors)
+	c.mu.Unlock()
+
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Operation < b.Operation
+	})
+	return list
+}
+
+// PrintSummary will print a table of the failures to stdout, grouped by account, service, operation and error code
+func (c *ErrorCollector) PrintSummary() {
+	list := c.List()
+	if len(list) == 0 {
+		return
+	}
+
+	type group struct {
+		key     []string
+		regions []string
+		count   int
+	}
+	var groups []*group
+	index := make(map[string]*group)
+	for _, collectionError := range list {
+		key := []string{collectionError.Profile, collectionError.Service, collectionError.Operation, collectionError.Code}
+		joined := strings.Join(key, "|")
+		g, ok := index[joined]
+		if !ok {
+			g = &group{key: key}
+			index[joined] = g
+			groups = append(groups, g)
+		}
+		g.count++
+		if collectionError.Region != "" && !containsString(g.regions, collectionError.Region) {
+			g.regions = append(g.regions, collectionError.Region)
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Run finished with", len(list), "failures, the report may be missing data:")
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ACCOUNT\tSERVICE\tOPERATION\tCODE\tCOUNT\tREGIONS")
+	for _, g := range groups {
+		fmt.Fprintln(writer, strings.Join(g.key, "\t")+"\t"+strconv.Itoa(g.count)+"\t"+strings.Join(g.regions, ","))
+	}
+	writer.Flush()
+}
+
+// WriteReport will write every failure to errors.csv through the Output sink
+func (c *ErrorCollector) WriteReport() error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Region",
+		"Service",
+		"Operation",
+		"Error Code",
+		"Error Message",
+	}
+
+	report := NewReport("", "errors", columnTitles)
+	for _, collectionError := range c.List() {
+		var data = []string{collectionError.Profile,
+			collectionError.AccountId,
+			collectionError.Region,
+			collectionError.Service,
+			collectionError.Operation,
+			collectionError.Code,
+			collectionError.Message,
+		}
+		report.AddRow(data)
+	}
+	return report.WriteFormat(FormatCsv)
+}
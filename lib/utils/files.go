@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -21,16 +22,10 @@ type ELBLogInfo []ELBIPInfo
 //CreateFile will create a file with a given name
 //It will check to ensure that it does not overwrite a file with the same name
 func CreateFile(name string) (file *os.File, err error) {
-	splitName := strings.Split(name, ".")
-	lenSplit := len(splitName)
-	//prefix is everything leading up to the last period in the name
-	//suffix is everything after the last period in the name
-	var prefix, suffix string
-	if lenSplit == 1 {
-		prefix = splitName[0]
-	} else if lenSplit >= 2 {
-		prefix, suffix = splitName[lenSplit-2], splitName[lenSplit-1]
-	}
+	//prefix is everything leading up to the extension of the file
+	//suffix is the extension, without the period
+	suffix := strings.TrimPrefix(filepath.Ext(name), ".")
+	prefix := strings.TrimSuffix(name, filepath.Ext(name))
 
 	x := true
 	//Will loop through name appending to ensure that existing files with the same name are not overwritten
@@ -38,19 +33,19 @@ func CreateFile(name string) (file *os.File, err error) {
 		if i == 0 {
 			//this if will check to see if the file exists
 			//if it does, continue the loop, otherwise create the file
-			if _, err := os.Stat(name); err == nil {
+			if _, statErr := os.Stat(name); statErr == nil {
 				continue
 			} else {
 				file, err = os.Create(name)
 				x = false
 			}
 		} else {
-			if lenSplit == 1 {
+			if suffix == "" {
 				name = prefix + strconv.Itoa(i)
 			} else {
 				name = prefix + strconv.Itoa(i) + "." + suffix
 			}
-			if _, err := os.Stat(name); err == nil {
+			if _, statErr := os.Stat(name); statErr == nil {
 				continue
 			} else {
 				file, err = os.Create(name)
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AccountsInventory is the layout of the accounts inventory file, in either yaml or json
+// Anything set in Defaults is used for every account that does not set it
+// Example:
+/*
+defaults:
+  accessType: instanceassume
+  sessionName: aws-go-tool
+accounts:
+  - name: prod
+    roleArn: arn:aws:iam::123456789012:role/audit
+    externalId: abc123
+    regions: [us-east-1, us-west-2]
+    labels:
+      env: prod
+  - profile: dev
+    accessType: profile
+*/
+type AccountsInventory struct {
+	Defaults AccountInfo        `yaml:"defaults" json:"defaults"`
+	Accounts []InventoryAccount `yaml:"accounts" json:"accounts"`
+}
+
+// InventoryAccount is a single account in the inventory
+// Name is used in the reports when the account does not have a profile, such as the instanceassume access type
+type InventoryAccount struct {
+	Name        string `yaml:"name" json:"name"`
+	AccountInfo `yaml:",inline"`
+}
+
+// LoadAccountsInventory will read the accounts inventory file and return the accounts in it
+// The file is read as json if it has a .json extension, otherwise as yaml
+// accessType and credentialSource are used for any account that does not set them, in the account or in the defaults
+func LoadAccountsInventory(path string, accessType string, credentialSource string) ([]AccountInfo, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open accounts inventory: %v", err)
+	}
+
+	var inventory AccountsInventory
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(contents, &inventory)
+	} else {
+		err = yaml.Unmarshal(contents, &inventory)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse accounts inventory %s: %v", path, err)
+	}
+
+	var accounts []AccountInfo
+	for i, entry := range inventory.Accounts {
+		account := entry.AccountInfo.withDefaults(inventory.Defaults)
+		if account.AccessType == "" {
+			account.AccessType = accessType
+		}
+		if account.AccessType == "source" && account.CredentialSource == "" {
+			account.CredentialSource = credentialSource
+		}
+		if account.Profile == "" {
+			account.Profile = entry.Name
+		}
+		if account.AccountId == "" && account.Arn != "" {
+			account.AccountId = AccountIdFromArn(account.Arn)
+		}
+		if account.Profile == "" {
+			account.Profile = account.AccountId
+		}
+
+		if err = account.Validate(); err != nil {
+			return nil, fmt.Errorf("account %d (%s) in accounts inventory: %v", i+1, account.Profile, err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// withDefaults will fill in any empty fields of the account from defaults
+func (account AccountInfo) withDefaults(defaults AccountInfo) AccountInfo {
+	if account.AccessType == "" {
+		account.AccessType = defaults.AccessType
+	}
+	if account.Profile == "" {
+		account.Profile = defaults.Profile
+	}
+	if account.SourceProfile == "" {
+		account.SourceProfile = defaults.SourceProfile
+	}
+	if account.CredentialSource == "" {
+		account.CredentialSource = defaults.CredentialSource
+	}
+	if account.ExternalId == "" {
+		account.ExternalId = defaults.ExternalId
+	}
+	if account.SessionName == "" {
+		account.SessionName = defaults.SessionName
+	}
+	if len(account.Regions) == 0 {
+		account.Regions = defaults.Regions
+	}
+	if len(defaults.Labels) > 0 {
+		labels := make(map[string]string)
+		for key, value := range defaults.Labels {
+			labels[key] = value
+		}
+		for key, value := range account.Labels {
+			labels[key] = value
+		}
+		account.Labels = labels
+	}
+	return account
+}
+
+// Validate will check the account has everything needed for its access type
+func (account AccountInfo) Validate() error {
+	switch account.AccessType {
+	case "assume", "profile":
+		if account.Profile == "" {
+			return fmt.Errorf("access type %s needs a profile", account.AccessType)
+		}
+	case "instance":
+	case "instanceassume":
+		if account.Arn == "" {
+			return fmt.Errorf("access type instanceassume needs a roleArn")
+		}
+	case "profileassume":
+		if account.Arn == "" || account.SourceProfile == "" {
+			return fmt.Errorf("access type profileassume needs a roleArn and a sourceProfile")
+		}
+	case "source":
+		if _, ok := CredentialSources[account.CredentialSource]; !ok {
+			return fmt.Errorf("access type source needs a credentialSource configured in the config file, %s is not", account.CredentialSource)
+		}
+	case "":
+		return fmt.Errorf("no access type set, set it for the account, in the defaults, or with the -a flag")
+	default:
+		return fmt.Errorf("invalid access type %s.  Needs 'assume', 'profile', 'instance', 'instanceassume', 'profileassume' or 'source'", account.AccessType)
+	}
+	return nil
+}
+
+// AccountIdFromArn will return the account id section of an arn, or an empty string if it is not a valid arn
+// arn:aws:iam::123456789012:role/name
+func AccountIdFromArn(arn string) string {
+	splitArn := strings.Split(arn, ":")
+	if len(splitArn) < 6 || splitArn[0] != "arn" {
+		return ""
+	}
+	return splitArn[4]
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAccountsInventoryCredentialSource(t *testing.T) {
+	sources := CredentialSources
+	CredentialSources = map[string]CredentialSource{"central": {Type: "profile", Profile: "central"}, "other": {Type: "profile", Profile: "other"}}
+	defer func() { CredentialSources = sources }()
+
+	path := filepath.Join(t.TempDir(), "accounts.yaml")
+	inventory := `accounts:
+  - name: prod
+    accessType: source
+    roleArn: arn:aws:iam::111111111111:role/audit
+  - name: dev
+    accessType: source
+    credentialSource: other
+    roleArn: arn:aws:iam::222222222222:role/audit
+  - profile: test
+    accessType: profile
+`
+	if err := os.WriteFile(path, []byte(inventory), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := LoadAccountsInventory(path, "", "central")
+	if err != nil {
+		t.Fatalf("LoadAccountsInventory returned an error: %v", err)
+	}
+	want := []string{"central", "other", ""}
+	for i, account := range accounts {
+		if account.CredentialSource != want[i] {
+			t.Errorf("%s: credential source = %q, want %q", account.Profile, account.CredentialSource, want[i])
+		}
+	}
+
+	if _, err = LoadAccountsInventory(path, "", ""); err == nil {
+		t.Error("LoadAccountsInventory without a credential source did not return an error")
+	}
+}
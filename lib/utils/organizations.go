@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+// DefaultOrgRoleName is the role created in every account made by aws organizations
+const DefaultOrgRoleName = "OrganizationAccountAccessRole"
+
+// OrgOptions controls which accounts of an organization are discovered, and how they are accessed
+type OrgOptions struct {
+	// Management is the management or delegated admin account used to list the accounts
+	Management AccountInfo
+	// RoleName is assumed into every member account, defaults to DefaultOrgRoleName
+	RoleName   string
+	ExternalId string
+	// OuIds limits the accounts to those in these organizational units, or any ou below them
+	OuIds []string
+	// Tags limits the accounts to those with every one of these tags
+	Tags map[string]string
+}
+
+// DiscoverOrgAccounts will list the active accounts of the organization and build the AccountInfo to assume into each one
+// Suspended accounts, and accounts that are being closed, are skipped
+// The management account is used as is, instead of assuming the role into itself
+func DiscoverOrgAccounts(options OrgOptions) ([]AccountInfo, error) {
+	if options.RoleName == "" {
+		options.RoleName = DefaultOrgRoleName
+	}
+	switch options.Management.AccessType {
+	case "instance":
+	case "source":
+		if options.Management.CredentialSource == "" {
+			return nil, fmt.Errorf("a credential source is required to discover the organization accounts with access type source")
+		}
+	case "assume", "profile":
+		if options.Management.Profile == "" {
+			return nil, fmt.Errorf("a management profile is required to discover the organization accounts with access type %s", options.Management.AccessType)
+		}
+	default:
+		return nil, fmt.Errorf("access type %s can not be used to discover the organization accounts, use 'assume', 'profile', 'instance' or 'source'", options.Management.AccessType)
+	}
+	sess, err := options.Management.GetSession("us-east-1")
+	if err != nil {
+		return nil, err
+	}
+	svc := organizations.New(sess)
+
+	var orgAccounts []*organizations.Account
+	if len(options.OuIds) == 0 {
+		orgAccounts, err = listOrgAccounts(svc)
+	} else {
+		orgAccounts, err = listOuAccounts(svc, options.OuIds)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// sorted by id, so the accounts and every report are in the same order however they were listed
+	sort.Slice(orgAccounts, func(i, j int) bool {
+		return aws.StringValue(orgAccounts[i].Id) < aws.StringValue(orgAccounts[j].Id)
+	})
+
+	managementId, err := options.Management.CallerAccountId()
+	if err != nil {
+		return nil, fmt.Errorf("could not get the management account id: %w", err)
+	}
+
+	var accounts []AccountInfo
+	for _, orgAccount := range orgAccounts {
+		accountId := aws.StringValue(orgAccount.Id)
+		if aws.StringValue(orgAccount.Status) != organizations.AccountStatusActive {
+			LogAll("skipping account", accountId, "with status", aws.StringValue(orgAccount.Status))
+			continue
+		}
+
+		var tags map[string]string
+		if len(options.Tags) > 0 {
+			tags, err = listOrgAccountTags(svc, accountId)
+			if err != nil {
+				return nil, fmt.Errorf("could not get tags for account %s: %w", accountId, err)
+			}
+			if !matchesTags(tags, options.Tags) {
+				continue
+			}
+		}
+
+		account := options.Management
+		if accountId != managementId {
+			account = orgMemberAccount(options, orgAccount)
+		} else if account.Profile == "" {
+			account.Profile = aws.StringValue(orgAccount.Name)
+		}
+		account.AccountId = accountId
+		account.Labels = tags
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// orgMemberAccount will build the AccountInfo to assume the org role into the member account
+// The credentials of the management account are used to assume it
+func orgMemberAccount(options OrgOptions, orgAccount *organizations.Account) AccountInfo {
+	management := options.Management
+	account := AccountInfo{
+		Arn:         "arn:aws:iam::" + aws.StringValue(orgAccount.Id) + ":role/" + options.RoleName,
+		ExternalId:  options.ExternalId,
+		Profile:     aws.StringValue(orgAccount.Name),
+		SessionName: management.SessionName,
+	}
+	if account.Profile == "" {
+		account.Profile = aws.StringValue(orgAccount.Id)
+	}
+	switch management.AccessType {
+	case "instance":
+		account.AccessType = "instanceassume"
+	case "source":
+		account.AccessType = "source"
+		account.CredentialSource = management.CredentialSource
+	default:
+		account.AccessType = "profileassume"
+		account.SourceProfile = management.Profile
+	}
+	return account
+}
+
+// listOrgAccounts will list every account in the organization
+func listOrgAccounts(svc *organizations.Organizations) ([]*organizations.Account, error) {
+	var accounts []*organizations.Account
+	err := svc.ListAccountsPages(&organizations.ListAccountsInput{}, func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+		accounts = append(accounts, page.Accounts...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+// listOuAccounts will list every account in the ous, and in any ou below them
+func listOuAccounts(svc *organizations.Organizations, ouIds []string) ([]*organizations.Account, error) {
+	var accounts []*organizations.Account
+	seen := make(map[string]bool)
+	queue := append([]string{}, ouIds...)
+	for len(queue) > 0 {
+		parentId := queue[0]
+		queue = queue[1:]
+		if seen[parentId] {
+			continue
+		}
+		seen[parentId] = true
+
+		params := &organizations.ListAccountsForParentInput{ParentId: aws.String(parentId)}
+		err := svc.ListAccountsForParentPages(params, func(page *organizations.ListAccountsForParentOutput, lastPage bool) bool {
+			accounts = append(accounts, page.Accounts...)
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list accounts for %s: %w", parentId, err)
+		}
+
+		ouParams := &organizations.ListOrganizationalUnitsForParentInput{ParentId: aws.String(parentId)}
+		err = svc.ListOrganizationalUnitsForParentPages(ouParams, func(page *organizations.ListOrganizationalUnitsForParentOutput, lastPage bool) bool {
+			for _, ou := range page.OrganizationalUnits {
+				queue = append(queue, aws.StringValue(ou.Id))
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list ous for %s: %w", parentId, err)
+		}
+	}
+	return accounts, nil
+}
+
+// listOrgAccountTags will return the tags set on the account in the organization
+func listOrgAccountTags(svc *organizations.Organizations, accountId string) (map[string]string, error) {
+	tags := make(map[string]string)
+	params := &organizations.ListTagsForResourceInput{ResourceId: aws.String(accountId)}
+	err := svc.ListTagsForResourcePages(params, func(page *organizations.ListTagsForResourceOutput, lastPage bool) bool {
+		for _, tag := range page.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		return true
+	})
+	return tags, err
+}
+
+// matchesTags will return true if tags has every key and value in filter
+func matchesTags(tags map[string]string, filter map[string]string) bool {
+	for key, value := range filter {
+		if tagValue, ok := tags[key]; !ok || tagValue != value {
+			return false
+		}
+	}
+	return true
+}
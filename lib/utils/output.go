@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultNameTemplate keeps the original output/<service>/<report>.<ext> layout
+const DefaultNameTemplate = "{{.Service}}/{{.Report}}.{{.Ext}}"
+
+// OutputSink decides where every output file of a run is written to
+// Files are placed in Dir, with the path below it generated from NameTemplate
+type OutputSink struct {
+	Dir       string
+	Overwrite bool
+	// Timestamp is set once per run, so every file in the run gets the same value
+	Timestamp time.Time
+	template  *template.Template
+}
+
+// OutputName is the data available to the name template
+// Example: {{.Service}}/{{.Report}}-{{.Timestamp}}.{{.Ext}}
+type OutputName struct {
+	Service   string
+	Report    string
+	Format    string
+	Ext       string
+	Timestamp string // 20060102-150405
+	Date      string // 2006-01-02
+}
+
+// Output is the sink used by every writer, configured from the root command flags
+var Output = NewOutputSink()
+
+// NewOutputSink will return a sink writing to the "output" directory with the DefaultNameTemplate
+func NewOutputSink() *OutputSink {
+	sink := &OutputSink{Dir: "output", Timestamp: time.Now()}
+	sink.template = template.Must(template.New("name").Option("missingkey=error").Parse(DefaultNameTemplate))
+	return sink
+}
+
+// SetNameTemplate will parse and validate the template used to name the output files
+func (o *OutputSink) SetNameTemplate(nameTemplate string) error {
+	if nameTemplate == "" {
+		nameTemplate = DefaultNameTemplate
+	}
+	parsed, err := template.New("name").Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return fmt.Errorf("could not parse name template: %v", err)
+	}
+	// render a sample name to catch fields that do not exist before any collection starts
+	if err = parsed.Execute(&bytes.Buffer{}, OutputName{}); err != nil {
+		return fmt.Errorf("invalid name template: %v", err)
+	}
+	o.template = parsed
+	return nil
+}
+
+// Name will render the name template for a report, relative to the output directory
+func (o *OutputSink) Name(service string, report string, format string) (string, error) {
+	name := OutputName{
+		Service:   service,
+		Report:    report,
+		Format:    format,
+		Ext:       FormatExtension(format),
+		Timestamp: o.Timestamp.Format("20060102-150405"),
+		Date:      o.Timestamp.Format("2006-01-02"),
+	}
+
+	var buf bytes.Buffer
+	if err := o.template.Execute(&buf, name); err != nil {
+		return "", fmt.Errorf("could not render name template for %s: %v", report, err)
+	}
+	return buf.String(), nil
+}
+
+// Create will create the output file for a report in the given format
+func (o *OutputSink) Create(service string, report string, format string) (*os.File, error) {
+	name, err := o.Name(service, report, format)
+	if err != nil {
+		return nil, err
+	}
+	return o.CreatePath(name)
+}
+
+// CreateDocument will create the output file for a single resource document of a report, such as a policy document
+// It is named with the name template like a report, with the name in place of the report name
+// An existing document is always replaced, as it is the current copy of the resource and not a new report
+func (o *OutputSink) CreateDocument(service string, name string, format string) (*os.File, error) {
+	path, err := o.Name(service, name, format)
+	if err != nil {
+		return nil, err
+	}
+	return o.createPath(path, true)
+}
+
+// CreatePath will create a file at the path relative to the output directory, creating any directories needed
+// Unless Overwrite is set, an existing file is never replaced and a counter is added to the name instead
+func (o *OutputSink) CreatePath(path string) (*os.File, error) {
+	return o.createPath(path, o.Overwrite)
+}
+
+func (o *OutputSink) createPath(path string, overwrite bool) (*os.File, error) {
+	dir := o.Dir
+	if dir == "" {
+		dir = "output"
+	}
+	// a template field that is empty, such as the service of a run wide report, can leave a leading slash
+	path = filepath.Clean(filepath.FromSlash(strings.TrimLeft(path, "/")))
+	if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("output path %s needs to be inside of the output directory", path)
+	}
+	fullPath := filepath.Join(dir, path)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return nil, fmt.Errorf("could not create directory for %s: %v", fullPath, err)
+	}
+	if overwrite {
+		return os.Create(fullPath)
+	}
+	return CreateFile(fullPath)
+}
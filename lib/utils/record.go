@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// RecordDir is where every collector result is saved to, if set
+	RecordDir string
+	// ReplayDir is where collector results are loaded from instead of calling aws, if set
+	ReplayDir string
+)
+
+// Replaying will return true if the collector results are loaded from ReplayDir instead of calling aws
+func Replaying() bool {
+	return ReplayDir != ""
+}
+
+// Collect will return the result of collect, saving it to RecordDir if set
+// If ReplayDir is set, collect is never called and the result recorded with the same name is loaded instead
+// name is the path of the recording below the directory, such as ec2/instances
+func Collect[T any](name string, collect func() (T, error)) (T, error) {
+	var result T
+	if Replaying() {
+		err := LoadRecording(ReplayDir, name, &result)
+		return result, err
+	}
+
+	result, err := collect()
+	if err != nil {
+		return result, err
+	}
+	if RecordDir != "" {
+		if err = SaveRecording(RecordDir, name, result); err != nil {
+			LogAll("could not record", name, ":", err)
+		}
+	}
+	return result, nil
+}
+
+// CollectAccounts will call Collect with a collector that takes the accounts, such as ec2.GetProfilesInstances
+func CollectAccounts[T any](name string, collect func([]AccountInfo) (T, error), accounts []AccountInfo) (T, error) {
+	return Collect(name, func() (T, error) {
+		return collect(accounts)
+	})
+}
+
+// recordingPath will return the file the recording with the name is kept in
+func recordingPath(dir string, name string) string {
+	return filepath.Join(dir, filepath.FromSlash(name)+".json")
+}
+
+// SaveRecording will write the result as json to the recording with the name, replacing any earlier recording
+func SaveRecording(dir string, name string, result interface{}) error {
+	path := recordingPath(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create directory for %s: %v", path, err)
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode %s: %v", name, err)
+	}
+	fmt.Println("Recording", name, "to file:", path)
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadRecording will read the recording with the name into result
+func LoadRecording(dir string, name string, result interface{}) error {
+	path := recordingPath(dir, name)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("there is no recording of %s in %s, run the command with --record first", name, dir)
+	}
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("could not decode the recording %s: %v", path, err)
+	}
+	fmt.Println("Replaying", name, "from file:", path)
+	return nil
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var (
+	// IncludeRegions limits every account to these regions, if set
+	IncludeRegions []string
+	// ExcludeRegions are never checked in any account
+	ExcludeRegions []string
+
+	regionCache   = make(map[string]*regionCacheEntry)
+	regionCacheMu sync.Mutex
+)
+
+// regionCacheEntry holds the discovered regions for one account, so they are only discovered once per run
+type regionCacheEntry struct {
+	once    sync.Once
+	regions []string
+	err     error
+}
+
+// CacheKey will return the key used to cache anything per account for the run
+func (account AccountInfo) CacheKey() string {
+	return account.AccessType + "|" + account.Profile + "|" + account.Arn
+}
+
+// GetRegions will return the regions to check for the account
+// The enabled regions are discovered with DescribeRegions, including any opt-in regions the account has opted in to
+// These are then limited to the regions set on the account, and the IncludeRegions and ExcludeRegions
+func (account AccountInfo) GetRegions() ([]string, error) {
+	regionCacheMu.Lock()
+	entry, ok := regionCache[account.CacheKey()]
+	if !ok {
+		entry = &regionCacheEntry{}
+		regionCache[account.CacheKey()] = entry
+	}
+	regionCacheMu.Unlock()
+
+	entry.once.Do(func() {
+		entry.regions, entry.err = account.DescribeEnabledRegions()
+	})
+	if entry.err != nil {
+		return nil, entry.err
+	}
+
+	return FilterRegions(entry.regions, account.Regions, account.Profile), nil
+}
+
+// DescribeEnabledRegions will return every region that is enabled for the account
+func (account AccountInfo) DescribeEnabledRegions() ([]string, error) {
+	sess, err := account.GetSession("us-east-1")
+	if err != nil {
+		return nil, &OperationError{Service: "ec2", Operation: "GetSession", Err: err}
+	}
+
+	params := &ec2.DescribeRegionsInput{
+		AllRegions: aws.Bool(true),
+	}
+	resp, err := ec2.New(sess).DescribeRegions(params)
+	if err != nil {
+		return nil, &OperationError{Service: "ec2", Operation: "DescribeRegions", Err: fmt.Errorf("could not describe regions: %w", err)}
+	}
+
+	var regions []string
+	for _, region := range resp.Regions {
+		if region.OptInStatus != nil && *region.OptInStatus == "not-opted-in" {
+			continue
+		}
+		regions = append(regions, *region.RegionName)
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// FilterRegions will limit the enabled regions to the account regions and IncludeRegions, if set, and remove the ExcludeRegions
+// Any region asked for that is not enabled is logged and skipped
+func FilterRegions(enabled []string, accountRegions []string, profile string) []string {
+	regions := enabled
+	for _, limit := range [][]string{accountRegions, IncludeRegions} {
+		if len(limit) == 0 {
+			continue
+		}
+		var limited []string
+		for _, region := range limit {
+			if containsString(regions, region) {
+				limited = append(limited, region)
+			} else if !containsString(enabled, region) {
+				log.Println("region", region, "is not enabled for", profile, ", skipping it")
+			}
+		}
+		regions = limited
+	}
+
+	var filtered []string
+	for _, region := range regions {
+		if containsString(ExcludeRegions, region) || containsString(filtered, region) {
+			continue
+		}
+		filtered = append(filtered, region)
+	}
+	return filtered
+}
+
+// ServiceRegions will limit the regions to the ones the sdk endpoints list for the service, such as workspaces
+// Services that are only in some regions fail in the others, which are skipped instead of recorded as errors
+func ServiceRegions(regions []string, service string) []string {
+	offered := make(map[string]bool)
+	for _, partition := range endpoints.DefaultPartitions() {
+		if partitionService, ok := partition.Services()[service]; ok {
+			for region := range partitionService.Regions() {
+				offered[region] = true
+			}
+		}
+	}
+
+	var serviceRegions []string
+	for _, region := range regions {
+		if offered[region] {
+			serviceRegions = append(serviceRegions, region)
+		}
+	}
+	return serviceRegions
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServiceRegions(t *testing.T) {
+	regions := []string{"eu-north-1", "eu-west-1", "us-east-1", "us-east-2", "us-gov-west-1"}
+	got := ServiceRegions(regions, "workspaces")
+	want := []string{"eu-west-1", "us-east-1", "us-gov-west-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ServiceRegions(workspaces) = %v, want %v", got, want)
+	}
+	if got = ServiceRegions(regions, "ec2"); !reflect.DeepEqual(got, regions) {
+		t.Errorf("ServiceRegions(ec2) = %v, want %v", got, regions)
+	}
+	if got = ServiceRegions(regions, "not-a-service"); got != nil {
+		t.Errorf("ServiceRegions(not-a-service) = %v, want none", got)
+	}
+}
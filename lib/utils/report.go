@@ -0,0 +1,363 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatCsv      = "csv"
+	FormatJson     = "json"
+	FormatNdjson   = "ndjson"
+	FormatMarkdown = "markdown"
+	FormatXlsx     = "xlsx"
+)
+
+// OutputFormats are all of the formats a Report can be written in
+var OutputFormats = []string{FormatCsv, FormatJson, FormatNdjson, FormatMarkdown, FormatXlsx}
+
+// OutputFormat is the format every Report will be written in, set with SetOutputFormat
+var OutputFormat = FormatCsv
+
+// LabelsColumn is the column added to every report with a profile column when any account has labels
+const LabelsColumn = "Labels"
+
+// Report is a table of columns and rows that every Write* function builds up
+// The same report can then be written out in any of the OutputFormats
+type Report struct {
+	Service string
+	Name    string
+	Columns []string
+	Rows    [][]string
+	// labels is set if the LabelsColumn was added, looked up by the profile in profileColumn
+	labels        bool
+	profileColumn int
+}
+
+// accountLabels are the labels of every account in the run by profile, set with SetAccountLabels
+var accountLabels map[string]string
+
+// SetAccountLabels will keep the labels of the accounts, so they are added to every report as the LabelsColumn
+// The labels are written as key=value, sorted by key and separated by |
+func SetAccountLabels(accounts []AccountInfo) {
+	accountLabels = make(map[string]string)
+	for _, account := range accounts {
+		if len(account.Labels) == 0 {
+			continue
+		}
+		keys := make([]string, 0, len(account.Labels))
+		for key := range account.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		labels := make([]string, len(keys))
+		for i, key := range keys {
+			labels[i] = key + "=" + account.Labels[key]
+		}
+		accountLabels[account.Profile] = strings.Join(labels, "|")
+	}
+}
+
+// SetOutputFormat will validate the given format and set it as the OutputFormat
+func SetOutputFormat(format string) error {
+	format = strings.ToLower(format)
+	if format == "md" {
+		format = FormatMarkdown
+	}
+	for _, valid := range OutputFormats {
+		if format == valid {
+			OutputFormat = format
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid output format %q, needs to be one of: %s", format, strings.Join(OutputFormats, ", "))
+}
+
+// NewReport will create an empty report for the service, the name is used for the output file name
+// If any account has labels, and the report has a Profile or Account column, the LabelsColumn is added last
+func NewReport(service string, name string, columns []string) *Report {
+	report := &Report{Service: service, Name: name, Columns: columns}
+	if len(accountLabels) == 0 {
+		return report
+	}
+	for i, column := range columns {
+		if column == "Profile" || column == "Account" {
+			report.labels = true
+			report.profileColumn = i
+			report.Columns = append(columns[:len(columns):len(columns)], LabelsColumn)
+			break
+		}
+	}
+	return report
+}
+
+// AddRow will add a row of data to the report
+// Rows shorter than the columns are padded, so every row lines up with the column titles
+func (r *Report) AddRow(row []string) {
+	columns := len(r.Columns)
+	if r.labels {
+		columns--
+	}
+	for len(row) < columns {
+		row = append(row, "")
+	}
+	if r.labels {
+		row = append(row[:len(row):len(row)], accountLabels[row[r.profileColumn]])
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// Write will write the report to a file in the current OutputFormat
+func (r *Report) Write() error {
+	return r.WriteFormat(OutputFormat)
+}
+
+// WriteFormat will write the report to a file from the Output sink in the given format
+func (r *Report) WriteFormat(format string) error {
+	outfile, err := Output.Create(r.Service, r.Name, format)
+	if err != nil {
+		return fmt.Errorf("could not create %s file: %v", r.Name, err)
+	}
+	defer outfile.Close()
+
+	fmt.Println("Writing", r.Name, "to file:", outfile.Name())
+	return r.Encode(outfile, format)
+}
+
+// FormatExtension will return the file extension to use for the given format
+func FormatExtension(format string) string {
+	if format == FormatMarkdown {
+		return "md"
+	}
+	return format
+}
+
+// Encode will write the report to w in the given format
+func (r *Report) Encode(w io.Writer, format string) error {
+	switch format {
+	case FormatCsv:
+		return r.encodeCsv(w)
+	case FormatJson:
+		return r.encodeJson(w)
+	case FormatNdjson:
+		return r.encodeNdjson(w)
+	case FormatMarkdown:
+		return r.encodeMarkdown(w)
+	case FormatXlsx:
+		return r.encodeXlsx(w)
+	default:
+		return fmt.Errorf("invalid output format %q", format)
+	}
+}
+
+func (r *Report) encodeCsv(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(r.Columns); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// jsonString will marshal a string without escaping html characters, so values like <none> stay readable
+func jsonString(value string) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// rowObject will marshal a row into a json object keyed by the column titles
+// This is done by hand, as a map would lose the column ordering
+func (r *Report) rowObject(row []string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, column := range r.Columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := jsonString(column)
+		if err != nil {
+			return nil, err
+		}
+		value, err := jsonString(row[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (r *Report) encodeJson(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, row := range r.Rows {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		object, err := r.rowObject(row)
+		if err != nil {
+			return err
+		}
+		buf.Write(object)
+	}
+	buf.WriteByte(']')
+
+	var output bytes.Buffer
+	if err := json.Indent(&output, buf.Bytes(), "", "\t"); err != nil {
+		return err
+	}
+	output.WriteByte('\n')
+	_, err := output.WriteTo(w)
+	return err
+}
+
+func (r *Report) encodeNdjson(w io.Writer) error {
+	for _, row := range r.Rows {
+		object, err := r.rowObject(row)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(append(object, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownCell will escape a value so it can not break the markdown table
+func markdownCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	value = strings.ReplaceAll(value, "\r\n", "<br>")
+	return strings.ReplaceAll(value, "\n", "<br>")
+}
+
+func (r *Report) encodeMarkdown(w io.Writer) error {
+	var buf bytes.Buffer
+	writeLine := func(cells []string) {
+		buf.WriteString("|")
+		for _, cell := range cells {
+			buf.WriteString(" " + markdownCell(cell) + " |")
+		}
+		buf.WriteString("\n")
+	}
+
+	writeLine(r.Columns)
+	separators := make([]string, len(r.Columns))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	writeLine(separators)
+	for _, row := range r.Rows {
+		writeLine(row)
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// The xlsx format is a zip of xml documents
+// Only the parts needed for a single sheet of inline strings are written
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+)
+
+// xlsxColumn will return the spreadsheet column letters for a zero based index, 0 = A, 26 = AA
+func xlsxColumn(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// xlsxEscape will escape a value to be placed inside of an xml element or attribute
+func xlsxEscape(value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
+
+// xlsxSheetName will make the report name valid as a sheet name, which are limited to 31 characters
+func xlsxSheetName(name string) string {
+	name = strings.NewReplacer("[", "", "]", "", ":", "", "*", "", "?", "", "/", "", "\\", "").Replace(name)
+	if name == "" {
+		name = "Sheet1"
+	}
+	if len(name) > 31 {
+		name = name[:31]
+	}
+	return name
+}
+
+func (r *Report) encodeXlsx(w io.Writer) error {
+	var sheet bytes.Buffer
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	writeRow := func(index int, cells []string) {
+		rowNumber := strconv.Itoa(index + 1)
+		sheet.WriteString(`<row r="` + rowNumber + `">`)
+		for i, cell := range cells {
+			sheet.WriteString(`<c r="` + xlsxColumn(i) + rowNumber + `" t="inlineStr"><is><t xml:space="preserve">`)
+			sheet.WriteString(xlsxEscape(cell))
+			sheet.WriteString(`</t></is></c>`)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	writeRow(0, r.Columns)
+	for i, row := range r.Rows {
+		writeRow(i+1, row)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xlsxEscape(xlsxSheetName(r.Name)))},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+
+	archive := zip.NewWriter(w)
+	for _, part := range parts {
+		partWriter, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(partWriter, part.content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testReport() *Report {
+	report := NewReport("ec2", "instances", []string{"Profile", "Instance Name", "Notes"})
+	report.AddRow([]string{"prod", "web|1", "<none>"})
+	report.AddRow([]string{"dev", "db, \"primary\""})
+	return report
+}
+
+func encodeReport(t *testing.T, report *Report, format string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := report.Encode(&buf, format); err != nil {
+		t.Fatalf("Encode(%s) returned an error: %v", format, err)
+	}
+	return buf.String()
+}
+
+func TestReportEncodeCsv(t *testing.T) {
+	got := encodeReport(t, testReport(), FormatCsv)
+	want := "Profile,Instance Name,Notes\n" +
+		"prod,web|1,<none>\n" +
+		"dev,\"db, \"\"primary\"\"\",\n"
+	if got != want {
+		t.Errorf("csv:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReportEncodeJson(t *testing.T) {
+	got := encodeReport(t, testReport(), FormatJson)
+	want := `[
+	{
+		"Profile": "prod",
+		"Instance Name": "web|1",
+		"Notes": "<none>"
+	},
+	{
+		"Profile": "dev",
+		"Instance Name": "db, \"primary\"",
+		"Notes": ""
+	}
+]
+`
+	if got != want {
+		t.Errorf("json:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReportEncodeNdjson(t *testing.T) {
+	got := encodeReport(t, testReport(), FormatNdjson)
+	want := `{"Profile":"prod","Instance Name":"web|1","Notes":"<none>"}` + "\n" +
+		`{"Profile":"dev","Instance Name":"db, \"primary\"","Notes":""}` + "\n"
+	if got != want {
+		t.Errorf("ndjson:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReportEncodeMarkdown(t *testing.T) {
+	report := testReport()
+	report.AddRow([]string{"test", "two\nlines", ""})
+	got := encodeReport(t, report, FormatMarkdown)
+	want := "| Profile | Instance Name | Notes |\n" +
+		"| --- | --- | --- |\n" +
+		"| prod | web\\|1 | <none> |\n" +
+		"| dev | db, \"primary\" |  |\n" +
+		"| test | two<br>lines |  |\n"
+	if got != want {
+		t.Errorf("markdown:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReportEncodeXlsx(t *testing.T) {
+	got := encodeReport(t, testReport(), FormatXlsx)
+	archive, err := zip.NewReader(strings.NewReader(got), int64(len(got)))
+	if err != nil {
+		t.Fatalf("xlsx is not a zip: %v", err)
+	}
+	parts := make(map[string]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[file.Name] = string(data)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("xlsx is missing the %s part", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="instances"`) {
+		t.Errorf("workbook does not name the sheet after the report: %s", parts["xl/workbook.xml"])
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, cell := range []string{
+		`<c r="A1" t="inlineStr"><is><t xml:space="preserve">Profile</t></is></c>`,
+		`<c r="C2" t="inlineStr"><is><t xml:space="preserve">&lt;none&gt;</t></is></c>`,
+		`<c r="B3" t="inlineStr"><is><t xml:space="preserve">db, &#34;primary&#34;</t></is></c>`,
+	} {
+		if !strings.Contains(sheet, cell) {
+			t.Errorf("sheet is missing the cell %s", cell)
+		}
+	}
+	if strings.Count(sheet, "<row ") != 3 {
+		t.Errorf("sheet has %d rows, want 3", strings.Count(sheet, "<row "))
+	}
+}
+
+func TestReportEncodeInvalidFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().Encode(&buf, "yaml"); err == nil {
+		t.Error("Encode(yaml) did not return an error")
+	}
+}
+
+func TestReportLabels(t *testing.T) {
+	SetAccountLabels([]AccountInfo{
+		{Profile: "prod", Labels: map[string]string{"team": "web", "env": "prod"}},
+		{Profile: "dev"},
+	})
+	defer SetAccountLabels(nil)
+
+	report := NewReport("ec2", "instances", []string{"Profile", "Instance Name"})
+	report.AddRow([]string{"prod", "web1"})
+	report.AddRow([]string{"dev"})
+	got := encodeReport(t, report, FormatCsv)
+	want := "Profile,Instance Name,Labels\n" +
+		"prod,web1,env=prod|team=web\n" +
+		"dev,,\n"
+	if got != want {
+		t.Errorf("csv:\n%s\nwant:\n%s", got, want)
+	}
+
+	noProfile := NewReport("", "summary", []string{"Name", "Count"})
+	if len(noProfile.Columns) != 2 {
+		t.Errorf("report without a profile column got the columns %v", noProfile.Columns)
+	}
+}
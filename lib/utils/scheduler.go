@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"sync"
+)
+
+var (
+	// MaxAccounts is how many accounts are collected from at once
+	MaxAccounts = 10
+	// MaxRegions is how many regions are collected from at once, per account
+	MaxRegions = 5
+)
+
+// ForEachAccount will call fn for every account, with at most MaxAccounts running at once
+// It blocks until every call has returned
+func ForEachAccount(accounts []AccountInfo, fn func(account AccountInfo)) {
+	ForEach(len(accounts), MaxAccounts, func(i int) {
+		fn(accounts[i])
+	})
+}
+
+// ForEachRegion will call fn for every region, with at most MaxRegions running at once
+// It blocks until every call has returned
+func ForEachRegion(regions []string, fn func(region string)) {
+	ForEach(len(regions), MaxRegions, func(i int) {
+		fn(regions[i])
+	})
+}
+
+// ForEach will call fn with every index up to count, with at most limit running at once
+// A limit of 0 or less runs them all at once
+func ForEach(count int, limit int, fn func(i int)) {
+	if limit <= 0 || limit > count {
+		limit = count
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, limit)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
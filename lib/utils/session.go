@@ -2,11 +2,10 @@ package utils
 
 import (
 	"fmt"
-	"os"
-	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
 )
@@ -21,14 +20,42 @@ region = us-east-1
 output = json
 */
 // If AccessType is profile, then it will just use the profile in your shared credential file ~/.aws/credentials
+// If AccessType is profileassume, then the role in Arn is assumed with the credentials of SourceProfile
+// If AccessType is source, then the named CredentialSource from the config file is used, assuming Arn with it if set
+// The yaml and json tags are the field names used in the accounts inventory file
 type AccountInfo struct {
-	AccountId  string
-	Arn        string // only required if AccessType is instanceassume
-	ExternalId string
-	AccessType string
-	Profile    string
+	AccountId        string            `yaml:"accountId" json:"accountId"`
+	Arn              string            `yaml:"roleArn" json:"roleArn"` // only required if AccessType is instanceassume or profileassume
+	ExternalId       string            `yaml:"externalId" json:"externalId"`
+	AccessType       string            `yaml:"accessType" json:"accessType"`
+	Profile          string            `yaml:"profile" json:"profile"`
+	SourceProfile    string            `yaml:"sourceProfile" json:"sourceProfile"`       // only required if AccessType is profileassume
+	CredentialSource string            `yaml:"credentialSource" json:"credentialSource"` // only required if AccessType is source
+	SessionName      string            `yaml:"sessionName" json:"sessionName"`           // defaults to DefaultSessionName
+	Regions          []string          `yaml:"regions" json:"regions"`                   // if set, only these regions are used for the account
+	Labels           map[string]string `yaml:"labels" json:"labels"`
 }
 
+// FindAccount will return the account with the profile, and the account id if both it and the account know it
+func FindAccount(accounts []AccountInfo, profile string, accountId string) (AccountInfo, bool) {
+	for _, account := range accounts {
+		if account.Profile == profile && (account.AccountId == "" || accountId == "" || account.AccountId == accountId) {
+			return account, true
+		}
+	}
+	return AccountInfo{}, false
+}
+
+// DefaultSessionName is the role session name used when assuming a role, if the account does not set one
+const DefaultSessionName = "aws-go-tool"
+
+var (
+	// AssumeRoleDuration is how long assumed role credentials are valid for
+	AssumeRoleDuration = 15 * time.Minute
+	// AssumeRoleExpiryWindow is how long before they expire that assumed role credentials are refreshed
+	AssumeRoleExpiryWindow = time.Minute
+)
+
 // GetAccountId will get the account ID for the profile currently in use for the session
 func GetAccountId(sess *session.Session) (string, error) {
 	params := &sts.GetCallerIdentityInput{}
@@ -42,35 +69,49 @@ func GetAccountId(sess *session.Session) (string, error) {
 	return id, nil
 }
 
-func (account AccountInfo) SetAccountId() error {
-	sess, err := account.GetSession("us-east-1")
-	if err != nil {
-		return err
-	}
-	account.AccountId, err = GetAccountId(sess)
+// SetAccountId will set the AccountId to the account of the credentials in use, looked up once per run
+func (account *AccountInfo) SetAccountId() error {
+	id, err := account.CallerAccountId()
 	if err != nil {
 		return err
 	}
+	account.AccountId = id
 	return nil
 }
 
+// CallerAccountId will return the account ID of the credentials in use, looked up once per run
+func (account AccountInfo) CallerAccountId() (string, error) {
+	return Sessions.AccountId(account)
+}
+
+// GetSession will return a session for the region from the Sessions cache
+// The credentials are shared by every region of the account, and refreshed before they expire
 func (account AccountInfo) GetSession(region string) (*session.Session, error) {
-	var sess *session.Session
 	if region == "" {
 		region = "us-east-1"
 	}
+	return Sessions.Get(account, region)
+}
+
+// NewSession will open a new session for the account, without using the Sessions cache
+func (account AccountInfo) NewSession(region string) (*session.Session, error) {
+	var sess *session.Session
 	var err error
 	switch account.AccessType {
 	case "assume":
-		sess = AssumeRoleWithProfile(account, region)
+		sess, err = AssumeRoleWithProfile(account, region)
 	case "profile":
-		sess = OpenSession(account.Profile, region)
+		sess, err = openProfileSession(account.Profile, region)
 	case "instance":
-		sess = session.Must(session.NewSession())
+		sess, err = session.NewSession(&aws.Config{Region: aws.String(region)})
 	case "instanceassume":
 		sess, err = AssumeRoleWithInstance(account, region)
+	case "profileassume":
+		sess, err = AssumeRoleWithSourceProfile(account, region)
+	case "source":
+		sess, err = AssumeRoleWithCredentialSource(account, region)
 	default:
-		return nil, fmt.Errorf("no valid options in Access Type specified.  Needs 'assume', 'profile', 'instance', or 'instanceassume'")
+		return nil, fmt.Errorf("no valid options in Access Type specified.  Needs 'assume', 'profile', 'instance', 'instanceassume', 'profileassume' or 'source'")
 	}
 	if err != nil {
 		return nil, err
@@ -79,50 +120,85 @@ func (account AccountInfo) GetSession(region string) (*session.Session, error) {
 }
 
 func OpenSession(profile string, region string) *session.Session {
-	sess := session.Must(session.NewSessionWithOptions(session.Options{Config: aws.Config{Region: aws.String(region)}, Profile: profile}))
-	return sess
+	return session.Must(openProfileSession(profile, region))
+}
+
+// openProfileSession will open a session for a profile in the shared config or credentials file
+// The shared config file is always loaded, so assume role, sso and credential_process profiles all work
+// Profiles with an mfa_serial prompt for the token code
+func openProfileSession(profile string, region string) (*session.Session, error) {
+	return session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{Region: aws.String(region)},
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+		AssumeRoleTokenProvider: func() (string, error) {
+			return PromptMfaToken("profile " + profile)
+		},
+	})
 }
 
 // Assumes the role of the specified profile
-func AssumeRoleWithProfile(account AccountInfo, region string) *session.Session {
-	LoadConfigFile()
-	sess := session.Must(session.NewSessionWithOptions(session.Options{Config: aws.Config{Region: aws.String(region)}, Profile: account.Profile, SharedConfigState: session.SharedConfigEnable}))
-	return sess
+func AssumeRoleWithProfile(account AccountInfo, region string) (*session.Session, error) {
+	return openProfileSession(account.Profile, region)
 }
 
 // Assumes the role of the given arn with the instance profile and returns a session into the account associated with the arn
 func AssumeRoleWithInstance(account AccountInfo, region string) (*session.Session, error) {
 	// open a new session with the instance profile
-	sess := session.Must(session.NewSession())
-	svc := sts.New(sess)
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return AssumeRoleWithSession(sess, account, region)
+}
 
-	params := &sts.AssumeRoleInput{
-		RoleArn:         aws.String(account.Arn),
-		RoleSessionName: aws.String(""),
-		DurationSeconds: aws.Int64(900),
+// Assumes the role of the given arn with the SourceProfile and returns a session into the account associated with the arn
+// The source profile can itself be an assume role profile in the shared config file
+func AssumeRoleWithSourceProfile(account AccountInfo, region string) (*session.Session, error) {
+	if account.SourceProfile == "" {
+		return nil, fmt.Errorf("a source profile is required to assume a role with a profile")
 	}
-	if account.ExternalId != "" {
-		params.ExternalId = &account.ExternalId
+	sess, err := openProfileSession(account.SourceProfile, region)
+	if err != nil {
+		return nil, err
 	}
+	return AssumeRoleWithSession(sess, account, region)
+}
 
-	// AssumeRole gets an Access Key, Secret Key, and Session Token into the client account with the provided arn
-	resp, err := svc.AssumeRole(params)
+// AssumeRoleWithCredentialSource will return a session with the credentials of the account CredentialSource
+// If the account has a role arn, it is assumed with those credentials
+func AssumeRoleWithCredentialSource(account AccountInfo, region string) (*session.Session, error) {
+	if account.CredentialSource == "" {
+		return nil, fmt.Errorf("a credential source is required for access type source")
+	}
+	sess, err := GetSourceSession(account.CredentialSource)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not open credential source %s: %w", account.CredentialSource, err)
 	}
-	if resp.Credentials == nil {
-		return nil, fmt.Errorf("could not assume role")
+	if account.Arn == "" {
+		return sess.Copy(&aws.Config{Region: aws.String(region)}), nil
 	}
-
-	id := *resp.Credentials.AccessKeyId
-	secret := *resp.Credentials.SecretAccessKey
-	token := *resp.Credentials.SessionToken
-	// NewStaticCredentials gives the new session the credentials to use when opening the new session, based on the credentials from the AssumeRole response
-	newSess := session.Must(session.NewSessionWithOptions(session.Options{Config: aws.Config{Region: aws.String(region), Credentials: credentials.NewStaticCredentials(id, secret, token)}}))
-	return newSess, nil
+	return AssumeRoleWithSession(sess, account, region)
 }
 
-// This is a helper func to load the ~/.aws/config file
-func LoadConfigFile() {
-	os.Setenv("AWS_SDK_LOAD_CONFIG", strconv.Itoa(1))
+// AssumeRoleWithSession will assume the role of the given arn with the credentials of the session
+// The credentials are refreshed with a new AssumeRole call before they expire
+func AssumeRoleWithSession(sess *session.Session, account AccountInfo, region string) (*session.Session, error) {
+	if account.Arn == "" {
+		return nil, fmt.Errorf("a role arn is required to assume a role")
+	}
+
+	sessionName := account.SessionName
+	if sessionName == "" {
+		sessionName = DefaultSessionName
+	}
+	creds := stscreds.NewCredentials(sess, account.Arn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = sessionName
+		p.Duration = AssumeRoleDuration
+		p.ExpiryWindow = AssumeRoleExpiryWindow
+		if account.ExternalId != "" {
+			p.ExternalID = aws.String(account.ExternalId)
+		}
+	})
+	return session.NewSession(&aws.Config{Region: aws.String(region), Credentials: creds})
 }
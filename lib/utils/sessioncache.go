@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// SessionCache keeps one set of credentials per account for the run, and a session per account and region using them
+// It is safe to use from many goroutines
+type SessionCache struct {
+	mu       sync.Mutex
+	accounts map[string]*accountSessions
+}
+
+// accountSessions holds the base session of an account, which every region session is copied from
+// Copies share the credentials of the base session, so a role is only assumed once per account
+// and the sdk refreshes the credentials for every region when they are about to expire
+type accountSessions struct {
+	once      sync.Once
+	base      *session.Session
+	err       error
+	mu        sync.Mutex
+	regions   map[string]*session.Session
+	idOnce    sync.Once
+	accountId string
+	idErr     error
+}
+
+// Sessions is the cache used by AccountInfo.GetSession
+var Sessions = NewSessionCache()
+
+// NewSessionCache will return an empty SessionCache
+func NewSessionCache() *SessionCache {
+	return &SessionCache{accounts: make(map[string]*accountSessions)}
+}
+
+// account will return the cache entry for the account, creating it if needed
+func (c *SessionCache) account(account AccountInfo) *accountSessions {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.accounts[account.CacheKey()]
+	if !ok {
+		entry = &accountSessions{regions: make(map[string]*session.Session)}
+		c.accounts[account.CacheKey()] = entry
+	}
+	return entry
+}
+
+// Get will return the session for the account and region, opening the account session the first time it is used
+func (c *SessionCache) Get(account AccountInfo, region string) (*session.Session, error) {
+	entry := c.account(account)
+	entry.once.Do(func() {
+		var sess *session.Session
+		sess, entry.err = account.NewSession("us-east-1")
+		if entry.err == nil {
+			entry.base = ConfigureSession(sess, account)
+		}
+	})
+	if entry.err != nil {
+		return nil, entry.err
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	sess, ok := entry.regions[region]
+	if !ok {
+		sess = entry.base.Copy(&aws.Config{Region: aws.String(region)})
+		entry.regions[region] = sess
+	}
+	return sess, nil
+}
+
+// AccountId will return the account ID for the credentials of the account, only calling GetCallerIdentity once per account
+func (c *SessionCache) AccountId(account AccountInfo) (string, error) {
+	entry := c.account(account)
+	entry.idOnce.Do(func() {
+		var sess *session.Session
+		sess, entry.idErr = c.Get(account, "us-east-1")
+		if entry.idErr == nil {
+			entry.accountId, entry.idErr = GetAccountId(sess)
+		}
+	})
+	return entry.accountId, entry.idErr
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+var (
+	// RequestsPerSecond is the rate of api calls allowed per service, account and region, 0 for no limit
+	RequestsPerSecond = 10.0
+	// MaxRetries is how many times a throttled or failed api call is retried
+	MaxRetries = 8
+
+	rateLimiters   = make(map[string]*TokenBucket)
+	rateLimitersMu sync.Mutex
+)
+
+const (
+	retryBaseDelay     = 200 * time.Millisecond
+	retryMaxDelay      = 20 * time.Second
+	throttleBaseDelay  = time.Second
+	minRequestsRate    = 0.5
+	rateRecoveryFactor = 0.05
+)
+
+// TokenBucket is a rate limiter that allows bursts up to its size, refilling at rate tokens per second
+// When the api throttles, the rate is halved, and it slowly recovers back to the max rate on success
+type TokenBucket struct {
+	mu      sync.Mutex
+	maxRate float64
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+}
+
+// NewTokenBucket will return a full bucket with the given rate per second
+func NewTokenBucket(rate float64) *TokenBucket {
+	burst := math.Max(1, rate)
+	return &TokenBucket{maxRate: rate, rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Wait will block until a token is available, and take it
+func (b *TokenBucket) Wait() {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// Throttled will halve the rate of the bucket, down to minRequestsRate
+func (b *TokenBucket) Throttled() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = math.Max(minRequestsRate, b.rate/2)
+}
+
+// Succeeded will slowly raise the rate of the bucket back up to the max rate
+func (b *TokenBucket) Succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate < b.maxRate {
+		b.rate = math.Min(b.maxRate, b.rate+b.maxRate*rateRecoveryFactor)
+	}
+}
+
+// rateLimiter will return the shared bucket for the key, creating it if needed
+func rateLimiter(key string) *TokenBucket {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	bucket, ok := rateLimiters[key]
+	if !ok {
+		bucket = NewTokenBucket(RequestsPerSecond)
+		rateLimiters[key] = bucket
+	}
+	return bucket
+}
+
+// ThrottleRetryer retries the same errors as the sdk DefaultRetryer, using exponential backoff with full jitter
+// Throttling errors start from a longer base delay than other errors
+type ThrottleRetryer struct {
+	client.DefaultRetryer
+}
+
+// RetryRules will return a random delay between 0 and the exponential backoff for the attempt
+func (r ThrottleRetryer) RetryRules(req *request.Request) time.Duration {
+	base := retryBaseDelay
+	if req.IsErrorThrottle() {
+		base = throttleBaseDelay
+	}
+	backoff := float64(base) * math.Pow(2, float64(req.RetryCount))
+	backoff = math.Min(backoff, float64(retryMaxDelay))
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// ConfigureSession will return a copy of the session with rate limiting and the ThrottleRetryer added
+// The rate limit is shared by every session for the same account, region and service
+func ConfigureSession(sess *session.Session, account AccountInfo) *session.Session {
+	retryer := ThrottleRetryer{client.DefaultRetryer{NumMaxRetries: MaxRetries}}
+	configured := sess.Copy(request.WithRetryer(aws.NewConfig(), retryer))
+	if RequestsPerSecond <= 0 {
+		return configured
+	}
+
+	limiterKey := func(r *request.Request) string {
+		return account.CacheKey() + "|" + aws.StringValue(r.Config.Region) + "|" + r.ClientInfo.ServiceName
+	}
+	configured.Handlers.Send.PushFrontNamed(request.NamedHandler{
+		Name: "awsgotool.RateLimit",
+		Fn: func(r *request.Request) {
+			rateLimiter(limiterKey(r)).Wait()
+		},
+	})
+	configured.Handlers.AfterRetry.PushFrontNamed(request.NamedHandler{
+		Name: "awsgotool.Throttled",
+		Fn: func(r *request.Request) {
+			if r.Error != nil && r.IsErrorThrottle() {
+				rateLimiter(limiterKey(r)).Throttled()
+			}
+		},
+	})
+	configured.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "awsgotool.Succeeded",
+		Fn: func(r *request.Request) {
+			if r.Error == nil {
+				rateLimiter(limiterKey(r)).Succeeded()
+			}
+		},
+	})
+	return configured
+}
@@ -0,0 +1,520 @@
+package vpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Graph node types
+const (
+	NodeVpc               = "vpc"
+	NodeSubnet            = "subnet"
+	NodeRouteTable        = "routetable"
+	NodeInternetGateway   = "internetgateway"
+	NodeNatGateway        = "natgateway"
+	NodePeeringConnection = "peeringconnection"
+	NodeTransitGateway    = "transitgateway"
+	NodeVpcEndpoint       = "vpcendpoint"
+)
+
+type (
+	// RegionTopology is every network resource in a region needed to draw it
+	RegionTopology struct {
+		AccountId                 string
+		Region                    string
+		Profile                   string
+		Vpcs                      []ec2.Vpc
+		Subnets                   []ec2.Subnet
+		RouteTables               []ec2.RouteTable
+		InternetGateways          []ec2.InternetGateway
+		NatGateways               []ec2.NatGateway
+		PeeringConnections        []ec2.VpcPeeringConnection
+		TransitGatewayAttachments []ec2.TransitGatewayAttachment
+		VpcEndpoints              []ec2.VpcEndpoint
+	}
+
+	AccountTopology  []RegionTopology
+	ProfilesTopology []AccountTopology
+
+	// Graph is the network topology as nodes and edges
+	Graph struct {
+		Nodes []GraphNode `json:"nodes"`
+		Edges []GraphEdge `json:"edges"`
+	}
+
+	// GraphNode is one resource, placed in the cluster of its account, region and vpc
+	// Transit gateways and peering connections can span accounts, so they have no account or vpc
+	GraphNode struct {
+		Id         string            `json:"id"`
+		Type       string            `json:"type"`
+		Label      string            `json:"label"`
+		Profile    string            `json:"profile,omitempty"`
+		AccountId  string            `json:"accountId,omitempty"`
+		Region     string            `json:"region,omitempty"`
+		VpcId      string            `json:"vpcId,omitempty"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+	}
+
+	// GraphEdge is a link between two resources, such as a route or an attachment
+	GraphEdge struct {
+		From  string `json:"from"`
+		To    string `json:"to"`
+		Type  string `json:"type"`
+		Label string `json:"label,omitempty"`
+	}
+)
+
+// GetRegionTopology will take a session and get the vpcs, subnets, route tables, gateways, peering connections,
+// transit gateway attachments and vpc endpoints in the region
+func GetRegionTopology(sess *session.Session) (RegionTopology, error) {
+	var info RegionTopology
+	svc := ec2.New(sess)
+
+	err := svc.DescribeVpcsPages(&ec2.DescribeVpcsInput{}, func(page *ec2.DescribeVpcsOutput, lastPage bool) bool {
+		for _, vpc := range page.Vpcs {
+			info.Vpcs = append(info.Vpcs, *vpc)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe vpcs: %w", err)
+	}
+	subnets, err := GetRegionSubnets(sess)
+	if err != nil {
+		return info, fmt.Errorf("could not describe subnets: %w", err)
+	}
+	info.Subnets = subnets.Subnets
+	info.RouteTables = subnets.RouteTables
+
+	err = svc.DescribeInternetGatewaysPages(&ec2.DescribeInternetGatewaysInput{}, func(page *ec2.DescribeInternetGatewaysOutput, lastPage bool) bool {
+		for _, gateway := range page.InternetGateways {
+			info.InternetGateways = append(info.InternetGateways, *gateway)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe internet gateways: %w", err)
+	}
+
+	err = svc.DescribeNatGatewaysPages(&ec2.DescribeNatGatewaysInput{}, func(page *ec2.DescribeNatGatewaysOutput, lastPage bool) bool {
+		for _, gateway := range page.NatGateways {
+			if aws.StringValue(gateway.State) != ec2.NatGatewayStateDeleted {
+				info.NatGateways = append(info.NatGateways, *gateway)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe nat gateways: %w", err)
+	}
+
+	err = svc.DescribeVpcPeeringConnectionsPages(&ec2.DescribeVpcPeeringConnectionsInput{}, func(page *ec2.DescribeVpcPeeringConnectionsOutput, lastPage bool) bool {
+		for _, connection := range page.VpcPeeringConnections {
+			if connection.Status != nil && aws.StringValue(connection.Status.Code) == ec2.VpcPeeringConnectionStateReasonCodeActive {
+				info.PeeringConnections = append(info.PeeringConnections, *connection)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe peering connections: %w", err)
+	}
+
+	params := &ec2.DescribeTransitGatewayAttachmentsInput{
+		Filters: []*ec2.Filter{{Name: aws.String("resource-type"), Values: aws.StringSlice([]string{ec2.TransitGatewayAttachmentResourceTypeVpc})}},
+	}
+	err = svc.DescribeTransitGatewayAttachmentsPages(params, func(page *ec2.DescribeTransitGatewayAttachmentsOutput, lastPage bool) bool {
+		for _, attachment := range page.TransitGatewayAttachments {
+			if aws.StringValue(attachment.State) != ec2.TransitGatewayAttachmentStateDeleted {
+				info.TransitGatewayAttachments = append(info.TransitGatewayAttachments, *attachment)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe transit gateway attachments: %w", err)
+	}
+
+	err = svc.DescribeVpcEndpointsPages(&ec2.DescribeVpcEndpointsInput{}, func(page *ec2.DescribeVpcEndpointsOutput, lastPage bool) bool {
+		for _, endpoint := range page.VpcEndpoints {
+			info.VpcEndpoints = append(info.VpcEndpoints, *endpoint)
+		}
+		return true
+	})
+	if err != nil {
+		return info, fmt.Errorf("could not describe vpc endpoints: %w", err)
+	}
+	return info, nil
+}
+
+// GetAccountTopology will take a profile and go through all regions to get the network topology in the account
+func GetAccountTopology(account utils.AccountInfo) (AccountTopology, error) {
+	profile := account.Profile
+	fmt.Println("Getting network topology for profile:", profile)
+	topologyChan := make(chan RegionTopology)
+
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
+			sess, err := account.GetSession(region)
+			if err != nil {
+				utils.RecordError(account, region, "vpc", "GetSession", err)
+				return
+			}
+			info, err := GetRegionTopology(sess)
+			if err != nil {
+				utils.RecordError(account, region, "vpc", "Describe", err)
+				return
+			}
+			info.AccountId = account.AccountId
+			info.Region = region
+			info.Profile = profile
+			topologyChan <- info
+		})
+		close(topologyChan)
+	}()
+
+	var accountTopology AccountTopology
+	for regionTopology := range topologyChan {
+		accountTopology = append(accountTopology, regionTopology)
+	}
+	return accountTopology, nil
+}
+
+// GetProfilesTopology will return the network topology in all given accounts
+func GetProfilesTopology(accounts []utils.AccountInfo) (ProfilesTopology, error) {
+	profilesTopologyChan := make(chan AccountTopology)
+
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
+			accountTopology, err := GetAccountTopology(account)
+			if err != nil {
+				utils.RecordError(account, "", "vpc", "GetRegions", err)
+				return
+			}
+			profilesTopologyChan <- accountTopology
+		})
+		close(profilesTopologyChan)
+	}()
+
+	var profilesTopology ProfilesTopology
+	for accountTopology := range profilesTopologyChan {
+		profilesTopology = append(profilesTopology, accountTopology)
+	}
+	return profilesTopology, nil
+}
+
+// BuildGraph will turn the topology into nodes and edges
+// Vpcs in another account that are peered or attached to a transit gateway are added as nodes without an account
+// cluster, if the account was not collected
+func BuildGraph(profilesTopology ProfilesTopology) Graph {
+	var graph Graph
+	nodes := make(map[string]int) // index in graph.Nodes, by id
+	addNode := func(node GraphNode) {
+		if _, ok := nodes[node.Id]; ok {
+			return
+		}
+		nodes[node.Id] = len(graph.Nodes)
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	edges := make(map[string]bool)
+	addEdge := func(edge GraphEdge) {
+		key := edge.From + " " + edge.To + " " + edge.Type + " " + edge.Label
+		if edge.From == "" || edge.To == "" || edges[key] {
+			return
+		}
+		edges[key] = true
+		graph.Edges = append(graph.Edges, edge)
+	}
+
+	// every collected resource first, so the nodes referenced across accounts have their cluster
+	for _, accountTopology := range profilesTopology {
+		for _, region := range accountTopology {
+			node := func(id string, nodeType string, label string, vpcId string) GraphNode {
+				return GraphNode{Id: id, Type: nodeType, Label: label, Profile: region.Profile, AccountId: region.AccountId, Region: region.Region, VpcId: vpcId}
+			}
+
+			for _, vpc := range region.Vpcs {
+				vpcNode := node(*vpc.VpcId, NodeVpc, nodeLabel(*vpc.VpcId, vpc.Tags), *vpc.VpcId)
+				vpcNode.Attributes = map[string]string{"cidr": aws.StringValue(vpc.CidrBlock), "default": fmt.Sprint(aws.BoolValue(vpc.IsDefault))}
+				addNode(vpcNode)
+			}
+			for _, subnet := range region.Subnets {
+				subnetNode := node(*subnet.SubnetId, NodeSubnet, nodeLabel(*subnet.SubnetId, subnet.Tags), aws.StringValue(subnet.VpcId))
+				class := ClassifySubnet(subnet, region.RouteTables)
+				subnetNode.Attributes = map[string]string{
+					"cidr":           aws.StringValue(subnet.CidrBlock),
+					"az":             aws.StringValue(subnet.AvailabilityZone),
+					"classification": class.Classification,
+				}
+				addNode(subnetNode)
+				addEdge(GraphEdge{From: *subnet.SubnetId, To: aws.StringValue(subnet.VpcId), Type: "in"})
+				if class.RouteTableId != "" {
+					label := ""
+					if class.MainRouteTable {
+						label = "main"
+					}
+					addEdge(GraphEdge{From: *subnet.SubnetId, To: class.RouteTableId, Type: "uses", Label: label})
+				}
+			}
+			for _, routeTable := range region.RouteTables {
+				addNode(node(*routeTable.RouteTableId, NodeRouteTable, nodeLabel(*routeTable.RouteTableId, routeTable.Tags), aws.StringValue(routeTable.VpcId)))
+			}
+			for _, gateway := range region.InternetGateways {
+				for _, attachment := range gateway.Attachments {
+					vpcId := aws.StringValue(attachment.VpcId)
+					addNode(node(*gateway.InternetGatewayId, NodeInternetGateway, nodeLabel(*gateway.InternetGatewayId, gateway.Tags), vpcId))
+					addEdge(GraphEdge{From: *gateway.InternetGatewayId, To: vpcId, Type: "attached"})
+				}
+			}
+			for _, gateway := range region.NatGateways {
+				natNode := node(*gateway.NatGatewayId, NodeNatGateway, nodeLabel(*gateway.NatGatewayId, gateway.Tags), aws.StringValue(gateway.VpcId))
+				natNode.Attributes = map[string]string{"connectivity": aws.StringValue(gateway.ConnectivityType)}
+				addNode(natNode)
+				addEdge(GraphEdge{From: *gateway.NatGatewayId, To: aws.StringValue(gateway.SubnetId), Type: "in"})
+			}
+			for _, endpoint := range region.VpcEndpoints {
+				endpointNode := node(*endpoint.VpcEndpointId, NodeVpcEndpoint, *endpoint.VpcEndpointId+"\n"+aws.StringValue(endpoint.ServiceName), aws.StringValue(endpoint.VpcId))
+				endpointNode.Attributes = map[string]string{"service": aws.StringValue(endpoint.ServiceName), "endpointType": aws.StringValue(endpoint.VpcEndpointType)}
+				addNode(endpointNode)
+				for _, subnetId := range endpoint.SubnetIds {
+					addEdge(GraphEdge{From: *endpoint.VpcEndpointId, To: aws.StringValue(subnetId), Type: "in"})
+				}
+				if len(endpoint.SubnetIds) == 0 {
+					addEdge(GraphEdge{From: *endpoint.VpcEndpointId, To: aws.StringValue(endpoint.VpcId), Type: "in"})
+				}
+			}
+		}
+	}
+
+	// then everything that links resources, which can be in other accounts
+	for _, accountTopology := range profilesTopology {
+		for _, region := range accountTopology {
+			for _, routeTable := range region.RouteTables {
+				for _, route := range routeTable.Routes {
+					target := utils.RouteTarget(route)
+					if target == "" || target == "local" || aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+						continue
+					}
+					destination := aws.StringValue(route.DestinationCidrBlock)
+					if destination == "" {
+						destination = aws.StringValue(route.DestinationIpv6CidrBlock)
+					}
+					if destination == "" {
+						destination = aws.StringValue(route.DestinationPrefixListId)
+					}
+					if _, ok := nodes[target]; !ok {
+						addNode(GraphNode{Id: target, Type: targetType(target), Label: target, Region: region.Region})
+					}
+					addEdge(GraphEdge{From: *routeTable.RouteTableId, To: target, Type: "route", Label: destination})
+				}
+			}
+
+			for _, connection := range region.PeeringConnections {
+				id := *connection.VpcPeeringConnectionId
+				addNode(GraphNode{Id: id, Type: NodePeeringConnection, Label: nodeLabel(id, connection.Tags)})
+				for _, vpcInfo := range []*ec2.VpcPeeringConnectionVpcInfo{connection.RequesterVpcInfo, connection.AccepterVpcInfo} {
+					if vpcInfo == nil {
+						continue
+					}
+					vpcId := aws.StringValue(vpcInfo.VpcId)
+					if _, ok := nodes[vpcId]; !ok {
+						addNode(GraphNode{Id: vpcId, Type: NodeVpc, Label: vpcId + "\n" + aws.StringValue(vpcInfo.OwnerId), Region: aws.StringValue(vpcInfo.Region)})
+					}
+					addEdge(GraphEdge{From: vpcId, To: id, Type: "peering"})
+				}
+			}
+
+			for _, attachment := range region.TransitGatewayAttachments {
+				gatewayId := aws.StringValue(attachment.TransitGatewayId)
+				gatewayNode := GraphNode{Id: gatewayId, Type: NodeTransitGateway, Label: gatewayId, Region: region.Region}
+				gatewayNode.Attributes = map[string]string{"owner": aws.StringValue(attachment.TransitGatewayOwnerId)}
+				if index, ok := nodes[gatewayId]; ok && graph.Nodes[index].Attributes == nil {
+					graph.Nodes[index] = gatewayNode
+				}
+				addNode(gatewayNode)
+				vpcId := aws.StringValue(attachment.ResourceId)
+				if _, ok := nodes[vpcId]; !ok {
+					addNode(GraphNode{Id: vpcId, Type: NodeVpc, Label: vpcId + "\n" + aws.StringValue(attachment.ResourceOwnerId), Region: region.Region})
+				}
+				addEdge(GraphEdge{From: vpcId, To: gatewayId, Type: "attachment", Label: aws.StringValue(attachment.TransitGatewayAttachmentId)})
+			}
+		}
+	}
+	return graph
+}
+
+// nodeLabel will return the id of the resource, with its Name tag if it has one
+func nodeLabel(id string, tags []*ec2.Tag) string {
+	if name := utils.NameTag(tags); name != "" {
+		return name + "\n" + id
+	}
+	return id
+}
+
+// targetType will return the node type of a route target from its id
+func targetType(target string) string {
+	switch {
+	case strings.HasPrefix(target, "igw-"):
+		return NodeInternetGateway
+	case strings.HasPrefix(target, "nat-"):
+		return NodeNatGateway
+	case strings.HasPrefix(target, "tgw-"):
+		return NodeTransitGateway
+	case strings.HasPrefix(target, "pcx-"):
+		return NodePeeringConnection
+	case strings.HasPrefix(target, "vpce-"):
+		return NodeVpcEndpoint
+	}
+	return "other"
+}
+
+// nodeShapes are the graphviz shapes of each node type
+var nodeShapes = map[string]string{
+	NodeVpc:               "box3d",
+	NodeSubnet:            "box",
+	NodeRouteTable:        "note",
+	NodeInternetGateway:   "doublecircle",
+	NodeNatGateway:        "circle",
+	NodePeeringConnection: "diamond",
+	NodeTransitGateway:    "hexagon",
+	NodeVpcEndpoint:       "cds",
+}
+
+// dotQuote will quote the string for graphviz, keeping new lines in labels
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+// EncodeDot will write the graph in the graphviz dot format, with a cluster for each account, each region in it,
+// and each vpc in that.  Nodes without an account, such as transit gateways, are outside of every cluster
+func (graph Graph) EncodeDot(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph aws {\n\trankdir=LR;\n\tcompound=true;\n\tnode [fontsize=10];\n")
+
+	// account, region and vpc of every clustered node, sorted so the output is the same between runs
+	type clusterKey struct{ account, region, vpc string }
+	clusters := make(map[clusterKey][]GraphNode)
+	var keys []clusterKey
+	var loose []GraphNode
+	profiles := make(map[string]string)
+	vpcLabels := make(map[string]string)
+	for _, node := range graph.Nodes {
+		if node.AccountId == "" {
+			loose = append(loose, node)
+			continue
+		}
+		profiles[node.AccountId] = node.Profile
+		if node.Type == NodeVpc {
+			vpcLabels[node.Id] = node.Label + "\n" + node.Attributes["cidr"]
+		}
+		key := clusterKey{node.AccountId, node.Region, node.VpcId}
+		if _, ok := clusters[key]; !ok {
+			keys = append(keys, key)
+		}
+		clusters[key] = append(clusters[key], node)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].account != keys[j].account {
+			return keys[i].account < keys[j].account
+		}
+		if keys[i].region != keys[j].region {
+			return keys[i].region < keys[j].region
+		}
+		return keys[i].vpc < keys[j].vpc
+	})
+
+	writeNode := func(node GraphNode, indent string) {
+		shape := nodeShapes[node.Type]
+		if shape == "" {
+			shape = "ellipse"
+		}
+		fmt.Fprintf(&b, "%s%s [label=%s, shape=%s];\n", indent, dotQuote(node.Id), dotQuote(node.Label), shape)
+	}
+
+	var account, region string
+	for _, key := range keys {
+		if key.account != account {
+			if region != "" {
+				b.WriteString("\t\t}\n")
+			}
+			if account != "" {
+				b.WriteString("\t}\n")
+			}
+			account, region = key.account, ""
+			fmt.Fprintf(&b, "\tsubgraph %s {\n\t\tlabel=%s;\n", dotQuote("cluster_"+key.account), dotQuote(profiles[key.account]+" ("+key.account+")"))
+		}
+		if key.region != region {
+			if region != "" {
+				b.WriteString("\t\t}\n")
+			}
+			region = key.region
+			fmt.Fprintf(&b, "\t\tsubgraph %s {\n\t\t\tlabel=%s;\n", dotQuote("cluster_"+key.account+"_"+key.region), dotQuote(key.region))
+		}
+		fmt.Fprintf(&b, "\t\t\tsubgraph %s {\n\t\t\t\tlabel=%s;\n", dotQuote("cluster_"+key.account+"_"+key.region+"_"+key.vpc), dotQuote(vpcLabels[key.vpc]))
+		for _, node := range clusters[key] {
+			writeNode(node, "\t\t\t\t")
+		}
+		b.WriteString("\t\t\t}\n")
+	}
+	if region != "" {
+		b.WriteString("\t\t}\n")
+	}
+	if account != "" {
+		b.WriteString("\t}\n")
+	}
+
+	for _, node := range loose {
+		writeNode(node, "\t")
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n", dotQuote(edge.From), dotQuote(edge.To), dotQuote(edge.Label))
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteGraph will write the graph as graphviz dot and as json nodes and edges
+func WriteGraph(graph Graph) error {
+	dotFile, err := utils.Output.Create("vpc", "graph", "dot")
+	if err != nil {
+		return fmt.Errorf("could not create graph file: %v", err)
+	}
+	defer dotFile.Close()
+	if err = graph.EncodeDot(dotFile); err != nil {
+		return fmt.Errorf("could not write graph file: %v", err)
+	}
+	fmt.Println("Writing graph to file:", dotFile.Name())
+
+	jsonFile, err := utils.Output.Create("vpc", "graph", utils.FormatJson)
+	if err != nil {
+		return fmt.Errorf("could not create graph file: %v", err)
+	}
+	defer jsonFile.Close()
+	encoder := json.NewEncoder(jsonFile)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(graph); err != nil {
+		return fmt.Errorf("could not write graph file: %v", err)
+	}
+	fmt.Println("Writing graph to file:", jsonFile.Name())
+	return nil
+}
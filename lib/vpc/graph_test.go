@@ -0,0 +1,186 @@
+package vpc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// testTopology is two accounts joined by a transit gateway owned by the first, which also peers with a vpc in an
+// account that was not collected
+func testTopology() ProfilesTopology {
+	route := func(destination string, target func(*ec2.Route)) *ec2.Route {
+		r := &ec2.Route{DestinationCidrBlock: aws.String(destination), State: aws.String(ec2.RouteStateActive)}
+		target(r)
+		return r
+	}
+	gateway := func(id string) func(*ec2.Route) { return func(r *ec2.Route) { r.GatewayId = aws.String(id) } }
+	tgw := func(r *ec2.Route) { r.TransitGatewayId = aws.String("tgw-1") }
+	peering := func(r *ec2.Route) { r.VpcPeeringConnectionId = aws.String("pcx-1") }
+	blackhole := route("10.9.0.0/16", gateway("igw-old"))
+	blackhole.State = aws.String(ec2.RouteStateBlackhole)
+
+	prod := RegionTopology{
+		Profile:   "prod",
+		AccountId: "111111111111",
+		Region:    "us-east-1",
+		Vpcs: []ec2.Vpc{{
+			VpcId:     aws.String("vpc-a"),
+			CidrBlock: aws.String("10.0.0.0/16"),
+			IsDefault: aws.Bool(false),
+			Tags:      []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("prod")}},
+		}},
+		Subnets: []ec2.Subnet{
+			{SubnetId: aws.String("subnet-a"), VpcId: aws.String("vpc-a"), CidrBlock: aws.String("10.0.1.0/24"), AvailabilityZone: aws.String("us-east-1a")},
+		},
+		RouteTables: []ec2.RouteTable{{
+			RouteTableId: aws.String("rtb-a"),
+			VpcId:        aws.String("vpc-a"),
+			Associations: []*ec2.RouteTableAssociation{{SubnetId: aws.String("subnet-a")}},
+			Routes: []*ec2.Route{
+				route("10.0.0.0/16", gateway("local")),
+				route("0.0.0.0/0", gateway("igw-a")),
+				route("10.1.0.0/16", tgw),
+				route("10.2.0.0/16", peering),
+				blackhole,
+			},
+		}},
+		InternetGateways: []ec2.InternetGateway{
+			{InternetGatewayId: aws.String("igw-a"), Attachments: []*ec2.InternetGatewayAttachment{{VpcId: aws.String("vpc-a")}}},
+		},
+		PeeringConnections: []ec2.VpcPeeringConnection{{
+			VpcPeeringConnectionId: aws.String("pcx-1"),
+			RequesterVpcInfo:       &ec2.VpcPeeringConnectionVpcInfo{VpcId: aws.String("vpc-a"), OwnerId: aws.String("111111111111"), Region: aws.String("us-east-1")},
+			AccepterVpcInfo:        &ec2.VpcPeeringConnectionVpcInfo{VpcId: aws.String("vpc-x"), OwnerId: aws.String("333333333333"), Region: aws.String("us-west-2")},
+		}},
+	}
+	dev := RegionTopology{
+		Profile:   "dev",
+		AccountId: "222222222222",
+		Region:    "us-east-1",
+		Vpcs:      []ec2.Vpc{{VpcId: aws.String("vpc-b"), CidrBlock: aws.String("10.1.0.0/16"), IsDefault: aws.Bool(true)}},
+		Subnets: []ec2.Subnet{
+			{SubnetId: aws.String("subnet-b"), VpcId: aws.String("vpc-b"), CidrBlock: aws.String("10.1.1.0/24"), AvailabilityZone: aws.String("us-east-1b")},
+		},
+		RouteTables: []ec2.RouteTable{{
+			RouteTableId: aws.String("rtb-b"),
+			VpcId:        aws.String("vpc-b"),
+			Associations: []*ec2.RouteTableAssociation{{Main: aws.Bool(true)}},
+			Routes:       []*ec2.Route{route("10.1.0.0/16", gateway("local")), route("0.0.0.0/0", tgw)},
+		}},
+		// the attachment of a vpc in an account that was not collected is seen through the transit gateway
+		TransitGatewayAttachments: []ec2.TransitGatewayAttachment{
+			{TransitGatewayAttachmentId: aws.String("tgw-attach-b"), TransitGatewayId: aws.String("tgw-1"), TransitGatewayOwnerId: aws.String("111111111111"),
+				ResourceId: aws.String("vpc-b"), ResourceOwnerId: aws.String("222222222222")},
+			{TransitGatewayAttachmentId: aws.String("tgw-attach-c"), TransitGatewayId: aws.String("tgw-1"), TransitGatewayOwnerId: aws.String("111111111111"),
+				ResourceId: aws.String("vpc-c"), ResourceOwnerId: aws.String("444444444444")},
+		},
+	}
+	return ProfilesTopology{{prod}, {dev}}
+}
+
+func TestBuildGraph(t *testing.T) {
+	graph := BuildGraph(testTopology())
+
+	prod := func(node GraphNode) GraphNode {
+		node.Profile, node.AccountId, node.Region = "prod", "111111111111", "us-east-1"
+		return node
+	}
+	dev := func(node GraphNode) GraphNode {
+		node.Profile, node.AccountId, node.Region = "dev", "222222222222", "us-east-1"
+		return node
+	}
+	wantNodes := []GraphNode{
+		prod(GraphNode{Id: "vpc-a", Type: NodeVpc, Label: "prod\nvpc-a", VpcId: "vpc-a", Attributes: map[string]string{"cidr": "10.0.0.0/16", "default": "false"}}),
+		prod(GraphNode{Id: "subnet-a", Type: NodeSubnet, Label: "subnet-a", VpcId: "vpc-a",
+			Attributes: map[string]string{"cidr": "10.0.1.0/24", "az": "us-east-1a", "classification": SubnetPublic}}),
+		prod(GraphNode{Id: "rtb-a", Type: NodeRouteTable, Label: "rtb-a", VpcId: "vpc-a"}),
+		prod(GraphNode{Id: "igw-a", Type: NodeInternetGateway, Label: "igw-a", VpcId: "vpc-a"}),
+		dev(GraphNode{Id: "vpc-b", Type: NodeVpc, Label: "vpc-b", VpcId: "vpc-b", Attributes: map[string]string{"cidr": "10.1.0.0/16", "default": "true"}}),
+		dev(GraphNode{Id: "subnet-b", Type: NodeSubnet, Label: "subnet-b", VpcId: "vpc-b",
+			Attributes: map[string]string{"cidr": "10.1.1.0/24", "az": "us-east-1b", "classification": SubnetTgwEgress}}),
+		dev(GraphNode{Id: "rtb-b", Type: NodeRouteTable, Label: "rtb-b", VpcId: "vpc-b"}),
+		// first seen as a route target, then given the owner from its attachments
+		{Id: "tgw-1", Type: NodeTransitGateway, Label: "tgw-1", Region: "us-east-1", Attributes: map[string]string{"owner": "111111111111"}},
+		{Id: "pcx-1", Type: NodePeeringConnection, Label: "pcx-1", Region: "us-east-1"},
+		{Id: "vpc-x", Type: NodeVpc, Label: "vpc-x\n333333333333", Region: "us-west-2"},
+		{Id: "vpc-c", Type: NodeVpc, Label: "vpc-c\n444444444444", Region: "us-east-1"},
+	}
+	wantEdges := []GraphEdge{
+		{From: "subnet-a", To: "vpc-a", Type: "in"},
+		{From: "subnet-a", To: "rtb-a", Type: "uses"},
+		{From: "igw-a", To: "vpc-a", Type: "attached"},
+		{From: "subnet-b", To: "vpc-b", Type: "in"},
+		{From: "subnet-b", To: "rtb-b", Type: "uses", Label: "main"},
+		{From: "rtb-a", To: "igw-a", Type: "route", Label: "0.0.0.0/0"},
+		{From: "rtb-a", To: "tgw-1", Type: "route", Label: "10.1.0.0/16"},
+		{From: "rtb-a", To: "pcx-1", Type: "route", Label: "10.2.0.0/16"},
+		{From: "vpc-a", To: "pcx-1", Type: "peering"},
+		{From: "vpc-x", To: "pcx-1", Type: "peering"},
+		{From: "rtb-b", To: "tgw-1", Type: "route", Label: "0.0.0.0/0"},
+		{From: "vpc-b", To: "tgw-1", Type: "attachment", Label: "tgw-attach-b"},
+		{From: "vpc-c", To: "tgw-1", Type: "attachment", Label: "tgw-attach-c"},
+	}
+	if !reflect.DeepEqual(graph.Nodes, wantNodes) {
+		t.Errorf("nodes =\n%+v\nwant:\n%+v", graph.Nodes, wantNodes)
+	}
+	if !reflect.DeepEqual(graph.Edges, wantEdges) {
+		t.Errorf("edges =\n%+v\nwant:\n%+v", graph.Edges, wantEdges)
+	}
+}
+
+func TestEncodeDot(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph Graph
+		want  []string
+	}{
+		{
+			name:  "empty",
+			graph: Graph{},
+			want:  []string{"digraph aws {\n"},
+		},
+		{
+			name:  "two accounts",
+			graph: BuildGraph(testTopology()),
+			want: []string{
+				"digraph aws {\n",
+				"\tsubgraph \"cluster_111111111111\" {\n\t\tlabel=\"prod (111111111111)\";\n",
+				"\tsubgraph \"cluster_222222222222\" {\n\t\tlabel=\"dev (222222222222)\";\n",
+				"\t\tsubgraph \"cluster_111111111111_us-east-1\" {\n",
+				"\t\t\tsubgraph \"cluster_111111111111_us-east-1_vpc-a\" {\n\t\t\t\tlabel=\"prod\\nvpc-a\\n10.0.0.0/16\";\n",
+				"\t\t\t\t\"subnet-a\" [label=\"subnet-a\", shape=box];\n",
+				// nodes without an account are outside of every cluster
+				"\n\t\"tgw-1\" [label=\"tgw-1\", shape=hexagon];\n",
+				"\n\t\"vpc-x\" [label=\"vpc-x\\n333333333333\", shape=box3d];\n",
+				"\t\"rtb-b\" -> \"tgw-1\" [label=\"0.0.0.0/0\"];\n",
+				"\t\"vpc-c\" -> \"tgw-1\" [label=\"tgw-attach-c\"];\n",
+			},
+		},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		if err := test.graph.EncodeDot(&b); err != nil {
+			t.Errorf("%s: EncodeDot returned an error: %v", test.name, err)
+			continue
+		}
+		dot := b.String()
+		if open, closed := strings.Count(dot, "{"), strings.Count(dot, "}"); open != closed {
+			t.Errorf("%s: %d { and %d }:\n%s", test.name, open, closed, dot)
+		}
+		if !strings.HasSuffix(dot, "}\n") {
+			t.Errorf("%s: does not end the graph:\n%s", test.name, dot)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(dot, want) {
+				t.Errorf("%s: missing %q in:\n%s", test.name, want, dot)
+			}
+		}
+		if got := strings.Count(dot, " -> "); got != len(test.graph.Edges) {
+			t.Errorf("%s: %d edges, want %d", test.name, got, len(test.graph.Edges))
+		}
+	}
+}
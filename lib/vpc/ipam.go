@@ -0,0 +1,259 @@
+package vpc
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ReservedSubnetIps is how many ips aws reserves in every subnet, the first four and the last
+const ReservedSubnetIps = 5
+
+type (
+	IpamOptions struct {
+		// Supernet is the ipv4 cidr to suggest free blocks from, no blocks are suggested if it is empty
+		Supernet string
+		// Size is the prefix length of the blocks to suggest, such as 20 for /20 blocks
+		Size int
+		// Count is how many blocks to suggest
+		Count int
+		// IncludeDefault will include the default vpcs in the overlaps, which all have the same cidr
+		IncludeDefault bool
+	}
+
+	// VpcCidr is one ipv4 cidr of a vpc
+	VpcCidr struct {
+		Profile   string
+		AccountId string
+		Region    string
+		VpcId     string
+		Name      string
+		Default   bool
+		Cidr      netip.Prefix
+	}
+
+	// VpcOverlap is two vpcs with overlapping cidrs, which can not be peered or routed to each other through a transit gateway
+	VpcOverlap struct {
+		Vpc   VpcCidr
+		Other VpcCidr
+		Match string // how the other cidr matches the vpc cidr, equal, contains or contained
+	}
+)
+
+// GetVpcCidrs will return every associated ipv4 cidr of every vpc, including secondary cidrs
+func GetVpcCidrs(profilesVpcs ProfilesVpcs) []VpcCidr {
+	var cidrs []VpcCidr
+	for _, accountVpcs := range profilesVpcs {
+		for _, regionVpcs := range accountVpcs {
+			for _, vpc := range regionVpcs.Vpcs {
+				associations := vpc.CidrBlockAssociationSet
+				if len(associations) == 0 {
+					associations = []*ec2.VpcCidrBlockAssociation{{CidrBlock: vpc.CidrBlock}}
+				}
+				for _, association := range associations {
+					state := ""
+					if association.CidrBlockState != nil {
+						state = aws.StringValue(association.CidrBlockState.State)
+					}
+					if state != "" && state != ec2.VpcCidrBlockStateCodeAssociated {
+						continue
+					}
+					prefix, err := utils.ParseCidr(aws.StringValue(association.CidrBlock))
+					if err != nil {
+						continue
+					}
+					cidrs = append(cidrs, VpcCidr{
+						Profile:   regionVpcs.Profile,
+						AccountId: regionVpcs.AccountId,
+						Region:    regionVpcs.Region,
+						VpcId:     *vpc.VpcId,
+						Name:      utils.NameTag(vpc.Tags),
+						Default:   aws.BoolValue(vpc.IsDefault),
+						Cidr:      prefix,
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(cidrs, func(i, j int) bool {
+		if cidrs[i].Cidr.Addr() != cidrs[j].Cidr.Addr() {
+			return cidrs[i].Cidr.Addr().Less(cidrs[j].Cidr.Addr())
+		}
+		return cidrs[i].Cidr.Bits() < cidrs[j].Cidr.Bits()
+	})
+	return cidrs
+}
+
+// FindVpcOverlaps will find every pair of different vpcs with overlapping cidrs, across all accounts and regions
+// The default vpcs are skipped unless IncludeDefault is set, as every one of them is 172.31.0.0/16
+// The cidrs need to be sorted by address, as GetVpcCidrs returns them
+func FindVpcOverlaps(cidrs []VpcCidr, options IpamOptions) []VpcOverlap {
+	var overlaps []VpcOverlap
+	// cidrs either contain one another or do not overlap at all, so sweeping them by address, every earlier cidr
+	// that still contains the start of the next one overlaps it
+	var active []VpcCidr
+	for _, other := range cidrs {
+		if other.Default && !options.IncludeDefault {
+			continue
+		}
+		containing := active[:0]
+		for _, vpc := range active {
+			if vpc.Cidr.Contains(other.Cidr.Addr()) {
+				containing = append(containing, vpc)
+			}
+		}
+		active = containing
+
+		for _, vpc := range active {
+			if vpc.AccountId == other.AccountId && vpc.Region == other.Region && vpc.VpcId == other.VpcId {
+				continue
+			}
+			overlaps = append(overlaps, VpcOverlap{Vpc: vpc, Other: other, Match: utils.CidrMatch(vpc.Cidr, other.Cidr.String())})
+		}
+		active = append(active, other)
+	}
+	return overlaps
+}
+
+// WriteIpam will write the vpc cidr overlaps, the ip usage of every subnet, and the free blocks in the supernet if one is given
+func WriteIpam(profilesVpcs ProfilesVpcs, options IpamOptions) error {
+	cidrs := GetVpcCidrs(profilesVpcs)
+	if err := writeVpcOverlaps(FindVpcOverlaps(cidrs, options)); err != nil {
+		return err
+	}
+	if err := writeSubnetUsage(profilesVpcs); err != nil {
+		return err
+	}
+	if options.Supernet == "" {
+		return nil
+	}
+	return writeFreeCidrs(cidrs, options)
+}
+
+func writeVpcOverlaps(overlaps []VpcOverlap) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Region",
+		"VPC ID",
+		"VPC Name",
+		"CIDR Block",
+		"Overlap",
+		"Other Profile",
+		"Other Account ID",
+		"Other Region",
+		"Other VPC ID",
+		"Other VPC Name",
+		"Other CIDR Block",
+	}
+
+	report := utils.NewReport("vpc", "vpcoverlaps", columnTitles)
+	for _, overlap := range overlaps {
+		var data = []string{overlap.Vpc.Profile,
+			overlap.Vpc.AccountId,
+			overlap.Vpc.Region,
+			overlap.Vpc.VpcId,
+			overlap.Vpc.Name,
+			overlap.Vpc.Cidr.String(),
+			overlap.Match,
+			overlap.Other.Profile,
+			overlap.Other.AccountId,
+			overlap.Other.Region,
+			overlap.Other.VpcId,
+			overlap.Other.Name,
+			overlap.Other.Cidr.String(),
+		}
+		report.AddRow(data)
+	}
+	fmt.Println("Found", len(overlaps), "overlapping vpc cidrs")
+	return report.Write()
+}
+
+// writeSubnetUsage will write the total usable ips of every subnet, which is its size less the ips aws reserves,
+// along with how many are available and used
+func writeSubnetUsage(profilesVpcs ProfilesVpcs) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Region",
+		"VPC ID",
+		"Subnet ID",
+		"Subnet Name",
+		"Availability Zone",
+		"CIDR Block",
+		"Total IPs",
+		"Available IPs",
+		"Used IPs",
+		"Utilization %",
+	}
+
+	report := utils.NewReport("vpc", "subnetusage", columnTitles)
+	for _, accountVpcs := range profilesVpcs {
+		for _, regionVpcs := range accountVpcs {
+			for _, subnet := range regionVpcs.Subnets {
+				prefix, err := utils.ParseCidr(aws.StringValue(subnet.CidrBlock))
+				if err != nil {
+					continue
+				}
+				total := utils.CidrSize(prefix) - ReservedSubnetIps
+				available := aws.Int64Value(subnet.AvailableIpAddressCount)
+				used := total - available
+				var utilization string
+				if total > 0 {
+					utilization = strconv.FormatFloat(float64(used)/float64(total)*100, 'f', 1, 64)
+				}
+
+				var data = []string{regionVpcs.Profile,
+					regionVpcs.AccountId,
+					regionVpcs.Region,
+					aws.StringValue(subnet.VpcId),
+					*subnet.SubnetId,
+					utils.NameTag(subnet.Tags),
+					aws.StringValue(subnet.AvailabilityZone),
+					prefix.String(),
+					strconv.FormatInt(total, 10),
+					strconv.FormatInt(available, 10),
+					strconv.FormatInt(used, 10),
+					utilization,
+				}
+				report.AddRow(data)
+			}
+		}
+	}
+	return report.Write()
+}
+
+// writeFreeCidrs will write the blocks of options.Size in options.Supernet that no vpc cidr overlaps
+func writeFreeCidrs(cidrs []VpcCidr, options IpamOptions) error {
+	supernet, err := utils.ParseCidr(options.Supernet)
+	if err != nil {
+		return err
+	}
+	var used []netip.Prefix
+	for _, cidr := range cidrs {
+		used = append(used, cidr.Cidr)
+	}
+	free, err := utils.FreeCidrs(supernet, used, options.Size, options.Count)
+	if err != nil {
+		return err
+	}
+
+	var columnTitles = []string{"Supernet",
+		"Free CIDR Block",
+		"Total IPs",
+	}
+
+	report := utils.NewReport("vpc", "freecidrs", columnTitles)
+	for _, prefix := range free {
+		var data = []string{supernet.String(),
+			prefix.String(),
+			strconv.FormatInt(utils.CidrSize(prefix), 10),
+		}
+		report.AddRow(data)
+	}
+	fmt.Println("Found", len(free), "free /"+strconv.Itoa(options.Size), "blocks in", supernet)
+	return report.Write()
+}
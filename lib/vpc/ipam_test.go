@@ -0,0 +1,61 @@
+package vpc
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestFindVpcOverlaps(t *testing.T) {
+	cidr := func(accountId string, vpcId string, prefix string, isDefault bool) VpcCidr {
+		return VpcCidr{AccountId: accountId, Region: "us-east-1", VpcId: vpcId, Default: isDefault, Cidr: netip.MustParsePrefix(prefix)}
+	}
+	// sorted by address, as GetVpcCidrs returns them
+	cidrs := []VpcCidr{
+		cidr("1", "vpc-a", "10.0.0.0/8", false),
+		cidr("1", "vpc-a", "10.0.0.0/16", false), // secondary cidr of the same vpc
+		cidr("2", "vpc-b", "10.0.0.0/16", false),
+		cidr("2", "vpc-c", "10.1.0.0/16", false),
+		cidr("3", "vpc-d", "11.0.0.0/16", false),
+		cidr("1", "vpc-default1", "172.31.0.0/16", true),
+		cidr("2", "vpc-default2", "172.31.0.0/16", true),
+	}
+	type pair struct{ vpc, other, match string }
+	pairs := func(overlaps []VpcOverlap) []pair {
+		var list []pair
+		for _, overlap := range overlaps {
+			list = append(list, pair{overlap.Vpc.VpcId, overlap.Other.VpcId, overlap.Match})
+		}
+		return list
+	}
+
+	tests := []struct {
+		name    string
+		options IpamOptions
+		want    []pair
+	}{
+		{
+			name: "default vpcs skipped",
+			want: []pair{
+				{"vpc-a", "vpc-b", "contained"},
+				{"vpc-a", "vpc-b", "equal"},
+				{"vpc-a", "vpc-c", "contained"},
+			},
+		},
+		{
+			name:    "default vpcs included",
+			options: IpamOptions{IncludeDefault: true},
+			want: []pair{
+				{"vpc-a", "vpc-b", "contained"},
+				{"vpc-a", "vpc-b", "equal"},
+				{"vpc-a", "vpc-c", "contained"},
+				{"vpc-default1", "vpc-default2", "equal"},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := pairs(FindVpcOverlaps(cidrs, test.options)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: FindVpcOverlaps = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -1,14 +1,12 @@
 package vpc
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
@@ -30,27 +28,28 @@ func GetRegionSubnets(sess *session.Session) (*RegionSubnets, error) {
 	svc := ec2.New(sess)
 	params := &ec2.DescribeSubnetsInput{}
 
-	//resp, err := ec2.New(sess).DescribeSubnets(params)
-	resp, err := svc.DescribeSubnets(params)
+	err := svc.DescribeSubnetsPages(params, func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+		for _, subnet := range page.Subnets {
+			subnets.Subnets = append(subnets.Subnets, *subnet)
+		}
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, subnet := range resp.Subnets {
-		subnets.Subnets = append(subnets.Subnets, *subnet)
-	}
-
 	rtparams := &ec2.DescribeRouteTablesInput{}
 
-	rtresp, err := svc.DescribeRouteTables(rtparams)
+	err = svc.DescribeRouteTablesPages(rtparams, func(page *ec2.DescribeRouteTablesOutput, lastPage bool) bool {
+		for _, routeTable := range page.RouteTables {
+			subnets.RouteTables = append(subnets.RouteTables, *routeTable)
+		}
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, routeTable := range rtresp.RouteTables {
-		subnets.RouteTables = append(subnets.RouteTables, *routeTable)
-	}
-
 	return &subnets, nil
 }
 
@@ -59,37 +58,34 @@ func GetAccountSubnets(account utils.AccountInfo) (AccountSubnets, error) {
 	profile := account.Profile
 	fmt.Println("Getting subnet info for profile:", profile)
 	subnetsChan := make(chan RegionSubnets)
-	var wg sync.WaitGroup
 
-	for _, region := range utils.RegionMap {
-		wg.Add(1)
-		go func(region string) {
-			defer wg.Done()
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
 
 			var err error
 			sess, err := account.GetSession(region)
 			if err != nil {
-				log.Println("Could not get session for", account.Profile, ":", err)
+				utils.RecordError(account, region, "vpc", "GetSession", err)
 				return
 			}
 			subnets, err := GetRegionSubnets(sess)
 			if err != nil {
-				log.Println("Could not get subnet info for", region, "in", profile, ":", err)
+				utils.RecordError(account, region, "vpc", "DescribeSubnets", err)
 				return
 			}
-			subnets.AccountId, err = utils.GetAccountId(sess)
+			subnets.AccountId, err = account.CallerAccountId()
 			if err != nil {
-				log.Println("Could not get account id for", profile, ":", err)
+				utils.RecordError(account, region, "sts", "GetCallerIdentity", err)
 				return
 			}
 			subnets.Region = region
 			subnets.Profile = account.Profile
 			subnetsChan <- *subnets
-		}(region)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(subnetsChan)
 	}()
 
@@ -104,25 +100,18 @@ func GetAccountSubnets(account utils.AccountInfo) (AccountSubnets, error) {
 //GetProfilesSubnets will return all the subnets in all accounts of a given filename with a list of profiles in it
 func GetProfilesSubnets(accounts []utils.AccountInfo) (ProfilesSubnets, error) {
 	profilesSubnetsChan := make(chan AccountSubnets)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 
 			var err error
 			accountSubnets, err := GetAccountSubnets(account)
 			if err != nil {
-				log.Println("Could not get subnet info for", account.Profile, ":", err)
+				utils.RecordError(account, "", "vpc", "GetRegions", err)
 				return
 			}
 			profilesSubnetsChan <- accountSubnets
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesSubnetsChan)
 	}()
 
@@ -133,45 +122,110 @@ func GetProfilesSubnets(accounts []utils.AccountInfo) (ProfilesSubnets, error) {
 	return profilesSubnets, nil
 }
 
-//CheckPublicSubnet will check whether the route table associated with a subnet is actually public or not
-//returns true if public, false if not
-//TODO this is not the best check relying on the name.  Need to add a route check on the route table for an IGW
-func CheckPublicSubnet(subnetId string, routeTables []ec2.RouteTable) (bool, error) {
-	//loop through route tables
-	for _, routeTable := range routeTables {
-		//loop through subnet associations
+// Subnet classifications, from the routes of the subnet route table
+const (
+	SubnetPublic    = "public"     // a default route to an internet gateway
+	SubnetNat       = "nat"        // a default route to a nat gateway, nat instance, or egress only internet gateway
+	SubnetTgwEgress = "tgw-egress" // a default route to a transit gateway, so it is private and egresses through another vpc
+	SubnetTransit   = "transit"    // routes to a transit gateway, peering connection or vpn gateway, but no internet access
+	SubnetIsolated  = "isolated"   // only local routes, and routes to vpc endpoints
+)
+
+// SubnetClass is how a subnet routes traffic
+type SubnetClass struct {
+	Classification string
+	RouteTableId   string
+	// MainRouteTable is true when the subnet has no route table of its own, and uses the main route table of the vpc
+	MainRouteTable bool
+	DefaultTarget  string // where 0.0.0.0/0, or ::/0 if there is none, is routed to
+}
+
+// SubnetRouteTable will return the route table the subnet uses, which is the main route table of the vpc if
+// the subnet has no route table associated, and true if it is the main route table
+func SubnetRouteTable(subnet ec2.Subnet, routeTables []ec2.RouteTable) (*ec2.RouteTable, bool) {
+	var main *ec2.RouteTable
+	for i, routeTable := range routeTables {
 		for _, association := range routeTable.Associations {
-			//compare the association subnet id to the provided subnet id
-			if association.SubnetId == nil {
-				continue
-			} else if *association.SubnetId == subnetId {
-				//if it matches, start looping through tags to check the name
-				for _, tag := range routeTable.Tags {
-					//set value to lower to have fewer punctuation issues
-					lowerValue := strings.ToLower(*tag.Value)
-					if *tag.Key == "Name" && strings.Contains(lowerValue, "public") {
-						return true, nil
-					}
-				}
+			if aws.StringValue(association.SubnetId) == aws.StringValue(subnet.SubnetId) {
+				return &routeTables[i], false
+			}
+			if aws.BoolValue(association.Main) && aws.StringValue(routeTable.VpcId) == aws.StringValue(subnet.VpcId) {
+				main = &routeTables[i]
 			}
 		}
 	}
-	return false, nil
+	return main, main != nil
 }
 
-func WriteProfilesSubnets(profileSubnets ProfilesSubnets) error {
-	outputDir := "output/vpc/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "subnets.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create subnets file: %v", err)
+// ClassifySubnet will classify the subnet by the routes of its route table
+// Blackhole routes are ignored, as they do not route anywhere
+func ClassifySubnet(subnet ec2.Subnet, routeTables []ec2.RouteTable) SubnetClass {
+	class := SubnetClass{Classification: SubnetIsolated}
+	routeTable, main := SubnetRouteTable(subnet, routeTables)
+	if routeTable == nil {
+		return class
 	}
+	class.RouteTableId = aws.StringValue(routeTable.RouteTableId)
+	class.MainRouteTable = main
 
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing subnets to file:", outfile.Name())
+	var public, nat, tgwEgress, transit bool
+	for _, route := range routeTable.Routes {
+		if aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+			continue
+		}
+		target := utils.RouteTarget(route)
+		isDefault := aws.StringValue(route.DestinationCidrBlock) == "0.0.0.0/0" || aws.StringValue(route.DestinationIpv6CidrBlock) == "::/0"
+		if isDefault && (class.DefaultTarget == "" || route.DestinationCidrBlock != nil) {
+			class.DefaultTarget = target
+		}
+		switch {
+		case isDefault && strings.HasPrefix(target, "igw-"):
+			public = true
+		case isDefault && (route.NatGatewayId != nil || route.InstanceId != nil || route.NetworkInterfaceId != nil || route.EgressOnlyInternetGatewayId != nil):
+			nat = true
+		case isDefault && route.TransitGatewayId != nil:
+			tgwEgress = true
+		case route.TransitGatewayId != nil || route.VpcPeeringConnectionId != nil || strings.HasPrefix(target, "vgw-"):
+			transit = true
+		}
+	}
 
+	switch {
+	case public:
+		class.Classification = SubnetPublic
+	case nat:
+		class.Classification = SubnetNat
+	case tgwEgress:
+		class.Classification = SubnetTgwEgress
+	case transit:
+		class.Classification = SubnetTransit
+	}
+	return class
+}
+
+// NamingMismatch will compare the classification to what the subnet name says it is, or the route table name if the
+// subnet name does not say, and describe the mismatch
+// Names with public are expected to be public, and names with private, isolated or internal are expected not to be
+func NamingMismatch(class SubnetClass, subnetName string, routeTableName string) string {
+	for _, name := range []string{subnetName, routeTableName} {
+		lowerName := strings.ToLower(name)
+		switch {
+		case strings.Contains(lowerName, "public"):
+			if class.Classification != SubnetPublic {
+				return "named " + name + " but routes are " + class.Classification
+			}
+			return ""
+		case strings.Contains(lowerName, "private") || strings.Contains(lowerName, "isolated") || strings.Contains(lowerName, "internal"):
+			if class.Classification == SubnetPublic {
+				return "named " + name + " but routes are public"
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+func WriteProfilesSubnets(profileSubnets ProfilesSubnets) error {
 	var columnTitles = []string{"Account",
 		"Account ID",
 		"Region",
@@ -180,7 +234,12 @@ func WriteProfilesSubnets(profileSubnets ProfilesSubnets) error {
 		"VPC ID",
 		"CIDR Block",
 		"Is Default",
-		"Public Route Table Validated",
+		"Classification",
+		"Route Table ID",
+		"Main Route Table",
+		"Default Route Target",
+		"Map Public IP On Launch",
+		"Naming Mismatch",
 	}
 
 	//tags := options.Tags
@@ -190,26 +249,16 @@ func WriteProfilesSubnets(profileSubnets ProfilesSubnets) error {
 	//	}
 	//}
 
-	err = writer.Write(columnTitles)
-	if err != nil {
-		fmt.Println(err)
-	}
-
+	report := utils.NewReport("vpc", "subnets", columnTitles)
 	for _, accountSubnets := range profileSubnets {
 		for _, regionSubnets := range accountSubnets {
 			for _, subnet := range regionSubnets.Subnets {
-				var subnetName string
-				for _, tag := range subnet.Tags {
-					if *tag.Key == "Name" {
-						subnetName = *tag.Value
-					}
-				}
+				subnetName := utils.NameTag(subnet.Tags)
 
-				lowerName := strings.ToLower(subnetName)
-				validPublicSubnet := "N/A"
-				if strings.Contains(lowerName, "public") {
-					x, _ := CheckPublicSubnet(*subnet.SubnetId, regionSubnets.RouteTables)
-					validPublicSubnet = strconv.FormatBool(x)
+				class := ClassifySubnet(subnet, regionSubnets.RouteTables)
+				var routeTableName string
+				if routeTable, _ := SubnetRouteTable(subnet, regionSubnets.RouteTables); routeTable != nil {
+					routeTableName = utils.NameTag(routeTable.Tags)
 				}
 
 				var data = []string{regionSubnets.Profile,
@@ -218,9 +267,14 @@ func WriteProfilesSubnets(profileSubnets ProfilesSubnets) error {
 					subnetName,
 					*subnet.SubnetId,
 					*subnet.VpcId,
-					*subnet.CidrBlock,
-					strconv.FormatBool(*subnet.DefaultForAz),
-					validPublicSubnet,
+					aws.StringValue(subnet.CidrBlock),
+					strconv.FormatBool(aws.BoolValue(subnet.DefaultForAz)),
+					class.Classification,
+					class.RouteTableId,
+					strconv.FormatBool(class.MainRouteTable),
+					class.DefaultTarget,
+					strconv.FormatBool(aws.BoolValue(subnet.MapPublicIpOnLaunch)),
+					NamingMismatch(class, subnetName, routeTableName),
 				}
 
 				//if len(tags) > 0 {
@@ -238,12 +292,9 @@ func WriteProfilesSubnets(profileSubnets ProfilesSubnets) error {
 				//	}
 				//}
 
-				err = writer.Write(data)
-				if err != nil {
-					fmt.Println(err)
-				}
+				report.AddRow(data)
 			}
 		}
 	}
-	return nil
+	return report.Write()
 }
@@ -0,0 +1,115 @@
+package vpc
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestClassifySubnet(t *testing.T) {
+	subnet := ec2.Subnet{SubnetId: aws.String("subnet-1"), VpcId: aws.String("vpc-1")}
+	local := &ec2.Route{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local"), State: aws.String("active")}
+	route := func(destination string, target func(*ec2.Route)) *ec2.Route {
+		r := &ec2.Route{State: aws.String("active")}
+		if destination == "::/0" {
+			r.DestinationIpv6CidrBlock = aws.String(destination)
+		} else {
+			r.DestinationCidrBlock = aws.String(destination)
+		}
+		target(r)
+		return r
+	}
+	gateway := func(id string) func(*ec2.Route) { return func(r *ec2.Route) { r.GatewayId = aws.String(id) } }
+	nat := func(r *ec2.Route) { r.NatGatewayId = aws.String("nat-1") }
+	tgw := func(r *ec2.Route) { r.TransitGatewayId = aws.String("tgw-1") }
+	peering := func(r *ec2.Route) { r.VpcPeeringConnectionId = aws.String("pcx-1") }
+	egressOnly := func(r *ec2.Route) { r.EgressOnlyInternetGatewayId = aws.String("eigw-1") }
+	blackhole := func(r *ec2.Route) { r.GatewayId = aws.String("igw-1"); r.State = aws.String(ec2.RouteStateBlackhole) }
+
+	// the subnet has its own route table with the routes, and the vpc has a main route table with only the local route
+	routeTables := func(routes ...*ec2.Route) []ec2.RouteTable {
+		return []ec2.RouteTable{
+			{RouteTableId: aws.String("rtb-main"), VpcId: aws.String("vpc-1"), Routes: []*ec2.Route{local},
+				Associations: []*ec2.RouteTableAssociation{{Main: aws.Bool(true)}}},
+			{RouteTableId: aws.String("rtb-1"), VpcId: aws.String("vpc-1"), Routes: append([]*ec2.Route{local}, routes...),
+				Associations: []*ec2.RouteTableAssociation{{SubnetId: aws.String("subnet-1")}}},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		routeTables []ec2.RouteTable
+		want        SubnetClass
+	}{
+		{
+			name:        "internet gateway",
+			routeTables: routeTables(route("0.0.0.0/0", gateway("igw-1"))),
+			want:        SubnetClass{Classification: SubnetPublic, RouteTableId: "rtb-1", DefaultTarget: "igw-1"},
+		},
+		{
+			name:        "internet gateway wins over a transit gateway",
+			routeTables: routeTables(route("10.1.0.0/16", tgw), route("0.0.0.0/0", gateway("igw-1"))),
+			want:        SubnetClass{Classification: SubnetPublic, RouteTableId: "rtb-1", DefaultTarget: "igw-1"},
+		},
+		{
+			name:        "nat gateway",
+			routeTables: routeTables(route("0.0.0.0/0", nat)),
+			want:        SubnetClass{Classification: SubnetNat, RouteTableId: "rtb-1", DefaultTarget: "nat-1"},
+		},
+		{
+			name:        "egress only internet gateway",
+			routeTables: routeTables(route("::/0", egressOnly)),
+			want:        SubnetClass{Classification: SubnetNat, RouteTableId: "rtb-1", DefaultTarget: "eigw-1"},
+		},
+		{
+			name:        "default route to a transit gateway",
+			routeTables: routeTables(route("0.0.0.0/0", tgw)),
+			want:        SubnetClass{Classification: SubnetTgwEgress, RouteTableId: "rtb-1", DefaultTarget: "tgw-1"},
+		},
+		{
+			name:        "ipv4 default target is kept over ipv6",
+			routeTables: routeTables(route("0.0.0.0/0", tgw), route("::/0", egressOnly)),
+			want:        SubnetClass{Classification: SubnetNat, RouteTableId: "rtb-1", DefaultTarget: "tgw-1"},
+		},
+		{
+			name:        "transit gateway without a default route",
+			routeTables: routeTables(route("10.1.0.0/16", tgw)),
+			want:        SubnetClass{Classification: SubnetTransit, RouteTableId: "rtb-1"},
+		},
+		{
+			name:        "peering connection",
+			routeTables: routeTables(route("10.2.0.0/16", peering)),
+			want:        SubnetClass{Classification: SubnetTransit, RouteTableId: "rtb-1"},
+		},
+		{
+			name:        "vpn gateway",
+			routeTables: routeTables(route("192.168.0.0/16", gateway("vgw-1"))),
+			want:        SubnetClass{Classification: SubnetTransit, RouteTableId: "rtb-1"},
+		},
+		{
+			name:        "blackhole default route",
+			routeTables: routeTables(route("0.0.0.0/0", blackhole)),
+			want:        SubnetClass{Classification: SubnetIsolated, RouteTableId: "rtb-1"},
+		},
+		{
+			name:        "only local routes",
+			routeTables: routeTables(),
+			want:        SubnetClass{Classification: SubnetIsolated, RouteTableId: "rtb-1"},
+		},
+		{
+			name:        "main route table",
+			routeTables: routeTables()[:1],
+			want:        SubnetClass{Classification: SubnetIsolated, RouteTableId: "rtb-main", MainRouteTable: true},
+		},
+		{
+			name: "no route table",
+			want: SubnetClass{Classification: SubnetIsolated},
+		},
+	}
+	for _, test := range tests {
+		if got := ClassifySubnet(subnet, test.routeTables); got != test.want {
+			t.Errorf("%s: ClassifySubnet = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
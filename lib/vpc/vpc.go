@@ -1,11 +1,8 @@
 package vpc
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
 	"strconv"
-	"sync"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -29,14 +26,15 @@ func GetRegionVpcs(sess *session.Session, arn string) (*RegionVpcs, error) {
 	var vpcs RegionVpcs
 	params := &ec2.DescribeVpcsInput{}
 
-	vpcResp, err := svc.DescribeVpcs(params)
+	err := svc.DescribeVpcsPages(params, func(page *ec2.DescribeVpcsOutput, lastPage bool) bool {
+		for _, vpc := range page.Vpcs {
+			vpcs.Vpcs = append(vpcs.Vpcs, *vpc)
+		}
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	for _, vpc := range vpcResp.Vpcs {
-		vpcs.Vpcs = append(vpcs.Vpcs, *vpc)
-	}
 	subnets, err := GetRegionSubnets(sess)
 	if err != nil {
 		return nil, err
@@ -52,37 +50,34 @@ func GetAccountVpcs(account utils.AccountInfo) (AccountVpcs, error) {
 	profile := account.Profile
 	fmt.Println("Getting vpc info for profile:", profile)
 	vpcsChan := make(chan RegionVpcs)
-	var wg sync.WaitGroup
 
-	for _, region := range utils.RegionMap {
-		wg.Add(1)
-		go func(region string) {
-			defer wg.Done()
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
 
 			var err error
 			sess, err := account.GetSession(region)
 			if err != nil {
-				log.Println("Could not get session for", account.Profile, ":", err)
+				utils.RecordError(account, region, "vpc", "GetSession", err)
 				return
 			}
 			vpcs, err := GetRegionVpcs(sess, profile)
 			if err != nil {
-				log.Println("Could not get vpc info for", region, "in", profile, ":", err)
+				utils.RecordError(account, region, "vpc", "DescribeVpcs", err)
 				return
 			}
-			vpcs.AccountId, err = utils.GetAccountId(sess)
+			vpcs.AccountId, err = account.CallerAccountId()
 			if err != nil {
-				log.Println("Could not get account id for", profile, ":", err)
+				utils.RecordError(account, region, "sts", "GetCallerIdentity", err)
 				return
 			}
 			vpcs.Region = region
 			vpcs.Profile = account.Profile
 			vpcsChan <- *vpcs
-		}(region)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(vpcsChan)
 	}()
 
@@ -97,25 +92,18 @@ func GetAccountVpcs(account utils.AccountInfo) (AccountVpcs, error) {
 // GetProfilesVpcs will return all the vpcs/subnets in all accounts of a given filename with a list of profiles in it
 func GetProfilesVpcs(accounts []utils.AccountInfo) (ProfilesVpcs, error) {
 	profilesVpcsChan := make(chan AccountVpcs)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
 
 			var err error
 			accountVpcs, err := GetAccountVpcs(account)
 			if err != nil {
-				log.Println("Could not get vpc info for", account.Profile, ":", err)
+				utils.RecordError(account, "", "vpc", "GetRegions", err)
 				return
 			}
 			profilesVpcsChan <- accountVpcs
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesVpcsChan)
 	}()
 
@@ -127,18 +115,6 @@ func GetProfilesVpcs(accounts []utils.AccountInfo) (ProfilesVpcs, error) {
 }
 
 func WriteProfilesVpcs(profileVpcs ProfilesVpcs) error {
-	outputDir := "output/vpc/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "vpcs.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create vpcs file: %v", err)
-	}
-
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing vpcs to file:", outfile.Name())
-
 	var columnTitles = []string{"Account",
 		"Account ID",
 		"Region",
@@ -156,11 +132,7 @@ func WriteProfilesVpcs(profileVpcs ProfilesVpcs) error {
 	//	}
 	//}
 
-	err = writer.Write(columnTitles)
-	if err != nil {
-		fmt.Println(err)
-	}
-
+	report := utils.NewReport("vpc", "vpcs", columnTitles)
 	for _, accountVpcs := range profileVpcs {
 		for _, regionVpcs := range accountVpcs {
 			for _, vpc := range regionVpcs.Vpcs {
@@ -196,10 +168,7 @@ func WriteProfilesVpcs(profileVpcs ProfilesVpcs) error {
 				//	}
 				//}
 
-				err = writer.Write(data)
-				if err != nil {
-					fmt.Println(err)
-				}
+				report.AddRow(data)
 			}
 			for _, subnet := range regionVpcs.Subnets {
 				var subnetName string
@@ -234,12 +203,9 @@ func WriteProfilesVpcs(profileVpcs ProfilesVpcs) error {
 				//	}
 				//}
 
-				err = writer.Write(data)
-				if err != nil {
-					fmt.Println(err)
-				}
+				report.AddRow(data)
 			}
 		}
 	}
-	return nil
+	return report.Write()
 }
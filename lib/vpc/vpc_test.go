@@ -0,0 +1,31 @@
+package vpc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/afeeblechild/aws-go-tool/internal/testutil"
+	"github.com/afeeblechild/aws-go-tool/lib/utils"
+)
+
+func TestReplayVpcs(t *testing.T) {
+	readReport := testutil.ReplayReport(t, "testdata/replay")
+	profilesVpcs, err := utils.CollectAccounts("vpc/vpcs", GetProfilesVpcs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteProfilesVpcs(profilesVpcs); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"Account", "Account ID", "Region", "Resource Name", "VPC ID", "Subnet ID", "CIDR Block", "Is Default"},
+		{"prod", "111111111111", "us-east-1", "main", "vpc-0001", "N/A", "10.0.0.0/16", "false"},
+		{"prod", "111111111111", "us-east-1", "", "vpc-0002", "N/A", "172.31.0.0/16", "true"},
+		{"prod", "111111111111", "us-east-1", "main-public-a", "vpc-0001", "subnet-0001", "10.0.1.0/24", "false"},
+		{"prod", "111111111111", "us-east-1", "", "vpc-0002", "subnet-0002", "172.31.0.0/20", "true"},
+	}
+	if got := readReport("vpc/vpcs.csv"); !reflect.DeepEqual(got, want) {
+		t.Errorf("vpcs report:\n%v\nwant:\n%v", got, want)
+	}
+}
@@ -1,11 +1,10 @@
 package workspace
 
 import (
-	"encoding/csv"
 	"fmt"
-	"log"
+	"math"
 	"strconv"
-	"sync"
+	"time"
 
 	"github.com/afeeblechild/aws-go-tool/lib/utils"
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,35 +14,41 @@ import (
 
 type WorkspaceOptions struct {
 	Tags []string
+	// IdleDays is how many days without a user connection before a workspace is reported as idle
+	IdleDays int
 }
 
 type RegionWorkspaces struct {
-	AccountId    string
-	Region       string
-	Profile      string
-	Instances    []workspaces.Workspace
-	InstanceTags []workspaces.Tag
+	AccountId string
+	Region    string
+	Profile   string
+	Instances []workspaces.Workspace
+	// InstanceTags are the tags of each workspace, by workspace id
+	InstanceTags map[string][]workspaces.Tag
+	// ConnectionStatus is the last connection of each workspace, by workspace id
+	// It is nil if the connection status could not be read, so the last connections are unknown
+	ConnectionStatus map[string]workspaces.WorkspaceConnectionStatus
 }
 
 type AccountWorkspaces []RegionWorkspaces
 type ProfilesWorkspaces []AccountWorkspaces
 
-//GetRegionWorkspaces will take a session and pull all workspaces based on the region of the session
+// GetRegionWorkspaces will take a session and pull all workspaces based on the region of the session
 func GetRegionWorkspaces(sess *session.Session) ([]workspaces.Workspace, error) {
 	params := &workspaces.DescribeWorkspacesInput{}
 
 	var instances []workspaces.Workspace
-	//x is the check to ensure there is no roles left from the IsTruncated
+	//x is the check to ensure there is no workspaces left from the NextToken
 	x := true
 	for x {
 		resp, err := workspaces.New(sess).DescribeWorkspaces(params)
 		if err != nil {
-			return nil, fmt.Errorf("could not get workspaces", err)
+			return nil, fmt.Errorf("could not get workspaces: %w", err)
 		}
 		for _, instance := range resp.Workspaces {
 			instances = append(instances, *instance)
 		}
-		//If it is truncated, add the marker to the params for the next loop
+		//If there is a next token, add it to the params for the next loop
 		//If not, set x to false to exit for loop
 		if resp.NextToken != nil {
 			params.NextToken = resp.NextToken
@@ -55,63 +60,89 @@ func GetRegionWorkspaces(sess *session.Session) ([]workspaces.Workspace, error)
 	return instances, nil
 }
 
-func GetRegionWorkspaceTags(instances []workspaces.Workspace, sess *session.Session) ([]workspaces.Tag, error) {
-	var tags []workspaces.Tag
+// GetRegionWorkspaceTags will get the tags of every workspace, by workspace id
+func GetRegionWorkspaceTags(instances []workspaces.Workspace, sess *session.Session) (map[string][]workspaces.Tag, error) {
+	tags := make(map[string][]workspaces.Tag)
 	for _, instance := range instances {
 		params := &workspaces.DescribeTagsInput{
-			ResourceId: aws.String(*instance.WorkspaceId),
+			ResourceId: instance.WorkspaceId,
 		}
 
 		resp, err := workspaces.New(sess).DescribeTags(params)
-
-		tags = append(tags, resp.TagList)
+		if err != nil {
+			return nil, fmt.Errorf("could not get tags for %s: %w", *instance.WorkspaceId, err)
+		}
+		for _, tag := range resp.TagList {
+			tags[*instance.WorkspaceId] = append(tags[*instance.WorkspaceId], *tag)
+		}
 	}
 
 	return tags, nil
 }
 
-//GetAccountWorkspaces will take a profile and go through all regions to get all workspaces in the account
+// GetRegionConnectionStatus will get the last connection of every workspace in the region, by workspace id
+func GetRegionConnectionStatus(sess *session.Session) (map[string]workspaces.WorkspaceConnectionStatus, error) {
+	params := &workspaces.DescribeWorkspacesConnectionStatusInput{}
+
+	statuses := make(map[string]workspaces.WorkspaceConnectionStatus)
+	x := true
+	for x {
+		resp, err := workspaces.New(sess).DescribeWorkspacesConnectionStatus(params)
+		if err != nil {
+			return nil, fmt.Errorf("could not get workspaces connection status: %w", err)
+		}
+		for _, status := range resp.WorkspacesConnectionStatus {
+			statuses[aws.StringValue(status.WorkspaceId)] = *status
+		}
+		if resp.NextToken != nil {
+			params.NextToken = resp.NextToken
+		} else {
+			x = false
+		}
+	}
+
+	return statuses, nil
+}
+
+// GetAccountWorkspaces will take a profile and go through all regions to get all workspaces in the account
 func GetAccountWorkspaces(account utils.AccountInfo) (AccountWorkspaces, error) {
 	profile := account.Profile
 	fmt.Println("Getting workspaces for profile:", profile)
 	workspacesChan := make(chan RegionWorkspaces)
-	var wg sync.WaitGroup
-
-	for _, region := range utils.RegionMap {
-		wg.Add(1)
-		go func(region string) {
-			var info RegionWorkspaces
-			var err error
-			defer wg.Done()
-			account.Region = region
-			sess, err := account.GetSession()
+
+	regions, err := account.GetRegions()
+	if err != nil {
+		return nil, fmt.Errorf("could not get regions: %w", err)
+	}
+	// workspaces is not offered in every region
+	regions = utils.ServiceRegions(regions, "workspaces")
+	go func() {
+		utils.ForEachRegion(regions, func(region string) {
+			info := RegionWorkspaces{AccountId: account.AccountId, Profile: profile, Region: region}
+			sess, err := account.GetSession(region)
 			if err != nil {
-				log.Println("could not get session for", account.Profile, ":", err)
+				utils.RecordError(account, region, "workspaces", "GetSession", err)
 				return
 			}
 			info.Instances, err = GetRegionWorkspaces(sess)
 			if err != nil {
-				log.Println("could not get workspaces for", region, "in", profile, ":", err)
+				utils.RecordError(account, region, "workspaces", "DescribeWorkspaces", err)
+				return
+			}
+			if len(info.Instances) == 0 {
+				workspacesChan <- info
 				return
 			}
 			info.InstanceTags, err = GetRegionWorkspaceTags(info.Instances, sess)
 			if err != nil {
-				log.Println("could not get workspace tags for", region, "in", profile, ":", err)
-				return
+				utils.RecordError(account, region, "workspaces", "DescribeTags", err)
 			}
-			info.AccountId, err = utils.GetAccountId(sess)
+			info.ConnectionStatus, err = GetRegionConnectionStatus(sess)
 			if err != nil {
-				log.Println("could not get account id for profile: ", profile)
-				return
+				utils.RecordError(account, region, "workspaces", "DescribeWorkspacesConnectionStatus", err)
 			}
-			info.Region = region
-			info.Profile = profile
 			workspacesChan <- info
-		}(region)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(workspacesChan)
 	}()
 
@@ -123,27 +154,23 @@ func GetAccountWorkspaces(account utils.AccountInfo) (AccountWorkspaces, error)
 	return accountWorkspaces, nil
 }
 
-//GetProfilesWorkspaces will return all the workspaces in all accounts of a given filename with a list of profiles in it
+// GetProfilesWorkspaces will return all the workspaces in all accounts of a given filename with a list of profiles in it
 func GetProfilesWorkspaces(accounts []utils.AccountInfo) (ProfilesWorkspaces, error) {
 	profilesWorkspacesChan := make(chan AccountWorkspaces)
-	var wg sync.WaitGroup
 
-	for _, account := range accounts {
-		wg.Add(1)
-		go func(account utils.AccountInfo) {
-			var err error
-			defer wg.Done()
+	go func() {
+		utils.ForEachAccount(accounts, func(account utils.AccountInfo) {
+			if err := account.SetAccountId(); err != nil {
+				utils.RecordError(account, "", "sts", "GetCallerIdentity", err)
+				return
+			}
 			accountWorkspaces, err := GetAccountWorkspaces(account)
 			if err != nil {
-				log.Println("Could not get workspaces for", account.Profile, ":", err)
+				utils.RecordError(account, "", "workspaces", "GetRegions", err)
 				return
 			}
 			profilesWorkspacesChan <- accountWorkspaces
-		}(account)
-	}
-
-	go func() {
-		wg.Wait()
+		})
 		close(profilesWorkspacesChan)
 	}()
 
@@ -154,18 +181,7 @@ func GetProfilesWorkspaces(accounts []utils.AccountInfo) (ProfilesWorkspaces, er
 	return profilesWorkspaces, nil
 }
 
-func WriteProfilesWorkspaces(profileWorkspaces ProfilesWorkspaces, options utils.Ec2Options) error {
-	outputDir := "output/workspaces/"
-	utils.MakeDir(outputDir)
-	outputFile := outputDir + "workspaces.csv"
-	outfile, err := utils.CreateFile(outputFile)
-	if err != nil {
-		return fmt.Errorf("could not create workspaces file", err)
-	}
-
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	fmt.Println("Writing workspaces to file:", outfile.Name())
+func WriteProfilesWorkspaces(profileWorkspaces ProfilesWorkspaces, options WorkspaceOptions) error {
 	var columnTitles = []string{"Profile",
 		"Account ID",
 		"Region",
@@ -176,6 +192,8 @@ func WriteProfilesWorkspaces(profileWorkspaces ProfilesWorkspaces, options utils
 		"Bundle ID",
 		"Directory ID",
 		"IP Address",
+		"Running Mode",
+		"Compute Type",
 		"Root Volume Encrypted",
 		"User Volume Encrypted",
 		"Volume Encryption Key",
@@ -189,54 +207,139 @@ func WriteProfilesWorkspaces(profileWorkspaces ProfilesWorkspaces, options utils
 		}
 	}
 
-	err = writer.Write(columnTitles)
-	if err != nil {
-		fmt.Println(err)
+	report := utils.NewReport("workspaces", "workspaces", columnTitles)
+	for _, accountWorkspaces := range profileWorkspaces {
+		for _, regionWorkspaces := range accountWorkspaces {
+			for _, instance := range regionWorkspaces.Instances {
+				var runningMode, computeType string
+				if instance.WorkspaceProperties != nil {
+					runningMode = aws.StringValue(instance.WorkspaceProperties.RunningMode)
+					computeType = aws.StringValue(instance.WorkspaceProperties.ComputeTypeName)
+				}
+
+				var data = []string{regionWorkspaces.Profile,
+					regionWorkspaces.AccountId,
+					regionWorkspaces.Region,
+					aws.StringValue(instance.WorkspaceId),
+					aws.StringValue(instance.ComputerName),
+					aws.StringValue(instance.State),
+					aws.StringValue(instance.UserName),
+					aws.StringValue(instance.BundleId),
+					aws.StringValue(instance.DirectoryId),
+					aws.StringValue(instance.IpAddress),
+					runningMode,
+					computeType,
+					strconv.FormatBool(aws.BoolValue(instance.RootVolumeEncryptionEnabled)),
+					strconv.FormatBool(aws.BoolValue(instance.UserVolumeEncryptionEnabled)),
+					aws.StringValue(instance.VolumeEncryptionKey),
+					aws.StringValue(instance.SubnetId),
+				}
+
+				if len(tags) > 0 {
+					workspaceTags := regionWorkspaces.InstanceTags[aws.StringValue(instance.WorkspaceId)]
+					for _, tag := range tags {
+						x := false
+						for _, workspaceTag := range workspaceTags {
+							if *workspaceTag.Key == tag {
+								data = append(data, aws.StringValue(workspaceTag.Value))
+								x = true
+							}
+						}
+						if !x {
+							data = append(data, "")
+						}
+					}
+				}
+
+				report.AddRow(data)
+			}
+		}
 	}
-	//var pemKeys []string
-	//pemKeyFile, _ := utils.CreateFile("pemKeys.csv")
+	return report.Write()
+}
+
+// WriteProfilesWorkspacesIdle will write the last user connection of every workspace
+// A workspace is idle if no user has connected for options.IdleDays, or no user has ever connected
+// If the connection status of the region could not be read, the last connection and idle are unknown
+func WriteProfilesWorkspacesIdle(profileWorkspaces ProfilesWorkspaces, options WorkspaceOptions) error {
+	var columnTitles = []string{"Profile",
+		"Account ID",
+		"Region",
+		"Workspace ID",
+		"User Name",
+		"Workspace State",
+		"Running Mode",
+		"Connection State",
+		"Last User Connection",
+		"Days Since Connection",
+		"Idle",
+	}
+
+	tags := options.Tags
+	if len(tags) > 0 {
+		for _, tag := range tags {
+			columnTitles = append(columnTitles, tag)
+		}
+	}
+
+	now := time.Now()
+	report := utils.NewReport("workspaces", "workspacesidle", columnTitles)
 	for _, accountWorkspaces := range profileWorkspaces {
 		for _, regionWorkspaces := range accountWorkspaces {
 			for _, instance := range regionWorkspaces.Instances {
-				var volumeEncryptionKey string
-				if instance.VolumeEncryptionKey != nil {
-					volumeEncryptionKey = *instance.VolumeEncryptionKey
+				workspaceId := aws.StringValue(instance.WorkspaceId)
+				var runningMode string
+				if instance.WorkspaceProperties != nil {
+					runningMode = aws.StringValue(instance.WorkspaceProperties.RunningMode)
 				}
+
+				status := regionWorkspaces.ConnectionStatus[workspaceId]
+				lastConnection := "Never"
+				daysSince := ""
+				idle := "true"
+				switch {
+				case regionWorkspaces.ConnectionStatus == nil:
+					lastConnection = "unknown"
+					idle = "unknown"
+				case status.LastKnownUserConnectionTimestamp != nil:
+					lastConnection = status.LastKnownUserConnectionTimestamp.Format(time.RFC3339)
+					days := int(math.Floor(now.Sub(*status.LastKnownUserConnectionTimestamp).Hours() / 24))
+					daysSince = strconv.Itoa(days)
+					idle = strconv.FormatBool(days >= options.IdleDays)
+				}
+
 				var data = []string{regionWorkspaces.Profile,
 					regionWorkspaces.AccountId,
 					regionWorkspaces.Region,
-					*instance.WorkspaceId,
-					*instance.ComputerName,
-					*instance.UserName,
-					*instance.BundleId,
-					*instance.DirectoryId,
-					strconv.FormatBool(*instance.RootVolumeEncryptionEnabled),
-					strconv.FormatBool(*instance.UserVolumeEncryptionEnabled),
-					volumeEncryptionKey,
-					*instance.SubnetId,
+					workspaceId,
+					aws.StringValue(instance.UserName),
+					aws.StringValue(instance.State),
+					runningMode,
+					aws.StringValue(status.ConnectionState),
+					lastConnection,
+					daysSince,
+					idle,
 				}
 
-				//if len(tags) > 0 {
-				//	for _, tag := range tags {
-				//		x := false
-				//		for _, workspaceTag := range instance.WorkspaceProperties. {
-				//			if *workspaceTag.Key == tag {
-				//				data = append(data, *workspaceTag.Value)
-				//				x = true
-				//			}
-				//		}
-				//		if !x {
-				//			data = append(data, "")
-				//		}
-				//	}
-				//}
-
-				err = writer.Write(data)
-				if err != nil {
-					fmt.Println(err)
+				if len(tags) > 0 {
+					workspaceTags := regionWorkspaces.InstanceTags[workspaceId]
+					for _, tag := range tags {
+						x := false
+						for _, workspaceTag := range workspaceTags {
+							if *workspaceTag.Key == tag {
+								data = append(data, aws.StringValue(workspaceTag.Value))
+								x = true
+							}
+						}
+						if !x {
+							data = append(data, "")
+						}
+					}
 				}
+
+				report.AddRow(data)
 			}
 		}
 	}
-	return nil
+	return report.Write()
 }